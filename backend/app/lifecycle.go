@@ -0,0 +1,93 @@
+package app
+
+import (
+	"backend/config"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stopper is a background component stopped after the HTTP server, like the
+// email outbox or the database pool
+type Stopper struct {
+	Name string
+	Stop func(ctx context.Context) error
+}
+
+// draining is set from the shutdown signal on
+var draining atomic.Bool
+
+// Draining reports whether the service is shutting down
+func Draining() bool {
+	return draining.Load()
+}
+
+// Run serves until SIGINT or SIGTERM and then shuts down in order:
+//
+//  1. it keeps serving for cfg.DrainPeriod while Draining reports true, so the
+//     load balancer takes the instance out before its connections close
+//  2. the server stops accepting connections and waits for the requests in flight
+//  3. the stoppers run in the given order
+//
+// Steps 2 and 3 share cfg.ShutdownTimeout. A second signal exits right away
+func Run(cfg config.Server, server *http.Server, stoppers ...Stopper) error {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	failed := make(chan error, 1)
+	go func() {
+		log.Infof("Starting server on %s", server.Addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		// the server never started, the workers still have to stop
+		return errors.Join(fmt.Errorf("http server: %w", err), shutdown(cfg, nil, stoppers))
+	case received := <-signals:
+		log.Infof("Received %s, draining for %s", received, cfg.DrainPeriod)
+	}
+
+	draining.Store(true)
+	go func() {
+		<-signals
+		log.Warn("Received a second signal, exiting without finishing the shutdown")
+		os.Exit(1)
+	}()
+	time.Sleep(time.Duration(cfg.DrainPeriod))
+
+	return shutdown(cfg, server, stoppers)
+}
+
+// shutdown stops the server, if any, and then the stoppers. A step that fails
+// or times out doesn't keep the next ones from running
+func shutdown(cfg config.Server, server *http.Server, stoppers []Stopper) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+
+	var errs []error
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("http server: %w", err))
+		} else {
+			log.Info("HTTP server stopped")
+		}
+	}
+	for _, stopper := range stoppers {
+		if err := stopper.Stop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", stopper.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
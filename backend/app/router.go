@@ -1,9 +1,15 @@
 package app
 
 import (
+	"backend/config"
+	"backend/controllers"
+	"backend/services"
+	"fmt"
+	"net/http"
+	"time"
+
 	_ "github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	log "github.com/sirupsen/logrus"
 )
 
 var (
@@ -14,9 +20,29 @@ func init() {
 	router = gin.New()
 }
 
-func StartRoute() {
-	mapUrls()
+// Services are the services the controllers are built with
+type Services struct {
+	Users       *services.UserService
+	Invitations *services.InvitationService
+	Federation  *services.FederationService
+	OAuth       *services.OAuthService
+	Scim        *services.ScimService
+	Outbox      *services.EmailOutbox
+	Health      *services.HealthService
+}
+
+// NewServer maps the routes and returns the HTTP server of cfg, started by Run
+func NewServer(cfg config.Server, services Services) *http.Server {
+	controllers.ShowInternalErrors(cfg.Development())
+	mapUrls(cfg, services)
 
-	log.Info("Starting server")
-	router.Run(":8080")
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           router,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
 }
@@ -1,33 +1,114 @@
 package app
 
 import (
+	"backend/config"
 	"backend/controllers"
+	"backend/utils"
 	"time"
 
 	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
 )
 
-func mapUrls() {
+func mapUrls(cfg config.Server, services Services) {
+	users := controllers.NewUserController(services.Users)
+	invitations := controllers.NewInvitationController(services.Invitations)
+	federation := controllers.NewFederationController(services.Federation)
+	oauth := controllers.NewOAuthController(services.OAuth)
+	scim := controllers.NewScimController(services.Scim, services.Users)
+	emails := controllers.NewEmailController(services.Outbox)
+	health := controllers.NewHealthController(services.Health, Draining)
+
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowOrigins:     cfg.CORSOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour, //almacena la configuracion de CORS por 12 horas
 	}))
+	router.Use(gin.CustomRecovery(controllers.Recover)) // un panic responde 500 en vez de cortar la conexion
+	router.Use(controllers.RenderErrors)                // todos los errores salen como application/problem+json
+	router.NoRoute(controllers.NotFound)                // rutas inexistentes
+
+	// Probes of the orchestrator and the load balancer
+	router.GET("/healthz", health.Liveness) // The process is up
+	router.GET("/readyz", health.Readiness) // Database, migrations and optionally SMTP answer, 503 while draining
 
 	// Public endpoints (no authentication required)
-	router.POST("/users/register", controllers.Register)                   // Register new user
-	router.POST("/users/verify-email", controllers.VerifyEmail)            // Verify email with code
-	router.POST("/users/resend-code", controllers.ResendVerificationCode) // Resend verification code
-	router.POST("/users/login", controllers.Login)                         // Login with credentials
-	router.POST("/users/refresh-token", controllers.RefreshToken)         // Refresh access token
+	router.POST("/users/register", users.Register)                         // Register new user
+	router.POST("/users/verify-email", users.VerifyEmail)                  // Verify email with code
+	router.POST("/users/resend-code", users.ResendVerificationCode)        // Resend verification code
+	router.POST("/users/login", users.Login)                               // Login with credentials
+	router.POST("/users/refresh-token", users.RefreshToken)                // Refresh access token
+	router.POST("/users/forgot-password", users.ForgotPassword)            // Email a password reset code
+	router.POST("/users/reset-password", users.ResetPassword)              // Set a new password with the reset code
+	router.GET("/users/invitations/accept", invitations.PreviewInvitation) // Email and role of an invitation link
+	router.POST("/users/invitations/accept", invitations.AcceptInvitation) // Create the invited account and log in
+
+	// OAuth 2.0 endpoints
+	router.POST("/oauth/token", oauth.Token)            // Issue tokens (client_credentials, authorization_code, refresh_token)
+	router.GET("/oauth/authorize", oauth.AuthorizeForm) // Login and consent page (authorization code + PKCE)
+	router.POST("/oauth/authorize", oauth.Authorize)    // Login and consent form submission
+
+	// Federated login with external identity providers
+	router.GET("/auth/providers", federation.GetIdentityProviders)       // List configured providers
+	router.GET("/auth/:provider/login", federation.FederatedLogin)       // Redirect to the provider
+	router.GET("/auth/:provider/callback", federation.FederatedCallback) // Provider callback, returns the token pair
+
+	// OpenID Connect endpoints
+	router.GET("/.well-known/openid-configuration", controllers.OpenIDConfiguration) // Discovery document
+	router.GET("/oauth/jwks", controllers.JWKS)                                      // Public keys for ID tokens
+	router.GET("/userinfo", users.VerifyToken, oauth.UserInfo)                       // Claims of the token holder
+	router.POST("/userinfo", users.VerifyToken, oauth.UserInfo)                      // Same, for clients that POST
 
 	// Protected endpoints (authentication required)
-	router.GET("/users/:id", controllers.VerifyToken, controllers.GetUserByID) // Get user by ID
+	router.GET("/users/:id", users.VerifyToken, controllers.RequireScope(utils.ScopeUsersRead), users.GetUserByID)      // Get user by ID
+	router.POST("/users/batch", users.VerifyToken, controllers.RequireScope(utils.ScopeUsersRead), users.GetUsersBatch) // Get public profiles for up to 100 IDs
+
+	// Password change and preferences (user session required)
+	router.POST("/users/me/password", users.VerifyToken, controllers.RequireUserSession, users.ChangePassword) // Change own password
+	router.PUT("/users/me/locale", users.VerifyToken, controllers.RequireUserSession, users.UpdateLocale)      // Language of messages and emails
+
+	// Personal access tokens (user session required)
+	router.POST("/users/me/tokens", users.VerifyToken, controllers.RequireUserSession, users.CreateAccessToken)             // Create a token (shown once)
+	router.GET("/users/me/tokens", users.VerifyToken, controllers.RequireUserSession, users.GetAccessTokens)                // List own tokens
+	router.DELETE("/users/me/tokens/:token_id", users.VerifyToken, controllers.RequireUserSession, users.RevokeAccessToken) // Revoke a token
+
+	// Invitations (admins and the roles in INVITATION_ROLES)
+	router.POST("/users/invitations", users.VerifyToken, controllers.RequireUserSession, invitations.CreateInvitation)                  // Invite an email with a role
+	router.GET("/users/invitations", users.VerifyToken, controllers.RequireUserSession, invitations.GetInvitations)                     // List sent invitations (all for admins)
+	router.DELETE("/users/invitations/:invitation_id", users.VerifyToken, controllers.RequireUserSession, invitations.RevokeInvitation) // Revoke a pending invitation
+
+	// SCIM 2.0 provisioning (discovery is public, resources need a client token with the scim scope)
+	router.GET("/scim/v2/ServiceProviderConfig", controllers.GetScimServiceProviderConfig) // Supported SCIM features
+	router.GET("/scim/v2/Schemas", controllers.GetScimSchemas)                             // User and Group schemas
+	router.GET("/scim/v2/Schemas/:id", controllers.GetScimSchemas)                         // A single schema by URN
+	router.GET("/scim/v2/ResourceTypes", controllers.GetScimResourceTypes)                 // User and Group resource types
+	router.GET("/scim/v2/ResourceTypes/:id", controllers.GetScimResourceTypes)             // A single resource type
+	router.GET("/scim/v2/Users", scim.VerifyScimToken, scim.ListScimUsers)                 // List users (filter, startIndex, count)
+	router.POST("/scim/v2/Users", scim.VerifyScimToken, scim.CreateScimUser)               // Provision a user
+	router.GET("/scim/v2/Users/:id", scim.VerifyScimToken, scim.GetScimUser)               // Get a user
+	router.PUT("/scim/v2/Users/:id", scim.VerifyScimToken, scim.ReplaceScimUser)           // Replace a user
+	router.PATCH("/scim/v2/Users/:id", scim.VerifyScimToken, scim.PatchScimUser)           // Partially update a user
+	router.DELETE("/scim/v2/Users/:id", scim.VerifyScimToken, scim.DeleteScimUser)         // Delete a user
+	router.GET("/scim/v2/Groups", scim.VerifyScimToken, scim.ListScimGroups)               // List groups (filter, startIndex, count)
+	router.POST("/scim/v2/Groups", scim.VerifyScimToken, scim.CreateScimGroup)             // Create a group
+	router.GET("/scim/v2/Groups/:id", scim.VerifyScimToken, scim.GetScimGroup)             // Get a group
+	router.PUT("/scim/v2/Groups/:id", scim.VerifyScimToken, scim.ReplaceScimGroup)         // Replace a group
+	router.PATCH("/scim/v2/Groups/:id", scim.VerifyScimToken, scim.PatchScimGroup)         // Add or remove members
+	router.DELETE("/scim/v2/Groups/:id", scim.VerifyScimToken, scim.DeleteScimGroup)       // Delete a group
 
-	// Admin endpoints (admin authentication required)
-	router.GET("/users/admin", controllers.VerifyAdminToken)                       // Verify admin token
-	router.POST("/users/promote-admin", controllers.VerifyAdminToken, controllers.PromoteToAdmin) // Promote user to admin (admin only)
+	// Admin endpoints (admin session, or a personal token of an admin with the scope)
+	router.GET("/users/admin", users.VerifyAdminToken(utils.ScopeAdminRead))                                                             // Verify admin token
+	router.GET("/users/admin/stats", users.VerifyAdminToken(utils.ScopeAdminRead), users.GetUsageStats)                                  // Usage statistics (admin only)
+	router.POST("/users/admin/import", users.VerifyAdminToken(utils.ScopeUsersImport), users.ImportUsers)                                // Bulk import from CSV, dry run by default (admin only)
+	router.GET("/users/admin/registration-policy", users.VerifyAdminToken(utils.ScopeAdminRead), users.GetRegistrationPolicy)            // Registration mode and domain lists (admin only)
+	router.POST("/users/admin/registration-policy/reload", users.VerifyAdminToken(utils.ScopeAdminWrite), users.ReloadDisposableDomains) // Reload the disposable domains blocklist (admin only)
+	router.GET("/users/admin/emails", users.VerifyAdminToken(utils.ScopeAdminRead), emails.GetOutboxEmails)                              // Queued emails by status, failed by default (admin only)
+	router.POST("/users/admin/emails/:email_id/resend", users.VerifyAdminToken(utils.ScopeAdminWrite), emails.ResendOutboxEmail)         // Queue a failed email again (admin only)
+	router.POST("/users/promote-admin", users.VerifyAdminToken(utils.ScopeAdminWrite), users.PromoteToAdmin)                             // Promote user to admin (admin only)
+	router.POST("/oauth/clients", users.VerifyAdminToken(utils.ScopeAdminWrite), oauth.CreateOAuthClient)                                // Register a service client (admin only)
+	router.GET("/oauth/clients", users.VerifyAdminToken(utils.ScopeAdminRead), oauth.GetOAuthClients)                                    // List service clients (admin only)
+	router.DELETE("/oauth/clients/:client_id", users.VerifyAdminToken(utils.ScopeAdminWrite), oauth.RevokeOAuthClient)                   // Revoke a service client (admin only)
 }
@@ -0,0 +1,75 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AccessTokenRepository stores the personal access tokens with GORM
+type AccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAccessTokenRepository(db *gorm.DB) AccessTokenRepository {
+	return AccessTokenRepository{db: db}
+}
+
+// Create stores a new personal access token
+func (r AccessTokenRepository) Create(token model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	result := r.db.Create(&token)
+	if result.Error != nil {
+		return model.PersonalAccessToken{}, fmt.Errorf("failed to create access token: %w", result.Error)
+	}
+	return token, nil
+}
+
+// GetByHash gets a token by the hash of its value
+func (r AccessTokenRepository) GetByHash(tokenHash string) (model.PersonalAccessToken, error) {
+	var token model.PersonalAccessToken
+	query := r.db.Where("token_hash = ?", tokenHash).First(&token)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.PersonalAccessToken{}, gorm.ErrRecordNotFound
+		}
+		return model.PersonalAccessToken{}, fmt.Errorf("failed to get access token: %w", query.Error)
+	}
+	return token, nil
+}
+
+// ListByUser lists every token owned by a user, newest first
+func (r AccessTokenRepository) ListByUser(userID int) ([]model.PersonalAccessToken, error) {
+	var tokens []model.PersonalAccessToken
+	query := r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&tokens)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get access tokens: %w", query.Error)
+	}
+	return tokens, nil
+}
+
+// Revoke revokes one of the user's tokens
+func (r AccessTokenRepository) Revoke(userID int, tokenID int, at time.Time) error {
+	result := r.db.Model(&model.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke access token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// TouchLastUsed records the use of a token, throttled by model.ActivityThrottle
+func (r AccessTokenRepository) TouchLastUsed(tokenID int, at time.Time) error {
+	result := r.db.Model(&model.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", tokenID, model.ActivityCutoff(at)).
+		Update("last_used_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update access token last use: %w", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxRepository stores the queued emails with GORM
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return OutboxRepository{db: db}
+}
+
+// Create stores an email to be sent by the outbox workers
+func (r OutboxRepository) Create(email model.OutboxEmail) (model.OutboxEmail, error) {
+	result := r.db.Create(&email)
+	if result.Error != nil {
+		return model.OutboxEmail{}, fmt.Errorf("failed to enqueue email: %w", result.Error)
+	}
+	return email, nil
+}
+
+// Claim takes up to limit emails that are due, or whose worker
+// lease ran out, leasing them until now+lease. An email is only claimed by
+// one worker even with several instances sharing the database
+func (r OutboxRepository) Claim(now time.Time, lease time.Duration, limit int) ([]model.OutboxEmail, error) {
+	due := "(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)"
+	dueArgs := []interface{}{model.EmailStatusPending, now, model.EmailStatusSending, now}
+
+	var candidates []model.OutboxEmail
+	if err := r.db.Where(due, dueArgs...).Order("next_attempt_at, id").Limit(limit).Find(&candidates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get due emails: %w", err)
+	}
+
+	lockedUntil := now.Add(lease)
+	claimed := make([]model.OutboxEmail, 0, len(candidates))
+	for _, email := range candidates {
+		result := r.db.Model(&model.OutboxEmail{}).
+			Where("id = ?", email.ID).
+			Where(due, dueArgs...).
+			Updates(map[string]interface{}{"status": model.EmailStatusSending, "locked_until": lockedUntil})
+		if result.Error != nil {
+			return claimed, fmt.Errorf("failed to claim email: %w", result.Error)
+		}
+		// taken by another worker in the meantime
+		if result.RowsAffected == 0 {
+			continue
+		}
+		email.Status = model.EmailStatusSending
+		email.LockedUntil = &lockedUntil
+		claimed = append(claimed, email)
+	}
+	return claimed, nil
+}
+
+// MarkSent records the delivery and drops the bodies, so the codes
+// and links they carry don't stay in the database
+func (r OutboxRepository) MarkSent(id int, attempts int, at time.Time) error {
+	return r.update(id, map[string]interface{}{
+		"status":       model.EmailStatusSent,
+		"attempts":     attempts,
+		"sent_at":      at,
+		"locked_until": nil,
+		"last_error":   "",
+		"text_body":    "",
+		"html_body":    "",
+	})
+}
+
+// Retry puts an email that failed back in the queue until nextAttemptAt
+func (r OutboxRepository) Retry(id int, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return r.update(id, map[string]interface{}{
+		"status":          model.EmailStatusPending,
+		"attempts":        attempts,
+		"next_attempt_at": nextAttemptAt,
+		"locked_until":    nil,
+		"last_error":      lastError,
+	})
+}
+
+// Fail moves an email to the dead letter state, where it stays
+// until an admin re-sends it. dropBody clears the bodies of the emails that
+// can't be re-sent, so their codes don't stay in the database
+func (r OutboxRepository) Fail(id int, attempts int, lastError string, dropBody bool) error {
+	fields := map[string]interface{}{
+		"status":       model.EmailStatusFailed,
+		"attempts":     attempts,
+		"locked_until": nil,
+		"last_error":   lastError,
+	}
+	if dropBody {
+		fields["text_body"] = ""
+		fields["html_body"] = ""
+	}
+	return r.update(id, fields)
+}
+
+func (r OutboxRepository) update(id int, fields map[string]interface{}) error {
+	result := r.db.Model(&model.OutboxEmail{}).Where("id = ?", id).Updates(fields)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update email %d: %w", id, result.Error)
+	}
+	return nil
+}
+
+// GetByID gets a queued email
+func (r OutboxRepository) GetByID(id int) (model.OutboxEmail, error) {
+	var email model.OutboxEmail
+	if err := r.db.First(&email, id).Error; err != nil {
+		return model.OutboxEmail{}, err
+	}
+	return email, nil
+}
+
+// List lists the emails with a status, newest first
+func (r OutboxRepository) List(status string, limit int) ([]model.OutboxEmail, error) {
+	var emails []model.OutboxEmail
+	query := r.db.Where("status = ?", status).Order("id DESC").Limit(limit).Find(&emails)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get emails: %w", query.Error)
+	}
+	return emails, nil
+}
+
+// Requeue sends a failed email again from scratch. It fails with
+// gorm.ErrRecordNotFound if the email doesn't exist or isn't failed
+func (r OutboxRepository) Requeue(id int, now time.Time) (model.OutboxEmail, error) {
+	result := r.db.Model(&model.OutboxEmail{}).
+		Where("id = ? AND status = ?", id, model.EmailStatusFailed).
+		Updates(map[string]interface{}{
+			"status":          model.EmailStatusPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+		})
+	if result.Error != nil {
+		return model.OutboxEmail{}, fmt.Errorf("failed to requeue email: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return model.OutboxEmail{}, gorm.ErrRecordNotFound
+	}
+
+	var email model.OutboxEmail
+	if err := r.db.First(&email, id).Error; err != nil {
+		return model.OutboxEmail{}, fmt.Errorf("failed to get email: %w", err)
+	}
+	return email, nil
+}
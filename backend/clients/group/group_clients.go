@@ -0,0 +1,186 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GroupRepository stores the groups and their members with GORM. The
+// searches take SQL, built from the SCIM filters
+type GroupRepository struct {
+	db *gorm.DB
+}
+
+func NewGroupRepository(db *gorm.DB) GroupRepository {
+	return GroupRepository{db: db}
+}
+
+// Create stores a group and its members in a single transaction
+func (r GroupRepository) Create(group model.UserGroup, memberIDs []int) (model.UserGroup, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return fmt.Errorf("failed to create group: %w", err)
+		}
+		return addMembers(tx, group.ID, memberIDs)
+	})
+	if err != nil {
+		return model.UserGroup{}, err
+	}
+	return group, nil
+}
+
+// GetByID gets a group by ID
+func (r GroupRepository) GetByID(id int) (model.UserGroup, error) {
+	var group model.UserGroup
+	query := r.db.Where("id = ?", id).First(&group)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.UserGroup{}, gorm.ErrRecordNotFound
+		}
+		return model.UserGroup{}, fmt.Errorf("failed to get group by id: %w", query.Error)
+	}
+	return group, nil
+}
+
+// GetByDisplayName gets a group by its unique name
+func (r GroupRepository) GetByDisplayName(displayName string) (model.UserGroup, error) {
+	var group model.UserGroup
+	query := r.db.Where("display_name = ?", displayName).First(&group)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.UserGroup{}, gorm.ErrRecordNotFound
+		}
+		return model.UserGroup{}, fmt.Errorf("failed to get group by name: %w", query.Error)
+	}
+	return group, nil
+}
+
+// Search pages through the groups matching where (empty for all),
+// ordered by ID, and returns the total count of matches
+func (r GroupRepository) Search(where string, args []interface{}, offset int, limit int) ([]model.UserGroup, int64, error) {
+	query := r.db.Model(&model.UserGroup{})
+	if where != "" {
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count groups: %w", err)
+	}
+
+	groups := []model.UserGroup{}
+	if limit > 0 {
+		if err := query.Order("id").Offset(offset).Limit(limit).Find(&groups).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to search groups: %w", err)
+		}
+	}
+	return groups, total, nil
+}
+
+// GetMembers returns the members of the given groups, ordered by group and user
+func (r GroupRepository) GetMembers(groupIDs []int) ([]model.GroupMember, error) {
+	var members []model.GroupMember
+	query := r.db.Where("group_id IN ?", groupIDs).Order("group_id, user_id").Find(&members)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", query.Error)
+	}
+	return members, nil
+}
+
+// GetMemberships returns the groups of the given users
+func (r GroupRepository) GetMemberships(userIDs []int) ([]model.GroupMembership, error) {
+	var memberships []model.GroupMembership
+	query := r.db.Model(&model.GroupMember{}).
+		Select("group_members.user_id, group_members.group_id, user_groups.display_name").
+		Joins("JOIN user_groups ON user_groups.id = group_members.group_id").
+		Where("group_members.user_id IN ?", userIDs).
+		Order("user_groups.display_name").
+		Scan(&memberships)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get group memberships: %w", query.Error)
+	}
+	return memberships, nil
+}
+
+// Update saves the group attributes, leaving the members as they are
+func (r GroupRepository) Update(group model.UserGroup) error {
+	if err := r.db.Save(&group).Error; err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+	return nil
+}
+
+// Replace saves the group attributes and replaces its whole member list
+func (r GroupRepository) Replace(group model.UserGroup, memberIDs []int) (model.UserGroup, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&model.GroupMember{}).Error; err != nil {
+			return fmt.Errorf("failed to clear group members: %w", err)
+		}
+		return addMembers(tx, group.ID, memberIDs)
+	})
+	if err != nil {
+		return model.UserGroup{}, err
+	}
+	return group, nil
+}
+
+// UpdateMembers adds and removes members without touching the rest
+func (r GroupRepository) UpdateMembers(groupID int, add []int, remove []int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if len(remove) > 0 {
+			err := tx.Where("group_id = ? AND user_id IN ?", groupID, remove).Delete(&model.GroupMember{}).Error
+			if err != nil {
+				return fmt.Errorf("failed to remove group members: %w", err)
+			}
+		}
+		if err := addMembers(tx, groupID, add); err != nil {
+			return err
+		}
+		// membership changes count as a change of the group
+		err := tx.Model(&model.UserGroup{}).Where("id = ?", groupID).Update("updated_at", time.Now()).Error
+		if err != nil {
+			return fmt.Errorf("failed to update group: %w", err)
+		}
+		return nil
+	})
+}
+
+// Delete removes a group and its memberships
+func (r GroupRepository) Delete(id int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", id).Delete(&model.GroupMember{}).Error; err != nil {
+			return fmt.Errorf("failed to delete group members: %w", err)
+		}
+		result := tx.Delete(&model.UserGroup{}, id)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete group: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// addMembers inserts memberships, ignoring the ones that already exist
+func addMembers(tx *gorm.DB, groupID int, userIDs []int) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	members := make([]model.GroupMember, 0, len(userIDs))
+	for _, userID := range userIDs {
+		members = append(members, model.GroupMember{GroupID: groupID, UserID: userID})
+	}
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+	if err != nil {
+		return fmt.Errorf("failed to add group members: %w", err)
+	}
+	return nil
+}
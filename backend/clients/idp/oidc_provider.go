@@ -0,0 +1,372 @@
+package clients
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCConfig configures an external OpenID Connect provider
+type OIDCConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string     // defaults to openid email profile
+	HTTPClient   *http.Client // defaults to a client with a 10 second timeout
+}
+
+// OIDCProvider runs the authorization code flow against an OpenID Connect
+// provider, found through its discovery document
+type OIDCProvider struct {
+	config OIDCConfig
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// oidcClaims are the ID token and userinfo claims we use. email_verified is
+// decoded loosely because some providers send it as a string
+type oidcClaims struct {
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	GivenName     string      `json:"given_name"`
+	FamilyName    string      `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+// NewOIDCProvider creates a provider. Discovery happens lazily on first use
+func NewOIDCProvider(config OIDCConfig) *OIDCProvider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{config: config}
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	target, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization endpoint: %w", err)
+	}
+
+	query := target.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	target.RawQuery = query.Encode()
+
+	return target.String(), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (Identity, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to build token request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var tokens oidcTokenResponse
+	status, err := p.doJSON(request, &tokens)
+	if err != nil {
+		return Identity{}, fmt.Errorf("token request failed: %w", err)
+	}
+	if status != http.StatusOK || tokens.IDToken == "" {
+		return Identity{}, fmt.Errorf("token request rejected (%d): %s %s", status, tokens.Error, tokens.Description)
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokens.IDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if claims.Nonce != nonce {
+		return Identity{}, fmt.Errorf("id token nonce does not match")
+	}
+
+	// Some providers only put the email in the userinfo response
+	if claims.Email == "" && discovery.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		if info, err := p.getUserInfo(ctx, discovery.UserinfoEndpoint, tokens.AccessToken); err == nil && info.Subject == claims.Subject {
+			claims.Email = info.Email
+			claims.EmailVerified = info.EmailVerified
+			if claims.GivenName == "" {
+				claims.GivenName = info.GivenName
+			}
+			if claims.FamilyName == "" {
+				claims.FamilyName = info.FamilyName
+			}
+		}
+	}
+
+	return Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience and expiration
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, idToken string) (*oidcClaims, error) {
+	claims := &oidcClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.getKey(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid id token: missing sub")
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) getUserInfo(ctx context.Context, endpoint string, accessToken string) (*oidcClaims, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	request.Header.Set("Accept", "application/json")
+
+	info := &oidcClaims{}
+	status, err := p.doJSON(request, info)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("userinfo request rejected (%d)", status)
+	}
+	return info, nil
+}
+
+// getDiscovery fetches and caches the discovery document
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+
+	var discovery oidcDiscovery
+	status, err := p.doJSON(request, &discovery)
+	if err != nil {
+		return nil, fmt.Errorf("discovery of %s failed: %w", p.config.Issuer, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("discovery of %s failed with status %d", p.config.Issuer, status)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery issuer %s does not match %s", discovery.Issuer, p.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JwksURI == "" {
+		return nil, fmt.Errorf("discovery of %s is missing endpoints", p.config.Issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// getKey returns the signing key with the given ID, refreshing the key set
+// once when the ID is unknown since providers rotate their keys
+func (p *OIDCProvider) getKey(ctx context.Context, kid string) (interface{}, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, discovery.JwksURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	status, err := p.doJSON(request, &set)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch jwks: status %d", status)
+	}
+
+	p.keys = map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a cached key. Without kid it only succeeds if there is a single key
+func (p *OIDCProvider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *OIDCProvider) doJSON(request *http.Request, target interface{}) (int, error) {
+	response, err := p.config.HTTPClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return response.StatusCode, err
+	}
+	if err := json.Unmarshal(body, target); err != nil && response.StatusCode == http.StatusOK {
+		return response.StatusCode, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return response.StatusCode, nil
+}
+
+// publicKey converts an RSA or EC JSON web key
+func (k oidcJWK) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
@@ -0,0 +1,74 @@
+package clients
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"backend/config"
+)
+
+// Identity is what an external identity provider tells us about a user
+type Identity struct {
+	Subject       string // stable user ID at the provider
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider is an external identity provider able to run the authorization code flow
+type Provider interface {
+	// Name identifies the provider in URLs and in linked identities
+	Name() string
+	// AuthCodeURL is where the user is sent to log in
+	AuthCodeURL(ctx context.Context, state string, nonce string, codeChallenge string) (string, error)
+	// Exchange trades the authorization code for the user's identity
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (Identity, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// Register makes a provider available, replacing any provider with the same name
+func Register(provider Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[provider.Name()] = provider
+}
+
+// GetProvider returns a registered provider by name
+func GetProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	provider, ok := providers[name]
+	return provider, ok
+}
+
+// GetProviderNames lists the registered providers, sorted
+func GetProviderNames() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadProviders registers the OpenID Connect providers of the federation section
+func LoadProviders(cfg config.Federation) {
+	for _, provider := range cfg.Providers {
+		Register(NewOIDCProvider(OIDCConfig{
+			Name:         provider.Name,
+			Issuer:       provider.Issuer,
+			ClientID:     provider.ClientID,
+			ClientSecret: provider.ClientSecret,
+			RedirectURL:  provider.RedirectURL,
+			Scopes:       provider.Scopes,
+		}))
+	}
+}
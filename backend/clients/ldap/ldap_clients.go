@@ -0,0 +1,177 @@
+package clients
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrUserNotFound is returned when the search finds no entry for the user
+var ErrUserNotFound = errors.New("ldap user not found")
+
+// ErrInvalidCredentials is returned when the bind as the user fails
+var ErrInvalidCredentials = errors.New("ldap invalid credentials")
+
+// Config describes how to reach the directory and map its attributes
+type Config struct {
+	URL                string        // ldap://host:389 or ldaps://host:636
+	StartTLS           bool          // upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify bool          // only for development
+	CAFile             string        // PEM bundle to verify the server certificate
+	BindDN             string        // service account used to search, empty for anonymous
+	BindPassword       string        // password of the service account
+	BaseDN             string        // where users are searched
+	UserFilter         string        // %s is replaced by the escaped login, e.g. (mail=%s)
+	EmailAttribute     string        // defaults to mail
+	FirstNameAttribute string        // defaults to givenName
+	LastNameAttribute  string        // defaults to sn
+	Timeout            time.Duration // dial and operation timeout
+}
+
+// Entry is the directory user after a successful bind
+type Entry struct {
+	DN        string
+	Email     string
+	FirstName string
+	LastName  string
+}
+
+// Directory authenticates users against an LDAP or Active Directory server
+// with search-then-bind
+type Directory struct {
+	config Config
+}
+
+// NewDirectory creates a directory client, filling in the default attributes
+func NewDirectory(config Config) *Directory {
+	if config.UserFilter == "" {
+		config.UserFilter = "(mail=%s)"
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.FirstNameAttribute == "" {
+		config.FirstNameAttribute = "givenName"
+	}
+	if config.LastNameAttribute == "" {
+		config.LastNameAttribute = "sn"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &Directory{config: config}
+}
+
+// Authenticate finds the user entry with the service account and then binds
+// as that entry with the given password
+func (d *Directory) Authenticate(login string, password string) (Entry, error) {
+	// An empty password would be an unauthenticated bind, which many servers accept
+	if password == "" {
+		return Entry{}, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return Entry{}, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		err = conn.Bind(d.config.BindDN, d.config.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to bind ldap service account: %w", err)
+	}
+
+	search := ldap.NewSearchRequest(
+		d.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.config.Timeout.Seconds()), false,
+		strings.ReplaceAll(d.config.UserFilter, "%s", ldap.EscapeFilter(login)),
+		[]string{d.config.EmailAttribute, d.config.FirstNameAttribute, d.config.LastNameAttribute},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return Entry{}, fmt.Errorf("failed to search ldap user: %w", err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return Entry{}, ErrUserNotFound
+	}
+	if len(result.Entries) > 1 {
+		return Entry{}, fmt.Errorf("ldap filter matched more than one user")
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Entry{}, ErrInvalidCredentials
+		}
+		return Entry{}, fmt.Errorf("failed to bind ldap user: %w", err)
+	}
+
+	return Entry{
+		DN:        entry.DN,
+		Email:     entry.GetAttributeValue(d.config.EmailAttribute),
+		FirstName: entry.GetAttributeValue(d.config.FirstNameAttribute),
+		LastName:  entry.GetAttributeValue(d.config.LastNameAttribute),
+	}, nil
+}
+
+// connect dials the server, with TLS for ldaps:// and StartTLS when configured
+func (d *Directory) connect() (*ldap.Conn, error) {
+	tlsConfig, err := d.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: d.config.Timeout}
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithTLSConfig(tlsConfig), ldap.DialWithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ldap: %w", err)
+	}
+	conn.SetTimeout(d.config.Timeout)
+
+	if d.config.StartTLS && strings.HasPrefix(strings.ToLower(d.config.URL), "ldap://") {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (d *Directory) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: d.config.InsecureSkipVerify,
+	}
+
+	if d.config.CAFile != "" {
+		pem, err := os.ReadFile(d.config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ldap CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ldap CA file")
+		}
+		config.RootCAs = pool
+	}
+
+	// the server name is needed to verify the certificate
+	if parsed, err := url.Parse(d.config.URL); err == nil {
+		config.ServerName = parsed.Hostname()
+	}
+	return config, nil
+}
@@ -0,0 +1,261 @@
+// Package ldaptest is an in-process LDAP server for the tests of the
+// directory login, like net/http/httptest for HTTP. It answers simple binds
+// and searches over a fixed list of entries, enough for search-then-bind
+package ldaptest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is a directory entry. Password is the one of its simple bind
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server serves its entries on a local port until Close
+type Server struct {
+	// URL is the ldap:// address to dial
+	URL string
+
+	listener net.Listener
+	entries  []Entry
+
+	mu      sync.Mutex
+	binds   []string
+	filters []string
+	conns   sync.WaitGroup
+}
+
+// NewServer starts a server with entries
+func NewServer(entries ...Entry) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{URL: "ldap://" + listener.Addr().String(), listener: listener, entries: entries}
+	go server.serve()
+	return server, nil
+}
+
+// Close stops listening and waits for the open connections to end
+func (s *Server) Close() {
+	s.listener.Close()
+	s.conns.Wait()
+}
+
+// Binds are the DNs of the bind requests received, anonymous ones included
+func (s *Server) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+// Filters are the filters of the search requests received, in their string form
+func (s *Server) Filters() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.filters...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle answers the requests of a connection until the unbind
+func (s *Server) handle(conn net.Conn) {
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			if _, err := conn.Write(s.bind(id, request).Bytes()); err != nil {
+				return
+			}
+		case ldap.ApplicationSearchRequest:
+			for _, response := range s.search(id, request) {
+				if _, err := conn.Write(response.Bytes()); err != nil {
+					return
+				}
+			}
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			// StartTLS and the other extended operations aren't supported
+			if _, err := conn.Write(result(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform, "unsupported operation").Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind accepts the password of an entry. Like most servers, an empty
+// password is an unauthenticated bind, which succeeds for any DN
+func (s *Server) bind(id int64, request *ber.Packet) *ber.Packet {
+	if len(request.Children) < 3 {
+		return result(id, ldap.ApplicationBindResponse, ldap.LDAPResultProtocolError, "malformed bind")
+	}
+	dn := request.Children[1].Data.String()
+	password := request.Children[2].Data.String()
+
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	s.mu.Unlock()
+
+	if password == "" {
+		return result(id, ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")
+	}
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return result(id, ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")
+		}
+	}
+	return result(id, ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials, "invalid credentials")
+}
+
+// search returns the entries under the base DN that match the filter, with
+// the requested attributes, and the final result
+func (s *Server) search(id int64, request *ber.Packet) []*ber.Packet {
+	if len(request.Children) < 8 {
+		return []*ber.Packet{result(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, "malformed search")}
+	}
+	base := strings.ToLower(request.Children[0].Data.String())
+	filter := request.Children[6]
+	var wanted []string
+	for _, attribute := range request.Children[7].Children {
+		wanted = append(wanted, attribute.Data.String())
+	}
+
+	text, err := ldap.DecompileFilter(filter)
+	if err != nil {
+		return []*ber.Packet{result(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, err.Error())}
+	}
+	s.mu.Lock()
+	s.filters = append(s.filters, text)
+	s.mu.Unlock()
+
+	var responses []*ber.Packet
+	for _, entry := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), base) || !matches(filter, entry) {
+			continue
+		}
+		responses = append(responses, searchEntry(id, entry, wanted))
+	}
+	return append(responses, result(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, ""))
+}
+
+// matches evaluates the and, or, equality and presence filters. Attribute
+// names are compared without case and values exactly
+func matches(filter *ber.Packet, entry Entry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, value := range entry.values(filter.Children[0].Data.String()) {
+			if value == filter.Children[1].Data.String() {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(entry.values(filter.Data.String())) > 0
+	}
+	return false
+}
+
+func (e Entry) values(attribute string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attribute) {
+			return values
+		}
+	}
+	return nil
+}
+
+func searchEntry(id int64, entry Entry, wanted []string) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+	attributes := ber.NewSequence("Attributes")
+	for name, values := range entry.Attributes {
+		if !requested(name, wanted) {
+			continue
+		}
+		attribute := ber.NewSequence("Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	response.AppendChild(attributes)
+	return message(id, response)
+}
+
+// requested reports whether the search asked for the attribute. No list
+// means all of them
+func requested(name string, wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, attribute := range wanted {
+		if strings.EqualFold(attribute, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// result is an LDAPResult response of the operation tag
+func result(id int64, tag ber.Tag, code uint16, diagnostic string) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnostic, "Diagnostic Message"))
+	return message(id, response)
+}
+
+func message(id int64, response *ber.Packet) *ber.Packet {
+	packet := ber.NewSequence("LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	packet.AppendChild(response)
+	return packet
+}
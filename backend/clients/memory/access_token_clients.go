@@ -0,0 +1,91 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Create stores a new personal access token. Hashes are unique, like the
+// index of the GORM repository
+func (r AccessTokenRepository) Create(token model.PersonalAccessToken) (model.PersonalAccessToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, stored := range r.store.accessTokens {
+		if stored.TokenHash == token.TokenHash {
+			return model.PersonalAccessToken{}, fmt.Errorf("failed to create access token: duplicated hash")
+		}
+	}
+	r.store.lastAccessTokenID++
+	token.ID = r.store.lastAccessTokenID
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	r.store.accessTokens[token.ID] = token
+	return token, nil
+}
+
+// GetByHash gets a token by the hash of its value
+func (r AccessTokenRepository) GetByHash(tokenHash string) (model.PersonalAccessToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, token := range r.store.accessTokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return model.PersonalAccessToken{}, gorm.ErrRecordNotFound
+}
+
+// ListByUser lists every token owned by a user, newest first
+func (r AccessTokenRepository) ListByUser(userID int) ([]model.PersonalAccessToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	tokens := []model.PersonalAccessToken{}
+	for _, token := range r.store.accessTokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// Revoke revokes one of the user's tokens. It fails with
+// gorm.ErrRecordNotFound if the token isn't the user's or is already revoked
+func (r AccessTokenRepository) Revoke(userID int, tokenID int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token, ok := r.store.accessTokens[tokenID]
+	if !ok || token.UserID != userID || token.RevokedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	token.RevokedAt = &at
+	r.store.accessTokens[tokenID] = token
+	return nil
+}
+
+// TouchLastUsed records the use of a token, throttled by model.ActivityThrottle
+func (r AccessTokenRepository) TouchLastUsed(tokenID int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token, ok := r.store.accessTokens[tokenID]
+	if ok && (token.LastUsedAt == nil || token.LastUsedAt.Before(model.ActivityCutoff(at))) {
+		token.LastUsedAt = &at
+		r.store.accessTokens[tokenID] = token
+	}
+	return nil
+}
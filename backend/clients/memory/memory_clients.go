@@ -0,0 +1,561 @@
+package clients
+
+import (
+	"backend/model"
+	"backend/utils"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Store keeps users, one-time codes, linked identities, access tokens, OAuth
+// clients and queued emails in memory, for trying the services without a
+// database. Its repositories behave like the GORM ones: emails are
+// stored canonical and unique, and lookups that find nothing fail with
+// gorm.ErrRecordNotFound
+type Store struct {
+	mu                 sync.Mutex
+	users              map[int]model.UserModel
+	tokens             map[int]model.VerificationToken
+	identities         map[int]model.FederatedIdentity
+	accessTokens       map[int]model.PersonalAccessToken
+	oauthClients       map[int]model.OAuthClient
+	authorizationCodes map[int]model.AuthorizationCode
+	emails             map[int]model.OutboxEmail
+	activity           map[model.UserActivity]bool
+	lastUserID         int
+	lastTokenID        int
+	lastIdentityID     int
+	lastAccessTokenID  int
+	lastOAuthClientID  int
+	lastCodeID         int
+	lastEmailID        int
+}
+
+func NewStore() *Store {
+	return &Store{
+		users:              map[int]model.UserModel{},
+		tokens:             map[int]model.VerificationToken{},
+		identities:         map[int]model.FederatedIdentity{},
+		accessTokens:       map[int]model.PersonalAccessToken{},
+		oauthClients:       map[int]model.OAuthClient{},
+		authorizationCodes: map[int]model.AuthorizationCode{},
+		emails:             map[int]model.OutboxEmail{},
+		activity:           map[model.UserActivity]bool{},
+	}
+}
+
+// The repositories share the data of their Store, so a user created by one
+// is seen by the others
+type UserRepository struct{ store *Store }
+type TokenRepository struct{ store *Store }
+type SessionRepository struct{ store *Store }
+type FederatedIdentityRepository struct{ store *Store }
+type AccessTokenRepository struct{ store *Store }
+type OAuthClientRepository struct{ store *Store }
+type AuthorizationCodeRepository struct{ store *Store }
+type OutboxRepository struct{ store *Store }
+
+func (s *Store) Users() UserRepository {
+	return UserRepository{store: s}
+}
+
+func (s *Store) Tokens() TokenRepository {
+	return TokenRepository{store: s}
+}
+
+func (s *Store) Sessions() SessionRepository {
+	return SessionRepository{store: s}
+}
+
+func (s *Store) Identities() FederatedIdentityRepository {
+	return FederatedIdentityRepository{store: s}
+}
+
+func (s *Store) AccessTokens() AccessTokenRepository {
+	return AccessTokenRepository{store: s}
+}
+
+func (s *Store) OAuthClients() OAuthClientRepository {
+	return OAuthClientRepository{store: s}
+}
+
+func (s *Store) AuthorizationCodes() AuthorizationCodeRepository {
+	return AuthorizationCodeRepository{store: s}
+}
+
+func (s *Store) Outbox() OutboxRepository {
+	return OutboxRepository{store: s}
+}
+
+// GetByID gets a user by ID
+func (r UserRepository) GetByID(id int) (model.UserModel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, ok := r.store.users[id]
+	if !ok {
+		return model.UserModel{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+// GetByEmail gets a user by email address, compared in its normalized form
+func (r UserRepository) GetByEmail(email string) (model.UserModel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if user, ok := r.store.findByEmail(email); ok {
+		return user, nil
+	}
+	return model.UserModel{}, gorm.ErrRecordNotFound
+}
+
+// GetByIDs gets every existing user whose ID is in ids, ordered by ID
+func (r UserRepository) GetByIDs(ids []int) ([]model.UserModel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	users := []model.UserModel{}
+	for _, id := range ids {
+		if user, ok := r.store.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// GetByEmails gets every existing user whose email is in emails
+func (r UserRepository) GetByEmails(emails []string) ([]model.UserModel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	found := map[int]model.UserModel{}
+	for _, email := range emails {
+		if user, ok := r.store.findByEmail(email); ok {
+			found[user.ID] = user
+		}
+	}
+	users := make([]model.UserModel, 0, len(found))
+	for _, user := range found {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// Create creates a new user
+func (r UserRepository) Create(user model.UserModel) (model.UserModel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.createUser(user)
+}
+
+// CreateWithToken creates a user together with the one-time code token
+// builds for its ID
+func (r UserRepository) CreateWithToken(user model.UserModel, token func(userID int) model.VerificationToken) (model.UserModel, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	created, err := r.store.createUser(user)
+	if err != nil {
+		return model.UserModel{}, err
+	}
+	r.store.replaceToken(token(created.ID))
+	return created, nil
+}
+
+// CreateBatch creates users together with the one-time code token builds for
+// each of them, if any. A failure removes the users already created, like
+// the rollback of the GORM repository
+func (r UserRepository) CreateBatch(users []model.UserModel, token func(index int, userID int) *model.VerificationToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var created []int
+	for i, user := range users {
+		stored, err := r.store.createUser(user)
+		if err != nil {
+			for _, userID := range created {
+				delete(r.store.users, userID)
+				for id, token := range r.store.tokens {
+					if token.UserID == userID {
+						delete(r.store.tokens, id)
+					}
+				}
+			}
+			return fmt.Errorf("%s: %w", user.Email, err)
+		}
+		created = append(created, stored.ID)
+		if code := token(i, stored.ID); code != nil {
+			r.store.replaceToken(*code)
+		}
+	}
+	return nil
+}
+
+// Update updates an existing user
+func (r UserRepository) Update(user model.UserModel) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if _, ok := r.store.users[user.ID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if user.NormalizedEmail != nil {
+		if err := setNormalizedEmail(&user); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if other, ok := r.store.findByEmail(user.Email); ok && other.ID != user.ID {
+			return fmt.Errorf("failed to update user: email %s already exists", user.Email)
+		}
+	}
+	user.UpdatedAt = time.Now()
+	r.store.users[user.ID] = user
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user
+func (r UserRepository) UpdatePassword(userID int, passwordHash string) error {
+	return r.store.updateUser(userID, func(user *model.UserModel) {
+		user.PasswordHash = passwordHash
+	})
+}
+
+// UpdateLocale saves the preferred language of a user
+func (r UserRepository) UpdateLocale(userID int, locale string) error {
+	return r.store.updateUser(userID, func(user *model.UserModel) {
+		user.Locale = locale
+	})
+}
+
+// VerifyEmail marks the email of a user as verified and drops its pending
+// verification codes
+func (r UserRepository) VerifyEmail(userID int) error {
+	err := r.store.updateUser(userID, func(user *model.UserModel) {
+		user.IsVerified = true
+	})
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	r.store.deletePendingTokens(userID, model.TokenPurposeVerifyEmail)
+	return nil
+}
+
+// VerifyExternally marks the email verified by an identity provider or a
+// directory, clearing the password and dropping every pending code
+func (r UserRepository) VerifyExternally(userID int) error {
+	err := r.store.updateUser(userID, func(user *model.UserModel) {
+		user.IsVerified = true
+		user.PasswordHash = ""
+	})
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for id, token := range r.store.tokens {
+		if token.UserID == userID && token.ConsumedAt == nil {
+			delete(r.store.tokens, id)
+		}
+	}
+	return nil
+}
+
+// PromoteToAdmin promotes a user to admin status
+func (r UserRepository) PromoteToAdmin(userID int) error {
+	return r.store.updateUser(userID, func(user *model.UserModel) {
+		user.IsAdmin = true
+		user.Role = utils.RoleAdmin
+	})
+}
+
+// CountRegistrations counts users created in [from, to), optionally only the verified ones
+func (r UserRepository) CountRegistrations(from time.Time, to time.Time, onlyVerified bool) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var count int64
+	for _, user := range r.store.users {
+		if inRange(user.CreatedAt, from, to) && (!onlyVerified || user.IsVerified) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountRegistrationsPerDay groups users created in [from, to) by creation day
+func (r UserRepository) CountRegistrationsPerDay(from time.Time, to time.Time) ([]model.DailyCount, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	counts := map[string]int64{}
+	for _, user := range r.store.users {
+		if inRange(user.CreatedAt, from, to) {
+			counts[user.CreatedAt.Format("2006-01-02")]++
+		}
+	}
+
+	rows := make([]model.DailyCount, 0, len(counts))
+	for day, count := range counts {
+		rows = append(rows, model.DailyCount{Day: day, Count: count})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Day < rows[j].Day })
+	return rows, nil
+}
+
+// Replace stores a new code for the user, dropping the pending ones with the
+// same purpose so only the last code sent works
+func (r TokenRepository) Replace(token model.VerificationToken) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.replaceToken(token)
+	return nil
+}
+
+// GetPending gets the last unused code of a user for purpose
+func (r TokenRepository) GetPending(userID int, purpose string) (model.VerificationToken, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	var pending model.VerificationToken
+	for _, token := range r.store.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.ConsumedAt == nil && token.ID > pending.ID {
+			pending = token
+		}
+	}
+	if pending.ID == 0 {
+		return model.VerificationToken{}, gorm.ErrRecordNotFound
+	}
+	return pending, nil
+}
+
+// AddAttempt counts a try of an unused code. It fails with
+// gorm.ErrRecordNotFound once the code was used or tried maxAttempts times
+func (r TokenRepository) AddAttempt(tokenID int, maxAttempts int) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token, ok := r.store.tokens[tokenID]
+	if !ok || token.ConsumedAt != nil || token.Attempts >= maxAttempts {
+		return gorm.ErrRecordNotFound
+	}
+	token.Attempts++
+	r.store.tokens[tokenID] = token
+	return nil
+}
+
+// Consume marks a code as used. It fails with gorm.ErrRecordNotFound if it
+// was already used
+func (r TokenRepository) Consume(tokenID int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	token, ok := r.store.tokens[tokenID]
+	if !ok || token.ConsumedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	token.ConsumedAt = &at
+	r.store.tokens[tokenID] = token
+	return nil
+}
+
+// UpdateLastLogin records a successful login, which also counts as activity
+func (r SessionRepository) UpdateLastLogin(userID int, at time.Time) error {
+	err := r.store.updateUser(userID, func(user *model.UserModel) {
+		user.LastLoginAt = &at
+		user.LastSeenAt = &at
+		r.store.activity[model.UserActivity{UserID: userID, Day: model.ActivityDay(at)}] = true
+	})
+	// like the UPDATE of the GORM repository, a missing user is not an error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	return err
+}
+
+// TouchLastSeen records activity, throttled by model.ActivityThrottle
+func (r SessionRepository) TouchLastSeen(userID int, at time.Time) error {
+	err := r.store.updateUser(userID, func(user *model.UserModel) {
+		if user.LastSeenAt == nil || user.LastSeenAt.Before(model.ActivityCutoff(at)) {
+			user.LastSeenAt = &at
+			r.store.activity[model.UserActivity{UserID: userID, Day: model.ActivityDay(at)}] = true
+		}
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	return err
+}
+
+// CountActiveUsersBetween counts users active on any day in [from, to)
+func (r SessionRepository) CountActiveUsersBetween(from time.Time, to time.Time) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	first, last := model.ActivityDay(from), model.ActivityDay(to)
+	active := map[int]bool{}
+	for activity := range r.store.activity {
+		if activity.Day >= first && activity.Day < last {
+			active[activity.UserID] = true
+		}
+	}
+	return int64(len(active)), nil
+}
+
+// Get gets the identity linked to a provider subject
+func (r FederatedIdentityRepository) Get(provider string, subject string) (model.FederatedIdentity, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, identity := range r.store.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return model.FederatedIdentity{}, gorm.ErrRecordNotFound
+}
+
+// Create links a provider identity to a user. A subject is linked once per
+// provider, like the unique index of the GORM repository
+func (r FederatedIdentityRepository) Create(identity model.FederatedIdentity) (model.FederatedIdentity, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, linked := range r.store.identities {
+		if linked.Provider == identity.Provider && linked.Subject == identity.Subject {
+			return model.FederatedIdentity{}, fmt.Errorf("failed to create federated identity: %s %s is already linked", identity.Provider, identity.Subject)
+		}
+	}
+	r.store.lastIdentityID++
+	identity.ID = r.store.lastIdentityID
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+	r.store.identities[identity.ID] = identity
+	return identity, nil
+}
+
+// UpdateLogin records a login through a linked identity
+func (r FederatedIdentityRepository) UpdateLogin(id int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if identity, ok := r.store.identities[id]; ok {
+		identity.LastLoginAt = &at
+		r.store.identities[id] = identity
+	}
+	return nil
+}
+
+// createUser adds a user with the next ID. The caller holds the lock
+func (s *Store) createUser(user model.UserModel) (model.UserModel, error) {
+	if err := setNormalizedEmail(&user); err != nil {
+		return model.UserModel{}, fmt.Errorf("failed to create user: %w", err)
+	}
+	if _, ok := s.findByEmail(user.Email); ok {
+		return model.UserModel{}, fmt.Errorf("failed to create user: email %s already exists", user.Email)
+	}
+
+	// the defaults of the columns. GORM also applies the one of is_active to
+	// a false value, so new users are always active
+	if user.Role == "" {
+		user.Role = utils.RoleStudent
+	}
+	user.IsActive = true
+
+	s.lastUserID++
+	user.ID = s.lastUserID
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	user.UpdatedAt = now
+	s.users[user.ID] = user
+	return user, nil
+}
+
+// updateUser changes a stored user with fn
+func (s *Store) updateUser(userID int, fn func(user *model.UserModel)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	fn(&user)
+	s.users[userID] = user
+	return nil
+}
+
+// findByEmail looks a user up by the normalized form of email. The caller
+// holds the lock
+func (s *Store) findByEmail(email string) (model.UserModel, bool) {
+	normalized, err := utils.NormalizeEmail(email)
+	if err != nil {
+		return model.UserModel{}, false
+	}
+	canonical, _ := utils.CanonicalEmail(email)
+	for _, user := range s.users {
+		if user.NormalizedEmail != nil && *user.NormalizedEmail == normalized {
+			return user, true
+		}
+		if user.NormalizedEmail == nil && user.Email == canonical {
+			return user, true
+		}
+	}
+	return model.UserModel{}, false
+}
+
+// replaceToken stores token with the next ID. The caller holds the lock
+func (s *Store) replaceToken(token model.VerificationToken) {
+	s.deletePendingTokens(token.UserID, token.Purpose)
+	s.lastTokenID++
+	token.ID = s.lastTokenID
+	if token.CreatedAt.IsZero() {
+		token.CreatedAt = time.Now()
+	}
+	s.tokens[token.ID] = token
+}
+
+// deletePendingTokens drops the unused codes of a user for purpose. The
+// caller holds the lock
+func (s *Store) deletePendingTokens(userID int, purpose string) {
+	for id, token := range s.tokens {
+		if token.UserID == userID && token.Purpose == purpose && token.ConsumedAt == nil {
+			delete(s.tokens, id)
+		}
+	}
+}
+
+// setNormalizedEmail stores the email in its canonical form and fills the
+// normalized lookup key, like the GORM repository
+func setNormalizedEmail(user *model.UserModel) error {
+	canonical, err := utils.CanonicalEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	normalized, err := utils.NormalizeEmail(canonical)
+	if err != nil {
+		return err
+	}
+	user.Email = canonical
+	user.NormalizedEmail = &normalized
+	return nil
+}
+
+// inRange reports whether at falls in [from, to)
+func inRange(at time.Time, from time.Time, to time.Time) bool {
+	return !at.Before(from) && at.Before(to)
+}
@@ -0,0 +1,118 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Create registers a new OAuth client. Client IDs are unique, like the index
+// of the GORM repository
+func (r OAuthClientRepository) Create(client model.OAuthClient) (model.OAuthClient, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, stored := range r.store.oauthClients {
+		if stored.ClientID == client.ClientID {
+			return model.OAuthClient{}, fmt.Errorf("failed to create oauth client: %s already exists", client.ClientID)
+		}
+	}
+	r.store.lastOAuthClientID++
+	client.ID = r.store.lastOAuthClientID
+	if client.CreatedAt.IsZero() {
+		client.CreatedAt = time.Now()
+	}
+	r.store.oauthClients[client.ID] = client
+	return client, nil
+}
+
+// GetByClientID gets a client by its public client_id
+func (r OAuthClientRepository) GetByClientID(clientID string) (model.OAuthClient, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, client := range r.store.oauthClients {
+		if client.ClientID == clientID {
+			return client, nil
+		}
+	}
+	return model.OAuthClient{}, gorm.ErrRecordNotFound
+}
+
+// List lists every registered client
+func (r OAuthClientRepository) List() ([]model.OAuthClient, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	clients := make([]model.OAuthClient, 0, len(r.store.oauthClients))
+	for _, client := range r.store.oauthClients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients, nil
+}
+
+// Deactivate revokes a client so it can no longer request tokens
+func (r OAuthClientRepository) Deactivate(clientID string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for id, client := range r.store.oauthClients {
+		if client.ClientID == clientID {
+			client.IsActive = false
+			r.store.oauthClients[id] = client
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// Create stores a new authorization code
+func (r AuthorizationCodeRepository) Create(code model.AuthorizationCode) (model.AuthorizationCode, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, stored := range r.store.authorizationCodes {
+		if stored.CodeHash == code.CodeHash {
+			return model.AuthorizationCode{}, fmt.Errorf("failed to create authorization code: duplicated hash")
+		}
+	}
+	r.store.lastCodeID++
+	code.ID = r.store.lastCodeID
+	if code.CreatedAt.IsZero() {
+		code.CreatedAt = time.Now()
+	}
+	r.store.authorizationCodes[code.ID] = code
+	return code, nil
+}
+
+// GetByHash gets an authorization code by the hash of its value
+func (r AuthorizationCodeRepository) GetByHash(codeHash string) (model.AuthorizationCode, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	for _, code := range r.store.authorizationCodes {
+		if code.CodeHash == codeHash {
+			return code, nil
+		}
+	}
+	return model.AuthorizationCode{}, gorm.ErrRecordNotFound
+}
+
+// Consume marks a code as used. It returns gorm.ErrRecordNotFound if the code
+// was already consumed
+func (r AuthorizationCodeRepository) Consume(id int, at time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	code, ok := r.store.authorizationCodes[id]
+	if !ok || code.ConsumedAt != nil {
+		return gorm.ErrRecordNotFound
+	}
+	code.ConsumedAt = &at
+	r.store.authorizationCodes[id] = code
+	return nil
+}
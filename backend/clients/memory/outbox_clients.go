@@ -0,0 +1,159 @@
+package clients
+
+import (
+	"backend/model"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Create stores an email to be sent by the outbox workers
+func (r OutboxRepository) Create(email model.OutboxEmail) (model.OutboxEmail, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.lastEmailID++
+	email.ID = r.store.lastEmailID
+	now := time.Now()
+	if email.CreatedAt.IsZero() {
+		email.CreatedAt = now
+	}
+	email.UpdatedAt = now
+	r.store.emails[email.ID] = email
+	return email, nil
+}
+
+// Claim takes up to limit emails that are due, or whose worker lease ran
+// out, leasing them until now+lease
+func (r OutboxRepository) Claim(now time.Time, lease time.Duration, limit int) ([]model.OutboxEmail, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	due := []model.OutboxEmail{}
+	for _, email := range r.store.emails {
+		pending := email.Status == model.EmailStatusPending && !email.NextAttemptAt.After(now)
+		abandoned := email.Status == model.EmailStatusSending && email.LockedUntil != nil && email.LockedUntil.Before(now)
+		if pending || abandoned {
+			due = append(due, email)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	lockedUntil := now.Add(lease)
+	for i := range due {
+		due[i].Status = model.EmailStatusSending
+		due[i].LockedUntil = &lockedUntil
+		r.store.emails[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+// MarkSent records the delivery and drops the bodies, like the GORM
+// repository
+func (r OutboxRepository) MarkSent(id int, attempts int, at time.Time) error {
+	return r.update(id, func(email *model.OutboxEmail) {
+		email.Status = model.EmailStatusSent
+		email.Attempts = attempts
+		email.SentAt = &at
+		email.LockedUntil = nil
+		email.LastError = ""
+		email.TextBody = ""
+		email.HTMLBody = ""
+	})
+}
+
+// Retry puts an email that failed back in the queue until nextAttemptAt
+func (r OutboxRepository) Retry(id int, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return r.update(id, func(email *model.OutboxEmail) {
+		email.Status = model.EmailStatusPending
+		email.Attempts = attempts
+		email.NextAttemptAt = nextAttemptAt
+		email.LockedUntil = nil
+		email.LastError = lastError
+	})
+}
+
+// Fail moves an email to the dead letter state, without its body when
+// dropBody is set
+func (r OutboxRepository) Fail(id int, attempts int, lastError string, dropBody bool) error {
+	return r.update(id, func(email *model.OutboxEmail) {
+		email.Status = model.EmailStatusFailed
+		email.Attempts = attempts
+		email.LockedUntil = nil
+		email.LastError = lastError
+		if dropBody {
+			email.TextBody = ""
+			email.HTMLBody = ""
+		}
+	})
+}
+
+// GetByID gets a queued email
+func (r OutboxRepository) GetByID(id int) (model.OutboxEmail, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	email, ok := r.store.emails[id]
+	if !ok {
+		return model.OutboxEmail{}, gorm.ErrRecordNotFound
+	}
+	return email, nil
+}
+
+// List lists the emails with a status, newest first
+func (r OutboxRepository) List(status string, limit int) ([]model.OutboxEmail, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	emails := []model.OutboxEmail{}
+	for _, email := range r.store.emails {
+		if email.Status == status {
+			emails = append(emails, email)
+		}
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].ID > emails[j].ID })
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+	return emails, nil
+}
+
+// Requeue sends a failed email again from scratch. It fails with
+// gorm.ErrRecordNotFound if the email doesn't exist or isn't failed
+func (r OutboxRepository) Requeue(id int, now time.Time) (model.OutboxEmail, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	email, ok := r.store.emails[id]
+	if !ok || email.Status != model.EmailStatusFailed {
+		return model.OutboxEmail{}, gorm.ErrRecordNotFound
+	}
+	email.Status = model.EmailStatusPending
+	email.Attempts = 0
+	email.NextAttemptAt = now
+	email.UpdatedAt = now
+	r.store.emails[id] = email
+	return email, nil
+}
+
+// update changes an email, a missing one is not an error like with an UPDATE
+func (r OutboxRepository) update(id int, fn func(email *model.OutboxEmail)) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if email, ok := r.store.emails[id]; ok {
+		fn(&email)
+		email.UpdatedAt = time.Now()
+		r.store.emails[id] = email
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ClientRepository stores the registered OAuth clients with GORM
+type ClientRepository struct {
+	db *gorm.DB
+}
+
+func NewClientRepository(db *gorm.DB) ClientRepository {
+	return ClientRepository{db: db}
+}
+
+// AuthorizationCodeRepository stores the authorization codes with GORM
+type AuthorizationCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewAuthorizationCodeRepository(db *gorm.DB) AuthorizationCodeRepository {
+	return AuthorizationCodeRepository{db: db}
+}
+
+// Create registers a new OAuth client
+func (r ClientRepository) Create(client model.OAuthClient) (model.OAuthClient, error) {
+	result := r.db.Create(&client)
+	if result.Error != nil {
+		return model.OAuthClient{}, fmt.Errorf("failed to create oauth client: %w", result.Error)
+	}
+	return client, nil
+}
+
+// GetByClientID gets a client by its public client_id
+func (r ClientRepository) GetByClientID(clientID string) (model.OAuthClient, error) {
+	var client model.OAuthClient
+	query := r.db.Where("client_id = ?", clientID).First(&client)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.OAuthClient{}, gorm.ErrRecordNotFound
+		}
+		return model.OAuthClient{}, fmt.Errorf("failed to get oauth client: %w", query.Error)
+	}
+	return client, nil
+}
+
+// List lists every registered client
+func (r ClientRepository) List() ([]model.OAuthClient, error) {
+	var clients []model.OAuthClient
+	query := r.db.Order("id").Find(&clients)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get oauth clients: %w", query.Error)
+	}
+	return clients, nil
+}
+
+// Deactivate revokes a client so it can no longer request tokens
+func (r ClientRepository) Deactivate(clientID string) error {
+	result := r.db.Model(&model.OAuthClient{}).
+		Where("client_id = ?", clientID).
+		Update("is_active", false)
+	if result.Error != nil {
+		return fmt.Errorf("failed to deactivate oauth client: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Create stores a new authorization code
+func (r AuthorizationCodeRepository) Create(code model.AuthorizationCode) (model.AuthorizationCode, error) {
+	result := r.db.Create(&code)
+	if result.Error != nil {
+		return model.AuthorizationCode{}, fmt.Errorf("failed to create authorization code: %w", result.Error)
+	}
+	return code, nil
+}
+
+// GetByHash gets an authorization code by the hash of its value
+func (r AuthorizationCodeRepository) GetByHash(codeHash string) (model.AuthorizationCode, error) {
+	var code model.AuthorizationCode
+	query := r.db.Where("code_hash = ?", codeHash).First(&code)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.AuthorizationCode{}, gorm.ErrRecordNotFound
+		}
+		return model.AuthorizationCode{}, fmt.Errorf("failed to get authorization code: %w", query.Error)
+	}
+	return code, nil
+}
+
+// Consume marks a code as used. It returns gorm.ErrRecordNotFound if the code
+// was already consumed
+func (r AuthorizationCodeRepository) Consume(id int, at time.Time) error {
+	result := r.db.Model(&model.AuthorizationCode{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		Update("consumed_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to consume authorization code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
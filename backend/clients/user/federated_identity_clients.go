@@ -0,0 +1,52 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FederatedIdentityRepository stores the links between users and the
+// subjects of the identity providers with GORM
+type FederatedIdentityRepository struct {
+	db *gorm.DB
+}
+
+func NewFederatedIdentityRepository(db *gorm.DB) FederatedIdentityRepository {
+	return FederatedIdentityRepository{db: db}
+}
+
+// Get gets the identity linked to a provider subject
+func (r FederatedIdentityRepository) Get(provider string, subject string) (model.FederatedIdentity, error) {
+	var identity model.FederatedIdentity
+	query := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.FederatedIdentity{}, gorm.ErrRecordNotFound
+		}
+		return model.FederatedIdentity{}, fmt.Errorf("failed to get federated identity: %w", query.Error)
+	}
+	return identity, nil
+}
+
+// Create links a provider identity to a user
+func (r FederatedIdentityRepository) Create(identity model.FederatedIdentity) (model.FederatedIdentity, error) {
+	result := r.db.Create(&identity)
+	if result.Error != nil {
+		return model.FederatedIdentity{}, fmt.Errorf("failed to create federated identity: %w", result.Error)
+	}
+	return identity, nil
+}
+
+// UpdateLogin records a login through a linked identity
+func (r FederatedIdentityRepository) UpdateLogin(id int, at time.Time) error {
+	result := r.db.Model(&model.FederatedIdentity{}).
+		Where("id = ?", id).
+		Update("last_login_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update federated identity: %w", result.Error)
+	}
+	return nil
+}
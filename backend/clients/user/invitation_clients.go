@@ -0,0 +1,117 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InvitationRepository stores the invitations with GORM
+type InvitationRepository struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepository(db *gorm.DB) InvitationRepository {
+	return InvitationRepository{db: db}
+}
+
+// Create stores a new invitation, revoking the pending invitations of the
+// same email so only the newest link works
+func (r InvitationRepository) Create(invitation model.Invitation) (model.Invitation, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Invitation{}).
+			Where("email = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.Email).
+			Update("revoked_at", invitation.CreatedAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return model.Invitation{}, fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return invitation, nil
+}
+
+// GetByID gets an invitation by its ID
+func (r InvitationRepository) GetByID(id int) (model.Invitation, error) {
+	var invitation model.Invitation
+	query := r.db.Where("id = ?", id).First(&invitation)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.Invitation{}, gorm.ErrRecordNotFound
+		}
+		return model.Invitation{}, fmt.Errorf("failed to get invitation: %w", query.Error)
+	}
+	return invitation, nil
+}
+
+// List lists invitations newest first. invitedBy 0 lists everyone's
+func (r InvitationRepository) List(invitedBy int) ([]model.Invitation, error) {
+	var invitations []model.Invitation
+	query := r.db.Order("created_at DESC")
+	if invitedBy != 0 {
+		query = query.Where("invited_by = ?", invitedBy)
+	}
+	if err := query.Find(&invitations).Error; err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+// Revoke revokes a pending invitation. invitedBy 0 revokes anyone's
+func (r InvitationRepository) Revoke(id int, invitedBy int, at time.Time) error {
+	query := r.db.Model(&model.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id)
+	if invitedBy != 0 {
+		query = query.Where("invited_by = ?", invitedBy)
+	}
+	result := query.Update("revoked_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Accept creates the invited user, or saves the one an import created, and
+// marks the invitation as accepted in one transaction. The invitation must still be pending, so a link can't be
+// used twice
+func (r InvitationRepository) Accept(invitationID int, user model.UserModel, at time.Time) (model.UserModel, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if user.ID != 0 {
+			// the account of an import, waiting for its password
+			if err := NewUserRepository(tx).Update(user); err != nil {
+				return err
+			}
+		} else {
+			created, err := NewUserRepository(tx).Create(user)
+			if err != nil {
+				return err
+			}
+			user = created
+		}
+
+		result := tx.Model(&model.Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitationID).
+			Updates(map[string]interface{}{"accepted_at": at, "accepted_user_id": user.ID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return model.UserModel{}, gorm.ErrRecordNotFound
+		}
+		return model.UserModel{}, fmt.Errorf("failed to accept invitation: %w", err)
+	}
+	return user, nil
+}
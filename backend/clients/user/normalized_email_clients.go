@@ -0,0 +1,118 @@
+package clients
+
+import (
+	"backend/model"
+	"backend/utils"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// EmailCollision is a group of users whose emails normalize to the same key.
+// They keep no key until an admin merges or renames the accounts
+type EmailCollision struct {
+	NormalizedEmail string
+	Users           []model.UserModel
+}
+
+// EmailNormalizationReport is the outcome of NormalizeStoredEmails
+type EmailNormalizationReport struct {
+	Updated    int
+	Collisions []EmailCollision
+	Invalid    []model.UserModel // emails that can't be normalized
+}
+
+// NormalizeStoredEmails fills the normalized email of the users that don't
+// have one yet, or recomputes it for every user when all is set (after the
+// normalization options change). Users that would share a key are left out
+// and reported instead of failing the unique index
+func NormalizeStoredEmails(db *gorm.DB, all bool) (EmailNormalizationReport, error) {
+	columns := []string{"id", "email", "normalized_email", "first_name", "last_name"}
+	query := db.Select(columns).Order("id")
+	if !all {
+		query = query.Where("normalized_email IS NULL")
+	}
+	var users []model.UserModel
+	if err := query.Find(&users).Error; err != nil {
+		return EmailNormalizationReport{}, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	report := EmailNormalizationReport{}
+	groups := map[string][]model.UserModel{}
+	for _, user := range users {
+		normalized, err := utils.NormalizeEmail(user.Email)
+		if err != nil {
+			report.Invalid = append(report.Invalid, user)
+			continue
+		}
+		groups[normalized] = append(groups[normalized], user)
+	}
+
+	// Users that already hold one of the keys take part in the collision
+	if !all && len(groups) > 0 {
+		keys := make([]string, 0, len(groups))
+		for normalized := range groups {
+			keys = append(keys, normalized)
+		}
+		var holders []model.UserModel
+		if err := db.Select(columns).Where("normalized_email IN ?", keys).Find(&holders).Error; err != nil {
+			return EmailNormalizationReport{}, fmt.Errorf("failed to get users: %w", err)
+		}
+		for _, holder := range holders {
+			groups[*holder.NormalizedEmail] = append([]model.UserModel{holder}, groups[*holder.NormalizedEmail]...)
+		}
+	}
+
+	updates := map[int]string{}
+	for normalized, group := range groups {
+		if len(group) > 1 {
+			report.Collisions = append(report.Collisions, EmailCollision{NormalizedEmail: normalized, Users: group})
+			continue
+		}
+		user := group[0]
+		if user.NormalizedEmail != nil && (!all || *user.NormalizedEmail == normalized) {
+			continue
+		}
+		updates[user.ID] = normalized
+	}
+	sort.Slice(report.Collisions, func(i, j int) bool {
+		return report.Collisions[i].Users[0].ID < report.Collisions[j].Users[0].ID
+	})
+
+	var cleared []int
+	if all {
+		for _, collision := range report.Collisions {
+			for _, user := range collision.Users {
+				if user.NormalizedEmail != nil {
+					cleared = append(cleared, user.ID)
+				}
+			}
+		}
+	}
+	if len(updates) == 0 && len(cleared) == 0 {
+		return report, nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// clear the keys first so two users can swap keys without tripping the unique index
+		ids := cleared
+		for id := range updates {
+			ids = append(ids, id)
+		}
+		if err := tx.Model(&model.UserModel{}).Where("id IN ?", ids).UpdateColumn("normalized_email", nil).Error; err != nil {
+			return err
+		}
+		for id, normalized := range updates {
+			if err := tx.Model(&model.UserModel{}).Where("id = ?", id).UpdateColumn("normalized_email", normalized).Error; err != nil {
+				return fmt.Errorf("user %d: %w", id, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return EmailNormalizationReport{}, fmt.Errorf("failed to normalize emails: %w", err)
+	}
+	report.Updated = len(updates)
+	return report, nil
+}
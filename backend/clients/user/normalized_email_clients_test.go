@@ -0,0 +1,123 @@
+package clients_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	userCLient "backend/clients/user"
+	"backend/config"
+	"backend/db"
+	"backend/model"
+	"backend/utils"
+)
+
+// TestNormalizeStoredEmails runs what the normalize_emails migration and the
+// normalize-emails command run, on users created before the normalized key
+func TestNormalizeStoredEmails(t *testing.T) {
+	defaults := config.Emails{FoldLocalPart: true}
+	utils.ConfigureEmails(defaults)
+	t.Cleanup(func() { utils.ConfigureEmails(defaults) })
+
+	if err := db.Connect(config.Database{Driver: "sqlite", DSN: db.SQLiteDSN(filepath.Join(t.TempDir(), "normalize.db"))}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a user created with the key, then the legacy ones without it
+	holder, err := userCLient.NewUserRepository(db.DB).Create(model.UserModel{Email: "maria@uni.edu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := map[string]int{}
+	for _, email := range []string{"Ana@uni.edu", "ana@UNI.edu", "MARIA@uni.edu", "luis@uni.edu", "luis+news@uni.edu", "broken"} {
+		user := model.UserModel{Email: email}
+		if err := db.DB.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+		legacy[email] = user.ID
+	}
+
+	report, err := userCLient.NormalizeStoredEmails(db.DB, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Updated != 2 {
+		t.Errorf("expected the two luis addresses to get a key, got %d updates", report.Updated)
+	}
+	collisions := collisionIDs(report)
+	expected := map[string][]int{
+		"ana@uni.edu":   {legacy["Ana@uni.edu"], legacy["ana@UNI.edu"]},
+		"maria@uni.edu": {holder.ID, legacy["MARIA@uni.edu"]},
+	}
+	if len(collisions) != len(expected) {
+		t.Errorf("expected the collisions %v, got %v", expected, collisions)
+	}
+	for key, ids := range expected {
+		if !sameIDs(collisions[key], ids) {
+			t.Errorf("collision %s: expected users %v, got %v", key, ids, collisions[key])
+		}
+	}
+	if len(report.Invalid) != 1 || report.Invalid[0].ID != legacy["broken"] {
+		t.Errorf("expected the broken email to be reported as invalid, got %+v", report.Invalid)
+	}
+	if key := normalizedEmail(t, legacy["Ana@uni.edu"]); key != nil {
+		t.Errorf("a colliding user got the key %s", *key)
+	}
+	if key := normalizedEmail(t, holder.ID); key == nil || *key != "maria@uni.edu" {
+		t.Errorf("the user holding the key lost it: %v", key)
+	}
+
+	// stripping subaddresses makes the two luis addresses the same account
+	utils.ConfigureEmails(config.Emails{FoldLocalPart: true, StripSubaddress: true})
+	report, err = userCLient.NormalizeStoredEmails(db.DB, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collisions = collisionIDs(report)
+	if !sameIDs(collisions["luis@uni.edu"], []int{legacy["luis@uni.edu"], legacy["luis+news@uni.edu"]}) {
+		t.Errorf("expected luis@uni.edu to collide after stripping subaddresses, got %v", collisions)
+	}
+	for _, email := range []string{"luis@uni.edu", "luis+news@uni.edu"} {
+		if key := normalizedEmail(t, legacy[email]); key != nil {
+			t.Errorf("%s kept the key %s after colliding", email, *key)
+		}
+	}
+}
+
+func collisionIDs(report userCLient.EmailNormalizationReport) map[string][]int {
+	result := map[string][]int{}
+	for _, collision := range report.Collisions {
+		for _, user := range collision.Users {
+			result[collision.NormalizedEmail] = append(result[collision.NormalizedEmail], user.ID)
+		}
+	}
+	return result
+}
+
+func sameIDs(got []int, expected []int) bool {
+	if len(got) != len(expected) {
+		return false
+	}
+	seen := map[int]bool{}
+	for _, id := range got {
+		seen[id] = true
+	}
+	for _, id := range expected {
+		if !seen[id] {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizedEmail(t *testing.T, userID int) *string {
+	t.Helper()
+	var user model.UserModel
+	if err := db.DB.First(&user, userID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user.NormalizedEmail
+}
@@ -0,0 +1,74 @@
+package clients
+
+import (
+	"backend/model"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SessionRepository records the logins and the activity of the users with
+// GORM. Sessions are stateless tokens, only their use is stored
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return SessionRepository{db: db}
+}
+
+// UpdateLastLogin records a successful login, which also counts as activity
+func (r SessionRepository) UpdateLastLogin(userID int, at time.Time) error {
+	result := r.db.Model(&model.UserModel{}).
+		Where("id = ?", userID).
+		UpdateColumns(map[string]interface{}{
+			"last_login_at": at,
+			"last_seen_at":  at,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to update last login: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	return r.recordActivity(userID, at)
+}
+
+// TouchLastSeen records activity, throttled by model.ActivityThrottle
+func (r SessionRepository) TouchLastSeen(userID int, at time.Time) error {
+	result := r.db.Model(&model.UserModel{}).
+		Where("id = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", userID, model.ActivityCutoff(at)).
+		UpdateColumn("last_seen_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update last seen: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	return r.recordActivity(userID, at)
+}
+
+// recordActivity marks the day of at as active, once per user and day
+func (r SessionRepository) recordActivity(userID int, at time.Time) error {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.UserActivity{UserID: userID, Day: model.ActivityDay(at)})
+	if result.Error != nil {
+		return fmt.Errorf("failed to record activity: %w", result.Error)
+	}
+	return nil
+}
+
+// CountActiveUsersBetween counts users active on any day in [from, to)
+func (r SessionRepository) CountActiveUsersBetween(from time.Time, to time.Time) (int64, error) {
+	var count int64
+	result := r.db.Model(&model.UserActivity{}).
+		Where("day >= ? AND day < ?", model.ActivityDay(from), model.ActivityDay(to)).
+		Distinct("user_id").
+		Count(&count)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to count active users: %w", result.Error)
+	}
+	return count, nil
+}
@@ -2,28 +2,26 @@ package clients
 
 import (
 	"backend/model"
+	"backend/utils"
 	"fmt"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-var Db *gorm.DB
-
-func GetUserByUsername(username string) (model.UserModel, error) {
-	var user model.UserModel
-	query := Db.First(&user, "email = ?", username)
-	if query.Error != nil {
-		return model.UserModel{}, fmt.Errorf("failed to get user by username: %w", query.Error)
-	}
+// UserRepository stores the users with GORM
+type UserRepository struct {
+	db *gorm.DB
+}
 
-	return user, nil
+func NewUserRepository(db *gorm.DB) UserRepository {
+	return UserRepository{db: db}
 }
 
-// GetUserByID gets a user by ID
-func GetUserByID(id int) (model.UserModel, error) {
+// GetByID gets a user by ID
+func (r UserRepository) GetByID(id int) (model.UserModel, error) {
 	var user model.UserModel
-	query := Db.Where("id = ?", id).First(&user)
+	query := r.db.Where("id = ?", id).First(&user)
 	if query.Error != nil {
 		return model.UserModel{}, fmt.Errorf("failed to get user by id: %w", query.Error)
 	}
@@ -36,71 +34,166 @@ func GetUserByID(id int) (model.UserModel, error) {
 	return user, nil
 }
 
-// CreateUser creates a new user in the database
-func CreateUser(user model.UserModel) (model.UserModel, error) {
-	result := Db.Create(&user)
+// GetByEmail gets a user by email address
+func (r UserRepository) GetByEmail(email string) (model.UserModel, error) {
+	var user model.UserModel
+	query := whereEmail(r.db, email).First(&user)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.UserModel{}, gorm.ErrRecordNotFound
+		}
+		return model.UserModel{}, fmt.Errorf("failed to get user by email: %w", query.Error)
+	}
+	return user, nil
+}
+
+// GetByIDs gets every existing user whose ID is in ids with a single query
+func (r UserRepository) GetByIDs(ids []int) ([]model.UserModel, error) {
+	var users []model.UserModel
+	query := r.db.Where("id IN ?", ids).Order("id").Find(&users)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get users by ids: %w", query.Error)
+	}
+	return users, nil
+}
+
+// Create creates a new user
+func (r UserRepository) Create(user model.UserModel) (model.UserModel, error) {
+	if err := setNormalizedEmail(&user); err != nil {
+		return model.UserModel{}, fmt.Errorf("failed to create user: %w", err)
+	}
+	result := r.db.Create(&user)
 	if result.Error != nil {
 		return model.UserModel{}, fmt.Errorf("failed to create user: %w", result.Error)
 	}
 	return user, nil
 }
 
-// UpdateUser updates an existing user
-func UpdateUser(user model.UserModel) error {
-	result := Db.Save(&user)
-	if result.Error != nil {
-		return fmt.Errorf("failed to update user: %w", result.Error)
+// CreateWithToken creates a user together with the one-time code token
+// builds for its ID, in a single transaction
+func (r UserRepository) CreateWithToken(user model.UserModel, token func(userID int) model.VerificationToken) (model.UserModel, error) {
+	var created model.UserModel
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		created, err = NewUserRepository(tx).Create(user)
+		if err != nil {
+			return err
+		}
+		return NewTokenRepository(tx).replace(token(created.ID))
+	})
+	if err != nil {
+		return model.UserModel{}, err
 	}
-	return nil
+	return created, nil
 }
 
-// GetUserByEmail gets a user by email address
-func GetUserByEmail(email string) (model.UserModel, error) {
-	var user model.UserModel
-	query := Db.Where("email = ?", email).First(&user)
-	if query.Error != nil {
-		if query.Error == gorm.ErrRecordNotFound {
-			return model.UserModel{}, gorm.ErrRecordNotFound
+// CreateBatch creates users in a single transaction, together with the
+// one-time code token builds for each of them, if any. A failure rolls the
+// whole batch back and names the email of the user that failed
+func (r UserRepository) CreateBatch(users []model.UserModel, token func(index int, userID int) *model.VerificationToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for i, user := range users {
+			created, err := NewUserRepository(tx).Create(user)
+			if err != nil {
+				return fmt.Errorf("%s: %w", user.Email, err)
+			}
+			code := token(i, created.ID)
+			if code == nil {
+				continue
+			}
+			if err := NewTokenRepository(tx).replace(*code); err != nil {
+				return fmt.Errorf("%s: %w", user.Email, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Update updates an existing user
+func (r UserRepository) Update(user model.UserModel) error {
+	// Users left without a key by a collision keep it empty until it is resolved
+	if user.NormalizedEmail != nil {
+		if err := setNormalizedEmail(&user); err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
 		}
-		return model.UserModel{}, fmt.Errorf("failed to get user by email: %w", query.Error)
 	}
-	return user, nil
+	result := r.db.Save(&user)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	return nil
 }
 
-// VerifyUserEmail verifies user email and clears verification code
-func VerifyUserEmail(userID int) error {
-	result := Db.Model(&model.UserModel{}).
+// UpdatePassword replaces the password hash of a user
+func (r UserRepository) UpdatePassword(userID int, passwordHash string) error {
+	result := r.db.Model(&model.UserModel{}).
 		Where("id = ?", userID).
-		Updates(map[string]interface{}{
-			"is_verified":       true,
-			"verification_code": nil,
-			"code_expires_at":   nil,
-		})
+		Update("password_hash", passwordHash)
 	if result.Error != nil {
-		return fmt.Errorf("failed to verify user email: %w", result.Error)
+		return fmt.Errorf("failed to update password: %w", result.Error)
 	}
 	return nil
 }
 
-// UpdateVerificationCode updates the verification code and expiration
-func UpdateVerificationCode(userID int, code string, expiresAt time.Time) error {
-	result := Db.Model(&model.UserModel{}).
+// UpdateLocale saves the preferred language of a user
+func (r UserRepository) UpdateLocale(userID int, locale string) error {
+	result := r.db.Model(&model.UserModel{}).
 		Where("id = ?", userID).
-		Updates(map[string]interface{}{
-			"verification_code": code,
-			"code_expires_at":   expiresAt,
-		})
+		Update("locale", locale)
 	if result.Error != nil {
-		return fmt.Errorf("failed to update verification code: %w", result.Error)
+		return fmt.Errorf("failed to update locale: %w", result.Error)
 	}
 	return nil
 }
 
+// VerifyEmail marks the email of a user as verified and drops its pending
+// verification codes
+func (r UserRepository) VerifyEmail(userID int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.UserModel{}).
+			Where("id = ?", userID).
+			Update("is_verified", true)
+		if result.Error != nil {
+			return fmt.Errorf("failed to verify user email: %w", result.Error)
+		}
+		return deletePendingTokens(tx, userID, model.TokenPurposeVerifyEmail)
+	})
+}
+
+// VerifyExternally marks the email of a user as verified by an identity
+// provider or a directory. The password and the pending codes were set by
+// whoever registered the address before anyone proved to own it, so they are
+// dropped: otherwise they would keep a way into the account
+func (r UserRepository) VerifyExternally(userID int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.UserModel{}).
+			Where("id = ?", userID).
+			Updates(map[string]interface{}{
+				"is_verified":   true,
+				"password_hash": "",
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to verify user email: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		err := tx.Where("user_id = ? AND consumed_at IS NULL", userID).Delete(&model.VerificationToken{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to delete verification tokens: %w", err)
+		}
+		return nil
+	})
+}
+
 // PromoteToAdmin promotes a user to admin status
-func PromoteToAdmin(userID int) error {
-	result := Db.Model(&model.UserModel{}).
+func (r UserRepository) PromoteToAdmin(userID int) error {
+	result := r.db.Model(&model.UserModel{}).
 		Where("id = ?", userID).
-		Update("is_admin", true)
+		Updates(map[string]interface{}{
+			"is_admin": true,
+			"role":     utils.RoleAdmin,
+		})
 	if result.Error != nil {
 		return fmt.Errorf("failed to promote user to admin: %w", result.Error)
 	}
@@ -109,3 +202,154 @@ func PromoteToAdmin(userID int) error {
 	}
 	return nil
 }
+
+// CountRegistrationsPerDay groups users created in [from, to) by creation day
+func (r UserRepository) CountRegistrationsPerDay(from time.Time, to time.Time) ([]model.DailyCount, error) {
+	var rows []model.DailyCount
+	result := r.db.Model(&model.UserModel{}).
+		Select(dayOf(r.db, "created_at")+" AS day, COUNT(*) AS count").
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Group("day").
+		Order("day").
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to count registrations per day: %w", result.Error)
+	}
+	return rows, nil
+}
+
+// dayOf formats column as YYYY-MM-DD in the SQL of the database
+func dayOf(db *gorm.DB, column string) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "to_char(" + column + ", 'YYYY-MM-DD')"
+	case "sqlite":
+		return "strftime('%Y-%m-%d', " + column + ")"
+	}
+	return "DATE_FORMAT(" + column + ", '%Y-%m-%d')"
+}
+
+// CountRegistrations counts users created in [from, to), optionally only the verified ones
+func (r UserRepository) CountRegistrations(from time.Time, to time.Time, onlyVerified bool) (int64, error) {
+	var count int64
+	query := r.db.Model(&model.UserModel{}).
+		Where("created_at >= ? AND created_at < ?", from, to)
+	if onlyVerified {
+		query = query.Where("is_verified = ?", true)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count registrations: %w", err)
+	}
+	return count, nil
+}
+
+// Search pages through the users matching where (empty for all), ordered by
+// ID, and returns the total count of matches
+func (r UserRepository) Search(where string, args []interface{}, offset int, limit int) ([]model.UserModel, int64, error) {
+	query := r.db.Model(&model.UserModel{})
+	if where != "" {
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	users := []model.UserModel{}
+	if limit > 0 {
+		if err := query.Order("id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to search users: %w", err)
+		}
+	}
+	return users, total, nil
+}
+
+// Delete removes a user together with the rows that only make sense for it
+func (r UserRepository) Delete(userID int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, owned := range []interface{}{
+			&model.GroupMember{},
+			&model.FederatedIdentity{},
+			&model.PersonalAccessToken{},
+			&model.VerificationToken{},
+			&model.UserActivity{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(owned).Error; err != nil {
+				return fmt.Errorf("failed to delete user data: %w", err)
+			}
+		}
+
+		result := tx.Delete(&model.UserModel{}, userID)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete user: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// SyncAdminRoles gives the admin role to admins created before roles existed
+func SyncAdminRoles(tx *gorm.DB) error {
+	result := tx.Model(&model.UserModel{}).
+		Where("is_admin = ? AND role <> ?", true, utils.RoleAdmin).
+		UpdateColumn("role", utils.RoleAdmin)
+	if result.Error != nil {
+		return fmt.Errorf("failed to sync admin roles: %w", result.Error)
+	}
+	return nil
+}
+
+// GetByEmails gets every existing user whose email is in emails with a single query
+func (r UserRepository) GetByEmails(emails []string) ([]model.UserModel, error) {
+	normalized := make([]string, 0, len(emails))
+	canonical := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if key, err := utils.NormalizeEmail(email); err == nil {
+			normalized = append(normalized, key)
+		}
+		if address, err := utils.CanonicalEmail(email); err == nil {
+			canonical = append(canonical, address)
+		}
+	}
+	if len(normalized) == 0 {
+		return []model.UserModel{}, nil
+	}
+
+	var users []model.UserModel
+	query := r.db.Where("normalized_email IN ? OR (normalized_email IS NULL AND email IN ?)", normalized, canonical).Find(&users)
+	if query.Error != nil {
+		return nil, fmt.Errorf("failed to get users by emails: %w", query.Error)
+	}
+	return users, nil
+}
+
+// whereEmail filters users by the normalized form of email. Users still
+// without one (an unresolved collision) are only found by their exact address
+func whereEmail(db *gorm.DB, email string) *gorm.DB {
+	normalized, err := utils.NormalizeEmail(email)
+	if err != nil {
+		// not an email, it can't match any user
+		return db.Where("1 = 0")
+	}
+	canonical, _ := utils.CanonicalEmail(email)
+	return db.Where("normalized_email = ? OR (normalized_email IS NULL AND email = ?)", normalized, canonical)
+}
+
+// setNormalizedEmail stores the email in its canonical form and fills the
+// normalized lookup key
+func setNormalizedEmail(user *model.UserModel) error {
+	canonical, err := utils.CanonicalEmail(user.Email)
+	if err != nil {
+		return err
+	}
+	normalized, err := utils.NormalizeEmail(canonical)
+	if err != nil {
+		return err
+	}
+	user.Email = canonical
+	user.NormalizedEmail = &normalized
+	return nil
+}
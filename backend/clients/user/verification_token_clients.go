@@ -0,0 +1,160 @@
+package clients
+
+import (
+	"backend/model"
+	"backend/utils"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TokenRepository stores the one-time codes with GORM
+type TokenRepository struct {
+	db *gorm.DB
+}
+
+func NewTokenRepository(db *gorm.DB) TokenRepository {
+	return TokenRepository{db: db}
+}
+
+// Replace stores a new code for the user, dropping the pending ones with the
+// same purpose so only the last code sent works
+func (r TokenRepository) Replace(token model.VerificationToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return NewTokenRepository(tx).replace(token)
+	})
+}
+
+// replace is Replace inside a transaction already open
+func (r TokenRepository) replace(token model.VerificationToken) error {
+	if err := deletePendingTokens(r.db, token.UserID, token.Purpose); err != nil {
+		return err
+	}
+	if err := r.db.Create(&token).Error; err != nil {
+		return fmt.Errorf("failed to create verification token: %w", err)
+	}
+	return nil
+}
+
+// GetPending gets the last unused code of a user for purpose
+func (r TokenRepository) GetPending(userID int, purpose string) (model.VerificationToken, error) {
+	var token model.VerificationToken
+	query := r.db.Where("user_id = ? AND purpose = ? AND consumed_at IS NULL", userID, purpose).
+		Order("id DESC").
+		First(&token)
+	if query.Error != nil {
+		if query.Error == gorm.ErrRecordNotFound {
+			return model.VerificationToken{}, gorm.ErrRecordNotFound
+		}
+		return model.VerificationToken{}, fmt.Errorf("failed to get verification token: %w", query.Error)
+	}
+	return token, nil
+}
+
+// AddAttempt counts a try of an unused code. It fails with
+// gorm.ErrRecordNotFound once the code was used or tried maxAttempts times,
+// so concurrent guesses can't go over the limit
+func (r TokenRepository) AddAttempt(tokenID int, maxAttempts int) error {
+	result := r.db.Model(&model.VerificationToken{}).
+		Where("id = ? AND consumed_at IS NULL AND attempts < ?", tokenID, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to count verification attempt: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Consume marks a code as used. It fails with gorm.ErrRecordNotFound if it
+// was already used
+func (r TokenRepository) Consume(tokenID int, at time.Time) error {
+	result := r.db.Model(&model.VerificationToken{}).
+		Where("id = ? AND consumed_at IS NULL", tokenID).
+		Update("consumed_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to consume verification token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func deletePendingTokens(db *gorm.DB, userID int, purpose string) error {
+	err := db.Where("user_id = ? AND purpose = ? AND consumed_at IS NULL", userID, purpose).
+		Delete(&model.VerificationToken{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to delete verification tokens: %w", err)
+	}
+	return nil
+}
+
+// MigrateLegacyVerificationCodes moves the plaintext codes kept in
+// user_models.verification_code into hashed verification tokens and drops the
+// old columns. It returns how many pending codes were moved
+func MigrateLegacyVerificationCodes(db *gorm.DB) (int, error) {
+	migrator := db.Migrator()
+
+	// Plaintext reset codes from the first version of the table, they have to
+	// be requested again
+	if migrator.HasColumn(&model.VerificationToken{}, "token") {
+		if err := db.Where("token_hash = ''").Delete(&model.VerificationToken{}).Error; err != nil {
+			return 0, fmt.Errorf("failed to delete plaintext verification tokens: %w", err)
+		}
+		if err := migrator.DropColumn(&model.VerificationToken{}, "token"); err != nil {
+			return 0, fmt.Errorf("failed to drop verification_tokens.token: %w", err)
+		}
+	}
+
+	if !migrator.HasColumn(&model.UserModel{}, "verification_code") {
+		return 0, nil
+	}
+
+	var legacy []struct {
+		ID               int
+		VerificationCode string
+		CodeExpiresAt    *time.Time
+	}
+	err := db.Model(&model.UserModel{}).
+		Select("id, verification_code, code_expires_at").
+		Where("is_verified = ? AND verification_code IS NOT NULL AND verification_code <> ''", false).
+		Scan(&legacy).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to read verification codes: %w", err)
+	}
+
+	moved := 0
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range legacy {
+			// expired codes are useless, the user asks for a new one
+			if user.CodeExpiresAt == nil || now.After(*user.CodeExpiresAt) {
+				continue
+			}
+			err := NewTokenRepository(tx).replace(model.VerificationToken{
+				UserID:    user.ID,
+				Purpose:   model.TokenPurposeVerifyEmail,
+				TokenHash: utils.HashOneTimeToken(model.TokenPurposeVerifyEmail, user.ID, user.VerificationCode),
+				ExpiresAt: *user.CodeExpiresAt,
+			})
+			if err != nil {
+				return err
+			}
+			moved++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, column := range []string{"verification_code", "code_expires_at"} {
+		if err := migrator.DropColumn(&model.UserModel{}, column); err != nil {
+			return moved, fmt.Errorf("failed to drop user_models.%s: %w", column, err)
+		}
+	}
+	return moved, nil
+}
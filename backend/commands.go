@@ -0,0 +1,409 @@
+package main
+
+import (
+	accessTokenClient "backend/clients/accesstoken"
+	userCLient "backend/clients/user"
+	"backend/config"
+	"backend/db"
+	"backend/dto"
+	"backend/services"
+	"backend/utils"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runCommand runs a maintenance subcommand instead of the server and returns
+// the process exit code
+func runCommand(cfg config.Config, name string, args []string) int {
+	switch name {
+	case "import-users":
+		return importUsersCommand(cfg, args)
+	case "normalize-emails":
+		return normalizeEmailsCommand(cfg, args)
+	case "build-breached-filter":
+		return buildBreachedFilterCommand(args)
+	case "migrate":
+		return migrateCommand(cfg, args)
+	case "create-admin":
+		return createAdminCommand(cfg, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\ncommands:\n"+
+			"  import-users            create users from a CSV file\n"+
+			"  normalize-emails        recompute the normalized emails and report collisions\n"+
+			"  build-breached-filter   build the breached passwords filter from a list of SHA-1 hashes\n"+
+			"  migrate                 apply, roll back or list the schema migrations\n"+
+			"  create-admin            create an administrator with a password read from stdin\n", name)
+		return 2
+	}
+}
+
+// importUsersCommand: backend import-users -file alumnos.csv [-commit] [-pre-verified] [-invite=false]
+func importUsersCommand(cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("import-users", flag.ContinueOnError)
+	path := flags.String("file", "", "CSV file with email, first name, last name and role columns (- for stdin)")
+	commit := flags.Bool("commit", false, "write the users; without it the import is a dry run")
+	preVerified := flags.Bool("pre-verified", false, "create the accounts already verified")
+	invite := flags.Bool("invite", true, "email each new user a link to choose a password")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "-file is required")
+		flags.Usage()
+		return 2
+	}
+
+	var file io.Reader = os.Stdin
+	if *path != "-" {
+		opened, err := os.Open(*path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer opened.Close()
+		file = opened
+	}
+
+	if err := db.Connect(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db.StartDbEngine(cfg.Database)
+
+	users, err := newUserService(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	response, apiErr := users.ImportUsers(file, dto.ImportUsersOptions{
+		DryRun:          !*commit,
+		PreVerified:     *preVerified,
+		SendInvitations: *invite,
+	})
+	if apiErr != nil {
+		fmt.Fprintln(os.Stderr, apiErr.Message())
+		return 1
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "LINE\tSTATUS\tEMAIL\tROLE\tREASON")
+	for _, row := range response.Rows {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\n", row.Line, row.Status, row.Email, row.Role, row.Reason)
+	}
+	table.Flush()
+
+	mode := "committed"
+	if response.DryRun {
+		mode = "dry run, nothing was written (use -commit)"
+	}
+	fmt.Printf("\n%d rows: %d created, %d skipped, %d invalid, %d failed (%s)\n",
+		response.Total, response.Created, response.Skipped, response.Invalid, response.Failed, mode)
+
+	if response.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// normalizeEmailsCommand: backend normalize-emails
+// Needed after changing EMAIL_FOLD_LOCAL_PART or EMAIL_STRIP_SUBADDRESS, and
+// lists the accounts that share an email so they can be merged by hand
+func normalizeEmailsCommand(cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("normalize-emails", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := db.Connect(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db.StartDbEngine(cfg.Database)
+
+	report, err := userCLient.NormalizeStoredEmails(db.DB, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if len(report.Collisions) > 0 || len(report.Invalid) > 0 {
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "NORMALIZED\tID\tEMAIL\tNAME")
+		for _, collision := range report.Collisions {
+			for _, user := range collision.Users {
+				fmt.Fprintf(table, "%s\t%d\t%s\t%s %s\n", collision.NormalizedEmail, user.ID, user.Email, user.FirstName, user.LastName)
+			}
+		}
+		for _, user := range report.Invalid {
+			fmt.Fprintf(table, "(invalid)\t%d\t%s\t%s %s\n", user.ID, user.Email, user.FirstName, user.LastName)
+		}
+		table.Flush()
+		fmt.Println()
+	}
+	fmt.Printf("%d users updated, %d collisions, %d invalid emails\n", report.Updated, len(report.Collisions), len(report.Invalid))
+
+	if len(report.Collisions) > 0 || len(report.Invalid) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// migrateCommand: backend migrate up | down [-steps N] | status
+// Doesn't start the engine: the server already applies the migrations on
+// start unless DB_AUTO_MIGRATE=false
+func migrateCommand(cfg config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: backend migrate up | down [-steps N] | status")
+		return 2
+	}
+	if err := db.Connect(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("the schema is up to date")
+		}
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to roll back, newest first")
+		if err := flags.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *steps < 1 {
+			fmt.Fprintln(os.Stderr, "-steps must be at least 1")
+			return 2
+		}
+		rolledBack, err := db.MigrateDown(*steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("rolled back %d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("no migrations to roll back")
+		}
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", ""
+			if status.AppliedAt != nil {
+				state, appliedAt = "applied", status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Modified {
+				state = "modified"
+			} else if status.Unknown {
+				state = "unknown"
+			}
+			fmt.Fprintf(table, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+		table.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate action %q, expected up, down or status\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// createAdminCommand: backend create-admin -email admin@unichat.edu -first-name Ana -last-name Pérez < password.txt
+// The password is the first line of stdin, or of -password-file, so it
+// doesn't show up in the process list or the shell history
+func createAdminCommand(cfg config.Config, args []string) int {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the administrator")
+	firstName := flags.String("first-name", "Admin", "first name")
+	lastName := flags.String("last-name", "UniChat", "last name")
+	passwordFile := flags.String("password-file", "-", "file whose first line is the password (- for stdin)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *email == "" {
+		fmt.Fprintln(os.Stderr, "-email is required")
+		flags.Usage()
+		return 2
+	}
+
+	password, err := readPassword(*passwordFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// the password policy applies to the first admin too
+	if err := services.ConfigurePasswordPolicy(cfg.Password); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := db.Connect(cfg.Database); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	db.StartDbEngine(cfg.Database)
+
+	users, err := newUserService(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	admin, apiErr := users.CreateAdmin(dto.CreateAdminRequest{
+		Email:     *email,
+		Password:  password,
+		FirstName: *firstName,
+		LastName:  *lastName,
+	})
+	if apiErr != nil {
+		fmt.Fprintln(os.Stderr, apiErr.Message())
+		for _, cause := range apiErr.Cause() {
+			if reason, ok := cause.(map[string]interface{}); ok {
+				fmt.Fprintf(os.Stderr, "  %v\n", reason["message"])
+			}
+		}
+		return 1
+	}
+
+	fmt.Printf("created administrator %d (%s)\n", admin.ID, admin.Email)
+	return 0
+}
+
+// newUserService builds the user service on the connected database, the way
+// the server does
+func newUserService(cfg config.Config) (*services.UserService, error) {
+	registration, err := services.NewRegistrationPolicy(cfg.Registration)
+	if err != nil {
+		return nil, err
+	}
+	users := userCLient.NewUserRepository(db.DB)
+	sessions := userCLient.NewSessionRepository(db.DB)
+	invitations := services.NewInvitationService(users, userCLient.NewInvitationRepository(db.DB), sessions, cfg.Invitations)
+	return services.NewUserService(
+		users,
+		userCLient.NewTokenRepository(db.DB),
+		sessions,
+		accessTokenClient.NewAccessTokenRepository(db.DB),
+		invitations,
+		registration,
+	), nil
+}
+
+// readPassword returns the first line of path, or of stdin for "-"
+func readPassword(path string) (string, error) {
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	line, err := bufio.NewReader(input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("error reading the password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("the password is empty, write it to stdin or -password-file")
+	}
+	return password, nil
+}
+
+// buildBreachedFilterCommand: backend build-breached-filter -in pwned-passwords-sha1.txt -out breached.bloom
+// The input has one SHA-1 hash per line, optionally followed by ":count" as
+// in the Have I Been Pwned downloads. The output is what PASSWORD_BREACHED_FILE expects
+func buildBreachedFilterCommand(args []string) int {
+	flags := flag.NewFlagSet("build-breached-filter", flag.ContinueOnError)
+	in := flags.String("in", "", "file with one SHA-1 hash per line")
+	out := flags.String("out", "breached.bloom", "filter to write")
+	rate := flags.Float64("false-positives", 0.001, "rate of passwords wrongly reported as breached")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *in == "" || *rate <= 0 || *rate >= 1 {
+		fmt.Fprintln(os.Stderr, "-in is required and -false-positives must be between 0 and 1")
+		flags.Usage()
+		return 2
+	}
+
+	// the first pass counts the hashes to size the filter
+	var count uint64
+	err := eachBreachedHash(*in, func([]byte) { count++ })
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	filter := utils.NewBloomFilter(count, *rate)
+	if err := eachBreachedHash(*in, filter.Add); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	size, err := filter.WriteTo(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%d hashes written to %s (%d MB)\n", count, *out, size>>20)
+	return 0
+}
+
+// eachBreachedHash calls fn with the digest of every valid line of path
+func eachBreachedHash(path string, fn func(digest []byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if text == "" {
+			continue
+		}
+		digest, err := hex.DecodeString(text)
+		if err != nil || len(digest) != sha1.Size {
+			return fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		fn(digest)
+	}
+	return scanner.Err()
+}
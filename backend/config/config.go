@@ -0,0 +1,405 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config is the resolved configuration of the service. Load fills it from, in
+// increasing order of precedence: the defaults, a YAML or TOML file, the
+// environment and the command line flags. Each component gets its section
+//
+// The tags name each setting in every source: yaml and toml are the keys of
+// the file, env the environment variable (NAME_FILE reads it from a file, as
+// with Docker secrets) and flag the command line flag, for the few that have one
+type Config struct {
+	Server       Server       `yaml:"server" toml:"server"`
+	Database     Database     `yaml:"database" toml:"database"`
+	JWT          JWT          `yaml:"jwt" toml:"jwt"`
+	OIDC         OIDC         `yaml:"oidc" toml:"oidc"`
+	Mail         Mail         `yaml:"mail" toml:"mail"`
+	Outbox       Outbox       `yaml:"outbox" toml:"outbox"`
+	Emails       Emails       `yaml:"emails" toml:"emails"`
+	Registration Registration `yaml:"registration" toml:"registration"`
+	Password     Password     `yaml:"password" toml:"password"`
+	Invitations  Invitations  `yaml:"invitations" toml:"invitations"`
+	Auth         Auth         `yaml:"auth" toml:"auth"`
+	Federation   Federation   `yaml:"federation" toml:"federation"`
+	Health       Health       `yaml:"health" toml:"health"`
+}
+
+// Server is the HTTP server
+type Server struct {
+	Port int `yaml:"port" toml:"port" env:"PORT" flag:"port"`
+	// development shows the internal cause of the 5xx errors in the responses
+	Env         string   `yaml:"env" toml:"env" env:"APP_ENV" flag:"env"`
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins" env:"CORS_ORIGINS"`
+
+	// limits of each connection, so slow clients can't hold them forever
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ReadTimeout       Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT"`
+	WriteTimeout      Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout       Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT"`
+	MaxHeaderBytes    int      `yaml:"max_header_bytes" toml:"max_header_bytes" env:"HTTP_MAX_HEADER_BYTES"`
+
+	// on SIGTERM or SIGINT the service keeps serving for DrainPeriod while it
+	// reports not ready, so the load balancer stops sending requests. Then
+	// the requests in flight and the background workers get ShutdownTimeout
+	// to finish
+	DrainPeriod     Duration `yaml:"drain_period" toml:"drain_period" env:"SHUTDOWN_DRAIN_PERIOD"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+// Development reports whether the service runs in development mode
+func (s Server) Development() bool {
+	return s.Env == "development"
+}
+
+// Database is the connection to the database. DSN replaces the other fields
+// but Driver and AutoMigrate
+type Database struct {
+	Driver   string `yaml:"driver" toml:"driver" env:"DB_DRIVER" flag:"db-driver"`
+	DSN      string `yaml:"dsn" toml:"dsn" env:"DB_DSN" flag:"db-dsn"`
+	Host     string `yaml:"host" toml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" toml:"user" env:"DB_USER"`
+	Password string `yaml:"password" toml:"password" env:"DB_PASS"`
+	// the file of the database with SQLite, without the .db extension
+	Name string `yaml:"name" toml:"name" env:"DB_NAME"`
+	// false leaves the migrations to "backend migrate up"
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+}
+
+// JWT signs the tokens of the service
+type JWT struct {
+	Secret string `yaml:"secret" toml:"secret" env:"JWT_SECRET"`
+}
+
+// OIDC is this service as an OpenID Connect provider
+type OIDC struct {
+	// public URL of the service, the issuer of the ID tokens
+	Issuer string `yaml:"issuer" toml:"issuer" env:"OIDC_ISSUER"`
+	// PEM RSA key of the ID tokens. Empty generates one on each start, so the
+	// ID tokens don't survive a restart
+	SigningKeyFile string `yaml:"signing_key_file" toml:"signing_key_file" env:"OIDC_SIGNING_KEY_FILE"`
+}
+
+// Mail is how the emails are delivered
+type Mail struct {
+	// smtp, file (a maildir in Dir) or log. Empty is smtp when SMTP.Host is set
+	Driver string `yaml:"driver" toml:"driver" env:"MAIL_DRIVER" flag:"mail-driver"`
+	Dir    string `yaml:"dir" toml:"dir" env:"MAIL_DIR"`
+	// language of the recipients that never chose one
+	Locale string `yaml:"locale" toml:"locale" env:"MAIL_LOCALE"`
+	// sender, like "UniChat <no-reply@unichat.edu>"
+	From string `yaml:"from" toml:"from" env:"SMTP_FROM"`
+	SMTP SMTP   `yaml:"smtp" toml:"smtp"`
+}
+
+// SMTP is the server of the smtp mail driver
+type SMTP struct {
+	Host     string `yaml:"host" toml:"host" env:"SMTP_HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"SMTP_PORT"`
+	User     string `yaml:"user" toml:"user" env:"SMTP_USER"`
+	Password string `yaml:"password" toml:"password" env:"SMTP_PASS"`
+	// starttls (port 587), tls (port 465) or none
+	Security string `yaml:"security" toml:"security" env:"SMTP_SECURITY"`
+}
+
+// Ways to secure the connection to the SMTP server
+const (
+	SecurityStartTLS = "starttls" // plain connection upgraded with STARTTLS, usually port 587
+	SecurityTLS      = "tls"      // TLS from the start (SMTPS), usually port 465
+	SecurityNone     = "none"     // no encryption, only for local relays
+)
+
+// DefaultMailLocale is the language of the emails when none is configured,
+// and of the locales without templates
+const DefaultMailLocale = "es"
+
+// Outbox is the queue the emails are sent from
+type Outbox struct {
+	// emails sent at the same time
+	Workers int `yaml:"workers" toml:"workers" env:"EMAIL_WORKERS"`
+	// tries before an email moves to the failed (dead letter) state
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts" env:"EMAIL_MAX_ATTEMPTS"`
+	// wait after the first failure, doubled on each retry up to MaxRetryDelay
+	RetryDelay    Duration `yaml:"retry_delay" toml:"retry_delay" env:"EMAIL_RETRY_DELAY"`
+	MaxRetryDelay Duration `yaml:"max_retry_delay" toml:"max_retry_delay" env:"EMAIL_MAX_RETRY_DELAY"`
+}
+
+// Emails is how email addresses are compared. Changing it needs
+// "backend normalize-emails"
+type Emails struct {
+	// Ana@uni.edu and ana@uni.edu are the same account
+	FoldLocalPart bool `yaml:"fold_local_part" toml:"fold_local_part" env:"EMAIL_FOLD_LOCAL_PART"`
+	// ana+tag@uni.edu is ana@uni.edu
+	StripSubaddress bool `yaml:"strip_subaddress" toml:"strip_subaddress" env:"EMAIL_STRIP_SUBADDRESS"`
+}
+
+// Registration is who can create an account on their own
+type Registration struct {
+	// open, domain, invite-only or closed
+	Mode string `yaml:"mode" toml:"mode" env:"REGISTRATION_MODE"`
+	// emails accepted in domain mode, like unc.edu.ar or *.edu.ar
+	AllowedDomains []string `yaml:"allowed_domains" toml:"allowed_domains" env:"REGISTRATION_ALLOWED_DOMAINS"`
+	// replaces the bundled blocklist of disposable email domains
+	DisposableDomainsFile string `yaml:"disposable_domains_file" toml:"disposable_domains_file" env:"DISPOSABLE_DOMAINS_FILE"`
+}
+
+// Password is the policy every new password goes through
+type Password struct {
+	MinLength int `yaml:"min_length" toml:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	// minimum strength, from 0 to 4
+	MinScore int `yaml:"min_score" toml:"min_score" env:"PASSWORD_MIN_SCORE"`
+	// besides "unichat", which is always banned
+	BannedWords []string `yaml:"banned_words" toml:"banned_words" env:"PASSWORD_BANNED_WORDS"`
+	// bloom filter of breached passwords, from "backend build-breached-filter"
+	BreachedFile string `yaml:"breached_file" toml:"breached_file" env:"PASSWORD_BREACHED_FILE"`
+}
+
+// Invitations are the links to create an account with a given role
+type Invitations struct {
+	// page of the frontend that receives the ?token= of the link
+	URL string `yaml:"url" toml:"url" env:"INVITATION_URL"`
+	// roles that can invite people besides the admins
+	Roles []string `yaml:"roles" toml:"roles" env:"INVITATION_ROLES"`
+}
+
+// Auth is how the logins with a password are checked
+type Auth struct {
+	// authenticators tried in order: local and ldap
+	Chain []string `yaml:"chain" toml:"chain" env:"AUTH_CHAIN"`
+	LDAP  LDAP     `yaml:"ldap" toml:"ldap"`
+}
+
+// LDAP is the directory of the ldap authenticator
+type LDAP struct {
+	// ldap://host:389 or ldaps://host:636
+	URL string `yaml:"url" toml:"url" env:"LDAP_URL"`
+	// upgrade ldap:// connections with StartTLS
+	StartTLS bool `yaml:"start_tls" toml:"start_tls" env:"LDAP_START_TLS"`
+	// only for development
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" toml:"insecure_skip_verify" env:"LDAP_INSECURE_SKIP_VERIFY"`
+	// PEM bundle that verifies the certificate of the server
+	CAFile string `yaml:"ca_file" toml:"ca_file" env:"LDAP_CA_FILE"`
+	// service account that searches the users, empty for anonymous
+	BindDN       string `yaml:"bind_dn" toml:"bind_dn" env:"LDAP_BIND_DN"`
+	BindPassword string `yaml:"bind_password" toml:"bind_password" env:"LDAP_BIND_PASSWORD"`
+	BaseDN       string `yaml:"base_dn" toml:"base_dn" env:"LDAP_BASE_DN"`
+	// %s is replaced by the escaped login
+	UserFilter         string   `yaml:"user_filter" toml:"user_filter" env:"LDAP_USER_FILTER"`
+	EmailAttribute     string   `yaml:"email_attribute" toml:"email_attribute" env:"LDAP_ATTR_EMAIL"`
+	FirstNameAttribute string   `yaml:"first_name_attribute" toml:"first_name_attribute" env:"LDAP_ATTR_FIRST_NAME"`
+	LastNameAttribute  string   `yaml:"last_name_attribute" toml:"last_name_attribute" env:"LDAP_ATTR_LAST_NAME"`
+	Timeout            Duration `yaml:"timeout" toml:"timeout" env:"LDAP_TIMEOUT"`
+}
+
+// Federation is the external identity providers users can log in with
+type Federation struct {
+	// in the environment, OIDC_PROVIDERS lists the names and each provider
+	// has its OIDC_PROVIDER_<NAME>_* variables, see loadProvidersEnv
+	Providers []IdentityProvider `yaml:"providers" toml:"providers"`
+}
+
+// IdentityProvider is an OpenID Connect provider
+type IdentityProvider struct {
+	// the provider in the URLs, like /auth/university/login
+	Name         string   `yaml:"name" toml:"name"`
+	Issuer       string   `yaml:"issuer" toml:"issuer"`
+	ClientID     string   `yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string `yaml:"scopes" toml:"scopes"`
+}
+
+// Health is the readiness check of /readyz
+type Health struct {
+	// time each component gets to answer
+	Timeout Duration `yaml:"timeout" toml:"timeout" env:"HEALTH_TIMEOUT"`
+	// also connect to the SMTP server, with the smtp mail driver
+	CheckSMTP bool `yaml:"check_smtp" toml:"check_smtp" env:"HEALTH_CHECK_SMTP"`
+}
+
+// Defaults is the configuration before reading any source
+func Defaults() Config {
+	return Config{
+		Server: Server{
+			Port:              8080,
+			CORSOrigins:       []string{"http://localhost:3000"},
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(15 * time.Second),
+			WriteTimeout:      Duration(30 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			MaxHeaderBytes:    64 << 10,
+			DrainPeriod:       Duration(5 * time.Second),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		Database: Database{
+			Driver:      "mysql",
+			Host:        "db",
+			User:        "appuser",
+			Name:        "users_db",
+			AutoMigrate: true,
+		},
+		OIDC: OIDC{
+			Issuer: "http://localhost:8080",
+		},
+		Mail: Mail{
+			Dir:    "mail",
+			Locale: DefaultMailLocale,
+			SMTP:   SMTP{Port: 587, Security: SecurityStartTLS},
+		},
+		Outbox: Outbox{
+			Workers:       2,
+			MaxAttempts:   8,
+			RetryDelay:    Duration(30 * time.Second),
+			MaxRetryDelay: Duration(time.Hour),
+		},
+		Emails: Emails{
+			FoldLocalPart: true,
+		},
+		Registration: Registration{
+			Mode: "open",
+		},
+		Password: Password{
+			MinLength: 8,
+			MinScore:  2,
+		},
+		Invitations: Invitations{
+			URL:   "http://localhost:3000/invitations/accept",
+			Roles: []string{"professor"},
+		},
+		Auth: Auth{
+			Chain: []string{"local"},
+			LDAP: LDAP{
+				UserFilter:         "(mail=%s)",
+				EmailAttribute:     "mail",
+				FirstNameAttribute: "givenName",
+				LastNameAttribute:  "sn",
+				Timeout:            Duration(10 * time.Second),
+			},
+		},
+		Health: Health{
+			Timeout: Duration(2 * time.Second),
+		},
+	}
+}
+
+// Load resolves the configuration from args (the command line without the
+// program name) and the environment, and validates it. The file is the one
+// of -config or CONFIG_FILE, if any. It also returns the arguments left after
+// the flags, like the name of a maintenance command
+func Load(args []string) (Config, []string, error) {
+	cfg := Defaults()
+
+	flags := flag.NewFlagSet("backend", flag.ContinueOnError)
+	path := flags.String("config", "", "YAML (.yaml, .yml) or TOML (.toml) configuration file, also CONFIG_FILE")
+	values := registerFlags(flags, &cfg)
+	if err := flags.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	if *path == "" {
+		*path = os.Getenv("CONFIG_FILE")
+	}
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	if err := loadEnv(&cfg); err != nil {
+		return Config{}, nil, err
+	}
+	if err := applyFlags(flags, values); err != nil {
+		return Config{}, nil, err
+	}
+
+	cfg.resolve()
+	if err := cfg.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// resolve fills the settings whose default depends on others
+func (c *Config) resolve() {
+	c.Database.Driver = strings.ToLower(strings.TrimSpace(c.Database.Driver))
+	if c.Database.Port == 0 {
+		switch c.Database.Driver {
+		case "mysql":
+			c.Database.Port = 3306
+		case "postgres":
+			c.Database.Port = 5432
+		}
+	}
+
+	c.Mail.Driver = strings.ToLower(strings.TrimSpace(c.Mail.Driver))
+	if c.Mail.Driver == "" {
+		c.Mail.Driver = "log"
+		if c.Mail.SMTP.Host != "" {
+			c.Mail.Driver = "smtp"
+		}
+	}
+	c.Mail.SMTP.Security = strings.ToLower(strings.TrimSpace(c.Mail.SMTP.Security))
+	c.Mail.Locale = strings.ToLower(strings.TrimSpace(c.Mail.Locale))
+
+	c.OIDC.Issuer = strings.TrimSuffix(strings.TrimSpace(c.OIDC.Issuer), "/")
+	c.Registration.Mode = strings.ToLower(strings.TrimSpace(c.Registration.Mode))
+	c.Invitations.Roles = lowerAll(c.Invitations.Roles)
+	c.Auth.Chain = lowerAll(c.Auth.Chain)
+	for i := range c.Federation.Providers {
+		provider := &c.Federation.Providers[i]
+		provider.Name = strings.ToLower(strings.TrimSpace(provider.Name))
+	}
+}
+
+// lowerAll lowercases and trims a list, dropping the empty items
+func lowerAll(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value = strings.ToLower(strings.TrimSpace(value)); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// Duration is a time.Duration written like "15s" or "2m" in every source
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(strings.TrimSpace(string(text)))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration like 15s or 2m", text)
+	}
+	*d = Duration(value)
+	return nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Errors are all the problems Validate found, one per line
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(lines, "\n  ")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// invalid is the error of a setting, named by its file key and variable
+func invalid(key string, env string, format string, args ...interface{}) error {
+	return fmt.Errorf("%s (%s): %s", key, env, fmt.Sprintf(format, args...))
+}
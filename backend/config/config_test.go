@@ -0,0 +1,149 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backend/config"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeFile writes a configuration file in a temporary folder
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setEnv sets the variables for the test, after clearing the ones a
+// developer may have exported
+func setEnv(t *testing.T, variables map[string]string) {
+	t.Helper()
+	for _, name := range []string{"CONFIG_FILE", "PORT", "APP_ENV", "DB_DRIVER", "DB_NAME", "JWT_SECRET", "JWT_SECRET_FILE", "MAIL_DRIVER", "OIDC_PROVIDERS"} {
+		t.Setenv(name, "")
+	}
+	for name, value := range variables {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+server:
+  port: 8081
+  env: staging
+database:
+  driver: sqlite
+  name: from-file
+jwt:
+  secret: `+testSecret+`
+`)
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		port     int
+		database string
+	}{
+		{"defaults", map[string]string{"JWT_SECRET": testSecret}, nil, 8080, "users_db"},
+		{"file over defaults", nil, []string{"-config", file}, 8081, "from-file"},
+		{"CONFIG_FILE", map[string]string{"CONFIG_FILE": file}, nil, 8081, "from-file"},
+		{"env over file", map[string]string{"PORT": "8082"}, []string{"-config", file}, 8082, "from-file"},
+		{"flags over env", map[string]string{"PORT": "8082", "DB_NAME": "from-env"}, []string{"-config", file, "-port", "8083"}, 8083, "from-env"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, test.env)
+			cfg, _, err := config.Load(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != test.port || cfg.Database.Name != test.database {
+				t.Errorf("got port %d and database %s, expected %d and %s", cfg.Server.Port, cfg.Database.Name, test.port, test.database)
+			}
+		})
+	}
+}
+
+func TestLoadReadsSecretsFromFiles(t *testing.T) {
+	file := writeFile(t, "config.toml", "[jwt]\nsecret = \"from-the-config-file-0123456789ab\"\n")
+	secret := writeFile(t, "jwt_secret", testSecret+"\n")
+
+	setEnv(t, map[string]string{"JWT_SECRET_FILE": secret})
+	cfg, _, err := config.Load([]string{"-config", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the trailing newline of the file isn't part of the secret
+	if cfg.JWT.Secret != testSecret {
+		t.Errorf("got the secret %q, expected the one of JWT_SECRET_FILE", cfg.JWT.Secret)
+	}
+
+	setEnv(t, map[string]string{"JWT_SECRET": testSecret, "JWT_SECRET_FILE": secret})
+	if _, _, err := config.Load(nil); err == nil || !strings.Contains(err.Error(), "JWT_SECRET and JWT_SECRET_FILE are both set") {
+		t.Errorf("expected an error for both variables, got %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		key     string
+	}{
+		{"yaml section", "config.yaml", "servr:\n  port: 8081\n", "servr"},
+		{"yaml key", "config.yaml", "server:\n  prot: 8081\n", "prot"},
+		{"toml section", "config.toml", "[servr]\nport = 8081\n", "servr"},
+		{"toml key", "config.toml", "[server]\nprot = 8081\n", "prot"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setEnv(t, map[string]string{"JWT_SECRET": testSecret})
+			_, _, err := config.Load([]string{"-config", writeFile(t, test.file, test.content)})
+			if err == nil || !strings.Contains(err.Error(), test.key) {
+				t.Errorf("expected an error naming %s, got %v", test.key, err)
+			}
+		})
+	}
+
+	setEnv(t, map[string]string{"JWT_SECRET": testSecret})
+	if _, _, err := config.Load([]string{"-config", writeFile(t, "config.json", "{}")}); err == nil {
+		t.Error("a .json file was accepted")
+	}
+}
+
+func TestLoadReportsEveryInvalidSetting(t *testing.T) {
+	setEnv(t, map[string]string{"PORT": "0", "DB_DRIVER": "oracle"})
+	_, _, err := config.Load(nil)
+
+	var errs config.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected config.Errors, got %v", err)
+	}
+	for _, name := range []string{"PORT", "DB_DRIVER", "JWT_SECRET"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("the error doesn't name %s: %v", name, err)
+		}
+	}
+}
+
+// The example documents every key with its default, so it must load as is
+func TestExampleFileLoads(t *testing.T) {
+	setEnv(t, map[string]string{"JWT_SECRET": testSecret})
+	cfg, _, err := config.Load([]string{"-config", "../config.example.yaml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != config.Defaults().Server.Port {
+		t.Errorf("the example has port %d, the default is %d", cfg.Server.Port, config.Defaults().Server.Port)
+	}
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a field of Config that can be set from the environment
+type setting struct {
+	env   string
+	flag  string
+	value reflect.Value
+}
+
+// settings lists the fields of cfg with an env tag, walking the sections
+func settings(cfg *Config) []setting {
+	var list []setting
+	var walk func(value reflect.Value)
+	walk = func(value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			field, tag := value.Field(i), value.Type().Field(i).Tag
+			if field.Kind() == reflect.Struct {
+				walk(field)
+				continue
+			}
+			if env := tag.Get("env"); env != "" {
+				list = append(list, setting{env: env, flag: tag.Get("flag"), value: field})
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+	return list
+}
+
+// set parses text into the setting, as written in the environment: lists are
+// comma separated
+func (s setting) set(text string) error {
+	text = strings.TrimSpace(text)
+	if unmarshaler, ok := s.value.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		if err := unmarshaler.UnmarshalText([]byte(text)); err != nil {
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+		return nil
+	}
+	switch s.value.Kind() {
+	case reflect.String:
+		s.value.SetString(text)
+	case reflect.Int:
+		number, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", s.env, text)
+		}
+		s.value.SetInt(int64(number))
+	case reflect.Bool:
+		flag, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", s.env, text)
+		}
+		s.value.SetBool(flag)
+	case reflect.Slice:
+		items := []string{}
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		s.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", s.env, s.value.Type())
+	}
+	return nil
+}
+
+// loadFile reads a YAML or TOML file, picked by its extension. Unknown keys
+// are an error, so a typo doesn't leave a setting silently at its default
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading the configuration file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(cfg)
+		if errors.Is(err, io.EOF) {
+			// an empty file keeps the defaults
+			err = nil
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+		var unknown *toml.StrictMissingError
+		if errors.As(err, &unknown) {
+			// the plain error doesn't say which keys
+			err = errors.New(unknown.String())
+		}
+	default:
+		return fmt.Errorf("unknown configuration file type %q, expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables that are set. Empty ones count as
+// unset, like the blank entries of .env.example. NAME_FILE reads the value of
+// NAME from a file without its trailing newline, for Docker secrets
+func loadEnv(cfg *Config) error {
+	for _, s := range settings(cfg) {
+		value, ok, err := lookupEnv(s.env)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			return err
+		}
+	}
+	return loadProvidersEnv(&cfg.Federation)
+}
+
+// lookupEnv reads the variable name, or the file of name_FILE
+func lookupEnv(name string) (string, bool, error) {
+	value := os.Getenv(name)
+	ok := strings.TrimSpace(value) != ""
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		if ok {
+			return "", false, fmt.Errorf("%s and %s_FILE are both set, use only one", name, name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return strings.TrimSpace(value), ok, nil
+}
+
+// loadProvidersEnv applies the identity providers of the environment. The
+// list has no fixed variables: OIDC_PROVIDERS names the providers and
+// OIDC_PROVIDER_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and
+// _SCOPES (space separated) set each one, over the provider with the same
+// name in the file, if any
+func loadProvidersEnv(federation *Federation) error {
+	names, _, err := lookupEnv("OIDC_PROVIDERS")
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		index := -1
+		for i, provider := range federation.Providers {
+			if strings.EqualFold(strings.TrimSpace(provider.Name), name) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			federation.Providers = append(federation.Providers, IdentityProvider{Name: name})
+			index = len(federation.Providers) - 1
+		}
+		provider := &federation.Providers[index]
+
+		prefix := "OIDC_PROVIDER_" + strings.ToUpper(name) + "_"
+		for suffix, target := range map[string]*string{
+			"ISSUER":        &provider.Issuer,
+			"CLIENT_ID":     &provider.ClientID,
+			"CLIENT_SECRET": &provider.ClientSecret,
+			"REDIRECT_URL":  &provider.RedirectURL,
+		} {
+			value, ok, err := lookupEnv(prefix + suffix)
+			if err != nil {
+				return err
+			}
+			if ok {
+				*target = value
+			}
+		}
+		scopes, ok, err := lookupEnv(prefix + "SCOPES")
+		if err != nil {
+			return err
+		}
+		if ok {
+			provider.Scopes = strings.Fields(scopes)
+		}
+	}
+	return nil
+}
+
+// flagSetting is a setting with a command line flag, and the text given to it
+type flagSetting struct {
+	setting
+	text *string
+}
+
+// registerFlags defines a flag for each setting with a flag tag. They are
+// applied after the environment, so the values are kept aside until then
+func registerFlags(flags *flag.FlagSet, cfg *Config) map[string]flagSetting {
+	values := map[string]flagSetting{}
+	for _, s := range settings(cfg) {
+		if s.flag != "" {
+			values[s.flag] = flagSetting{setting: s, text: flags.String(s.flag, "", "overrides "+s.env)}
+		}
+	}
+	return values
+}
+
+// applyFlags applies the flags given in the command line
+func applyFlags(flags *flag.FlagSet, values map[string]flagSetting) error {
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := values[f.Name]; ok && err == nil {
+			if err = value.set(*value.text); err != nil {
+				err = fmt.Errorf("-%s: %w", f.Name, err)
+			}
+		}
+	})
+	return err
+}
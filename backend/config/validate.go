@@ -0,0 +1,245 @@
+package config
+
+import (
+	"backend/i18n"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// Validate checks every setting and reports all the problems at once
+func (c Config) Validate() error {
+	var errs Errors
+	check := func(err error) {
+		if list, ok := err.(Errors); ok {
+			// the checks of a section report a problem each
+			errs = append(errs, list...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(validPort("server.port", "PORT", c.Server.Port))
+	if len(c.Server.CORSOrigins) == 0 {
+		check(invalid("server.cors_origins", "CORS_ORIGINS", "at least one origin is required"))
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		check(validOrigin(origin))
+	}
+	// zero disables a timeout, except the one of the headers
+	check(positive("server.read_header_timeout", "HTTP_READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout, false))
+	check(positive("server.read_timeout", "HTTP_READ_TIMEOUT", c.Server.ReadTimeout, true))
+	check(positive("server.write_timeout", "HTTP_WRITE_TIMEOUT", c.Server.WriteTimeout, true))
+	check(positive("server.idle_timeout", "HTTP_IDLE_TIMEOUT", c.Server.IdleTimeout, true))
+	if c.Server.MaxHeaderBytes < 4<<10 {
+		check(invalid("server.max_header_bytes", "HTTP_MAX_HEADER_BYTES", "%d is less than 4096 bytes", c.Server.MaxHeaderBytes))
+	}
+	check(positive("server.drain_period", "SHUTDOWN_DRAIN_PERIOD", c.Server.DrainPeriod, true))
+	check(positive("server.shutdown_timeout", "SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout, false))
+
+	db := c.Database
+	switch db.Driver {
+	case "mysql", "postgres":
+		if db.DSN == "" {
+			check(required("database.host", "DB_HOST", db.Host))
+			check(validPort("database.port", "DB_PORT", db.Port))
+			check(required("database.user", "DB_USER", db.User))
+			check(required("database.name", "DB_NAME", db.Name))
+		}
+	case "sqlite":
+		if db.DSN == "" {
+			check(required("database.name", "DB_NAME", db.Name))
+		}
+	default:
+		check(invalid("database.driver", "DB_DRIVER", "unknown driver %q, expected mysql, postgres or sqlite", db.Driver))
+	}
+
+	check(required("jwt.secret", "JWT_SECRET", c.JWT.Secret))
+
+	switch c.Mail.Driver {
+	case "smtp":
+		check(required("mail.smtp.host", "SMTP_HOST", c.Mail.SMTP.Host))
+		check(validPort("mail.smtp.port", "SMTP_PORT", c.Mail.SMTP.Port))
+		switch c.Mail.SMTP.Security {
+		case SecurityStartTLS, SecurityTLS, SecurityNone:
+		default:
+			check(invalid("mail.smtp.security", "SMTP_SECURITY", "unknown security %q, expected starttls, tls or none", c.Mail.SMTP.Security))
+		}
+	case "file":
+		check(required("mail.dir", "MAIL_DIR", c.Mail.Dir))
+	case "log":
+	default:
+		check(invalid("mail.driver", "MAIL_DRIVER", "unknown driver %q, expected smtp, file or log", c.Mail.Driver))
+	}
+	if c.Mail.From != "" {
+		if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+			check(invalid("mail.from", "SMTP_FROM", "%q is not an email address: %v", c.Mail.From, err))
+		}
+	}
+	if locale, ok := i18n.Supported(c.Mail.Locale); !ok || locale != c.Mail.Locale {
+		check(invalid("mail.locale", "MAIL_LOCALE", "unsupported locale %q, expected one of %v", c.Mail.Locale, i18n.Locales()))
+	}
+
+	check(validURL("oidc.issuer", "OIDC_ISSUER", c.OIDC.Issuer))
+
+	if c.Outbox.Workers < 1 {
+		check(invalid("outbox.workers", "EMAIL_WORKERS", "%d is less than 1", c.Outbox.Workers))
+	}
+	if c.Outbox.MaxAttempts < 1 {
+		check(invalid("outbox.max_attempts", "EMAIL_MAX_ATTEMPTS", "%d is less than 1", c.Outbox.MaxAttempts))
+	}
+	check(positive("outbox.retry_delay", "EMAIL_RETRY_DELAY", c.Outbox.RetryDelay, false))
+	if c.Outbox.MaxRetryDelay < c.Outbox.RetryDelay {
+		check(invalid("outbox.max_retry_delay", "EMAIL_MAX_RETRY_DELAY", "%s is shorter than the retry delay %s", c.Outbox.MaxRetryDelay, c.Outbox.RetryDelay))
+	}
+
+	switch c.Registration.Mode {
+	case "open", "invite-only", "closed":
+	case "domain":
+		if len(c.Registration.AllowedDomains) == 0 {
+			check(invalid("registration.allowed_domains", "REGISTRATION_ALLOWED_DOMAINS", "is required in the domain mode"))
+		}
+	default:
+		check(invalid("registration.mode", "REGISTRATION_MODE", "unknown mode %q, expected open, domain, invite-only or closed", c.Registration.Mode))
+	}
+
+	// the maximum length of the policy is 128
+	if c.Password.MinLength < 1 || c.Password.MinLength > 128 {
+		check(invalid("password.min_length", "PASSWORD_MIN_LENGTH", "%d is not between 1 and 128", c.Password.MinLength))
+	}
+	if c.Password.MinScore < 0 || c.Password.MinScore > 4 {
+		check(invalid("password.min_score", "PASSWORD_MIN_SCORE", "%d is not between 0 and 4", c.Password.MinScore))
+	}
+
+	check(validURL("invitations.url", "INVITATION_URL", c.Invitations.URL))
+	for _, role := range c.Invitations.Roles {
+		switch role {
+		case "student", "professor", "admin":
+		default:
+			check(invalid("invitations.roles", "INVITATION_ROLES", "unknown role %q, expected student, professor or admin", role))
+		}
+	}
+
+	check(c.Auth.validate())
+	for i, provider := range c.Federation.Providers {
+		for _, other := range c.Federation.Providers[:i] {
+			if other.Name == provider.Name && provider.Name != "" {
+				check(invalid("federation.providers", "OIDC_PROVIDERS", "%q is repeated", provider.Name))
+			}
+		}
+		check(provider.validate())
+	}
+
+	check(positive("health.timeout", "HEALTH_TIMEOUT", c.Health.Timeout, false))
+	if c.Health.CheckSMTP && c.Mail.Driver != "smtp" {
+		check(invalid("health.check_smtp", "HEALTH_CHECK_SMTP", "needs the smtp mail driver, not %q", c.Mail.Driver))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate checks the chain and, when the chain uses it, the directory
+func (a Auth) validate() error {
+	var errs Errors
+	if len(a.Chain) == 0 {
+		errs = append(errs, invalid("auth.chain", "AUTH_CHAIN", "at least one authenticator is required"))
+	}
+	ldap := false
+	for _, name := range a.Chain {
+		switch name {
+		case "local":
+		case "ldap":
+			ldap = true
+		default:
+			errs = append(errs, invalid("auth.chain", "AUTH_CHAIN", "unknown authenticator %q, expected local or ldap", name))
+		}
+	}
+	if ldap {
+		if parsed, err := url.Parse(a.LDAP.URL); err != nil || (parsed.Scheme != "ldap" && parsed.Scheme != "ldaps") || parsed.Host == "" {
+			errs = append(errs, invalid("auth.ldap.url", "LDAP_URL", "%q is not a URL like ldaps://ldap.example.com:636", a.LDAP.URL))
+		}
+		if a.LDAP.BaseDN == "" {
+			errs = append(errs, invalid("auth.ldap.base_dn", "LDAP_BASE_DN", "is required with the ldap authenticator"))
+		}
+		if !strings.Contains(a.LDAP.UserFilter, "%s") {
+			errs = append(errs, invalid("auth.ldap.user_filter", "LDAP_USER_FILTER", "%q has no %%s for the login", a.LDAP.UserFilter))
+		}
+		if err := positive("auth.ldap.timeout", "LDAP_TIMEOUT", a.LDAP.Timeout, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate checks an identity provider. The variables are named after it
+func (p IdentityProvider) validate() error {
+	if p.Name == "" {
+		return invalid("federation.providers.name", "OIDC_PROVIDERS", "is required")
+	}
+	prefix := "OIDC_PROVIDER_" + strings.ToUpper(p.Name) + "_"
+	key := "federation.providers." + p.Name + "."
+	var errs Errors
+	if err := validURL(key+"issuer", prefix+"ISSUER", p.Issuer); err != nil {
+		errs = append(errs, err)
+	}
+	if err := required(key+"client_id", prefix+"CLIENT_ID", p.ClientID); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validURL(key+"redirect_url", prefix+"REDIRECT_URL", p.RedirectURL); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func required(key string, env string, value string) error {
+	if value == "" {
+		return invalid(key, env, "is required")
+	}
+	return nil
+}
+
+func positive(key string, env string, value Duration, zeroAllowed bool) error {
+	if value < 0 {
+		return invalid(key, env, "%s can't be negative", value)
+	}
+	if value == 0 && !zeroAllowed {
+		return invalid(key, env, "must be more than zero")
+	}
+	return nil
+}
+
+func validPort(key string, env string, port int) error {
+	if port < 1 || port > 65535 {
+		return invalid(key, env, "port %d is not between 1 and 65535", port)
+	}
+	return nil
+}
+
+// validURL checks an absolute http or https URL
+func validURL(key string, env string, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return invalid(key, env, "%q is not a URL like https://example.com", value)
+	}
+	return nil
+}
+
+// validOrigin checks a CORS origin: a scheme and a host, without a path or a
+// trailing slash. "*" is not allowed, the requests carry credentials
+func validOrigin(origin string) error {
+	parsed, err := url.Parse(origin)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+		parsed.Path != "" || parsed.RawQuery != "" {
+		return invalid("server.cors_origins", "CORS_ORIGINS", "%q is not an origin like https://app.example.com", origin)
+	}
+	return nil
+}
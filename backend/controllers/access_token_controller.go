@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (c *UserController) CreateAccessToken(ctx *gin.Context) {
+	var request dto.CreateAccessTokenRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	response, err := c.users.CreateAccessToken(getAuthContext(ctx).UserID, request)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func (c *UserController) GetAccessTokens(ctx *gin.Context) {
+	tokens, err := c.users.GetAccessTokens(getAuthContext(ctx).UserID)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+func (c *UserController) RevokeAccessToken(ctx *gin.Context) {
+	tokenID, err := strconv.Atoi(ctx.Param("token_id"))
+	if err != nil {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "access_token.invalid_id", nil)
+		return
+	}
+
+	err = c.users.RevokeAccessToken(getAuthContext(ctx).UserID, tokenID)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respondMessage(ctx, http.StatusOK, "access_token.revoked", nil)
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"backend/services"
+	"backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailController expone la cola de emails a los administradores
+type EmailController struct {
+	outbox *services.EmailOutbox
+}
+
+func NewEmailController(outbox *services.EmailOutbox) *EmailController {
+	return &EmailController{outbox: outbox}
+}
+
+func (c *EmailController) GetOutboxEmails(ctx *gin.Context) {
+	// por defecto lista los que agotaron los reintentos
+	emails, apiErr := c.outbox.GetOutboxEmails(ctx.Query("status"))
+	if apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, emails)
+}
+
+func (c *EmailController) ResendOutboxEmail(ctx *gin.Context) {
+	emailID, err := strconv.Atoi(ctx.Param("email_id"))
+	if err != nil {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "email.invalid_id", nil)
+		return
+	}
+
+	email, apiErr := c.outbox.ResendOutboxEmail(emailID)
+	if apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, email)
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"backend/i18n"
+	"backend/utils"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tipo de contenido de los errores (RFC 7807)
+const problemContentType = "application/problem+json"
+
+// solo en desarrollo los errores 5xx muestran su causa interna
+var showInternalErrors bool
+
+// ShowInternalErrors hace que los errores 5xx muestren su causa interna, para
+// desarrollo
+func ShowInternalErrors(show bool) {
+	showInternalErrors = show
+}
+
+// RenderErrors es el middleware que escribe los errores que dejaron los
+// handlers con abortWithError. Todas las respuestas de error salen de acá
+func RenderErrors(ctx *gin.Context) {
+	ctx.Next()
+
+	if len(ctx.Errors) == 0 || ctx.Writer.Written() {
+		return
+	}
+	renderProblem(ctx, ctx.Errors.Last().Err)
+}
+
+// NotFound responde las rutas que no existen
+func NotFound(ctx *gin.Context) {
+	abortWithErrorKey(ctx, http.StatusNotFound, utils.CodeNotFound, "error.not_found", nil)
+}
+
+// Recover convierte un panic de un handler en un error 500. El panic ya lo
+// registra el middleware de recovery de gin
+func Recover(ctx *gin.Context, recovered interface{}) {
+	// el panic saltea RenderErrors, así que el error se escribe acá
+	ctx.Abort()
+	if !ctx.Writer.Written() {
+		renderProblem(ctx, utils.NewInternalServerApiError("Panic handling request", fmt.Errorf("%v", recovered)))
+	}
+}
+
+// abortWithError deja el error para RenderErrors y corta la cadena de handlers
+func abortWithError(ctx *gin.Context, err error) {
+	ctx.Error(err)
+	ctx.Abort()
+}
+
+// abortWithErrorKey corta la request con un error del catalogo
+func abortWithErrorKey(ctx *gin.Context, status int, code string, key string, params i18n.Params) {
+	abortWithError(ctx, utils.NewLocalizedApiError(key, params, code, status, utils.CauseList{}))
+}
+
+// abortWithBindError corta la request con el error de validación del body
+func abortWithBindError(ctx *gin.Context, err error) {
+	abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeValidation, "request.invalid", i18n.Params{"detail": err.Error()})
+}
+
+// renderProblem escribe el error como application/problem+json. Los errores
+// sin tipo son inesperados: salen como 500 con un mensaje genérico
+func renderProblem(ctx *gin.Context, err error) {
+	var apiErr utils.ApiError
+	if !errors.As(err, &apiErr) {
+		apiErr = utils.NewInternalServerApiError("Unexpected error", err)
+	}
+
+	key, params := "error.unexpected", i18n.Params(nil)
+	var localized i18n.Localized
+	if errors.As(apiErr, &localized) {
+		key, params = localized.MessageKey(), localized.MessageParams()
+	}
+
+	locale := requestLocale(ctx)
+	status := apiErr.Status()
+	problem := gin.H{
+		"type":        "about:blank",
+		"title":       http.StatusText(status),
+		"status":      status,
+		"detail":      i18n.T(locale, key, params),
+		"instance":    ctx.Request.URL.Path,
+		"code":        apiErr.Code(),
+		"message_key": key,
+	}
+
+	if status >= http.StatusInternalServerError {
+		// la causa queda en el log, nunca en la respuesta de producción
+		log.Printf("Error handling %s %s: %v", ctx.Request.Method, ctx.Request.URL.Path, err)
+		if showInternalErrors {
+			problem["cause"] = apiErr.Cause()
+		}
+	} else if causes := apiErr.Cause(); len(causes) > 0 {
+		problem["cause"] = localizeCauses(locale, causes)
+	}
+
+	ctx.Header("Content-Type", problemContentType)
+	ctx.JSON(status, problem)
+}
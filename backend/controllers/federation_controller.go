@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"backend/i18n"
+	"backend/services"
+	"backend/utils"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cookie que guarda el estado firmado mientras el usuario esta en el proveedor externo
+const federationStateCookie = "federation_state"
+
+// FederationController atiende el login con los proveedores de identidad externos
+type FederationController struct {
+	federation *services.FederationService
+}
+
+func NewFederationController(federation *services.FederationService) *FederationController {
+	return &FederationController{federation: federation}
+}
+
+func (c *FederationController) GetIdentityProviders(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"providers": c.federation.GetIdentityProviders()})
+}
+
+// FederatedLogin redirects the user to the external identity provider
+func (c *FederationController) FederatedLogin(ctx *gin.Context) {
+	provider := ctx.Param("provider")
+
+	redirectURL, state, err := c.federation.StartFederatedLogin(provider)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	// SameSite Lax: la cookie tiene que viajar en la redireccion de vuelta desde el proveedor
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(federationStateCookie, state, 600, "/auth/", "", isSecureRequest(ctx), true)
+	ctx.Redirect(http.StatusFound, redirectURL)
+}
+
+// FederatedCallback finishes the login started by FederatedLogin and returns
+// the same token pair as /users/login
+func (c *FederationController) FederatedCallback(ctx *gin.Context) {
+	provider := ctx.Param("provider")
+
+	savedState, _ := ctx.Cookie(federationStateCookie)
+	// la cookie es de un solo uso
+	ctx.SetCookie(federationStateCookie, "", -1, "/auth/", "", isSecureRequest(ctx), true)
+
+	if errorCode := ctx.Query("error"); errorCode != "" {
+		abortWithErrorKey(ctx, http.StatusUnauthorized, utils.CodeUnauthorized, "federation.cancelled", i18n.Params{"error": errorCode})
+		return
+	}
+
+	response, err := c.federation.CompleteFederatedLogin(provider, ctx.Query("code"), ctx.Query("state"), savedState)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// isSecureRequest reports whether the request came over HTTPS, directly or through a proxy
+func isSecureRequest(ctx *gin.Context) bool {
+	return ctx.Request.TLS != nil || strings.EqualFold(ctx.GetHeader("X-Forwarded-Proto"), "https")
+}
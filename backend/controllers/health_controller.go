@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthController atiende las sondas de liveness y readiness. draining dice
+// si el servicio se está apagando
+type HealthController struct {
+	health   *services.HealthService
+	draining func() bool
+}
+
+func NewHealthController(health *services.HealthService, draining func() bool) *HealthController {
+	return &HealthController{health: health, draining: draining}
+}
+
+// Liveness responde mientras el proceso pueda atender, sin mirar las
+// dependencias: si la base se cae, reiniciar el proceso no la arregla
+func (c *HealthController) Liveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readiness responde 200 si todos los componentes responden y 503 si alguno
+// falla o el servicio se está apagando, para que el balanceador deje de
+// mandarle pedidos
+func (c *HealthController) Readiness(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	if c.draining() {
+		ctx.JSON(http.StatusServiceUnavailable, dto.HealthResponse{Status: services.HealthDraining})
+		return
+	}
+
+	response := c.health.Readiness(ctx.Request.Context())
+	// la causa puede nombrar hosts o usuarios, solo se muestra en desarrollo
+	if !showInternalErrors {
+		for name, check := range response.Checks {
+			if check.Error != "" {
+				check.Error = "unavailable"
+				response.Checks[name] = check
+			}
+		}
+	}
+
+	status := http.StatusOK
+	if response.Status != services.HealthReady {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, response)
+}
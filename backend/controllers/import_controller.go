@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/i18n"
+	"backend/utils"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ImportUsers recibe el CSV como archivo "file" de un form multipart o como
+// cuerpo text/csv. Por defecto es un dry run: ?dry_run=false escribe los usuarios
+func (c *UserController) ImportUsers(ctx *gin.Context) {
+	var options dto.ImportUsersOptions
+	if err := ctx.ShouldBindQuery(&options); err != nil {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeValidation, "request.invalid_options", i18n.Params{"detail": err.Error()})
+		return
+	}
+	// sin idioma elegido, los emails van en el de quien importa
+	if options.Locale == "" {
+		options.Locale = requestLocale(ctx)
+	}
+
+	var file io.Reader = ctx.Request.Body
+	if strings.HasPrefix(ctx.ContentType(), "multipart/form-data") {
+		header, err := ctx.FormFile("file")
+		if err != nil {
+			abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "import.file_required", nil)
+			return
+		}
+		opened, err := header.Open()
+		if err != nil {
+			abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "import.read_failed", nil)
+			return
+		}
+		defer opened.Close()
+		file = opened
+	}
+
+	response, err := c.users.ImportUsers(file, options)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
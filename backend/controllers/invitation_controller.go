@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/services"
+	"backend/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InvitationController atiende las invitaciones para crear una cuenta
+type InvitationController struct {
+	invitations *services.InvitationService
+}
+
+func NewInvitationController(invitations *services.InvitationService) *InvitationController {
+	return &InvitationController{invitations: invitations}
+}
+
+func (c *InvitationController) CreateInvitation(ctx *gin.Context) {
+	var request dto.CreateInvitationRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	// sin idioma elegido, el email va en el de quien invita
+	if request.Locale == "" {
+		request.Locale = requestLocale(ctx)
+	}
+
+	invitation, apiErr := c.invitations.CreateInvitation(getAuthContext(ctx).UserID, request)
+	if apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, invitation)
+}
+
+func (c *InvitationController) GetInvitations(ctx *gin.Context) {
+	// los admins ven todas, el resto solo las que envió
+	invitations, apiErr := c.invitations.GetInvitations(getAuthContext(ctx).UserID)
+	if apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (c *InvitationController) RevokeInvitation(ctx *gin.Context) {
+	invitationID, err := strconv.Atoi(ctx.Param("invitation_id"))
+	if err != nil {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "invitation.invalid_id", nil)
+		return
+	}
+
+	if apiErr := c.invitations.RevokeInvitation(getAuthContext(ctx).UserID, invitationID); apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	respondMessage(ctx, http.StatusOK, "invitation.revoke_done", nil)
+}
+
+func (c *InvitationController) PreviewInvitation(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if token == "" {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "invitation.token_required", nil)
+		return
+	}
+
+	invitation, apiErr := c.invitations.PreviewInvitation(token)
+	if apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+func (c *InvitationController) AcceptInvitation(ctx *gin.Context) {
+	var request dto.AcceptInvitationRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	if request.Locale == "" {
+		request.Locale = requestLocale(ctx)
+	}
+
+	// el enlace prueba que el invitado es dueño del correo, no hace falta verificarlo
+	response, apiErr := c.invitations.AcceptInvitation(request)
+	if apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, response)
+}
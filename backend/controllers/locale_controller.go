@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/i18n"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clave con la que requestLocale guarda el idioma elegido para la request
+const localeKey = "locale"
+
+// clave con la que VerifyToken guarda como buscar el idioma del usuario
+const savedLocaleKey = "saved_locale"
+
+// UpdateLocale guarda el idioma de los mensajes y los emails del usuario
+func (c *UserController) UpdateLocale(ctx *gin.Context) {
+	var request dto.UpdateLocaleRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	if apiErr := c.users.UpdateUserLocale(getAuthContext(ctx).UserID, request.Locale); apiErr != nil {
+		abortWithError(ctx, apiErr)
+		return
+	}
+
+	// la respuesta ya sale en el idioma nuevo
+	locale, _ := i18n.Supported(request.Locale)
+	ctx.Set(localeKey, locale)
+	respondMessage(ctx, http.StatusOK, "locale.updated", nil)
+}
+
+// requestLocale elige el idioma de la respuesta: el que guardó el usuario si
+// la request trae su sesión, y si no el mejor que pida Accept-Language
+func requestLocale(ctx *gin.Context) string {
+	locale := ctx.GetString(localeKey)
+	if locale == "" {
+		locale = i18n.Negotiate(ctx.GetHeader("Accept-Language"))
+		value, _ := ctx.Get(savedLocaleKey)
+		if savedLocale, ok := value.(func() string); ok {
+			if saved := savedLocale(); saved != "" {
+				locale = saved
+			}
+		}
+		ctx.Set(localeKey, locale)
+	}
+	ctx.Header("Content-Language", locale)
+	return locale
+}
+
+// respondMessage responde un mensaje del catalogo junto con su clave estable
+func respondMessage(ctx *gin.Context, status int, key string, params i18n.Params) {
+	ctx.JSON(status, gin.H{"message": i18n.T(requestLocale(ctx), key, params), "message_key": key})
+}
+
+// localizeCauses traduce el mensaje de las causas que traen message_key,
+// como las reglas incumplidas de la política de contraseñas
+func localizeCauses(locale string, causes []interface{}) []interface{} {
+	result := make([]interface{}, 0, len(causes))
+	for _, cause := range causes {
+		fields, ok := cause.(map[string]interface{})
+		key, hasKey := fields["message_key"].(string)
+		if !ok || !hasKey {
+			result = append(result, cause)
+			continue
+		}
+		localized := make(map[string]interface{}, len(fields))
+		for name, value := range fields {
+			localized[name] = value
+		}
+		params, _ := fields["params"].(i18n.Params)
+		localized["message"] = i18n.T(locale, key, params)
+		result = append(result, localized)
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/i18n"
+	"backend/services"
+	"backend/utils"
+	"bytes"
+	"embed"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed templates/authorize.html
+var templatesFS embed.FS
+
+var authorizeTemplate = template.Must(template.ParseFS(templatesFS, "templates/authorize.html"))
+
+// OAuthController atiende los flujos donde un usuario autoriza a un cliente
+// y los endpoints de OpenID Connect que dependen del usuario
+type OAuthController struct {
+	oauth *services.OAuthService
+}
+
+func NewOAuthController(oauth *services.OAuthService) *OAuthController {
+	return &OAuthController{oauth: oauth}
+}
+
+// authorizePage is what the login and consent page renders
+type authorizePage struct {
+	Locale     string
+	Request    dto.AuthorizeRequest
+	ClientName string
+	Scopes     []string
+	Email      string
+	Error      string
+	Fatal      bool // the request can't continue and must not be redirected
+}
+
+// T translates a text of the page to the locale of the request
+func (p authorizePage) T(key string) string {
+	return i18n.T(p.Locale, key, nil)
+}
+
+// Consent is the consent sentence with the client name in bold. The name is
+// escaped, the catalog text is ours
+func (p authorizePage) Consent() template.HTML {
+	text := template.HTMLEscapeString(i18n.T(p.Locale, "authorize.consent", i18n.Params{"client": "{client}"}))
+	client := "<strong>" + template.HTMLEscapeString(p.ClientName) + "</strong>"
+	return template.HTML(strings.ReplaceAll(text, "{client}", client))
+}
+
+// Token is the OAuth 2.0 token endpoint (RFC 6749 section 3.2)
+func (c *OAuthController) Token(ctx *gin.Context) {
+	// las respuestas del token endpoint no se deben cachear
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	// el cliente se puede autenticar con HTTP Basic o con parametros en el body
+	clientID, clientSecret, usedBasic := clientCredentials(ctx)
+
+	var response dto.TokenResponse
+	var apiErr utils.ApiError
+
+	switch grantType := ctx.PostForm("grant_type"); grantType {
+	case services.GrantClientCredentials:
+		response, apiErr = c.oauth.ClientCredentialsToken(clientID, clientSecret, ctx.PostForm("scope"))
+	case services.GrantAuthorizationCode:
+		response, apiErr = c.oauth.AuthorizationCodeToken(clientID, clientSecret, ctx.PostForm("code"), ctx.PostForm("redirect_uri"), ctx.PostForm("code_verifier"))
+	case services.GrantRefreshToken:
+		response, apiErr = c.oauth.RefreshTokenGrant(clientID, clientSecret, ctx.PostForm("refresh_token"), ctx.PostForm("scope"))
+	case "":
+		ctx.JSON(http.StatusBadRequest, dto.OAuthErrorResponse{Error: "invalid_request", ErrorDescription: "grant_type is required"})
+		return
+	default:
+		ctx.JSON(http.StatusBadRequest, dto.OAuthErrorResponse{Error: "unsupported_grant_type", ErrorDescription: "grant_type " + grantType + " is not supported"})
+		return
+	}
+
+	if apiErr != nil {
+		oauthError(ctx, apiErr, usedBasic)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// AuthorizeForm shows the login and consent page of the authorization code flow
+func (c *OAuthController) AuthorizeForm(ctx *gin.Context) {
+	var request dto.AuthorizeRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		renderAuthorizePage(ctx, http.StatusBadRequest, authorizePage{Error: authorizeText(ctx, "authorize.invalid_request"), Fatal: true})
+		return
+	}
+
+	// si el cliente o la redirect_uri no son validos no se puede redirigir
+	client, err := c.oauth.GetAuthorizeClient(request.ClientID, request.RedirectURI)
+	if err != nil {
+		renderAuthorizePage(ctx, http.StatusBadRequest, authorizePage{Error: authorizeError(ctx, err), Fatal: true})
+		return
+	}
+
+	scopes, apiErr := c.oauth.ValidateAuthorizeRequest(request)
+	if apiErr != nil {
+		redirectWithError(ctx, request, apiErr.Code(), apiErr.Message())
+		return
+	}
+
+	renderAuthorizePage(ctx, http.StatusOK, authorizePage{Request: request, ClientName: client.Name, Scopes: scopes})
+}
+
+// Authorize handles the login and consent form and redirects back to the
+// client with an authorization code
+func (c *OAuthController) Authorize(ctx *gin.Context) {
+	var request dto.AuthorizeLoginRequest
+	if err := ctx.ShouldBind(&request); err != nil {
+		renderAuthorizePage(ctx, http.StatusBadRequest, authorizePage{Error: authorizeText(ctx, "authorize.invalid_request"), Fatal: true})
+		return
+	}
+
+	client, err := c.oauth.GetAuthorizeClient(request.ClientID, request.RedirectURI)
+	if err != nil {
+		renderAuthorizePage(ctx, http.StatusBadRequest, authorizePage{Error: authorizeError(ctx, err), Fatal: true})
+		return
+	}
+
+	if request.Action != "approve" {
+		redirectWithError(ctx, request.AuthorizeRequest, "access_denied", "the user denied the request")
+		return
+	}
+
+	code, apiErr := c.oauth.Authorize(request.AuthorizeRequest, request.Email, request.Password)
+	if apiErr != nil {
+		// credenciales incorrectas: vuelvo a mostrar el formulario
+		if apiErr.Code() == utils.CodeInvalidCredentials {
+			scopes, _ := c.oauth.ValidateAuthorizeRequest(request.AuthorizeRequest)
+			renderAuthorizePage(ctx, http.StatusUnauthorized, authorizePage{
+				Request:    request.AuthorizeRequest,
+				ClientName: client.Name,
+				Scopes:     scopes,
+				Email:      request.Email,
+				Error:      authorizeError(ctx, apiErr),
+			})
+			return
+		}
+		redirectWithError(ctx, request.AuthorizeRequest, apiErr.Code(), apiErr.Message())
+		return
+	}
+
+	redirectToClient(ctx, request.AuthorizeRequest, url.Values{"code": {code}})
+}
+
+// renderAuthorizePage writes the login and consent page in the locale of the
+// request
+func renderAuthorizePage(ctx *gin.Context, status int, page authorizePage) {
+	page.Locale = requestLocale(ctx)
+
+	var buf bytes.Buffer
+	if err := authorizeTemplate.Execute(&buf, page); err != nil {
+		ctx.String(http.StatusInternalServerError, "could not render page")
+		return
+	}
+
+	// la pagina pide credenciales: no se cachea ni se puede embeber en un iframe
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("X-Frame-Options", "DENY")
+	ctx.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	ctx.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+// authorizeText traduce un texto de la pagina al idioma de la request
+func authorizeText(ctx *gin.Context, key string) string {
+	return i18n.T(requestLocale(ctx), key, nil)
+}
+
+// authorizeError traduce el mensaje de un error para mostrarlo en la pagina
+func authorizeError(ctx *gin.Context, err utils.ApiError) string {
+	var localized i18n.Localized
+	if errors.As(err, &localized) {
+		return i18n.T(requestLocale(ctx), localized.MessageKey(), localized.MessageParams())
+	}
+	return err.Message()
+}
+
+// redirectWithError sends an error back to the client (RFC 6749 section 4.1.2.1)
+func redirectWithError(ctx *gin.Context, request dto.AuthorizeRequest, code string, description string) {
+	if code == "internal_server_error" {
+		code = "server_error"
+		description = "internal error"
+	}
+	redirectToClient(ctx, request, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// redirectToClient redirects to the registered redirect_uri, keeping its own
+// query parameters and echoing back the state
+func redirectToClient(ctx *gin.Context, request dto.AuthorizeRequest, params url.Values) {
+	target, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		renderAuthorizePage(ctx, http.StatusBadRequest, authorizePage{Error: authorizeText(ctx, "authorize.invalid_redirect_uri"), Fatal: true})
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	target.RawQuery = query.Encode()
+
+	ctx.Redirect(http.StatusFound, target.String())
+}
+
+func (c *OAuthController) CreateOAuthClient(ctx *gin.Context) {
+	var request dto.CreateOAuthClientRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	response, err := c.oauth.CreateOAuthClient(request)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func (c *OAuthController) GetOAuthClients(ctx *gin.Context) {
+	clients, err := c.oauth.GetOAuthClients()
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, clients)
+}
+
+func (c *OAuthController) RevokeOAuthClient(ctx *gin.Context) {
+	err := c.oauth.RevokeOAuthClient(ctx.Param("client_id"))
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respondMessage(ctx, http.StatusOK, "oauth_client.revoked", nil)
+}
+
+// clientCredentials reads the client_id and client_secret from the Basic
+// Authorization header or, if absent, from the form body
+func clientCredentials(ctx *gin.Context) (string, string, bool) {
+	clientID, clientSecret, ok := ctx.Request.BasicAuth()
+	if !ok {
+		return ctx.PostForm("client_id"), ctx.PostForm("client_secret"), false
+	}
+
+	// RFC 6749 section 2.3.1: both values are form-urlencoded before encoding
+	if decoded, err := url.QueryUnescape(clientID); err == nil {
+		clientID = decoded
+	}
+	if decoded, err := url.QueryUnescape(clientSecret); err == nil {
+		clientSecret = decoded
+	}
+	return clientID, clientSecret, true
+}
+
+// oauthError writes an RFC 6749 error response
+func oauthError(ctx *gin.Context, apiErr utils.ApiError, usedBasic bool) {
+	if apiErr.Status() == http.StatusUnauthorized && usedBasic {
+		ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	description := apiErr.Message()
+	if apiErr.Status() == http.StatusInternalServerError {
+		description = "internal error"
+	}
+
+	code := apiErr.Code()
+	if code == "internal_server_error" {
+		code = "server_error"
+	}
+
+	ctx.JSON(apiErr.Status(), dto.OAuthErrorResponse{Error: code, ErrorDescription: description})
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"backend/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserInfo is the OpenID Connect userinfo endpoint. Must run after VerifyToken
+func (c *OAuthController) UserInfo(ctx *gin.Context) {
+	info, err := c.oauth.GetUserInfo(getAuthContext(ctx))
+	if err != nil {
+		// OpenID Connect Core 5.3.3: los errores del token van también en
+		// WWW-Authenticate, con el formato de RFC 6750
+		switch err.Status() {
+		case http.StatusUnauthorized:
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		case http.StatusForbidden:
+			ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		}
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, info)
+}
+
+// OpenIDConfiguration serves the discovery document
+func OpenIDConfiguration(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, services.GetOpenIDConfiguration())
+}
+
+// JWKS serves the public keys that verify ID tokens
+func JWKS(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"keys": services.GetJWKS()})
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"backend/dto"
+	"backend/services"
+	"backend/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIM responses use their own media type (RFC 7644 section 3.1)
+const scimContentType = "application/scim+json"
+
+// ScimController atiende el aprovisionamiento SCIM. Los tokens se verifican
+// con el servicio de usuarios
+type ScimController struct {
+	scim  *services.ScimService
+	users *services.UserService
+}
+
+func NewScimController(scim *services.ScimService, users *services.UserService) *ScimController {
+	return &ScimController{scim: scim, users: users}
+}
+
+// VerifyScimToken only lets through the access token of a service client
+// granted the scim scope, answering with SCIM errors
+func (c *ScimController) VerifyScimToken(ctx *gin.Context) {
+	token := utils.ExtractBearerToken(ctx.GetHeader("Authorization"))
+	if token == "" {
+		ctx.Header("WWW-Authenticate", `Bearer realm="scim"`)
+		scimError(ctx, utils.NewUnauthorizedApiError("Token is required"))
+		ctx.Abort()
+		return
+	}
+
+	auth, err := c.users.VerifyToken(token)
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+		scimError(ctx, utils.NewUnauthorizedApiError("Invalid token"))
+		ctx.Abort()
+		return
+	}
+
+	// solo el cliente de aprovisionamiento, nunca un usuario ni un token personal
+	if auth.ClientID == "" || auth.UserID != 0 || !utils.HasScope(auth.Scopes, utils.ScopeSCIM) {
+		scimError(ctx, utils.NewForbiddenApiError("Token is missing scope "+utils.ScopeSCIM))
+		ctx.Abort()
+		return
+	}
+
+	ctx.Set(authContextKey, auth)
+}
+
+func GetScimServiceProviderConfig(ctx *gin.Context) {
+	scimJSON(ctx, http.StatusOK, services.GetScimServiceProviderConfig())
+}
+
+func GetScimSchemas(ctx *gin.Context) {
+	schemas, err := services.GetScimSchemas()
+	if err != nil {
+		scimError(ctx, utils.NewInternalServerApiError("Could not load schemas", err))
+		return
+	}
+	scimDefinitionsResponse(ctx, schemas, "id")
+}
+
+func GetScimResourceTypes(ctx *gin.Context) {
+	resourceTypes, err := services.GetScimResourceTypes()
+	if err != nil {
+		scimError(ctx, utils.NewInternalServerApiError("Could not load resource types", err))
+		return
+	}
+	scimDefinitionsResponse(ctx, resourceTypes, "name")
+}
+
+// scimDefinitionsResponse answers with the whole list, or with the single
+// definition named in the :id path parameter
+func scimDefinitionsResponse(ctx *gin.Context, definitions []map[string]interface{}, key string) {
+	if id := ctx.Param("id"); id != "" {
+		for _, definition := range definitions {
+			if definition[key] == id {
+				scimJSON(ctx, http.StatusOK, definition)
+				return
+			}
+		}
+		scimError(ctx, utils.NewNotFoundApiError(id+" not found"))
+		return
+	}
+
+	scimJSON(ctx, http.StatusOK, dto.ScimListResponse{
+		Schemas:      []string{utils.ScimListResponseSchema},
+		TotalResults: int64(len(definitions)),
+		StartIndex:   1,
+		ItemsPerPage: len(definitions),
+		Resources:    definitions,
+	})
+}
+
+func (c *ScimController) ListScimUsers(ctx *gin.Context) {
+	query, ok := bindScimListQuery(ctx)
+	if !ok {
+		return
+	}
+
+	response, apiErr := c.scim.ListScimUsers(query, scimExcludes(ctx, "groups"))
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, response)
+}
+
+func (c *ScimController) GetScimUser(ctx *gin.Context) {
+	user, apiErr := c.scim.GetScimUser(ctx.Param("id"), scimExcludes(ctx, "groups"))
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, user)
+}
+
+func (c *ScimController) CreateScimUser(ctx *gin.Context) {
+	var request dto.ScimUser
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		scimError(ctx, utils.NewValidationApiError("Invalid request: "+err.Error(), utils.ScimInvalidSyntax, utils.CauseList{}))
+		return
+	}
+
+	user, apiErr := c.scim.CreateScimUser(request)
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	ctx.Header("Location", user.Meta.Location)
+	scimJSON(ctx, http.StatusCreated, user)
+}
+
+func (c *ScimController) ReplaceScimUser(ctx *gin.Context) {
+	var request dto.ScimUser
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		scimError(ctx, utils.NewValidationApiError("Invalid request: "+err.Error(), utils.ScimInvalidSyntax, utils.CauseList{}))
+		return
+	}
+
+	user, apiErr := c.scim.ReplaceScimUser(ctx.Param("id"), request)
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, user)
+}
+
+func (c *ScimController) PatchScimUser(ctx *gin.Context) {
+	var request dto.ScimPatchRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		scimError(ctx, utils.NewValidationApiError("Invalid request: "+err.Error(), utils.ScimInvalidSyntax, utils.CauseList{}))
+		return
+	}
+
+	user, apiErr := c.scim.PatchScimUser(ctx.Param("id"), request)
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, user)
+}
+
+func (c *ScimController) DeleteScimUser(ctx *gin.Context) {
+	if apiErr := c.scim.DeleteScimUser(ctx.Param("id")); apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *ScimController) ListScimGroups(ctx *gin.Context) {
+	query, ok := bindScimListQuery(ctx)
+	if !ok {
+		return
+	}
+
+	response, apiErr := c.scim.ListScimGroups(query, scimExcludes(ctx, "members"))
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, response)
+}
+
+func (c *ScimController) GetScimGroup(ctx *gin.Context) {
+	group, apiErr := c.scim.GetScimGroup(ctx.Param("id"), scimExcludes(ctx, "members"))
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, group)
+}
+
+func (c *ScimController) CreateScimGroup(ctx *gin.Context) {
+	var request dto.ScimGroup
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		scimError(ctx, utils.NewValidationApiError("Invalid request: "+err.Error(), utils.ScimInvalidSyntax, utils.CauseList{}))
+		return
+	}
+
+	group, apiErr := c.scim.CreateScimGroup(request)
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	ctx.Header("Location", group.Meta.Location)
+	scimJSON(ctx, http.StatusCreated, group)
+}
+
+func (c *ScimController) ReplaceScimGroup(ctx *gin.Context) {
+	var request dto.ScimGroup
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		scimError(ctx, utils.NewValidationApiError("Invalid request: "+err.Error(), utils.ScimInvalidSyntax, utils.CauseList{}))
+		return
+	}
+
+	group, apiErr := c.scim.ReplaceScimGroup(ctx.Param("id"), request)
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, group)
+}
+
+func (c *ScimController) PatchScimGroup(ctx *gin.Context) {
+	var request dto.ScimPatchRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		scimError(ctx, utils.NewValidationApiError("Invalid request: "+err.Error(), utils.ScimInvalidSyntax, utils.CauseList{}))
+		return
+	}
+
+	group, apiErr := c.scim.PatchScimGroup(ctx.Param("id"), request)
+	if apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	scimJSON(ctx, http.StatusOK, group)
+}
+
+func (c *ScimController) DeleteScimGroup(ctx *gin.Context) {
+	if apiErr := c.scim.DeleteScimGroup(ctx.Param("id")); apiErr != nil {
+		scimError(ctx, apiErr)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func bindScimListQuery(ctx *gin.Context) (dto.ScimListQuery, bool) {
+	var query dto.ScimListQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		scimError(ctx, utils.NewValidationApiError("startIndex and count must be numbers", utils.ScimInvalidValue, utils.CauseList{}))
+		return dto.ScimListQuery{}, false
+	}
+	return query, true
+}
+
+// scimExcludes reports whether the client asked to leave out attribute, which
+// avoids loading the members of large groups
+func scimExcludes(ctx *gin.Context, attribute string) bool {
+	for _, excluded := range strings.Split(ctx.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(utils.NormalizeScimPath(strings.TrimSpace(excluded)), attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+func scimJSON(ctx *gin.Context, status int, body interface{}) {
+	ctx.Header("Content-Type", scimContentType)
+	ctx.JSON(status, body)
+}
+
+// scimError writes the error body defined by RFC 7644. scimType is only
+// meaningful for 400 and 409 responses
+func scimError(ctx *gin.Context, apiErr utils.ApiError) {
+	body := dto.ScimError{
+		Schemas: []string{utils.ScimErrorSchema},
+		Status:  strconv.Itoa(apiErr.Status()),
+		Detail:  apiErr.Message(),
+	}
+	if apiErr.Status() == http.StatusBadRequest || apiErr.Status() == http.StatusConflict {
+		body.ScimType = apiErr.Code()
+	}
+	scimJSON(ctx, apiErr.Status(), body)
+}
@@ -2,77 +2,97 @@ package controllers
 
 import (
 	"backend/dto"
+	"backend/i18n"
 	"backend/services"
+	"backend/utils"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Register(ctx *gin.Context) {
+// UserController atiende las rutas de las cuentas de usuario con el
+// servicio que recibe al construirse
+type UserController struct {
+	users *services.UserService
+}
+
+func NewUserController(users *services.UserService) *UserController {
+	return &UserController{users: users}
+}
+
+func (c *UserController) Register(ctx *gin.Context) {
 	var request dto.RegisterRequest
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		abortWithBindError(ctx, err)
 		return
 	}
 
-	response, err := services.Register(request)
+	// sin idioma elegido, los emails van en el que pide el navegador
+	if request.Locale == "" {
+		request.Locale = requestLocale(ctx)
+	}
+
+	response, err := c.users.Register(request)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(ctx, err)
 		return
 	}
 
+	response.Message = i18n.T(requestLocale(ctx), response.MessageKey, nil)
 	ctx.JSON(http.StatusCreated, response)
 }
 
-func VerifyEmail(ctx *gin.Context) {
+func (c *UserController) VerifyEmail(ctx *gin.Context) {
 	var request dto.VerifyEmailRequest
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		abortWithBindError(ctx, err)
 		return
 	}
 
-	response, err := services.VerifyEmail(request)
+	response, err := c.users.VerifyEmail(request)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(ctx, err)
 		return
 	}
 
+	response.Message = i18n.T(requestLocale(ctx), response.MessageKey, nil)
 	ctx.JSON(http.StatusOK, response)
 }
 
-func ResendVerificationCode(ctx *gin.Context) {
+func (c *UserController) ResendVerificationCode(ctx *gin.Context) {
 	var request dto.ResendCodeRequest
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		abortWithBindError(ctx, err)
 		return
 	}
 
-	err := services.ResendVerificationCode(request.Email)
+	err := c.users.ResendVerificationCode(request.Email)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "Verification code sent successfully"})
+	respondMessage(ctx, http.StatusOK, "verification.code_sent", nil)
 }
 
-func Login(ctx *gin.Context) {
+func (c *UserController) Login(ctx *gin.Context) {
 	var request dto.LoginRequest
 	// recibo usuario y contraseña desde el body de la request
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		abortWithBindError(ctx, err)
 		return
 	}
 
 	// llamar al servicio de login
 	// el servicio de login devuelve access token, refresh token, nombre y apellido
-	response, err := services.Login(request.Email, request.Password)
+	response, err := c.users.Login(request.Email, request.Password)
 	if err != nil {
-		ctx.JSON(http.StatusForbidden, gin.H{"error": "No se pudo iniciar sesion"})
+		abortWithError(ctx, err)
 		return
 	}
 
@@ -80,7 +100,7 @@ func Login(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
-func GetUserByID(ctx *gin.Context) {
+func (c *UserController) GetUserByID(ctx *gin.Context) {
 	// recibo el id del usuario desde el path de la request
 	userID := ctx.Param("id")
 	// hago string a int
@@ -88,88 +108,254 @@ func GetUserByID(ctx *gin.Context) {
 	// llamar al servicio de get user by id
 
 	if err1 != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "user.invalid_id", nil)
 		return
 	}
 
-	user, err := services.GetUserByID(userIDInt)
+	user, err := c.users.GetUserByID(userIDInt)
 
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		abortWithError(ctx, err)
 		return
 	}
 	// si el usuario existe, devolver el usuario
 	ctx.JSON(http.StatusOK, user)
 }
 
-func VerifyToken(ctx *gin.Context) {
-	// recibo el token desde el header de la request
-	token := ctx.GetHeader("Authorization")
-	if token == "" {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token is required"})
-		ctx.Abort()
+func (c *UserController) GetUsersBatch(ctx *gin.Context) {
+	var request dto.BatchUsersRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
 		return
 	}
 
-	// llamar al servicio de verify token
-	err := services.VerifyToken(token)
+	// los ids que no existen vuelven en not_found, no hacen fallar la request
+	response, err := c.users.GetUsersByIDs(request.IDs)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		ctx.Abort()
+		abortWithError(ctx, err)
 		return
 	}
+
+	ctx.JSON(http.StatusOK, response)
 }
 
-func VerifyAdminToken(ctx *gin.Context) {
+// clave con la que VerifyToken guarda el dto.AuthContext en el contexto de gin
+const authContextKey = "auth"
+
+func (c *UserController) VerifyToken(ctx *gin.Context) {
 	// recibo el token desde el header de la request
-	token := ctx.GetHeader("Authorization")
+	token := utils.ExtractBearerToken(ctx.GetHeader("Authorization"))
 	if token == "" {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Token is required"})
-		ctx.Abort()
+		abortWithErrorKey(ctx, http.StatusUnauthorized, utils.CodeUnauthorized, "auth.token_required", nil)
 		return
 	}
 
-	// llamar al servicio de verify admin token
-	err := services.VerifyAdminToken(token)
+	// llamar al servicio de verify token
+	auth, err := c.users.VerifyToken(token)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		ctx.Abort()
+		abortWithError(ctx, err)
+		return
+	}
+
+	// dejo disponible quien hizo la request para los siguientes handlers
+	ctx.Set(authContextKey, auth)
+	// el idioma guardado solo se busca si la respuesta lo necesita
+	if auth.UserID != 0 {
+		ctx.Set(savedLocaleKey, func() string { return c.users.GetUserLocale(auth.UserID) })
+	}
+}
+
+// RequireScope only lets through user sessions and tokens granted scope.
+// Must run after VerifyToken
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		auth := getAuthContext(ctx)
+		if auth.Scopes != nil && !utils.HasScope(auth.Scopes, scope) {
+			abortWithErrorKey(ctx, http.StatusForbidden, utils.CodeForbidden, "auth.missing_scope", i18n.Params{"scope": scope})
+			return
+		}
+	}
+}
+
+// RequireUserSession only lets through user sessions, rejecting service
+// clients and personal access tokens. Must run after VerifyToken
+func RequireUserSession(ctx *gin.Context) {
+	auth := getAuthContext(ctx)
+	if auth.UserID == 0 || auth.Scopes != nil {
+		abortWithErrorKey(ctx, http.StatusForbidden, utils.CodeForbidden, "auth.user_session_required", nil)
 		return
 	}
 }
 
-func RefreshToken(ctx *gin.Context) {
+// getAuthContext returns what VerifyToken stored for the current request
+func getAuthContext(ctx *gin.Context) dto.AuthContext {
+	value, exists := ctx.Get(authContextKey)
+	if !exists {
+		return dto.AuthContext{}
+	}
+	auth, _ := value.(dto.AuthContext)
+	return auth
+}
+
+// VerifyAdminToken protege los endpoints de admin. Los tokens personales de un
+// admin solo pasan si tienen el scope del endpoint
+func (c *UserController) VerifyAdminToken(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// recibo el token desde el header de la request
+		token := utils.ExtractBearerToken(ctx.GetHeader("Authorization"))
+		if token == "" {
+			abortWithErrorKey(ctx, http.StatusUnauthorized, utils.CodeUnauthorized, "auth.token_required", nil)
+			return
+		}
+
+		// llamar al servicio de verify admin token
+		err := c.users.VerifyAdminToken(token, scope)
+		if err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+	}
+}
+
+func (c *UserController) RefreshToken(ctx *gin.Context) {
 	var request dto.RefreshTokenRequest
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		abortWithBindError(ctx, err)
 		return
 	}
 
 	// llamar al servicio de refresh token
-	response, err := services.RefreshAccessToken(request.RefreshToken)
+	response, err := c.users.RefreshAccessToken(request.RefreshToken)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		abortWithError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, response)
 }
 
-func PromoteToAdmin(ctx *gin.Context) {
+func (c *UserController) PromoteToAdmin(ctx *gin.Context) {
 	var request dto.PromoteToAdminRequest
 
 	if err := ctx.ShouldBindJSON(&request); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		abortWithBindError(ctx, err)
 		return
 	}
 
 	// llamar al servicio de promover a admin
-	err := services.PromoteToAdmin(request.UserID)
+	err := c.users.PromoteToAdmin(request.UserID)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respondMessage(ctx, http.StatusOK, "user.promoted_admin", nil)
+}
+
+func (c *UserController) GetUsageStats(ctx *gin.Context) {
+	// rango por defecto: los ultimos 30 dias incluyendo hoy
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from := to.AddDate(0, 0, -29)
+
+	if value := ctx.Query("from"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "stats.invalid_from", nil)
+			return
+		}
+		from = parsed
+	}
+
+	if value := ctx.Query("to"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "stats.invalid_to", nil)
+			return
+		}
+		to = parsed
+	}
+
+	if from.After(to) {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "stats.invalid_range", nil)
+		return
+	}
+	if to.Sub(from) > 366*24*time.Hour {
+		abortWithErrorKey(ctx, http.StatusBadRequest, utils.CodeBadRequest, "stats.range_too_long", nil)
+		return
+	}
+
+	response, err := c.users.GetUsageStats(from, to)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *UserController) GetRegistrationPolicy(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.users.GetRegistrationPolicy())
+}
+
+func (c *UserController) ReloadDisposableDomains(ctx *gin.Context) {
+	// vuelve a leer DISPOSABLE_DOMAINS_FILE sin reiniciar el servicio
+	policy, err := c.users.ReloadDisposableDomains()
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, policy)
+}
+
+func (c *UserController) ChangePassword(ctx *gin.Context) {
+	var request dto.ChangePasswordRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	if err := c.users.ChangePassword(getAuthContext(ctx).UserID, request); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respondMessage(ctx, http.StatusOK, "password.changed", nil)
+}
+
+func (c *UserController) ForgotPassword(ctx *gin.Context) {
+	var request dto.ForgotPasswordRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	// la respuesta es la misma exista o no el usuario
+	if err := c.users.ForgotPassword(request.Email); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	respondMessage(ctx, http.StatusOK, "password.reset_code_sent", nil)
+}
+
+func (c *UserController) ResetPassword(ctx *gin.Context) {
+	var request dto.ResetPasswordRequest
+
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		abortWithBindError(ctx, err)
+		return
+	}
+
+	if err := c.users.ResetPassword(request); err != nil {
+		abortWithError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "User promoted to admin successfully"})
+	respondMessage(ctx, http.StatusOK, "password.reset_done", nil)
 }
@@ -2,12 +2,15 @@ package db
 
 import (
 	userCLient "backend/clients/user"
-	"backend/model"
+	"backend/config"
+	"context"
 	"fmt"
-	"os"
+	"strings"
 
+	"github.com/glebarez/sqlite"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
@@ -16,36 +19,107 @@ var (
 	err error
 )
 
-func init() {
+// Connect opens the database of cfg (mysql, postgres or sqlite), which the
+// repositories of the clients are built with. cfg.DSN replaces the connection
+// string built from the other fields
+func Connect(cfg config.Database) error {
+	dsn := cfg.DSN
+	if dsn == "" {
+		dsn = buildDSN(cfg)
+	}
 
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	user := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASS")
-	name := os.Getenv("DB_NAME")
+	var dialector gorm.Dialector
+	switch cfg.Driver {
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	default:
+		return fmt.Errorf("unknown database driver %q, expected mysql, postgres or sqlite", cfg.Driver)
+	}
 
-	dsn := fmt.Sprintf(
-		"%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		user, password, host, port, name,
+	DB, err = gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		log.Info("Connection Failed to Open")
+		return err
+	}
+	log.Infof("Connection Established (%s)", cfg.Driver)
+	return nil
+}
+
+// buildDSN builds the connection string of cfg. SQLite only uses the name, as
+// the name of the file
+func buildDSN(cfg config.Database) string {
+	switch cfg.Driver {
+	case "postgres":
+		return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=prefer",
+			cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Name)
+	case "sqlite":
+		return SQLiteDSN(cfg.Name + ".db")
+	}
+	return fmt.Sprintf(
+		"%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name,
 	)
+}
 
-	//dsn := "root:FranMySql1@@tcp(127.0.0.1:3306)/arqui_software?charset=utf8mb4&parseTime=True&loc=Local"
-	DB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// Close closes the connection pool, after the queries still running finish
+func Close() error {
+	sqlDB, err := DB.DB()
 	if err != nil {
-		log.Info("Connection Failed to Open")
-		log.Fatal(err)
-	} else {
-		log.Info("Connection Established")
+		return err
 	}
-	userCLient.Db = DB
+	if err := sqlDB.Close(); err != nil {
+		return err
+	}
+	log.Info("Connection Closed")
+	return nil
+}
+
+// Ping checks the database answers before ctx ends
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// SQLiteDSN is the connection string of the SQLite file at path. Concurrent
+// writers wait for each other instead of failing with "database is locked"
+func SQLiteDSN(path string) string {
+	return path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
 
-	log.Info("Finishing Migration Database Tables")
+func StartDbEngine(cfg config.Database) {
+	// Bring the schema up to date with the embedded migrations. Without
+	// auto_migrate they are left to "backend migrate up"
+	if cfg.AutoMigrate {
+		applied, err := MigrateUp()
+		if err != nil {
+			panic(fmt.Sprintf("Error migrating database: %v", err))
+		}
+		for _, migration := range applied {
+			log.Infof("Applied migration %d_%s", migration.Version, migration.Name)
+		}
+	} else if statuses, err := MigrationStatus(); err != nil {
+		log.Warnf("Could not check the migrations: %v", err)
+	} else {
+		for _, status := range statuses {
+			if status.AppliedAt == nil {
+				log.Warnf("Migration %d_%s is pending, run \"backend migrate up\"", status.Version, status.Name)
+			}
+		}
+	}
+	log.Info("Database ready")
 }
 
-func StartDbEngine() {
-	// Migrating User and VerificationToken models.
-	if err := DB.AutoMigrate(&model.UserModel{}, &model.VerificationToken{}); err != nil {
-		panic(fmt.Sprintf("Error creating tables: %v", err))
+func collisionUserIDs(collision userCLient.EmailCollision) string {
+	ids := make([]string, 0, len(collision.Users))
+	for _, user := range collision.Users {
+		ids = append(ids, fmt.Sprint(user.ID))
 	}
-	log.Info("Database tables migrated successfully")
+	return strings.Join(ids, ", ")
 }
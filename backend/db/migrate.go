@@ -0,0 +1,167 @@
+package db
+
+import (
+	userCLient "backend/clients/user"
+	"backend/db/migrations"
+	"backend/model"
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Version of the migration with the schema AutoMigrate used to create
+const baselineVersion = 1
+
+// legacyModels are the tables AutoMigrate created before the migrations existed
+var legacyModels = []interface{}{
+	&model.UserModel{},
+	&model.VerificationToken{},
+	&model.OAuthClient{},
+	&model.AuthorizationCode{},
+	&model.PersonalAccessToken{},
+	&model.FederatedIdentity{},
+	&model.UserGroup{},
+	&model.GroupMember{},
+	&model.Invitation{},
+	&model.OutboxEmail{},
+}
+
+// dataMigrations change rows instead of the schema. They are the same for
+// every dialect and run once, holding the lock like the SQL ones
+var dataMigrations = []migrations.Migration{
+	{Version: 2, Name: "sync_admin_roles", Data: gormMigration(userCLient.SyncAdminRoles)},
+	{Version: 3, Name: "normalize_emails", Data: gormMigration(normalizeEmails)},
+	{Version: 4, Name: "legacy_verification_codes", Data: gormMigration(moveLegacyVerificationCodes)},
+}
+
+func migrationRunner() (*migrations.Runner, error) {
+	// the dialector names match the folders of the migrations
+	dialect := DB.Dialector.Name()
+	list, err := migrations.Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	if list, err = migrations.WithData(list, dataMigrations); err != nil {
+		return nil, err
+	}
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	runner, err := migrations.NewRunner(sqlDB, dialect, list)
+	if err != nil {
+		return nil, err
+	}
+	runner.Adopt = adoptLegacySchema
+	return runner, nil
+}
+
+// MigrateUp applies the pending migrations. A database created by AutoMigrate
+// is adopted first
+func MigrateUp() ([]migrations.Migration, error) {
+	runner, err := migrationRunner()
+	if err != nil {
+		return nil, err
+	}
+	return runner.Up(context.Background())
+}
+
+// MigrateDown rolls back the last steps migrations
+func MigrateDown(steps int) ([]migrations.Migration, error) {
+	runner, err := migrationRunner()
+	if err != nil {
+		return nil, err
+	}
+	return runner.Down(context.Background(), steps)
+}
+
+// MigrationStatus lists the migrations and whether they were applied
+func MigrationStatus() ([]migrations.MigrationStatus, error) {
+	runner, err := migrationRunner()
+	if err != nil {
+		return nil, err
+	}
+	return runner.Status(context.Background())
+}
+
+// CheckMigrations fails when some migration isn't applied yet
+func CheckMigrations(ctx context.Context) error {
+	runner, err := migrationRunner()
+	if err != nil {
+		return err
+	}
+	pending, err := runner.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations, the first is %d_%s", len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// onConn runs GORM on the connection or transaction of a migration
+func onConn(ctx context.Context, conn migrations.Conn) *gorm.DB {
+	tx := DB.Session(&gorm.Session{NewDB: true, Context: ctx})
+	tx.Statement.ConnPool = conn
+	return tx
+}
+
+// gormMigration turns a function of the clients into a data migration
+func gormMigration(fn func(tx *gorm.DB) error) func(ctx context.Context, conn migrations.Conn) error {
+	return func(ctx context.Context, conn migrations.Conn) error {
+		return fn(onConn(ctx, conn))
+	}
+}
+
+// adoptLegacySchema makes the baseline the version of databases that have the
+// tables but no schema_migrations. AutoMigrate runs one last time before, to
+// add the columns an older version may not have created yet. The runner
+// calls it holding the lock, so only one replica adopts the database
+func adoptLegacySchema(ctx context.Context, conn migrations.Conn) (int, error) {
+	tx := onConn(ctx, conn)
+	if !tx.Migrator().HasTable(&model.UserModel{}) {
+		return 0, nil
+	}
+
+	log.Info("Adopting the tables created by AutoMigrate as the baseline migration")
+	if err := tx.AutoMigrate(legacyModels...); err != nil {
+		return 0, fmt.Errorf("error updating the legacy tables: %w", err)
+	}
+	return baselineVersion, nil
+}
+
+// moveLegacyVerificationCodes moves the plaintext codes of user_models into
+// hashed verification tokens and drops the old columns. Only databases
+// adopted from AutoMigrate can still have them
+func moveLegacyVerificationCodes(tx *gorm.DB) error {
+	moved, err := userCLient.MigrateLegacyVerificationCodes(tx)
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		log.Infof("Moved %d pending verification codes to verification_tokens", moved)
+	}
+	return nil
+}
+
+// normalizeEmails fills the normalized email of the users created before it
+// existed. Users that would share one are left without it and reported
+func normalizeEmails(tx *gorm.DB) error {
+	report, err := userCLient.NormalizeStoredEmails(tx, false)
+	if err != nil {
+		return err
+	}
+	if report.Updated > 0 {
+		log.Infof("Normalized the email of %d users", report.Updated)
+	}
+	for _, collision := range report.Collisions {
+		log.Warnf("Users %s share the email %s, run \"backend normalize-emails\" for details", collisionUserIDs(collision), collision.NormalizedEmail)
+	}
+	for _, user := range report.Invalid {
+		log.Warnf("User %d has an invalid email %q", user.ID, user.Email)
+	}
+	return nil
+}
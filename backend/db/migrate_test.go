@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+
+	"backend/db/migrations"
+)
+
+// Versions are one numbering shared by the folders and the data migrations,
+// without gaps, so a removed migration can't leave a hole behind
+func TestMigrationVersionsAreContiguous(t *testing.T) {
+	names := map[int]string{}
+	for _, dialect := range migrations.Dialects() {
+		list, err := migrations.Load(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list, err = migrations.WithData(list, dataMigrations); err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		for _, migration := range list {
+			if name, ok := names[migration.Version]; ok && name != migration.Name {
+				t.Errorf("version %d is %s in one dialect and %s in %s", migration.Version, name, migration.Name, dialect)
+			}
+			names[migration.Version] = migration.Name
+		}
+	}
+	for version := 1; version <= len(names); version++ {
+		if _, ok := names[version]; !ok {
+			t.Errorf("no migration has the version %d", version)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect is what changes between databases for the runner. The names match
+// the GORM dialectors and the folders of the migrations
+type dialect struct {
+	// type of schema_migrations.applied_at
+	timestampType string
+	// query counting the tables named like its only parameter
+	tableExists string
+	// DDL runs inside transactions, so a failing migration leaves nothing behind
+	transactionalDDL bool
+	// # starts a comment, as -- does everywhere
+	hashComments bool
+	// numbered placeholders ($1, $2) instead of ?
+	numberedParams bool
+	lock           func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error
+	unlock         func(conn *sql.Conn)
+}
+
+var dialects = map[string]dialect{
+	"mysql": {
+		timestampType: "datetime(3)",
+		tableExists:   "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+		hashComments:  true,
+		lock:          mysqlLock,
+		unlock:        mysqlUnlock,
+	},
+	"postgres": {
+		timestampType:    "timestamptz",
+		tableExists:      "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?",
+		transactionalDDL: true,
+		numberedParams:   true,
+		lock:             postgresLock,
+		unlock:           postgresUnlock,
+	},
+	"sqlite": {
+		timestampType:    "datetime",
+		tableExists:      "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+		transactionalDDL: true,
+		lock:             sqliteLock,
+		unlock:           sqliteUnlock,
+	},
+}
+
+// Dialects lists the databases with migrations
+func Dialects() []string {
+	return []string{"mysql", "postgres", "sqlite"}
+}
+
+// bind rewrites the ? placeholders of query for the dialect
+func (d dialect) bind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+	var result strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			result.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		result.WriteRune(c)
+	}
+	return result.String()
+}
+
+func mysqlLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", Table, int(timeout/time.Second)).Scan(&locked); err != nil {
+		return fmt.Errorf("error taking the migration lock: %w", err)
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return fmt.Errorf("another instance is migrating the database, gave up after %s", timeout)
+	}
+	return nil
+}
+
+func mysqlUnlock(conn *sql.Conn) {
+	var released sql.NullInt64
+	conn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", Table).Scan(&released)
+}
+
+// postgresLockKey identifies the advisory lock of the migrations
+func postgresLockKey() int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(Table))
+	return int64(hash.Sum64())
+}
+
+// postgresLock polls pg_try_advisory_lock, since pg_advisory_lock has no timeout
+func postgresLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", postgresLockKey()).Scan(&locked); err != nil {
+			return fmt.Errorf("error taking the migration lock: %w", err)
+		}
+		if locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("another instance is migrating the database, gave up after %s", timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func postgresUnlock(conn *sql.Conn) {
+	var released bool
+	conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", postgresLockKey()).Scan(&released)
+}
+
+// SQLite has no named locks. Several processes can open the same file, so
+// the lock is the only row of a table: taking it is inserting the row
+const sqliteLockTable = Table + "_lock"
+
+func sqliteLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+sqliteLockTable+" (id integer NOT NULL PRIMARY KEY, acquired_at datetime NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", sqliteLockTable, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		result, err := conn.ExecContext(ctx, "INSERT OR IGNORE INTO "+sqliteLockTable+" (id, acquired_at) VALUES (1, ?)", time.Now())
+		if err != nil {
+			return fmt.Errorf("error taking the migration lock: %w", err)
+		}
+		if inserted, _ := result.RowsAffected(); inserted == 1 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			// unlike the locks of the servers, the row outlives a crashed process
+			return fmt.Errorf("another instance is migrating the database, gave up after %s; "+
+				"if none is running, delete the row of %s left by one that crashed", timeout, sqliteLockTable)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func sqliteUnlock(conn *sql.Conn) {
+	conn.ExecContext(context.Background(), "DELETE FROM "+sqliteLockTable+" WHERE id = 1")
+}
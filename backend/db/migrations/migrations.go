@@ -0,0 +1,188 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Each dialect has its own folder with one pair of files per migration:
+// <version>_<name>.up.sql and <version>_<name>.down.sql. Versions are applied
+// in increasing order and can't be edited once released, the checksum of
+// every applied migration is checked before migrating
+//
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one step of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Data is the code of a data migration, written in Go instead of SQL and
+	// shared by every dialect. It has no down: rolling it back only forgets
+	// it was applied, so it must be safe to run again
+	Data     func(ctx context.Context, conn Conn) error
+	Checksum string // SHA-256 of the up script, or of the name of a data migration
+}
+
+// Conn is the connection holding the lock, or the transaction of the
+// migration where the dialect has one. It matches gorm.ConnPool, so data
+// migrations can run GORM on it
+type Conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Load returns the migrations of a dialect ordered by version
+func Load(dialect string) ([]Migration, error) {
+	names, err := fs.Glob(files, dialect+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, name := range names {
+		parts := fileName.FindStringSubmatch(path.Base(name))
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, _ := strconv.Atoi(parts[1])
+		data, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != parts[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, parts[2])
+		}
+		if parts[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		result = append(result, *migration)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// WithData adds the data migrations to the ones of a dialect, keeping them
+// ordered by version. A version can't be both
+func WithData(list []Migration, data []Migration) ([]Migration, error) {
+	result := append([]Migration(nil), list...)
+	versions := map[int]string{}
+	for _, migration := range list {
+		versions[migration.Version] = migration.Name
+	}
+	for _, migration := range data {
+		if name, ok := versions[migration.Version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version %d", name, migration.Name, migration.Version)
+		}
+		if migration.Data == nil {
+			return nil, fmt.Errorf("data migration %d_%s has no code", migration.Version, migration.Name)
+		}
+		versions[migration.Version] = migration.Name
+		sum := sha256.Sum256([]byte("data:" + migration.Name))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		result = append(result, migration)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// splitStatements splits a script on the semicolons that end a statement,
+// skipping comments and quoted text. The drivers run one statement per call.
+// hashComments is for MySQL, where # also starts a comment
+func splitStatements(script string, hashComments bool) []string {
+	var statements []string
+	var current strings.Builder
+	var quote rune
+	lineComment, blockComment := false, false
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+
+		switch {
+		case lineComment:
+			if c == '\n' {
+				lineComment = false
+				current.WriteRune(c)
+			}
+			continue
+		case blockComment:
+			if c == '*' && next == '/' {
+				blockComment = false
+				i++
+			}
+			continue
+		case quote != 0:
+			current.WriteRune(c)
+			if c == '\\' && next != 0 {
+				current.WriteRune(next)
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case c == '-' && next == '-', c == '#' && hashComments:
+			lineComment = true
+		case c == '/' && next == '*':
+			blockComment = true
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteRune(c)
+		case c == ';':
+			if statement := strings.TrimSpace(current.String()); statement != "" {
+				statements = append(statements, statement)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if statement := strings.TrimSpace(current.String()); statement != "" {
+		statements = append(statements, statement)
+	}
+	return statements
+}
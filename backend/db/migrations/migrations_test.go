@@ -0,0 +1,291 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	// registers the sqlite driver of database/sql
+	_ "github.com/glebarez/sqlite"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       string
+		hashComments bool
+		expected     []string
+	}{
+		{"one per semicolon", "CREATE TABLE a (id int);\nCREATE TABLE b (id int);", false,
+			[]string{"CREATE TABLE a (id int)", "CREATE TABLE b (id int)"}},
+		{"without the last semicolon", "SELECT 1;\nSELECT 2", false, []string{"SELECT 1", "SELECT 2"}},
+		{"empty statements", ";;\n  ;SELECT 1;;", false, []string{"SELECT 1"}},
+		{"line comments", "-- first; still a comment\nSELECT 1; -- trailing;\nSELECT 2;", false,
+			[]string{"SELECT 1", "SELECT 2"}},
+		{"block comments", "/* a; b */SELECT 1;/* multi\nline; */SELECT 2;", false, []string{"SELECT 1", "SELECT 2"}},
+		{"semicolons in quotes", "INSERT INTO a VALUES ('x;y', \"z;w\");SELECT `c;d`;", false,
+			[]string{"INSERT INTO a VALUES ('x;y', \"z;w\")", "SELECT `c;d`"}},
+		{"escaped quotes", `INSERT INTO a VALUES ('it\'s; fine');SELECT 1;`, false,
+			[]string{`INSERT INTO a VALUES ('it\'s; fine')`, "SELECT 1"}},
+		{"comment markers in quotes", "INSERT INTO a VALUES ('-- no', '/* no */', '# no');", true,
+			[]string{"INSERT INTO a VALUES ('-- no', '/* no */', '# no')"}},
+		{"hash comments in MySQL", "# comment; here\nSELECT 1;", true, []string{"SELECT 1"}},
+		{"hash elsewhere", "SELECT '#' || x # y;", false, []string{"SELECT '#' || x # y"}},
+		{"only comments", "-- nothing\n/* to run */", false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			statements := splitStatements(test.script, test.hashComments)
+			if !reflect.DeepEqual(statements, test.expected) {
+				t.Errorf("got %q, expected %q", statements, test.expected)
+			}
+		})
+	}
+}
+
+func TestLoadEveryDialect(t *testing.T) {
+	for _, dialect := range Dialects() {
+		list, err := Load(dialect)
+		if err != nil {
+			t.Fatalf("%s: %v", dialect, err)
+		}
+		if list[0].Version != 1 || list[0].Name != "baseline" {
+			t.Errorf("%s starts with %d_%s, expected the baseline", dialect, list[0].Version, list[0].Name)
+		}
+		for _, migration := range list {
+			// splitting the scripts must leave the statements the drivers run
+			if len(splitStatements(migration.Up, dialects[dialect].hashComments)) == 0 {
+				t.Errorf("%s %d_%s has an empty up script", dialect, migration.Version, migration.Name)
+			}
+		}
+	}
+}
+
+// testMigrations are two steps over a table of notes
+func testMigrations() []Migration {
+	return withChecksums([]Migration{
+		{Version: 1, Name: "notes", Up: "CREATE TABLE notes (id integer PRIMARY KEY, body text);", Down: "DROP TABLE notes;"},
+		{Version: 2, Name: "note_title", Up: "ALTER TABLE notes ADD COLUMN title text;", Down: "ALTER TABLE notes DROP COLUMN title;"},
+	})
+}
+
+// withChecksums sets the checksums the way Load does
+func withChecksums(list []Migration) []Migration {
+	for i := range list {
+		sum := sha256.Sum256([]byte(list[i].Up))
+		list[i].Checksum = hex.EncodeToString(sum[:])
+	}
+	return list
+}
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newTestRunner(t *testing.T, db *sql.DB, list []Migration) *Runner {
+	runner, err := NewRunner(db, "sqlite", list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.LockTimeout = time.Second
+	return runner
+}
+
+func versions(list []Migration) []int {
+	var result []int
+	for _, migration := range list {
+		result = append(result, migration.Version)
+	}
+	return result
+}
+
+func TestRunnerAppliesAndRollsBack(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	runner := newTestRunner(t, db, testMigrations())
+
+	done, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions(done), []int{1, 2}) {
+		t.Fatalf("applied %v, expected [1 2]", versions(done))
+	}
+	if _, err := db.Exec("INSERT INTO notes (body, title) VALUES ('b', 't')"); err != nil {
+		t.Fatalf("the migrations didn't create the table: %v", err)
+	}
+
+	// a second run has nothing to do
+	if done, err = runner.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second Up applied %v, %v", versions(done), err)
+	}
+
+	done, err = runner.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions(done), []int{2}) {
+		t.Fatalf("rolled back %v, expected [2]", versions(done))
+	}
+	pending, err := runner.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions(pending), []int{2}) {
+		t.Errorf("pending %v, expected [2]", versions(pending))
+	}
+	if _, err := db.Exec("INSERT INTO notes (body, title) VALUES ('b', 't')"); err == nil {
+		t.Error("the down script didn't drop the column")
+	}
+}
+
+func TestRunnerRollsBackAFailingMigration(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	list := testMigrations()
+	list = append(list, withChecksums([]Migration{{
+		Version: 3, Name: "broken",
+		Up:   "CREATE TABLE tags (id integer PRIMARY KEY); INSERT INTO missing VALUES (1);",
+		Down: "DROP TABLE tags;",
+	}})...)
+
+	done, err := newTestRunner(t, db, list).Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "3_broken") {
+		t.Fatalf("expected the error of 3_broken, got %v", err)
+	}
+	if !reflect.DeepEqual(versions(done), []int{1, 2}) {
+		t.Errorf("applied %v, expected [1 2]", versions(done))
+	}
+	// sqlite runs each migration in a transaction
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'tags'").Scan(&count)
+	if count != 0 {
+		t.Error("the failed migration was left half applied")
+	}
+}
+
+func TestRunnerRefusesModifiedMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := newTestRunner(t, db, testMigrations()).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := testMigrations()
+	edited[0].Up = "CREATE TABLE notes (id integer PRIMARY KEY, body text NOT NULL);"
+	edited = withChecksums(edited)
+	runner := newTestRunner(t, db, edited)
+
+	if _, err := runner.Up(ctx); err == nil || !strings.Contains(err.Error(), "1_notes was modified") {
+		t.Errorf("Up: expected the checksum error, got %v", err)
+	}
+	if _, err := runner.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "1_notes was modified") {
+		t.Errorf("Down: expected the checksum error, got %v", err)
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Modified || statuses[1].Modified {
+		t.Errorf("expected only the first migration modified, got %+v", statuses)
+	}
+}
+
+func TestRunnerReportsUnknownMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := newTestRunner(t, db, testMigrations()).Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// an older binary, without the second migration
+	older := newTestRunner(t, db, testMigrations()[:1])
+	statuses, err := older.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[1].Unknown || statuses[1].Name != "note_title" {
+		t.Errorf("expected 2_note_title as unknown, got %+v", statuses)
+	}
+	if _, err := older.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "newer version") {
+		t.Errorf("expected the rollback of an unknown migration to fail, got %v", err)
+	}
+}
+
+func TestRunnerAdoptsLegacyTables(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	if _, err := db.Exec("CREATE TABLE notes (id integer PRIMARY KEY, body text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := newTestRunner(t, db, testMigrations())
+	runner.Adopt = func(ctx context.Context, conn Conn) (int, error) { return 1, nil }
+	done, err := runner.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the baseline is recorded without running it, it would fail on the existing table
+	if !reflect.DeepEqual(versions(done), []int{2}) {
+		t.Errorf("applied %v, expected [2]", versions(done))
+	}
+	statuses, err := runner.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].AppliedAt == nil {
+		t.Error("the baseline wasn't recorded as applied")
+	}
+}
+
+func TestRunnerWaitsForTheLock(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	// another instance holds the lock
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqliteLock(ctx, conn, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := newTestRunner(t, db, testMigrations())
+	runner.LockTimeout = 100 * time.Millisecond
+	if _, err := runner.Up(ctx); err == nil || !strings.Contains(err.Error(), "another instance is migrating") {
+		t.Fatalf("expected the lock timeout, got %v", err)
+	}
+	if err := sqliteLock(ctx, conn, 0); err == nil {
+		t.Error("the lock was taken twice")
+	}
+
+	// once released, the waiting instance migrates
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		sqliteUnlock(conn)
+		conn.Close()
+		close(released)
+	}()
+	runner.LockTimeout = 5 * time.Second
+	done, err := runner.Up(ctx)
+	<-released
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 {
+		t.Errorf("applied %v after the lock was released", versions(done))
+	}
+}
@@ -0,0 +1,158 @@
+-- Schema created by GORM AutoMigrate before the migrations existed. The
+-- tables are only created when missing, so databases from that time are
+-- adopted as they are
+
+CREATE TABLE IF NOT EXISTS `user_models` (
+  `id` bigint AUTO_INCREMENT,
+  `email` varchar(100) NOT NULL,
+  `normalized_email` varchar(255),
+  `password_hash` varchar(255),
+  `first_name` varchar(100) NOT NULL,
+  `last_name` varchar(100) NOT NULL,
+  `is_admin` boolean DEFAULT false,
+  `role` varchar(20) NOT NULL DEFAULT 'student',
+  `is_verified` boolean DEFAULT false,
+  `is_active` boolean DEFAULT true,
+  `external_id` varchar(255),
+  `locale` varchar(10),
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  `last_login_at` datetime(3) NULL,
+  `last_seen_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `idx_user_models_normalized_email` (`normalized_email`),
+  INDEX `idx_user_models_external_id` (`external_id`),
+  INDEX `idx_user_models_last_seen_at` (`last_seen_at`),
+  CONSTRAINT `uni_user_models_email` UNIQUE (`email`)
+);
+
+CREATE TABLE IF NOT EXISTS `verification_tokens` (
+  `id` bigint AUTO_INCREMENT,
+  `user_id` bigint NOT NULL,
+  `purpose` varchar(20) NOT NULL,
+  `token_hash` varchar(64) NOT NULL,
+  `target` varchar(100),
+  `attempts` bigint NOT NULL DEFAULT 0,
+  `expires_at` datetime(3) NOT NULL,
+  `consumed_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_user_purpose` (`user_id`, `purpose`)
+);
+
+CREATE TABLE IF NOT EXISTS `o_auth_clients` (
+  `id` bigint AUTO_INCREMENT,
+  `client_id` varchar(64) NOT NULL,
+  `secret_hash` varchar(64) NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `scopes` varchar(255) NOT NULL,
+  `grant_types` varchar(255) NOT NULL DEFAULT 'client_credentials',
+  `redirect_uris` text,
+  `is_public` boolean DEFAULT false,
+  `is_active` boolean DEFAULT true,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  CONSTRAINT `uni_o_auth_clients_client_id` UNIQUE (`client_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `authorization_codes` (
+  `id` bigint AUTO_INCREMENT,
+  `code_hash` varchar(64) NOT NULL,
+  `client_id` varchar(64) NOT NULL,
+  `user_id` bigint NOT NULL,
+  `redirect_uri` text NOT NULL,
+  `scope` varchar(255) NOT NULL,
+  `code_challenge` varchar(128) NOT NULL,
+  `code_challenge_method` varchar(10) NOT NULL,
+  `nonce` varchar(255),
+  `expires_at` datetime(3) NOT NULL,
+  `consumed_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_authorization_codes_client_id` (`client_id`),
+  INDEX `idx_authorization_codes_user_id` (`user_id`),
+  CONSTRAINT `uni_authorization_codes_code_hash` UNIQUE (`code_hash`)
+);
+
+CREATE TABLE IF NOT EXISTS `personal_access_tokens` (
+  `id` bigint AUTO_INCREMENT,
+  `user_id` bigint NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `token_hash` varchar(64) NOT NULL,
+  `prefix` varchar(16) NOT NULL,
+  `scopes` varchar(255) NOT NULL,
+  `expires_at` datetime(3) NULL,
+  `last_used_at` datetime(3) NULL,
+  `revoked_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_personal_access_tokens_user_id` (`user_id`),
+  CONSTRAINT `uni_personal_access_tokens_token_hash` UNIQUE (`token_hash`)
+);
+
+CREATE TABLE IF NOT EXISTS `federated_identities` (
+  `id` bigint AUTO_INCREMENT,
+  `user_id` bigint NOT NULL,
+  `provider` varchar(50) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `email` varchar(100),
+  `last_login_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_federated_identities_user_id` (`user_id`),
+  UNIQUE INDEX `idx_provider_subject` (`provider`, `subject`)
+);
+
+CREATE TABLE IF NOT EXISTS `user_groups` (
+  `id` bigint AUTO_INCREMENT,
+  `display_name` varchar(255) NOT NULL,
+  `external_id` varchar(255),
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_user_groups_external_id` (`external_id`),
+  CONSTRAINT `uni_user_groups_display_name` UNIQUE (`display_name`)
+);
+
+CREATE TABLE IF NOT EXISTS `group_members` (
+  `group_id` bigint,
+  `user_id` bigint,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`group_id`, `user_id`),
+  INDEX `idx_group_members_user_id` (`user_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `invitations` (
+  `id` bigint AUTO_INCREMENT,
+  `email` varchar(100) NOT NULL,
+  `role` varchar(20) NOT NULL,
+  `invited_by` bigint NOT NULL,
+  `expires_at` datetime(3) NOT NULL,
+  `accepted_at` datetime(3) NULL,
+  `accepted_user_id` bigint,
+  `revoked_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_invitations_email` (`email`),
+  INDEX `idx_invitations_invited_by` (`invited_by`)
+);
+
+CREATE TABLE IF NOT EXISTS `outbox_emails` (
+  `id` bigint AUTO_INCREMENT,
+  `kind` varchar(50) NOT NULL,
+  `recipient` varchar(100) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `text_body` text,
+  `html_body` text,
+  `status` varchar(20) NOT NULL,
+  `attempts` bigint NOT NULL DEFAULT 0,
+  `next_attempt_at` datetime(3) NOT NULL,
+  `locked_until` datetime(3) NULL,
+  `last_error` text,
+  `sent_at` datetime(3) NULL,
+  `created_at` datetime(3) NULL,
+  `updated_at` datetime(3) NULL,
+  PRIMARY KEY (`id`),
+  INDEX `idx_outbox_emails_recipient` (`recipient`),
+  INDEX `idx_outbox_due` (`status`, `next_attempt_at`)
+);
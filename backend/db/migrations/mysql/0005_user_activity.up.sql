@@ -0,0 +1,12 @@
+-- Days each user was active, for the active users of past ranges. The last
+-- day of every user is recovered from last_seen_at
+
+CREATE TABLE IF NOT EXISTS `user_activities` (
+  `user_id` bigint,
+  `day` varchar(10),
+  PRIMARY KEY (`user_id`, `day`),
+  INDEX `idx_user_activities_day` (`day`)
+);
+
+INSERT INTO `user_activities` (`user_id`, `day`)
+SELECT `id`, DATE_FORMAT(`last_seen_at`, '%Y-%m-%d') FROM `user_models` WHERE `last_seen_at` IS NOT NULL;
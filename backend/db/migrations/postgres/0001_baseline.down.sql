@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS "outbox_emails";
+DROP TABLE IF EXISTS "invitations";
+DROP TABLE IF EXISTS "group_members";
+DROP TABLE IF EXISTS "user_groups";
+DROP TABLE IF EXISTS "federated_identities";
+DROP TABLE IF EXISTS "personal_access_tokens";
+DROP TABLE IF EXISTS "authorization_codes";
+DROP TABLE IF EXISTS "o_auth_clients";
+DROP TABLE IF EXISTS "verification_tokens";
+DROP TABLE IF EXISTS "user_models";
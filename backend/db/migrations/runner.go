@@ -0,0 +1,388 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Table with one row per applied migration
+const Table = "schema_migrations"
+
+// DefaultLockTimeout is how long an instance waits for another one to finish
+// migrating before giving up
+const DefaultLockTimeout = time.Minute
+
+// Runner applies and rolls back migrations. Only one runner works on a
+// database at a time: the others wait for the lock, so several instances can
+// start together
+type Runner struct {
+	db          *sql.DB
+	dialect     dialect
+	migrations  []Migration
+	LockTimeout time.Duration
+	// Adopt is called by Up, holding the lock, on a database without
+	// schema_migrations. It prepares the tables an older version created and
+	// returns the version they match, recorded as applied without running
+	// it; 0 when the database is empty
+	Adopt func(ctx context.Context, conn Conn) (int, error)
+}
+
+// MigrationStatus is the state of a migration in the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Modified  bool // applied with a different checksum, the file was edited
+	Unknown   bool // applied by a newer binary, this one doesn't have it
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// NewRunner returns the runner of migrations for a database of dialect
+// (mysql, postgres or sqlite)
+func NewRunner(db *sql.DB, dialectName string, migrations []Migration) (*Runner, error) {
+	dialect, ok := dialects[dialectName]
+	if !ok {
+		return nil, fmt.Errorf("no migrations for dialect %q", dialectName)
+	}
+	return &Runner{db: db, dialect: dialect, migrations: migrations, LockTimeout: DefaultLockTimeout}, nil
+}
+
+// Status lists the known migrations and the applied ones this binary doesn't have
+func (r *Runner) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// a database that was never migrated has every migration pending
+	applied := map[int]appliedMigration{}
+	exists, err := r.hasTable(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = r.readApplied(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	var result []MigrationStatus
+	known := map[int]bool{}
+	for _, migration := range r.migrations {
+		known[migration.Version] = true
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.appliedAt
+			status.AppliedAt = &appliedAt
+			status.Modified = row.checksum != migration.Checksum
+		}
+		result = append(result, status)
+	}
+	for version, row := range applied {
+		if !known[version] {
+			appliedAt := row.appliedAt
+			result = append(result, MigrationStatus{Version: version, Name: row.name, AppliedAt: &appliedAt, Unknown: true})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// Pending returns the migrations not applied yet
+func (r *Runner) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := r.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]bool{}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			applied[status.Version] = true
+		}
+	}
+	var pending []Migration
+	for _, migration := range r.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations in order and returns them. It refuses to
+// run if an applied migration was edited afterwards
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		if err := r.adopt(ctx, conn); err != nil {
+			return err
+		}
+		applied, err := r.readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := r.verify(applied); err != nil {
+			return err
+		}
+
+		for _, migration := range r.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := r.step(ctx, conn, r.up(ctx, migration), func(exec execer) error {
+				return r.markApplied(ctx, exec, migration)
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the last steps applied migrations, newest first
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := r.withLock(ctx, func(conn *sql.Conn) error {
+		if err := r.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+		applied, err := r.readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := r.verify(applied); err != nil {
+			return err
+		}
+
+		versions := make([]int, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+		if steps < len(versions) {
+			versions = versions[:steps]
+		}
+
+		for _, version := range versions {
+			migration, ok := r.find(version)
+			if !ok {
+				return fmt.Errorf("migration %d_%s was applied by a newer version and can't be rolled back by this one", version, applied[version].name)
+			}
+			err := r.step(ctx, conn, r.script(ctx, migration.Down), func(exec execer) error {
+				_, err := exec.ExecContext(ctx, r.dialect.bind("DELETE FROM "+Table+" WHERE version = ?"), version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Baseline records the migrations up to version as applied without running
+// them, for databases whose tables already exist
+func (r *Runner) Baseline(ctx context.Context, version int) error {
+	return r.withLock(ctx, func(conn *sql.Conn) error {
+		if err := r.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+		return r.baseline(ctx, conn, version)
+	})
+}
+
+func (r *Runner) baseline(ctx context.Context, conn *sql.Conn, version int) error {
+	applied, err := r.readApplied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for _, migration := range r.migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := r.markApplied(ctx, conn, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adopt creates schema_migrations, letting Adopt record the tables that
+// were there before it
+func (r *Runner) adopt(ctx context.Context, conn *sql.Conn) error {
+	exists, err := r.hasTable(ctx, conn)
+	if err != nil || exists {
+		return err
+	}
+
+	version := 0
+	if r.Adopt != nil {
+		if version, err = r.Adopt(ctx, conn); err != nil {
+			return err
+		}
+	}
+	if err := r.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	if version == 0 {
+		return nil
+	}
+	return r.baseline(ctx, conn, version)
+}
+
+func (r *Runner) find(version int) (Migration, bool) {
+	for _, migration := range r.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (r *Runner) verify(applied map[int]appliedMigration) error {
+	for _, migration := range r.migrations {
+		if row, ok := applied[migration.Version]; ok && row.checksum != migration.Checksum {
+			return fmt.Errorf("migration %d_%s was modified after being applied (applied checksum %s, file checksum %s)",
+				migration.Version, migration.Name, row.checksum, migration.Checksum)
+		}
+	}
+	return nil
+}
+
+// withLock runs fn holding the migration lock. The lock belongs to the
+// connection, so everything runs on the same one
+func (r *Runner) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := r.dialect.lock(ctx, conn, r.LockTimeout); err != nil {
+		return err
+	}
+	defer r.dialect.unlock(conn)
+
+	return fn(conn)
+}
+
+func (r *Runner) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+Table+" ("+
+		"version bigint NOT NULL PRIMARY KEY,"+
+		"name varchar(255) NOT NULL,"+
+		"checksum char(64) NOT NULL,"+
+		"applied_at "+r.dialect.timestampType+" NOT NULL)")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", Table, err)
+	}
+	return nil
+}
+
+func (r *Runner) hasTable(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var count int
+	err := conn.QueryRowContext(ctx, r.dialect.bind(r.dialect.tableExists), Table).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error looking for %s: %w", Table, err)
+	}
+	return count > 0, nil
+}
+
+func (r *Runner) readApplied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM "+Table)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", Table, err)
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var row appliedMigration
+		if err := rows.Scan(&version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+// execer is a connection or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (r *Runner) markApplied(ctx context.Context, exec execer, migration Migration) error {
+	_, err := exec.ExecContext(ctx, r.dialect.bind("INSERT INTO "+Table+" (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+		migration.Version, migration.Name, migration.Checksum, time.Now())
+	if err != nil {
+		return fmt.Errorf("error recording migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+// up is what applying migration runs: its code or its up script
+func (r *Runner) up(ctx context.Context, migration Migration) func(exec Conn) error {
+	if migration.Data != nil {
+		return func(exec Conn) error {
+			return migration.Data(ctx, exec)
+		}
+	}
+	return r.script(ctx, migration.Up)
+}
+
+// script runs the statements of a script one by one
+func (r *Runner) script(ctx context.Context, script string) func(exec Conn) error {
+	statements := splitStatements(script, r.dialect.hashComments)
+	return func(exec Conn) error {
+		for _, statement := range statements {
+			if _, err := exec.ExecContext(ctx, statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// step runs apply and then calls record. Where DDL is transactional
+// everything runs in one transaction; MySQL commits each DDL statement on its
+// own, so there a failing migration may be left half applied and has to be
+// fixed by hand before retrying
+func (r *Runner) step(ctx context.Context, conn *sql.Conn, apply func(exec Conn) error, record func(exec execer) error) error {
+	if !r.dialect.transactionalDDL {
+		if err := apply(conn); err != nil {
+			return err
+		}
+		return record(conn)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := apply(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
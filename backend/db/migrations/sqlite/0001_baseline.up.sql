@@ -0,0 +1,147 @@
+-- Tables of the service, as GORM creates them for SQLite
+
+CREATE TABLE IF NOT EXISTS `user_models` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `email` varchar(100) NOT NULL,
+  `normalized_email` varchar(255),
+  `password_hash` varchar(255),
+  `first_name` varchar(100) NOT NULL,
+  `last_name` varchar(100) NOT NULL,
+  `is_admin` numeric DEFAULT false,
+  `role` varchar(20) NOT NULL DEFAULT 'student',
+  `is_verified` numeric DEFAULT false,
+  `is_active` numeric DEFAULT true,
+  `external_id` varchar(255),
+  `locale` varchar(10),
+  `created_at` datetime,
+  `updated_at` datetime,
+  `last_login_at` datetime,
+  `last_seen_at` datetime,
+  CONSTRAINT `uni_user_models_email` UNIQUE (`email`)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_user_models_normalized_email` ON `user_models` (`normalized_email`);
+CREATE INDEX IF NOT EXISTS `idx_user_models_external_id` ON `user_models` (`external_id`);
+CREATE INDEX IF NOT EXISTS `idx_user_models_last_seen_at` ON `user_models` (`last_seen_at`);
+
+CREATE TABLE IF NOT EXISTS `verification_tokens` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `user_id` integer NOT NULL,
+  `purpose` varchar(20) NOT NULL,
+  `token_hash` varchar(64) NOT NULL,
+  `target` varchar(100),
+  `attempts` integer NOT NULL DEFAULT 0,
+  `expires_at` datetime NOT NULL,
+  `consumed_at` datetime,
+  `created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_user_purpose` ON `verification_tokens` (`user_id`, `purpose`);
+
+CREATE TABLE IF NOT EXISTS `o_auth_clients` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `client_id` varchar(64) NOT NULL,
+  `secret_hash` varchar(64) NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `scopes` varchar(255) NOT NULL,
+  `grant_types` varchar(255) NOT NULL DEFAULT 'client_credentials',
+  `redirect_uris` text,
+  `is_public` numeric DEFAULT false,
+  `is_active` numeric DEFAULT true,
+  `created_at` datetime,
+  CONSTRAINT `uni_o_auth_clients_client_id` UNIQUE (`client_id`)
+);
+
+CREATE TABLE IF NOT EXISTS `authorization_codes` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `code_hash` varchar(64) NOT NULL,
+  `client_id` varchar(64) NOT NULL,
+  `user_id` integer NOT NULL,
+  `redirect_uri` text NOT NULL,
+  `scope` varchar(255) NOT NULL,
+  `code_challenge` varchar(128) NOT NULL,
+  `code_challenge_method` varchar(10) NOT NULL,
+  `nonce` varchar(255),
+  `expires_at` datetime NOT NULL,
+  `consumed_at` datetime,
+  `created_at` datetime,
+  CONSTRAINT `uni_authorization_codes_code_hash` UNIQUE (`code_hash`)
+);
+CREATE INDEX IF NOT EXISTS `idx_authorization_codes_client_id` ON `authorization_codes` (`client_id`);
+CREATE INDEX IF NOT EXISTS `idx_authorization_codes_user_id` ON `authorization_codes` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `personal_access_tokens` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `user_id` integer NOT NULL,
+  `name` varchar(100) NOT NULL,
+  `token_hash` varchar(64) NOT NULL,
+  `prefix` varchar(16) NOT NULL,
+  `scopes` varchar(255) NOT NULL,
+  `expires_at` datetime,
+  `last_used_at` datetime,
+  `revoked_at` datetime,
+  `created_at` datetime,
+  CONSTRAINT `uni_personal_access_tokens_token_hash` UNIQUE (`token_hash`)
+);
+CREATE INDEX IF NOT EXISTS `idx_personal_access_tokens_user_id` ON `personal_access_tokens` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `federated_identities` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `user_id` integer NOT NULL,
+  `provider` varchar(50) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `email` varchar(100),
+  `last_login_at` datetime,
+  `created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_federated_identities_user_id` ON `federated_identities` (`user_id`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_provider_subject` ON `federated_identities` (`provider`, `subject`);
+
+CREATE TABLE IF NOT EXISTS `user_groups` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `display_name` varchar(255) NOT NULL,
+  `external_id` varchar(255),
+  `created_at` datetime,
+  `updated_at` datetime,
+  CONSTRAINT `uni_user_groups_display_name` UNIQUE (`display_name`)
+);
+CREATE INDEX IF NOT EXISTS `idx_user_groups_external_id` ON `user_groups` (`external_id`);
+
+CREATE TABLE IF NOT EXISTS `group_members` (
+  `group_id` integer,
+  `user_id` integer,
+  `created_at` datetime,
+  PRIMARY KEY (`group_id`, `user_id`)
+);
+CREATE INDEX IF NOT EXISTS `idx_group_members_user_id` ON `group_members` (`user_id`);
+
+CREATE TABLE IF NOT EXISTS `invitations` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `email` varchar(100) NOT NULL,
+  `role` varchar(20) NOT NULL,
+  `invited_by` integer NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `accepted_at` datetime,
+  `accepted_user_id` integer,
+  `revoked_at` datetime,
+  `created_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_invitations_email` ON `invitations` (`email`);
+CREATE INDEX IF NOT EXISTS `idx_invitations_invited_by` ON `invitations` (`invited_by`);
+
+CREATE TABLE IF NOT EXISTS `outbox_emails` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `kind` varchar(50) NOT NULL,
+  `recipient` varchar(100) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `text_body` text,
+  `html_body` text,
+  `status` varchar(20) NOT NULL,
+  `attempts` integer NOT NULL DEFAULT 0,
+  `next_attempt_at` datetime NOT NULL,
+  `locked_until` datetime,
+  `last_error` text,
+  `sent_at` datetime,
+  `created_at` datetime,
+  `updated_at` datetime
+);
+CREATE INDEX IF NOT EXISTS `idx_outbox_emails_recipient` ON `outbox_emails` (`recipient`);
+CREATE INDEX IF NOT EXISTS `idx_outbox_due` ON `outbox_emails` (`status`, `next_attempt_at`);
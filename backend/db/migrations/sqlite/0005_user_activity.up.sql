@@ -0,0 +1,12 @@
+-- Days each user was active, for the active users of past ranges. The last
+-- day of every user is recovered from last_seen_at
+
+CREATE TABLE IF NOT EXISTS `user_activities` (
+  `user_id` integer,
+  `day` varchar(10),
+  PRIMARY KEY (`user_id`, `day`)
+);
+CREATE INDEX IF NOT EXISTS `idx_user_activities_day` ON `user_activities` (`day`);
+
+INSERT INTO `user_activities` (`user_id`, `day`)
+SELECT `id`, strftime('%Y-%m-%d', `last_seen_at`) FROM `user_models` WHERE `last_seen_at` IS NOT NULL;
@@ -0,0 +1,14 @@
+package dto
+
+// HealthResponse is the state of the service and, for readiness, of each
+// component it depends on
+type HealthResponse struct {
+	Status string                    `json:"status"`
+	Checks map[string]HealthCheckDto `json:"checks,omitempty"`
+}
+
+type HealthCheckDto struct {
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
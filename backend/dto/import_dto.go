@@ -0,0 +1,35 @@
+package dto
+
+// ImportUsersOptions controls a bulk import from CSV
+type ImportUsersOptions struct {
+	DryRun          bool   `form:"dry_run,default=true"`          // only validate and report, nothing is written
+	PreVerified     bool   `form:"pre_verified"`                  // create the accounts already verified
+	SendInvitations bool   `form:"send_invitations,default=true"` // email each new user a link to choose a password
+	Locale          string `form:"locale"`                        // language of the emails, the importer's by default
+}
+
+// Row statuses of an import
+const (
+	ImportStatusCreated = "created" // created, or would be created in a dry run
+	ImportStatusSkipped = "skipped" // the email already exists or is repeated in the file
+	ImportStatusInvalid = "invalid" // the row has errors, see reason
+	ImportStatusFailed  = "failed"  // the batch could not be written
+)
+
+type ImportRowResult struct {
+	Line   int    `json:"line"` // line of the file, counting the header
+	Email  string `json:"email"`
+	Role   string `json:"role,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ImportUsersResponse struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Skipped int               `json:"skipped"`
+	Invalid int               `json:"invalid"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
@@ -0,0 +1,47 @@
+package dto
+
+import "time"
+
+// Invitation statuses, derived from the timestamps of the invitation
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusRevoked  = "revoked"
+	InvitationStatusExpired  = "expired"
+)
+
+type CreateInvitationRequest struct {
+	Email         string `json:"email" binding:"required,email,max=100"`
+	Role          string `json:"role"`                                   // defaults to student
+	ExpiresInDays int    `json:"expires_in_days" binding:"min=0,max=30"` // 0 means the default of 7 days
+	Locale        string `json:"locale"`                                 // language of the email, the inviter's by default
+}
+
+type InvitationDto struct {
+	ID             int        `json:"id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	Status         string     `json:"status"`
+	InvitedBy      int        `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	AcceptedUserID *int       `json:"accepted_user_id"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// InvitationPreviewResponse lets the accept page show who is being invited
+// before asking for a password
+type InvitationPreviewResponse struct {
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AcceptInvitationRequest struct {
+	Token     string `json:"token" binding:"required"`
+	Password  string `json:"password" binding:"required"` // checked by the password policy
+	FirstName string `json:"first_name" binding:"required,max=100"`
+	LastName  string `json:"last_name" binding:"required,max=100"`
+	Locale    string `json:"locale"` // preferred language, Accept-Language when empty
+}
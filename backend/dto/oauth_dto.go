@@ -0,0 +1,97 @@
+package dto
+
+import "time"
+
+type CreateOAuthClientRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	Scopes       []string `json:"scopes" binding:"required,min=1"`
+	GrantTypes   []string `json:"grant_types"`   // defaults to client_credentials
+	RedirectURIs []string `json:"redirect_uris"` // required for authorization_code
+	Public       bool     `json:"public"`        // SPA or mobile app without a secret
+}
+
+type CreateOAuthClientResponse struct {
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty"` // only returned once, stored hashed
+	Name         string   `json:"name"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+}
+
+type OAuthClientDto struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	Scopes       []string  `json:"scopes"`
+	GrantTypes   []string  `json:"grant_types"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Public       bool      `json:"public"`
+	IsActive     bool      `json:"is_active"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"` // only with the openid scope
+	Scope        string `json:"scope,omitempty"`
+}
+
+// OAuthErrorResponse is the error body defined by RFC 6749 section 5.2
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// AuthorizeRequest holds the parameters of an authorization request (RFC 6749
+// section 4.1.1 plus RFC 7636). They come in the query string on GET and are
+// echoed back as hidden fields on the login form
+type AuthorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	Nonce               string `form:"nonce"` // OpenID Connect, echoed in the ID token
+}
+
+// AuthorizeLoginRequest is the login and consent form posted to /oauth/authorize
+type AuthorizeLoginRequest struct {
+	AuthorizeRequest
+	Email    string `form:"email"`
+	Password string `form:"password"`
+	Action   string `form:"action"` // approve or deny
+}
+
+// UserInfoResponse is the OpenID Connect userinfo response. Claims are
+// filtered by the scopes of the access token
+type UserInfoResponse struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+}
+
+// OpenIDConfiguration is the OpenID Connect discovery document
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
@@ -0,0 +1,124 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SCIM 2.0 resources and messages (RFC 7643 and RFC 7644). Field names follow
+// the SCIM schema, which uses camelCase
+
+type ScimUser struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	UserName    string           `json:"userName"`
+	Name        *ScimName        `json:"name,omitempty"`
+	DisplayName string           `json:"displayName,omitempty"`
+	Emails      []ScimMultiValue `json:"emails,omitempty"`
+	Active      *bool            `json:"active,omitempty"`
+	Password    string           `json:"password,omitempty"` // write only, never returned
+	Roles       []ScimMultiValue `json:"roles,omitempty"`
+	Groups      []ScimMultiValue `json:"groups,omitempty"` // read only
+	Meta        *ScimMeta        `json:"meta,omitempty"`
+}
+
+type ScimName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+// ScimMultiValue is an entry of emails, roles, groups or members
+type ScimMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type ScimGroup struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []ScimMultiValue `json:"members,omitempty"`
+	Meta        *ScimMeta        `json:"meta,omitempty"`
+}
+
+type ScimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// ScimListQuery holds the query parameters of a list request. StartIndex is
+// 1-based as defined by SCIM
+type ScimListQuery struct {
+	Filter     string `form:"filter"`
+	StartIndex int    `form:"startIndex"`
+	Count      *int   `form:"count"` // nil means the default page size, 0 only counts
+}
+
+type ScimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations" binding:"required,min=1"`
+}
+
+type ScimPatchOperation struct {
+	Op    string          `json:"op"`   // add, replace or remove, case insensitive
+	Path  string          `json:"path"` // optional for add and replace
+	Value json.RawMessage `json:"value"`
+}
+
+// ScimError is the error body defined by RFC 7644 section 3.12
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+type ScimServiceProviderConfig struct {
+	Schemas               []string                 `json:"schemas"`
+	DocumentationURI      string                   `json:"documentationUri,omitempty"`
+	Patch                 ScimSupported            `json:"patch"`
+	Bulk                  ScimBulkConfig           `json:"bulk"`
+	Filter                ScimFilterConfig         `json:"filter"`
+	ChangePassword        ScimSupported            `json:"changePassword"`
+	Sort                  ScimSupported            `json:"sort"`
+	Etag                  ScimSupported            `json:"etag"`
+	AuthenticationSchemes []ScimAuthenticationType `json:"authenticationSchemes"`
+}
+
+type ScimSupported struct {
+	Supported bool `json:"supported"`
+}
+
+type ScimBulkConfig struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type ScimFilterConfig struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type ScimAuthenticationType struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary"`
+}
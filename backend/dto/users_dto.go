@@ -7,19 +7,22 @@ type UserDto struct {
 	FirstName  string `json:"first_name"`
 	LastName   string `json:"last_name"`
 	IsAdmin    bool   `json:"is_admin"`
+	Role       string `json:"role"`
 	IsVerified bool   `json:"is_verified"`
 }
 
 type RegisterRequest struct {
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=6"`
+	Password  string `json:"password" binding:"required"` // checked by the password policy
 	FirstName string `json:"first_name" binding:"required"`
 	LastName  string `json:"last_name" binding:"required"`
+	Locale    string `json:"locale"` // language of the emails, Accept-Language when empty
 }
 
 type RegisterResponse struct {
-	Message string `json:"message"`
-	Email   string `json:"email"`
+	Message    string `json:"message"`
+	MessageKey string `json:"message_key"`
+	Email      string `json:"email"`
 }
 
 type VerifyEmailRequest struct {
@@ -29,6 +32,7 @@ type VerifyEmailRequest struct {
 
 type VerifyEmailResponse struct {
 	Message      string `json:"message"`
+	MessageKey   string `json:"message_key"`
 	AccessToken  string `json:"access_token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 }
@@ -58,6 +62,81 @@ type RefreshTokenResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"` // checked by the password policy
+}
+
+type UpdateLocaleRequest struct {
+	Locale string `json:"locale" binding:"required"` // es or en
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Email       string `json:"email" binding:"required,email"`
+	Code        string `json:"code" binding:"required,len=6"`
+	NewPassword string `json:"new_password" binding:"required"` // checked by the password policy
+}
+
 type PromoteToAdminRequest struct {
 	UserID int `json:"user_id" binding:"required"`
 }
+
+// CreateAdminRequest is the first administrator, created by the create-admin command
+type CreateAdminRequest struct {
+	Email     string
+	Password  string // checked by the password policy
+	FirstName string
+	LastName  string
+}
+
+type DailyRegistrationsDto struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+type UsageStatsResponse struct {
+	From                       string                  `json:"from"`
+	To                         string                  `json:"to"`
+	DailyActiveUsers           int64                   `json:"daily_active_users"`
+	WeeklyActiveUsers          int64                   `json:"weekly_active_users"`
+	MonthlyActiveUsers         int64                   `json:"monthly_active_users"`
+	TotalRegistrations         int64                   `json:"total_registrations"`
+	VerifiedRegistrations      int64                   `json:"verified_registrations"`
+	VerificationConversionRate float64                 `json:"verification_conversion_rate"`
+	RegistrationsPerDay        []DailyRegistrationsDto `json:"registrations_per_day"`
+}
+
+type PublicUserDto struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type BatchUsersRequest struct {
+	IDs []int `json:"ids" binding:"required,min=1,max=100,dive,min=1"`
+}
+
+type BatchUsersResponse struct {
+	Users    []PublicUserDto `json:"users"`
+	NotFound []int           `json:"not_found"`
+}
+
+// AuthContext describes who made an authenticated request. Scopes is nil for
+// user sessions, which are not scope restricted
+type AuthContext struct {
+	UserID   int      `json:"user_id,omitempty"`
+	IsAdmin  bool     `json:"is_admin"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+type RegistrationPolicyDto struct {
+	Mode              string   `json:"mode"`
+	AllowedDomains    []string `json:"allowed_domains"`
+	DisposableDomains int      `json:"disposable_domains"` // size of the blocklist
+	DisposableSource  string   `json:"disposable_source"`  // "bundled" or the file it was read from
+}
@@ -0,0 +1,124 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// DefaultLocale is used when the client asks for no supported locale
+const DefaultLocale = "es"
+
+// Each locale is a flat JSON object from message key to text. Texts may have
+// {name} placeholders, filled from the Params of T
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Params fills the placeholders of a message
+type Params map[string]interface{}
+
+var (
+	catalogs = loadCatalogs()
+	locales  = catalogLocales()
+	matcher  = newMatcher()
+)
+
+func loadCatalogs() map[string]map[string]string {
+	files, err := fs.Glob(localeFiles, "locales/*.json")
+	if err != nil {
+		panic(err)
+	}
+	result := map[string]map[string]string{}
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: invalid %s: %v", file, err))
+		}
+		result[strings.TrimSuffix(strings.TrimPrefix(file, "locales/"), ".json")] = catalog
+	}
+	return result
+}
+
+// catalogLocales lists the locales with the default one first, which the
+// matcher takes as the fallback
+func catalogLocales() []string {
+	result := []string{DefaultLocale}
+	for locale := range catalogs {
+		if locale != DefaultLocale {
+			result = append(result, locale)
+		}
+	}
+	sort.Strings(result[1:])
+	return result
+}
+
+func newMatcher() language.Matcher {
+	tags := make([]language.Tag, 0, len(locales))
+	for _, locale := range locales {
+		tags = append(tags, language.Make(locale))
+	}
+	return language.NewMatcher(tags)
+}
+
+// Locales lists the supported locales, the default one first
+func Locales() []string {
+	return append([]string(nil), locales...)
+}
+
+// Supported returns the supported locale for a tag like "en" or "en-US"
+func Supported(locale string) (string, bool) {
+	tag, err := language.Parse(strings.TrimSpace(locale))
+	if err != nil {
+		return "", false
+	}
+	base, _ := tag.Base()
+	if _, ok := catalogs[base.String()]; !ok {
+		return "", false
+	}
+	return base.String(), true
+}
+
+// Negotiate picks the best supported locale for an Accept-Language header
+func Negotiate(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+	_, index, confidence := matcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+	return locales[index]
+}
+
+// T returns the text of key in locale, falling back to the default locale
+// and then to the key itself
+func T(locale string, key string, params Params) string {
+	text, ok := catalogs[locale][key]
+	if !ok {
+		if text, ok = catalogs[DefaultLocale][key]; !ok {
+			text = key
+		}
+	}
+	for name, value := range params {
+		text = strings.ReplaceAll(text, "{"+name+"}", fmt.Sprint(value))
+	}
+	return text
+}
+
+// Localized is implemented by the errors that carry a message key, so the
+// controllers can answer in the language of the request
+type Localized interface {
+	MessageKey() string
+	MessageParams() Params
+}
@@ -0,0 +1,140 @@
+// Package mailer builds MIME messages and delivers them through SMTP, a local
+// maildir, the log or memory
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Mailer delivers a message to its recipients
+type Mailer interface {
+	Send(message Message) error
+}
+
+// Checker is a Mailer that can tell whether it can deliver without sending,
+// like SMTPMailer connecting to its server
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// Message is an email with a plain text and an optional HTML version
+type Message struct {
+	From    mail.Address
+	To      []mail.Address
+	Subject string
+	Text    string
+	HTML    string
+	Date    time.Time // now when empty
+}
+
+// Recipients returns the bare addresses of To, for the SMTP envelope
+func (m Message) Recipients() []string {
+	addresses := make([]string, 0, len(m.To))
+	for _, to := range m.To {
+		addresses = append(addresses, to.Address)
+	}
+	return addresses
+}
+
+// Bytes renders the message in RFC 5322 format, as multipart/alternative when
+// it has an HTML version. Non ASCII headers are encoded and bodies go as
+// quoted-printable UTF-8
+func (m Message) Bytes() ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, fmt.Errorf("message has no recipients")
+	}
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID, err := newMessageID(m.From.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	to := make([]string, 0, len(m.To))
+	for _, address := range m.To {
+		to = append(to, address.String())
+	}
+
+	var body bytes.Buffer
+	var contentType string
+	if m.HTML == "" {
+		contentType = "text/plain; charset=utf-8"
+		if err := writeQuotedPrintable(&body, m.Text); err != nil {
+			return nil, err
+		}
+	} else {
+		parts := multipart.NewWriter(&body)
+		contentType = "multipart/alternative; boundary=" + parts.Boundary()
+		for _, part := range []struct{ contentType, content string }{
+			{"text/plain; charset=utf-8", m.Text},
+			{"text/html; charset=utf-8", m.HTML},
+		} {
+			writer, err := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err := writeQuotedPrintable(writer, part.content); err != nil {
+				return nil, err
+			}
+		}
+		if err := parts.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	var message bytes.Buffer
+	header := func(name, value string) {
+		message.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", m.From.String())
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+	header("Content-Type", contentType)
+	if m.HTML == "" {
+		header("Content-Transfer-Encoding", "quoted-printable")
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	encoder := quotedprintable.NewWriter(w)
+	if _, err := encoder.Write([]byte(content)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// newMessageID returns a unique Message-ID on the domain of the sender
+func newMessageID(from string) (string, error) {
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain), nil
+}
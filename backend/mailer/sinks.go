@@ -0,0 +1,87 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FileMailer writes each message to a maildir (tmp, new and cur folders), so
+// development mail can be opened with any mail client
+type FileMailer struct {
+	Dir string
+}
+
+func (m FileMailer) Send(message Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	for _, folder := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(m.Dir, folder), 0o755); err != nil {
+			return fmt.Errorf("error creating maildir: %w", err)
+		}
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%d_%s.unichat.eml", time.Now().UnixNano(), os.Getpid(), hex.EncodeToString(random))
+
+	// written in tmp and moved to new, so readers never see half a message
+	temporary := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(temporary, data, 0o644); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err := os.Rename(temporary, filepath.Join(m.Dir, "new", name)); err != nil {
+		return fmt.Errorf("error delivering message: %w", err)
+	}
+	return nil
+}
+
+// LogMailer prints the text version of each message instead of sending it,
+// the behaviour when no SMTP server is configured
+type LogMailer struct{}
+
+func (LogMailer) Send(message Message) error {
+	log.Warnf("SMTP not configured, printing email to %v", message.Recipients())
+	fmt.Printf("\n=== EMAIL TO %v ===\nSubject: %s\n\n%s\n===============================\n", message.Recipients(), message.Subject, message.Text)
+	return nil
+}
+
+// MemoryMailer keeps the messages in memory, for tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(message Message) error {
+	if _, err := message.Bytes(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset forgets the messages sent so far
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
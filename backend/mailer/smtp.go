@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"backend/config"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string // no authentication when empty
+	Password string
+	Security string // starttls (default), tls or none
+	Timeout  time.Duration
+}
+
+func (m SMTPMailer) Send(message Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+
+	client, err := m.session(context.Background())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(message.From.Address); err != nil {
+		return err
+	}
+	for _, recipient := range message.Recipients() {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("recipient %s rejected: %w", recipient, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Check opens a session with the server, authenticating if there are
+// credentials, and closes it without sending anything
+func (m SMTPMailer) Check(ctx context.Context) error {
+	client, err := m.session(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Quit()
+}
+
+// session connects to the server and secures and authenticates the
+// connection. It ends with m.Timeout or ctx, whichever comes first
+func (m SMTPMailer) session(ctx context.Context) (*smtp.Client, error) {
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	addr := net.JoinHostPort(m.Host, m.Port)
+	dialer := &net.Dialer{Deadline: deadline}
+	tlsConfig := &tls.Config{ServerName: m.Host}
+
+	var conn net.Conn
+	var err error
+	if m.Security == config.SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting SMTP session: %w", err)
+	}
+
+	if m.Security == "" || m.Security == config.SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("%s doesn't support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if m.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error authenticating: %w", err)
+		}
+	}
+	return client, nil
+}
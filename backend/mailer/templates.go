@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"backend/config"
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used for the locales without templates
+const DefaultLocale = config.DefaultMailLocale
+
+// Each email has a <name>.txt template, which also defines the "subject", and
+// a <name>.html template per locale. Both define "content", wrapped by the
+// shared layout.txt and layout.html. strings.tmpl has the texts of the
+// layout in each locale
+//
+//go:embed templates
+var templateFiles embed.FS
+
+type parsedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var (
+	parsedTemplates   = map[string]parsedTemplate{}
+	parsedTemplatesMu sync.Mutex
+)
+
+// Locales lists the locales that have templates
+func Locales() []string {
+	entries, _ := fs.ReadDir(templateFiles, "templates")
+	var locales []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			locales = append(locales, entry.Name())
+		}
+	}
+	return locales
+}
+
+// Render fills the template name in locale, falling back to DefaultLocale.
+// The returned message has the subject and both bodies, but no addresses
+func Render(locale string, name string, data interface{}) (Message, error) {
+	templates, err := loadTemplate(locale, name)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := templates.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("error rendering subject of %s: %w", name, err)
+	}
+	if err := templates.text.ExecuteTemplate(&text, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("error rendering %s: %w", name, err)
+	}
+	if err := templates.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return Message{}, fmt.Errorf("error rendering %s: %w", name, err)
+	}
+
+	return Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
+
+func loadTemplate(locale string, name string) (parsedTemplate, error) {
+	locale = strings.ToLower(locale)
+	if _, err := fs.Stat(templateFiles, "templates/"+locale+"/"+name+".txt"); locale == "" || err != nil {
+		locale = DefaultLocale
+	}
+	key := locale + "/" + name
+
+	parsedTemplatesMu.Lock()
+	defer parsedTemplatesMu.Unlock()
+	if templates, ok := parsedTemplates[key]; ok {
+		return templates, nil
+	}
+
+	dir := "templates/" + locale + "/"
+	text, err := texttemplate.ParseFS(templateFiles, "templates/layout.txt", dir+"strings.tmpl", dir+name+".txt")
+	if err != nil {
+		return parsedTemplate{}, fmt.Errorf("error parsing template %s: %w", key, err)
+	}
+	html, err := htmltemplate.ParseFS(templateFiles, "templates/layout.html", dir+"strings.tmpl", dir+name+".html")
+	if err != nil {
+		return parsedTemplate{}, fmt.Errorf("error parsing template %s: %w", key, err)
+	}
+
+	parsedTemplates[key] = parsedTemplate{text: text, html: html}
+	return parsedTemplates[key], nil
+}
@@ -3,25 +3,136 @@ package main
 import (
 	//importo modulo propio
 	"backend/app" //importo modulo propio
-	"backend/db"  //importo modulo propio
-	_ "fmt"       //importo libreria externa
+	accessTokenClient "backend/clients/accesstoken"
+	emailClient "backend/clients/email"
+	groupClient "backend/clients/group"
+	idpClient "backend/clients/idp"
+	oauthClient "backend/clients/oauth"
+	userCLient "backend/clients/user"
+	"backend/config"
+	"backend/db" //importo modulo propio
+	"backend/services"
+	"backend/utils"
+	"context"
+	"errors"
+	"flag"
+	_ "fmt" //importo libreria externa
+	"io/fs"
 	"log"
+	"os"
+	"time"
 
 	_ "github.com/gin-gonic/gin" //importo un link
 	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load .env file
-	err := godotenv.Load()
+	// Load .env file, optional since the settings can also come from a config file
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal("Error loading .env file: ", err)
+	}
+
+	// Settings from the defaults, the -config file, the environment and the flags
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
+	}
+	utils.ConfigureJWT(cfg.JWT)
+
+	// How emails are compared, used by the import and migration subcommands too
+	utils.ConfigureEmails(cfg.Emails)
+
+	// Email delivery (SMTP, maildir or log), needed by some subcommands too
+	if err := utils.LoadMailer(cfg.Mail); err != nil {
+		log.Fatal(err)
+	}
+
+	// Maintenance subcommands, e.g. "backend import-users -file alumnos.csv"
+	if len(args) > 0 {
+		os.Exit(runCommand(cfg, args[0], args[1:]))
 	}
 
 	//variable que me apunta al llamado
 
-	db.StartDbEngine()
-	app.StartRoute()
+	// Register the external identity providers users can log in with
+	idpClient.LoadProviders(cfg.Federation)
+
+	// Issuer and key of the OpenID Connect ID tokens, checked before serving
+	if err := utils.ConfigureOIDC(cfg.OIDC); err != nil {
+		log.Fatal(err)
+	}
+
+	// Database of DB_DRIVER (mysql, postgres or sqlite)
+	if err := db.Connect(cfg.Database); err != nil {
+		log.Fatal(err)
+	}
+
+	// Repositories the services get injected
+	users := userCLient.NewUserRepository(db.DB)
+	tokens := userCLient.NewTokenRepository(db.DB)
+	sessions := userCLient.NewSessionRepository(db.DB)
+	invitations := userCLient.NewInvitationRepository(db.DB)
+	identities := userCLient.NewFederatedIdentityRepository(db.DB)
+	accessTokens := accessTokenClient.NewAccessTokenRepository(db.DB)
+	oauthClients := oauthClient.NewClientRepository(db.DB)
+	authorizationCodes := oauthClient.NewAuthorizationCodeRepository(db.DB)
+	groups := groupClient.NewGroupRepository(db.DB)
+	outbox := services.NewEmailOutbox(emailClient.NewOutboxRepository(db.DB), cfg.Outbox)
+
+	// Who can self-register: mode, allowed domains and disposable domains blocklist
+	registration, err := services.NewRegistrationPolicy(cfg.Registration)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Build the login chain (local passwords, LDAP); directory users get an
+	// account on their first login if the registration policy allows it
+	if err := services.ConfigureAuthenticators(cfg.Auth, users, registration); err != nil {
+		log.Fatal(err)
+	}
+
+	// Password rules: length, banned words, strength and breached passwords
+	if err := services.ConfigurePasswordPolicy(cfg.Password); err != nil {
+		log.Fatal(err)
+	}
+
+	db.StartDbEngine(cfg.Database)
+	// Queued emails: retries, backoff and the failed (dead letter) state
+	outbox.Start()
+
+	// Components /readyz checks. SMTP is optional, the emails wait in the
+	// outbox while the server is down
+	checks := []services.HealthCheck{
+		{Name: "database", Check: db.Ping},
+		{Name: "migrations", Check: db.CheckMigrations},
+	}
+	if cfg.Health.CheckSMTP {
+		checks = append(checks, services.HealthCheck{Name: "smtp", Check: utils.CheckMailer})
+	}
+
+	invitationService := services.NewInvitationService(users, invitations, sessions, cfg.Invitations)
+	server := app.NewServer(cfg.Server, app.Services{
+		Users:       services.NewUserService(users, tokens, sessions, accessTokens, invitationService, registration),
+		Invitations: invitationService,
+		Federation:  services.NewFederationService(users, identities, sessions, registration),
+		OAuth:       services.NewOAuthService(users, sessions, oauthClients, authorizationCodes),
+		Scim:        services.NewScimService(users, groups),
+		Outbox:      outbox,
+		Health:      services.NewHealthService(time.Duration(cfg.Health.Timeout), checks...),
+	})
+
+	// Serve until SIGINT or SIGTERM, then stop the workers before the database
+	// they use. Queued emails stay in the database for the next start
+	err = app.Run(cfg.Server, server,
+		app.Stopper{Name: "email outbox", Stop: outbox.Stop},
+		app.Stopper{Name: "database", Stop: func(context.Context) error { return db.Close() }},
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	//el segundo parametro que recibe la funcion Get es la declaracion de una funcion, osea no se ejecutara en ese momento
 	//la funcion GetHotel es lo que va a hacer cuando se produzca ese llamado, es una referencia a la funcion, ya que no pasamos parametros
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+type PersonalAccessToken struct {
+	ID         int        `gorm:"primaryKey;autoIncrement"`         //PK
+	UserID     int        `gorm:"not null;index"`                   //Owner
+	Name       string     `gorm:"type:varchar(100);not null"`       //Label chosen by the user
+	TokenHash  string     `gorm:"unique;not null;type:varchar(64)"` //SHA-256 of the token, the token itself is never stored
+	Prefix     string     `gorm:"type:varchar(16);not null"`        //First characters, to recognise the token in listings
+	Scopes     string     `gorm:"type:varchar(255);not null"`       //Space separated scopes
+	ExpiresAt  *time.Time `gorm:"null"`                             //Optional expiration
+	LastUsedAt *time.Time `gorm:"null"`                             //Last successful authentication
+	RevokedAt  *time.Time `gorm:"null"`                             //Set when the user revokes the token
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`                   //Creation timestamp
+}
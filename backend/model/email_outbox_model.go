@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// Status of an email in the outbox
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSending = "sending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed" // gave up after the maximum attempts, can be re-sent by an admin
+)
+
+type OutboxEmail struct {
+	ID            int        `gorm:"primaryKey;autoIncrement"`                       //PK
+	Kind          string     `gorm:"type:varchar(50);not null"`                      //Template used, e.g. verification
+	Recipient     string     `gorm:"type:varchar(100);not null;index"`               //To address
+	Subject       string     `gorm:"type:varchar(255);not null"`                     //Rendered subject
+	TextBody      string     `gorm:"type:text"`                                      //Plain text version, cleared once sent or failed with a code
+	HTMLBody      string     `gorm:"type:text"`                                      //HTML version, cleared once sent or failed with a code
+	Status        string     `gorm:"type:varchar(20);not null;index:idx_outbox_due"` //pending, sending, sent or failed
+	Attempts      int        `gorm:"not null;default:0"`                             //Delivery attempts so far
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_due"`                  //Pending emails wait until this
+	LockedUntil   *time.Time `gorm:"null"`                                           //Lease of the worker sending it, taken back after a crash
+	LastError     string     `gorm:"type:text"`                                      //Error of the last failed attempt
+	SentAt        *time.Time `gorm:"null"`                                           //Delivery timestamp
+	CreatedAt     time.Time  `gorm:"autoCreateTime"`                                 //Enqueue timestamp
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime"`                                 //Last status change
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+type FederatedIdentity struct {
+	ID          int        `gorm:"primaryKey;autoIncrement"`                                    //PK
+	UserID      int        `gorm:"not null;index"`                                              //Linked local user
+	Provider    string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_provider_subject"`  //Provider name (e.g. university)
+	Subject     string     `gorm:"type:varchar(255);not null;uniqueIndex:idx_provider_subject"` //User ID at the provider
+	Email       string     `gorm:"type:varchar(100)"`                                           //Email reported by the provider when linked
+	LastLoginAt *time.Time `gorm:"null"`                                                        //Last login through this provider
+	CreatedAt   time.Time  `gorm:"autoCreateTime"`                                              //Link timestamp
+}
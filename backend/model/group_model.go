@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+type UserGroup struct {
+	ID          int       `gorm:"primaryKey;autoIncrement"`          //PK
+	DisplayName string    `gorm:"unique;not null;type:varchar(255)"` //Group name, e.g. a course or commission
+	ExternalID  string    `gorm:"type:varchar(255);index"`           //Identifier in the university identity system
+	CreatedAt   time.Time `gorm:"autoCreateTime"`                    //Creation timestamp
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`                    //Last change, including membership
+}
+
+type GroupMember struct {
+	GroupID   int       `gorm:"primaryKey"`       //FK to user_groups
+	UserID    int       `gorm:"primaryKey;index"` //FK to user_models
+	CreatedAt time.Time `gorm:"autoCreateTime"`   //Membership timestamp
+}
+
+// GroupMembership is a group a user belongs to, with the name of the group
+type GroupMembership struct {
+	UserID      int
+	GroupID     int
+	DisplayName string
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+type Invitation struct {
+	ID             int        `gorm:"primaryKey;autoIncrement"`         //PK
+	Email          string     `gorm:"type:varchar(100);not null;index"` //Invited email
+	Role           string     `gorm:"type:varchar(20);not null"`        //Role given to the user on acceptance
+	InvitedBy      int        `gorm:"not null;index"`                   //User who sent the invitation, 0 for imports
+	ExpiresAt      time.Time  `gorm:"not null"`                         //The link stops working after this
+	AcceptedAt     *time.Time `gorm:"null"`                             //Set when the invitee creates the account
+	AcceptedUserID *int       `gorm:"null"`                             //Account created from the invitation, set from the start for imported accounts
+	RevokedAt      *time.Time `gorm:"null"`                             //Set when revoked or replaced by a newer invitation
+	CreatedAt      time.Time  `gorm:"autoCreateTime"`                   //Creation timestamp
+}
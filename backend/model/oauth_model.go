@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+type OAuthClient struct {
+	ID           int       `gorm:"primaryKey;autoIncrement"`                                //PK
+	ClientID     string    `gorm:"unique;not null;type:varchar(64)"`                        //Public client identifier
+	SecretHash   string    `gorm:"type:varchar(64);not null"`                               //SHA-256 of the client secret, empty for public clients
+	Name         string    `gorm:"type:varchar(100);not null"`                              //Human readable name (e.g. chats)
+	Scopes       string    `gorm:"type:varchar(255);not null"`                              //Space separated allowed scopes
+	GrantTypes   string    `gorm:"type:varchar(255);not null;default:'client_credentials'"` //Space separated allowed grant types
+	RedirectURIs string    `gorm:"type:text"`                                               //Space separated registered redirect URIs
+	IsPublic     bool      `gorm:"default:false"`                                           //Public clients (SPA, mobile) have no secret and rely on PKCE
+	IsActive     bool      `gorm:"default:true"`                                            //Revoked clients can't get tokens
+	CreatedAt    time.Time `gorm:"autoCreateTime"`                                          //Creation timestamp
+}
+
+type AuthorizationCode struct {
+	ID                  int        `gorm:"primaryKey;autoIncrement"`         //PK
+	CodeHash            string     `gorm:"unique;not null;type:varchar(64)"` //SHA-256 of the code
+	ClientID            string     `gorm:"not null;index;type:varchar(64)"`  //Client the code was issued to
+	UserID              int        `gorm:"not null;index"`                   //User that approved the request
+	RedirectURI         string     `gorm:"type:text;not null"`               //Must match on the token request
+	Scope               string     `gorm:"type:varchar(255);not null"`       //Granted scopes
+	CodeChallenge       string     `gorm:"type:varchar(128);not null"`       //PKCE challenge
+	CodeChallengeMethod string     `gorm:"type:varchar(10);not null"`        //Always S256
+	Nonce               string     `gorm:"type:varchar(255)"`                //OpenID Connect nonce, copied into the ID token
+	ExpiresAt           time.Time  `gorm:"not null"`                         //Codes are short lived
+	ConsumedAt          *time.Time `gorm:"null"`                             //Codes can only be exchanged once
+	CreatedAt           time.Time  `gorm:"autoCreateTime"`                   //Creation timestamp
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// ActivityThrottle is the minimum time between two writes of the same
+// activity timestamp: users.last_seen_at and the last_used_at of a personal
+// access token. Every request of an active user would otherwise be a write,
+// and the statistics built on them are by day. The first activity of each
+// day is always written, so no active day is missed
+const ActivityThrottle = 5 * time.Minute
+
+// ActivityCutoff is the time before which a stored activity timestamp is
+// replaced by one at at, following ActivityThrottle
+func ActivityCutoff(at time.Time) time.Time {
+	cutoff := at.Add(-ActivityThrottle)
+	local := at.Local()
+	if dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local); dayStart.After(cutoff) {
+		return dayStart
+	}
+	return cutoff
+}
+
+// ActivityDay is the day of at in the time zone of the server, as stored in
+// user_activities
+func ActivityDay(at time.Time) string {
+	return at.Local().Format("2006-01-02")
+}
+
+// UserActivity records that a user was active on a day. The active users of
+// any past range are counted from it, last_seen_at only has the last day
+type UserActivity struct {
+	UserID int    `gorm:"primaryKey"`                        //FK to user_models
+	Day    string `gorm:"primaryKey;type:varchar(10);index"` //YYYY-MM-DD in the time zone of the server
+}
@@ -3,22 +3,48 @@ package model
 import "time"
 
 type UserModel struct {
-	ID               int       `gorm:"primaryKey;autoIncrement"`          //PK
-	Email            string    `gorm:"unique;not null;type:varchar(100)"` //Unique email
-	PasswordHash     string    `gorm:"longtext"`                          //Password Hash
-	FirstName        string    `gorm:"type:varchar(100);not null"`
-	LastName         string    `gorm:"type:varchar(100);not null"`
-	IsAdmin          bool      `gorm:"default:false"`        //Admin
-	IsVerified       bool      `gorm:"default:false"`        //Email verified
-	CreatedAt        time.Time `gorm:"autoCreateTime"`       //Creation timestamp
-	VerificationCode string    `gorm:"type:varchar(6);null"` //6-digit verification code
-	CodeExpiresAt    time.Time `gorm:"null"`                 //Code expiration time
+	ID              int        `gorm:"primaryKey;autoIncrement"`          //PK
+	Email           string     `gorm:"unique;not null;type:varchar(100)"` //Unique email
+	NormalizedEmail *string    `gorm:"type:varchar(255);uniqueIndex"`     //Lookup key, see utils.NormalizeEmail. NULL while a collision is unresolved
+	PasswordHash    string     `gorm:"type:varchar(255)"`                 //Password Hash
+	FirstName       string     `gorm:"type:varchar(100);not null"`
+	LastName        string     `gorm:"type:varchar(100);not null"`
+	IsAdmin         bool       `gorm:"default:false"`                               //Admin
+	Role            string     `gorm:"type:varchar(20);not null;default:'student'"` //student, professor or admin
+	IsVerified      bool       `gorm:"default:false"`                               //Email verified
+	IsActive        bool       `gorm:"default:true"`                                //Deactivated users can't log in
+	ExternalID      string     `gorm:"type:varchar(255);index"`                     //Identifier in the university identity system (SCIM externalId)
+	Locale          string     `gorm:"type:varchar(10)"`                            //Preferred language (es, en). Empty follows Accept-Language
+	CreatedAt       time.Time  `gorm:"autoCreateTime"`                              //Creation timestamp
+	UpdatedAt       time.Time  `gorm:"autoUpdateTime"`                              //Last profile change
+	LastLoginAt     *time.Time `gorm:"null"`                                        //Last successful login
+	LastSeenAt      *time.Time `gorm:"null;index"`                                  //Last activity (login or token refresh)
 }
 
+// Purposes of a VerificationToken. A code only works for its own purpose
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+	TokenPurposeLoginCode     = "login_code"
+	TokenPurposeChangeEmail   = "change_email"
+)
+
+// VerificationToken is a one-time code sent to the user, like the email
+// verification or the password reset code
 type VerificationToken struct {
-	ID        int       `gorm:"primaryKey;autoIncrement"`
-	UserID    int       `gorm:"not null;index"`
-	Token     string    `gorm:"type:varchar(6);not null"` //6-digit code
-	ExpiresAt time.Time `gorm:"not null"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ID         int        `gorm:"primaryKey;autoIncrement"`                         //PK
+	UserID     int        `gorm:"not null;index:idx_user_purpose"`                  //Owner
+	Purpose    string     `gorm:"type:varchar(20);not null;index:idx_user_purpose"` //verify_email, reset_password, login_code or change_email
+	TokenHash  string     `gorm:"type:varchar(64);not null"`                        //HMAC-SHA256 of the code, the code itself is never stored
+	Target     string     `gorm:"type:varchar(100)"`                                //New email for change_email
+	Attempts   int        `gorm:"not null;default:0"`                               //Codes tried, the right one included
+	ExpiresAt  time.Time  `gorm:"not null"`                                         //The code stops working after this
+	ConsumedAt *time.Time `gorm:"null"`                                             //Codes can only be used once
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`                                   //Creation timestamp
+}
+
+// DailyCount is the number of rows grouped under a single calendar day
+type DailyCount struct {
+	Day   string
+	Count int64
 }
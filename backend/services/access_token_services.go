@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/dto"
+	"backend/i18n"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Personal access tokens start with this prefix so they can be told apart from JWTs
+	AccessTokenPrefix = "pat_"
+	// Characters of the token kept in clear to recognise it in listings
+	accessTokenDisplayLength = 12
+)
+
+// CreateAccessToken creates a personal access token for the user. The token
+// value is only returned here; the database keeps its hash
+func (s *UserService) CreateAccessToken(userID int, request dto.CreateAccessTokenRequest) (dto.CreateAccessTokenResponse, utils.ApiError) {
+	for _, scope := range request.Scopes {
+		if utils.IsAdminScope(scope) {
+			if apiErr := s.checkAdminScope(userID, scope); apiErr != nil {
+				return dto.CreateAccessTokenResponse{}, apiErr
+			}
+			continue
+		}
+		if !utils.IsKnownScope(scope) {
+			return dto.CreateAccessTokenResponse{}, apiError(http.StatusBadRequest, utils.CodeValidation, "access_token.unknown_scope", i18n.Params{"scope": scope})
+		}
+		// provisioning is reserved to service clients
+		if scope == utils.ScopeSCIM {
+			return dto.CreateAccessTokenResponse{}, apiError(http.StatusForbidden, utils.CodeForbidden, "access_token.scope_not_allowed", i18n.Params{"scope": scope})
+		}
+	}
+
+	secret, err := utils.GenerateSecureToken(32)
+	if err != nil {
+		log.Println("Error generating access token:", err)
+		return dto.CreateAccessTokenResponse{}, utils.NewInternalServerApiError("Error generating access token", err)
+	}
+	value := AccessTokenPrefix + secret
+
+	var expiresAt *time.Time
+	if request.ExpiresInDays > 0 {
+		expiration := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		expiresAt = &expiration
+	}
+
+	token, err := s.accessTokens.Create(model.PersonalAccessToken{
+		UserID:    userID,
+		Name:      request.Name,
+		TokenHash: utils.HashSHA256(value),
+		Prefix:    value[:accessTokenDisplayLength],
+		Scopes:    strings.Join(request.Scopes, " "),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Println("Error creating access token:", err)
+		return dto.CreateAccessTokenResponse{}, utils.NewInternalServerApiError("Error creating access token", err)
+	}
+
+	return dto.CreateAccessTokenResponse{
+		AccessTokenDto: accessTokenToDto(token),
+		Token:          value,
+	}, nil
+}
+
+// GetAccessTokens lists the user's tokens, including revoked and expired ones
+func (s *UserService) GetAccessTokens(userID int) ([]dto.AccessTokenDto, utils.ApiError) {
+	tokens, err := s.accessTokens.ListByUser(userID)
+	if err != nil {
+		log.Println("Error getting access tokens:", err)
+		return nil, utils.NewInternalServerApiError("Error getting access tokens", err)
+	}
+
+	result := make([]dto.AccessTokenDto, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, accessTokenToDto(token))
+	}
+	return result, nil
+}
+
+// RevokeAccessToken revokes one of the user's tokens
+func (s *UserService) RevokeAccessToken(userID int, tokenID int) utils.ApiError {
+	err := s.accessTokens.Revoke(userID, tokenID, time.Now())
+	if err == gorm.ErrRecordNotFound {
+		return apiError(http.StatusNotFound, utils.CodeNotFound, "access_token.not_found", nil)
+	}
+	if err != nil {
+		log.Println("Error revoking access token:", err)
+		return utils.NewInternalServerApiError("Error revoking access token", err)
+	}
+	return nil
+}
+
+// checkAdminScope only lets admins put an admin scope on their tokens
+func (s *UserService) checkAdminScope(userID int, scope string) utils.ApiError {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		log.Println("Error getting access token owner:", err)
+		return utils.NewInternalServerApiError("Error getting user", err)
+	}
+	if !user.IsAdmin {
+		return apiError(http.StatusForbidden, utils.CodeForbidden, "access_token.admin_scope", i18n.Params{"scope": scope})
+	}
+	return nil
+}
+
+// verifyAccessToken resolves a personal access token to its owner and scopes
+func (s *UserService) verifyAccessToken(value string) (dto.AuthContext, error) {
+	token, err := s.accessTokens.GetByHash(utils.HashSHA256(value))
+	if err != nil {
+		return dto.AuthContext{}, fmt.Errorf("failed to verify access token: %w", err)
+	}
+
+	now := time.Now()
+	if token.RevokedAt != nil {
+		return dto.AuthContext{}, fmt.Errorf("access token revoked")
+	}
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return dto.AuthContext{}, fmt.Errorf("access token expired at %v", *token.ExpiresAt)
+	}
+
+	user, err := s.users.GetByID(token.UserID)
+	if err != nil {
+		return dto.AuthContext{}, fmt.Errorf("failed to get access token owner: %w", err)
+	}
+	if !user.IsActive {
+		return dto.AuthContext{}, fmt.Errorf("access token owner is deactivated")
+	}
+
+	if err := s.accessTokens.TouchLastUsed(token.ID, now); err != nil {
+		log.Println("Error updating access token last use:", err)
+	}
+
+	return dto.AuthContext{
+		UserID:  user.ID,
+		IsAdmin: user.IsAdmin,
+		Scopes:  utils.ParseScopes(token.Scopes),
+	}, nil
+}
+
+func accessTokenToDto(token model.PersonalAccessToken) dto.AccessTokenDto {
+	return dto.AccessTokenDto{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     utils.ParseScopes(token.Scopes),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		RevokedAt:  token.RevokedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
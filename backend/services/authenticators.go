@@ -0,0 +1,287 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	ldapClient "backend/clients/ldap"
+	"backend/config"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// errAuthUserNotFound lets the chain move on to the next authenticator
+	errAuthUserNotFound    = errors.New("user not found")
+	errAuthInvalidPassword = errors.New("invalid password")
+	errAuthNotVerified     = errors.New("email not verified")
+	// errAuthNoEmail is a directory entry without a usable email address
+	errAuthNoEmail = errors.New("directory entry without a valid email")
+	// errAuthUnavailable means the credential store couldn't be asked
+	errAuthUnavailable = errors.New("directory unavailable")
+)
+
+// registrationRejected is a directory user without an account whom the
+// registration policy doesn't let in. It carries the policy's error, which
+// the login returns as is
+type registrationRejected struct {
+	apiErr utils.ApiError
+}
+
+func (e registrationRejected) Error() string {
+	return e.apiErr.Error()
+}
+
+// Authenticator checks a login and password against one credential store
+type Authenticator interface {
+	Name() string
+	Authenticate(login string, password string) (model.UserModel, error)
+}
+
+// authenticators is the chain used by Login and the OAuth authorize form,
+// tried in order until one accepts the credentials. ConfigureAuthenticators
+// sets it up
+var authenticators []Authenticator
+
+// SetAuthenticators replaces the authentication chain
+func SetAuthenticators(chain ...Authenticator) {
+	authenticators = chain
+}
+
+// ConfigureAuthenticators builds the chain of the auth section, e.g. local
+// then ldap. users is where the local passwords are and where directory users
+// are provisioned, if policy lets them register
+func ConfigureAuthenticators(cfg config.Auth, users UserRepository, policy *RegistrationPolicy) error {
+	var chain []Authenticator
+	for _, name := range cfg.Chain {
+		switch name {
+		case "local":
+			chain = append(chain, NewLocalAuthenticator(users))
+		case "ldap":
+			directory := ldapClient.NewDirectory(ldapClient.Config{
+				URL:                cfg.LDAP.URL,
+				StartTLS:           cfg.LDAP.StartTLS,
+				InsecureSkipVerify: cfg.LDAP.InsecureSkipVerify,
+				CAFile:             cfg.LDAP.CAFile,
+				BindDN:             cfg.LDAP.BindDN,
+				BindPassword:       cfg.LDAP.BindPassword,
+				BaseDN:             cfg.LDAP.BaseDN,
+				UserFilter:         cfg.LDAP.UserFilter,
+				EmailAttribute:     cfg.LDAP.EmailAttribute,
+				FirstNameAttribute: cfg.LDAP.FirstNameAttribute,
+				LastNameAttribute:  cfg.LDAP.LastNameAttribute,
+				Timeout:            time.Duration(cfg.LDAP.Timeout),
+			})
+			chain = append(chain, NewLDAPAuthenticator(directory, users, policy))
+		default:
+			return fmt.Errorf("unknown authenticator %q in the auth chain", name)
+		}
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("the auth chain has no authenticators")
+	}
+
+	SetAuthenticators(chain...)
+	return nil
+}
+
+// authenticateUser checks the credentials with each authenticator of the chain.
+// Unknown users and wrong passwords get the same invalid_credentials error
+func authenticateUser(username string, password string) (model.UserModel, utils.ApiError) {
+	var firstErr error
+	var rejected registrationRejected
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(username, password)
+		if err == nil {
+			// Deprovisioned accounts keep their data but can't log in
+			if !user.IsActive {
+				return model.UserModel{}, apiError(http.StatusForbidden, utils.CodeAccountLocked, "auth.account_deactivated", nil)
+			}
+			return user, nil
+		}
+		log.Printf("Authenticator %s rejected %s: %v", authenticator.Name(), username, err)
+
+		// Keep the most meaningful error: a wrong password beats an unknown user
+		if firstErr == nil || errors.Is(firstErr, errAuthUserNotFound) {
+			firstErr = err
+		}
+	}
+
+	switch {
+	case firstErr == nil, errors.Is(firstErr, errAuthUserNotFound), errors.Is(firstErr, errAuthInvalidPassword):
+		return model.UserModel{}, apiError(http.StatusUnauthorized, utils.CodeInvalidCredentials, "auth.invalid_credentials", nil)
+	case errors.Is(firstErr, errAuthNotVerified):
+		return model.UserModel{}, apiError(http.StatusForbidden, utils.CodeEmailNotVerified, "auth.email_not_verified", nil)
+	case errors.As(firstErr, &rejected):
+		return model.UserModel{}, rejected.apiErr
+	case errors.Is(firstErr, errAuthNoEmail):
+		return model.UserModel{}, apiError(http.StatusForbidden, utils.CodeForbidden, "auth.directory_no_email", nil)
+	case errors.Is(firstErr, errAuthUnavailable):
+		return model.UserModel{}, apiError(http.StatusServiceUnavailable, utils.CodeUnavailable, "auth.directory_unavailable", nil)
+	}
+	return model.UserModel{}, utils.NewInternalServerApiError("Error authenticating user", firstErr)
+}
+
+// LocalAuthenticator checks the password hash stored in the database
+type LocalAuthenticator struct {
+	users UserRepository
+}
+
+func NewLocalAuthenticator(users UserRepository) LocalAuthenticator {
+	return LocalAuthenticator{users: users}
+}
+
+func (LocalAuthenticator) Name() string {
+	return "local"
+}
+
+func (a LocalAuthenticator) Authenticate(username string, password string) (model.UserModel, error) {
+	userModel, err := a.users.GetByEmail(username)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.UserModel{}, errAuthUserNotFound
+		}
+		log.Println("Error al obtener el usuario por username")
+		return model.UserModel{}, fmt.Errorf("failed to get user by user: %w", err)
+	}
+
+	// Users provisioned from a directory have no local password
+	if userModel.PasswordHash == "" {
+		return model.UserModel{}, errAuthUserNotFound
+	}
+
+	if utils.HashSHA256(password) != userModel.PasswordHash {
+		log.Println("Error al obtener el usuario por password")
+		return model.UserModel{}, errAuthInvalidPassword
+	}
+
+	// Check if email is verified
+	if !userModel.IsVerified {
+		log.Println("User email not verified")
+		return model.UserModel{}, errAuthNotVerified
+	}
+
+	return userModel, nil
+}
+
+// Directory is the part of the LDAP client used by the authenticator, so an
+// in-process server or a fake can stand in for the real one
+type Directory interface {
+	Authenticate(login string, password string) (ldapClient.Entry, error)
+}
+
+// LDAPAuthenticator binds against an LDAP or Active Directory server and
+// provisions the local user on the first successful login
+type LDAPAuthenticator struct {
+	directory Directory
+	users     UserRepository
+	policy    *RegistrationPolicy
+}
+
+func NewLDAPAuthenticator(directory Directory, users UserRepository, policy *RegistrationPolicy) LDAPAuthenticator {
+	return LDAPAuthenticator{directory: directory, users: users, policy: policy}
+}
+
+func (LDAPAuthenticator) Name() string {
+	return "ldap"
+}
+
+func (a LDAPAuthenticator) Authenticate(login string, password string) (model.UserModel, error) {
+	entry, err := a.directory.Authenticate(login, password)
+	if err != nil {
+		if errors.Is(err, ldapClient.ErrUserNotFound) {
+			return model.UserModel{}, errAuthUserNotFound
+		}
+		if errors.Is(err, ldapClient.ErrInvalidCredentials) {
+			return model.UserModel{}, errAuthInvalidPassword
+		}
+		log.Println("Error authenticating against ldap:", err)
+		return model.UserModel{}, fmt.Errorf("%w: %v", errAuthUnavailable, err)
+	}
+
+	// The account is matched by the email of the entry, never by the login:
+	// a uid like "jperez" isn't an email and anyone could register one that is
+	email, err := utils.CanonicalEmail(entry.Email)
+	if err != nil {
+		log.Printf("Directory entry %s has no valid email %q", entry.DN, entry.Email)
+		return model.UserModel{}, errAuthNoEmail
+	}
+
+	return a.provisionDirectoryUser(email, entry)
+}
+
+// provisionDirectoryUser creates the user on the first login and keeps the
+// names in sync with the directory afterwards. Accounts created on the first
+// login follow the registration policy, like the federated ones: a closed or
+// invite-only service doesn't get new accounts from the directory either. An
+// unverified local account with the same email is adopted without its
+// password and pending codes, they were set by whoever registered the address
+func (a LDAPAuthenticator) provisionDirectoryUser(email string, entry ldapClient.Entry) (model.UserModel, error) {
+	user, err := a.users.GetByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Error checking existing user:", err)
+		return model.UserModel{}, fmt.Errorf("error checking user existence: %w", err)
+	}
+
+	if user.ID == 0 {
+		if apiErr := a.policy.Check(email); apiErr != nil {
+			log.Printf("Registration policy rejected ldap user %s: %s", email, apiErr.Code())
+			return model.UserModel{}, registrationRejected{apiErr: apiErr}
+		}
+
+		firstName := entry.FirstName
+		if firstName == "" {
+			firstName = strings.Split(email, "@")[0]
+		}
+		lastName := entry.LastName
+		if lastName == "" {
+			lastName = "-"
+		}
+
+		user, err = a.users.Create(model.UserModel{
+			Email:      email,
+			FirstName:  firstName,
+			LastName:   lastName,
+			IsAdmin:    false,
+			IsVerified: true, // the directory vouches for the account
+		})
+		if err != nil {
+			log.Println("Error creating ldap user:", err)
+			return model.UserModel{}, fmt.Errorf("error creating user: %w", err)
+		}
+		log.Println("Provisioned ldap user:", email)
+		return user, nil
+	}
+
+	if !user.IsVerified {
+		if err := a.users.VerifyExternally(user.ID); err != nil {
+			log.Println("Error verifying ldap user:", err)
+			return model.UserModel{}, fmt.Errorf("error verifying user: %w", err)
+		}
+		user.IsVerified = true
+		user.PasswordHash = ""
+	}
+
+	changed := false
+	if entry.FirstName != "" && entry.FirstName != user.FirstName {
+		user.FirstName = entry.FirstName
+		changed = true
+	}
+	if entry.LastName != "" && entry.LastName != user.LastName {
+		user.LastName = entry.LastName
+		changed = true
+	}
+	if changed {
+		if err := a.users.Update(user); err != nil {
+			log.Println("Error syncing ldap user:", err)
+			return model.UserModel{}, fmt.Errorf("error updating user: %w", err)
+		}
+	}
+	return user, nil
+}
@@ -0,0 +1,233 @@
+package services_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/clients/ldap/ldaptest"
+	memoryClient "backend/clients/memory"
+	"backend/config"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+)
+
+const (
+	directoryServiceDN = "cn=unichat,ou=services,dc=uni,dc=edu"
+	directoryAnaDN     = "uid=ana,ou=people,dc=uni,dc=edu"
+	directoryPassword  = "Directory-Harbor-77"
+)
+
+// directoryHarness is the login of a UserService with an auth chain that
+// searches and binds against an in-process LDAP server
+type directoryHarness struct {
+	server *ldaptest.Server
+	store  *memoryClient.Store
+	users  *services.UserService
+}
+
+func newDirectoryHarness(t *testing.T, chain ...string) *directoryHarness {
+	return newDirectoryHarnessWithPolicy(t, registrationPolicy(t, services.RegistrationOpen), chain...)
+}
+
+// newDirectoryHarnessWithPolicy provisions directory users under policy
+func newDirectoryHarnessWithPolicy(t *testing.T, policy *services.RegistrationPolicy, chain ...string) *directoryHarness {
+	server, err := ldaptest.NewServer(
+		ldaptest.Entry{DN: directoryServiceDN, Password: "service-secret"},
+		ldaptest.Entry{DN: directoryAnaDN, Password: directoryPassword, Attributes: map[string][]string{
+			"uid":       {"ana"},
+			"mail":      {"Ana.Paz@uni.edu"},
+			"givenName": {"Ana"},
+			"sn":        {"Paz"},
+		}},
+		ldaptest.Entry{DN: "uid=nomail,ou=people,dc=uni,dc=edu", Password: directoryPassword, Attributes: map[string][]string{
+			"uid":       {"nomail"},
+			"givenName": {"Sin"},
+			"sn":        {"Correo"},
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	utils.ConfigureJWT(config.JWT{Secret: "0123456789abcdef0123456789abcdef"})
+	store := memoryClient.NewStore()
+	err = services.ConfigureAuthenticators(config.Auth{
+		Chain: chain,
+		LDAP: config.LDAP{
+			URL:                server.URL,
+			BindDN:             directoryServiceDN,
+			BindPassword:       "service-secret",
+			BaseDN:             "ou=people,dc=uni,dc=edu",
+			UserFilter:         "(|(uid=%s)(mail=%s))",
+			EmailAttribute:     "mail",
+			FirstNameAttribute: "givenName",
+			LastNameAttribute:  "sn",
+			Timeout:            config.Duration(5 * time.Second),
+		},
+	}, store.Users(), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { services.SetAuthenticators() })
+
+	return &directoryHarness{
+		server: server,
+		store:  store,
+		users:  services.NewUserService(store.Users(), store.Tokens(), store.Sessions(), store.AccessTokens(), nil, policy),
+	}
+}
+
+// expectRejected checks the error of a login and that nobody was provisioned
+func (h *directoryHarness) expectRejected(t *testing.T, apiErr utils.ApiError, status int, code string) {
+	t.Helper()
+	if apiErr == nil {
+		t.Fatal("the login was accepted")
+	}
+	if apiErr.Status() != status || apiErr.Code() != code {
+		t.Errorf("got %d %s, expected %d %s", apiErr.Status(), apiErr.Code(), status, code)
+	}
+	if _, err := h.store.Users().GetByEmail("ana.paz@uni.edu"); err == nil {
+		t.Error("a rejected login provisioned the user")
+	}
+}
+
+func TestDirectoryLoginProvisionsTheUser(t *testing.T) {
+	h := newDirectoryHarness(t, "ldap")
+	response, apiErr := h.users.Login("ana", directoryPassword)
+	if apiErr != nil {
+		t.Fatalf("login: %v", apiErr)
+	}
+	if response.AccessToken == "" || response.Name != "Ana" || response.Surname != "Paz" {
+		t.Errorf("unexpected login response %+v", response)
+	}
+
+	// search with the service account, then bind as the entry found
+	binds := h.server.Binds()
+	if len(binds) != 2 || binds[0] != directoryServiceDN || binds[1] != directoryAnaDN {
+		t.Errorf("expected the binds of the service account and the user, got %v", binds)
+	}
+	if filters := h.server.Filters(); len(filters) != 1 || filters[0] != "(|(uid=ana)(mail=ana))" {
+		t.Errorf("unexpected search filters %v", filters)
+	}
+
+	user, err := h.store.Users().GetByEmail("ana.paz@uni.edu")
+	if err != nil {
+		t.Fatalf("the user wasn't provisioned: %v", err)
+	}
+	if !user.IsVerified || user.PasswordHash != "" {
+		t.Errorf("expected a verified user without a local password, got verified %t, password %t", user.IsVerified, user.PasswordHash != "")
+	}
+}
+
+func TestDirectoryLoginEscapesTheFilter(t *testing.T) {
+	tests := []struct {
+		login  string
+		filter string
+	}{
+		// unescaped, (uid=*) would match every entry
+		{"*", `(|(uid=\2a)(mail=\2a))`},
+		{"ana)(uid=*", `(|(uid=ana\29\28uid=\2a)(mail=ana\29\28uid=\2a))`},
+	}
+	for _, test := range tests {
+		t.Run(test.login, func(t *testing.T) {
+			h := newDirectoryHarness(t, "ldap")
+			_, apiErr := h.users.Login(test.login, directoryPassword)
+			h.expectRejected(t, apiErr, http.StatusUnauthorized, utils.CodeInvalidCredentials)
+
+			if filters := h.server.Filters(); len(filters) != 1 || filters[0] != test.filter {
+				t.Errorf("expected the filter %s, got %v", test.filter, filters)
+			}
+			if binds := h.server.Binds(); len(binds) != 1 {
+				t.Errorf("expected only the bind of the service account, got %v", binds)
+			}
+		})
+	}
+}
+
+func TestDirectoryLoginRejectsAnEmptyPassword(t *testing.T) {
+	h := newDirectoryHarness(t, "ldap")
+	// the server accepts it as an unauthenticated bind of the DN
+	_, apiErr := h.users.Login("ana", "")
+	h.expectRejected(t, apiErr, http.StatusUnauthorized, utils.CodeInvalidCredentials)
+
+	if binds := h.server.Binds(); len(binds) != 0 {
+		t.Errorf("the directory was asked with an empty password: %v", binds)
+	}
+}
+
+func TestDirectoryLoginRejectsAWrongPassword(t *testing.T) {
+	h := newDirectoryHarness(t, "ldap")
+	_, apiErr := h.users.Login("ana", "Wrong-Harbor-77")
+	h.expectRejected(t, apiErr, http.StatusUnauthorized, utils.CodeInvalidCredentials)
+
+	if binds := h.server.Binds(); len(binds) != 2 || binds[1] != directoryAnaDN {
+		t.Errorf("expected a bind as the user, got %v", binds)
+	}
+}
+
+func TestDirectoryLoginRejectsAnEntryWithoutEmail(t *testing.T) {
+	h := newDirectoryHarness(t, "ldap")
+	// the login is never used as the email of the account
+	if _, err := h.store.Users().Create(model.UserModel{Email: "nomail@uni.edu", FirstName: "Otra", LastName: "Persona"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, apiErr := h.users.Login("nomail", directoryPassword)
+	h.expectRejected(t, apiErr, http.StatusForbidden, utils.CodeForbidden)
+}
+
+func TestDirectoryLoginFallsBackToTheLocalAccount(t *testing.T) {
+	h := newDirectoryHarness(t, "ldap", "local")
+	_, err := h.store.Users().Create(model.UserModel{
+		Email:        "luis@uni.edu",
+		FirstName:    "Luis",
+		LastName:     "Gómez",
+		PasswordHash: utils.HashSHA256("Violet-Tundra-Kettle-42"),
+		IsVerified:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, apiErr := h.users.Login("luis@uni.edu", "Violet-Tundra-Kettle-42")
+	if apiErr != nil {
+		t.Fatalf("login: %v", apiErr)
+	}
+	if response.Name != "Luis" {
+		t.Errorf("logged in as %s, expected Luis", response.Name)
+	}
+	if filters := h.server.Filters(); len(filters) != 1 {
+		t.Errorf("the directory should be searched before the local account, got %v", filters)
+	}
+
+	// the local password is still checked
+	_, apiErr = h.users.Login("luis@uni.edu", "Wrong-Tundra-Kettle-42")
+	h.expectRejected(t, apiErr, http.StatusUnauthorized, utils.CodeInvalidCredentials)
+}
+
+func TestDirectoryLoginFollowsTheRegistrationPolicy(t *testing.T) {
+	h := newDirectoryHarnessWithPolicy(t, registrationPolicy(t, services.RegistrationClosed), "ldap")
+	_, apiErr := h.users.Login("ana", directoryPassword)
+	h.expectRejected(t, apiErr, http.StatusForbidden, "registration_closed")
+
+	// an account that already exists isn't a registration
+	if _, err := h.store.Users().Create(model.UserModel{Email: "ana.paz@uni.edu", FirstName: "Ana", LastName: "Paz", IsVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, apiErr := h.users.Login("ana", directoryPassword); apiErr != nil {
+		t.Errorf("the existing account couldn't log in: %v", apiErr)
+	}
+}
+
+func TestDirectoryLoginChecksTheDomainOfTheEntry(t *testing.T) {
+	policy, err := services.NewRegistrationPolicy(config.Registration{Mode: services.RegistrationDomain, AllowedDomains: []string{"alumnos.uni.edu"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newDirectoryHarnessWithPolicy(t, policy, "ldap")
+	_, apiErr := h.users.Login("ana", directoryPassword)
+	h.expectRejected(t, apiErr, http.StatusBadRequest, "email_domain_not_allowed")
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/mail"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/dto"
+	"backend/i18n"
+	"backend/mailer"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// How long a worker owns a claimed email. Longer than the SMTP timeout, so
+	// an email is only taken back from a worker that died
+	outboxLease = 2 * time.Minute
+	// Page size of the admin listing
+	outboxListLimit = 100
+)
+
+// EmailOutboxConfig tunes the workers that send the queued emails
+type EmailOutboxConfig struct {
+	Workers       int           // emails sent at the same time
+	MaxAttempts   int           // tries before an email moves to the failed (dead letter) state
+	RetryDelay    time.Duration // wait after the first failure, doubled on each retry
+	MaxRetryDelay time.Duration // upper bound of the wait between tries
+	PollInterval  time.Duration // how often the queue is checked for due retries
+}
+
+// EmailOutbox queues the emails of the services in the database and sends
+// them with a set of workers, retrying the failed ones
+type EmailOutbox struct {
+	emails OutboxRepository
+	config EmailOutboxConfig
+	wake   chan struct{}
+
+	mu   sync.Mutex
+	stop chan struct{} // nil while the workers are stopped
+	done sync.WaitGroup
+}
+
+// How often the queue is checked for due retries
+const outboxPollInterval = 5 * time.Second
+
+// NewEmailOutbox builds the outbox of the outbox section. The workers only
+// run between Start and Stop
+func NewEmailOutbox(emails OutboxRepository, cfg config.Outbox) *EmailOutbox {
+	return &EmailOutbox{
+		emails: emails,
+		config: EmailOutboxConfig{
+			Workers:       cfg.Workers,
+			MaxAttempts:   cfg.MaxAttempts,
+			RetryDelay:    time.Duration(cfg.RetryDelay),
+			MaxRetryDelay: time.Duration(cfg.MaxRetryDelay),
+			PollInterval:  outboxPollInterval,
+		},
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Start starts the workers and makes the emails of the services go through
+// the outbox instead of being sent during the request
+func (o *EmailOutbox) Start() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stop != nil {
+		return
+	}
+
+	o.stop = make(chan struct{})
+	for i := 0; i < o.config.Workers; i++ {
+		o.done.Add(1)
+		go o.work(o.stop)
+	}
+	utils.SetEmailQueue(o.enqueue)
+	log.Printf("Email outbox started with %d workers", o.config.Workers)
+}
+
+// Stop stops taking new emails from the queue and waits for the ones being
+// sent. Queued emails stay in the database for the next start; emails still
+// being sent when ctx ends are taken back once their lease expires
+func (o *EmailOutbox) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	stop := o.stop
+	o.stop = nil
+	o.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+
+	// anything enqueued from now on is sent right away
+	utils.SetEmailQueue(nil)
+	close(stop)
+
+	finished := make(chan struct{})
+	go func() {
+		o.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		log.Println("Email outbox stopped")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("email outbox: %w while emails were being sent", ctx.Err())
+	}
+}
+
+func (o *EmailOutbox) enqueue(kind string, message mailer.Message) error {
+	if len(message.To) == 0 {
+		return fmt.Errorf("email has no recipient")
+	}
+	_, err := o.emails.Create(model.OutboxEmail{
+		Kind:          kind,
+		Recipient:     message.To[0].Address,
+		Subject:       message.Subject,
+		TextBody:      message.Text,
+		HTMLBody:      message.HTML,
+		Status:        model.EmailStatusPending,
+		NextAttemptAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+// notify wakes up an idle worker, if any
+func (o *EmailOutbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *EmailOutbox) work(stop chan struct{}) {
+	defer o.done.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		emails, err := o.emails.Claim(time.Now(), outboxLease, 1)
+		if err != nil {
+			log.Println("Error claiming queued emails:", err)
+		}
+		if len(emails) == 0 {
+			select {
+			case <-stop:
+				return
+			case <-o.wake:
+			case <-time.After(o.config.PollInterval):
+			}
+			continue
+		}
+
+		for _, email := range emails {
+			o.deliver(email)
+		}
+		// there may be more due emails, let another worker look too
+		o.notify()
+	}
+}
+
+// deliver tries to send a claimed email and records the outcome
+func (o *EmailOutbox) deliver(email model.OutboxEmail) {
+	attempts := email.Attempts + 1
+	err := utils.DeliverEmail(mailer.Message{
+		To:      []mail.Address{{Address: email.Recipient}},
+		Subject: email.Subject,
+		Text:    email.TextBody,
+		HTML:    email.HTMLBody,
+	})
+	if err == nil {
+		if err := o.emails.MarkSent(email.ID, attempts, time.Now()); err != nil {
+			log.Println("Error marking email as sent:", err)
+		}
+		return
+	}
+
+	if attempts >= o.config.MaxAttempts {
+		log.Printf("Email %d (%s) to %s failed %d times, giving up: %v", email.ID, email.Kind, email.Recipient, attempts, err)
+		if err := o.emails.Fail(email.ID, attempts, err.Error(), utils.EmailCarriesSecret(email.Kind)); err != nil {
+			log.Println("Error marking email as failed:", err)
+		}
+		return
+	}
+
+	next := time.Now().Add(o.retryDelay(attempts))
+	log.Printf("Email %d (%s) to %s failed, retrying at %s: %v", email.ID, email.Kind, email.Recipient, next.Format(time.RFC3339), err)
+	if err := o.emails.Retry(email.ID, attempts, next, err.Error()); err != nil {
+		log.Println("Error scheduling email retry:", err)
+	}
+}
+
+// retryDelay doubles the wait after each failed attempt, up to the maximum,
+// with some jitter so emails that failed together aren't retried together
+func (o *EmailOutbox) retryDelay(attempts int) time.Duration {
+	delay := o.config.RetryDelay
+	for i := 1; i < attempts && delay < o.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.config.MaxRetryDelay {
+		delay = o.config.MaxRetryDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay - delay/10 + jitter
+}
+
+// GetOutboxEmails lists the emails with a status, failed ones by default
+func (o *EmailOutbox) GetOutboxEmails(status string) ([]dto.OutboxEmailDto, utils.ApiError) {
+	if status == "" {
+		status = model.EmailStatusFailed
+	}
+	switch status {
+	case model.EmailStatusPending, model.EmailStatusSending, model.EmailStatusSent, model.EmailStatusFailed:
+	default:
+		return nil, apiError(http.StatusBadRequest, utils.CodeBadRequest, "email.unknown_status", i18n.Params{"status": status})
+	}
+
+	emails, err := o.emails.List(status, outboxListLimit)
+	if err != nil {
+		log.Println("Error getting queued emails:", err)
+		return nil, utils.NewInternalServerApiError("Error getting emails", err)
+	}
+
+	result := make([]dto.OutboxEmailDto, 0, len(emails))
+	for _, email := range emails {
+		result = append(result, outboxEmailToDto(email))
+	}
+	return result, nil
+}
+
+// ResendOutboxEmail queues a failed email again, with its attempts reset.
+// Emails with a code or a link aren't re-sent: what they carry
+// may have expired and their body was dropped, the user asks for a new one
+func (o *EmailOutbox) ResendOutboxEmail(id int) (dto.OutboxEmailDto, utils.ApiError) {
+	email, err := o.emails.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return dto.OutboxEmailDto{}, apiError(http.StatusNotFound, utils.CodeNotFound, "email.not_found", nil)
+	}
+	if err != nil {
+		log.Println("Error getting email:", err)
+		return dto.OutboxEmailDto{}, utils.NewInternalServerApiError("Error resending email", err)
+	}
+	if utils.EmailCarriesSecret(email.Kind) {
+		return dto.OutboxEmailDto{}, apiError(http.StatusConflict, utils.CodeConflict, "email.not_resendable", i18n.Params{"kind": email.Kind})
+	}
+
+	email, err = o.emails.Requeue(id, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return dto.OutboxEmailDto{}, apiError(http.StatusNotFound, utils.CodeNotFound, "email.not_found", nil)
+	}
+	if err != nil {
+		log.Println("Error requeueing email:", err)
+		return dto.OutboxEmailDto{}, utils.NewInternalServerApiError("Error resending email", err)
+	}
+
+	o.notify()
+	return outboxEmailToDto(email), nil
+}
+
+func outboxEmailToDto(email model.OutboxEmail) dto.OutboxEmailDto {
+	return dto.OutboxEmailDto{
+		ID:            email.ID,
+		Kind:          email.Kind,
+		Recipient:     email.Recipient,
+		Subject:       email.Subject,
+		Status:        email.Status,
+		Attempts:      email.Attempts,
+		NextAttemptAt: email.NextAttemptAt,
+		LastError:     email.LastError,
+		SentAt:        email.SentAt,
+		CreatedAt:     email.CreatedAt,
+	}
+}
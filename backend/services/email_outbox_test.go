@@ -0,0 +1,94 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"testing"
+	"time"
+
+	memoryClient "backend/clients/memory"
+	"backend/config"
+	"backend/mailer"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+)
+
+// downMailer fails every delivery, like an SMTP server that is down
+type downMailer struct{}
+
+func (downMailer) Send(message mailer.Message) error {
+	return errors.New("smtp down")
+}
+
+// failEmails queues the emails sent by send through an outbox whose mailer is
+// down and returns them once they failed
+func failEmails(t *testing.T, store *memoryClient.Store, count int, send func() error) []model.OutboxEmail {
+	t.Helper()
+	utils.SetMailer(downMailer{}, mail.Address{Address: "no-reply@uni.edu"}, mailer.DefaultLocale)
+	t.Cleanup(func() {
+		utils.SetMailer(mailer.LogMailer{}, mail.Address{Address: "no-reply@uni.edu"}, mailer.DefaultLocale)
+	})
+
+	outbox := services.NewEmailOutbox(store.Outbox(), config.Outbox{
+		Workers:       1,
+		MaxAttempts:   1,
+		RetryDelay:    config.Duration(time.Millisecond),
+		MaxRetryDelay: config.Duration(time.Millisecond),
+	})
+	outbox.Start()
+	defer outbox.Stop(context.Background())
+
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		failed, err := store.Outbox().List(model.EmailStatusFailed, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failed) == count {
+			return failed
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("the emails didn't fail in time")
+	return nil
+}
+
+func TestFailedEmailsWithCodesAreNotKept(t *testing.T) {
+	store := memoryClient.NewStore()
+	failed := failEmails(t, store, 2, func() error {
+		if err := utils.SendVerificationEmail("ana@uni.edu", "123456", "Ana", ""); err != nil {
+			return err
+		}
+		return utils.SendWelcomeEmail("ana@uni.edu", "Ana", "")
+	})
+
+	outbox := services.NewEmailOutbox(store.Outbox(), config.Outbox{})
+	for _, email := range failed {
+		switch email.Kind {
+		case "verification":
+			if email.TextBody != "" || email.HTMLBody != "" {
+				t.Errorf("the failed verification email kept its body: %q", email.TextBody)
+			}
+			_, apiErr := outbox.ResendOutboxEmail(email.ID)
+			if apiErr == nil || apiErr.Status() != http.StatusConflict {
+				t.Errorf("expected the verification email not to be re-sent, got %v", apiErr)
+			}
+		case "welcome":
+			if email.TextBody == "" {
+				t.Errorf("the failed welcome email lost its body")
+			}
+			resent, apiErr := outbox.ResendOutboxEmail(email.ID)
+			if apiErr != nil || resent.Status != model.EmailStatusPending {
+				t.Errorf("expected the welcome email to be queued again, got %+v, %v", resent, apiErr)
+			}
+		default:
+			t.Errorf("unexpected %s email", email.Kind)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"net/http"
+
+	"backend/i18n"
+	"backend/utils"
+)
+
+// apiError returns a typed error with a stable code and the message key the
+// controllers translate
+func apiError(status int, code string, key string, params i18n.Params) utils.ApiError {
+	return utils.NewLocalizedApiError(key, params, code, status, utils.CauseList{})
+}
+
+func errUserNotFound() utils.ApiError {
+	return apiError(http.StatusNotFound, utils.CodeNotFound, "user.not_found", nil)
+}
+
+func errEmailTaken(email string) utils.ApiError {
+	return apiError(http.StatusConflict, utils.CodeEmailTaken, "user.email_taken", i18n.Params{"email": email})
+}
+
+func errInvalidToken() utils.ApiError {
+	return apiError(http.StatusUnauthorized, utils.CodeInvalidToken, "auth.invalid_token", nil)
+}
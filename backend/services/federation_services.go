@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	idpClient "backend/clients/idp"
+	"backend/dto"
+	"backend/i18n"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Time allowed for the calls to an external identity provider
+const federationTimeout = 15 * time.Second
+
+// FederationService logs users in through the external identity providers,
+// linking the provider subjects to local accounts
+type FederationService struct {
+	users      UserRepository
+	identities FederatedIdentityRepository
+	sessions   SessionRepository
+	policy     *RegistrationPolicy
+}
+
+func NewFederationService(users UserRepository, identities FederatedIdentityRepository, sessions SessionRepository, policy *RegistrationPolicy) *FederationService {
+	return &FederationService{users: users, identities: identities, sessions: sessions, policy: policy}
+}
+
+// GetIdentityProviders lists the external identity providers users can log in with
+func (s *FederationService) GetIdentityProviders() []string {
+	return idpClient.GetProviderNames()
+}
+
+// StartFederatedLogin builds the URL that sends the user to an external
+// provider. The returned state must come back unchanged on the callback; it is
+// signed and carries the nonce and PKCE verifier of this login attempt
+func (s *FederationService) StartFederatedLogin(providerName string) (string, string, utils.ApiError) {
+	provider, ok := idpClient.GetProvider(providerName)
+	if !ok {
+		return "", "", errUnknownProvider(providerName)
+	}
+
+	state, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return "", "", utils.NewInternalServerApiError("Error generating state", err)
+	}
+	nonce, err := utils.GenerateSecureToken(16)
+	if err != nil {
+		return "", "", utils.NewInternalServerApiError("Error generating nonce", err)
+	}
+	verifier, err := utils.GenerateCodeVerifier()
+	if err != nil {
+		return "", "", utils.NewInternalServerApiError("Error generating code verifier", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), federationTimeout)
+	defer cancel()
+
+	redirectURL, err := provider.AuthCodeURL(ctx, state, nonce, utils.CodeChallengeS256(verifier))
+	if err != nil {
+		log.Println("Error building identity provider URL:", err)
+		return "", "", apiError(http.StatusServiceUnavailable, utils.CodeUnavailable, "federation.unavailable", i18n.Params{"provider": providerName})
+	}
+
+	savedState, err := utils.GenerateFederationState(utils.FederationStateClaims{
+		Provider:     providerName,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		return "", "", utils.NewInternalServerApiError("Error saving login state", err)
+	}
+
+	return redirectURL, savedState, nil
+}
+
+// CompleteFederatedLogin handles the callback of an external provider. The
+// user is found through the linked identity or by verified email, and created
+// if needed. It returns the same session as Login
+func (s *FederationService) CompleteFederatedLogin(providerName string, code string, state string, savedState string) (dto.LoginResponse, utils.ApiError) {
+	provider, ok := idpClient.GetProvider(providerName)
+	if !ok {
+		return dto.LoginResponse{}, errUnknownProvider(providerName)
+	}
+
+	saved, err := utils.ParseFederationState(savedState)
+	if err != nil || saved.Provider != providerName || saved.State != state || state == "" {
+		return dto.LoginResponse{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "federation.invalid_state", nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), federationTimeout)
+	defer cancel()
+
+	identity, err := provider.Exchange(ctx, code, saved.CodeVerifier, saved.Nonce)
+	if err != nil {
+		log.Println("Error exchanging identity provider code:", err)
+		return dto.LoginResponse{}, apiError(http.StatusUnauthorized, utils.CodeInvalidCredentials, "federation.login_failed", i18n.Params{"provider": providerName})
+	}
+
+	user, apiErr := s.resolveFederatedUser(providerName, identity)
+	if apiErr != nil {
+		return dto.LoginResponse{}, apiErr
+	}
+	if !user.IsActive {
+		return dto.LoginResponse{}, apiError(http.StatusForbidden, utils.CodeAccountLocked, "auth.account_deactivated", nil)
+	}
+
+	return startSession(s.sessions, user)
+}
+
+// resolveFederatedUser returns the local user of an external identity,
+// linking or creating it the first time
+func (s *FederationService) resolveFederatedUser(providerName string, identity idpClient.Identity) (model.UserModel, utils.ApiError) {
+	linked, err := s.identities.Get(providerName, identity.Subject)
+	if err == nil {
+		if err := s.identities.UpdateLogin(linked.ID, time.Now()); err != nil {
+			log.Println("Error updating federated identity:", err)
+		}
+		user, err := s.users.GetByID(linked.UserID)
+		if err != nil {
+			log.Println("Error getting linked user:", err)
+			return model.UserModel{}, utils.NewInternalServerApiError("Error getting linked user", err)
+		}
+		return user, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		log.Println("Error getting federated identity:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("Error getting linked identity", err)
+	}
+
+	// Accounts are only matched or created from emails the provider verified
+	if identity.Email == "" || !identity.EmailVerified {
+		return model.UserModel{}, apiError(http.StatusForbidden, utils.CodeEmailNotVerified, "federation.unverified_email", i18n.Params{"provider": providerName})
+	}
+
+	user, err := s.users.GetByEmail(identity.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Println("Error getting user by email:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("Error checking user existence", err)
+	}
+
+	if user.ID == 0 {
+		var apiErr utils.ApiError
+		user, apiErr = s.createFederatedUser(identity)
+		if apiErr != nil {
+			return model.UserModel{}, apiErr
+		}
+	} else if !user.IsVerified {
+		// The provider proved the mailbox belongs to the user, not to whoever
+		// registered the address: their password and codes stop working
+		if err := s.users.VerifyExternally(user.ID); err != nil {
+			log.Println("Error verifying user email:", err)
+			return model.UserModel{}, utils.NewInternalServerApiError("Error verifying email", err)
+		}
+		user.IsVerified = true
+		user.PasswordHash = ""
+	}
+
+	now := time.Now()
+	_, err = s.identities.Create(model.FederatedIdentity{
+		UserID:      user.ID,
+		Provider:    providerName,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	})
+	if err != nil {
+		log.Println("Error linking federated identity:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("Error linking identity", err)
+	}
+
+	return user, nil
+}
+
+// createFederatedUser provisions a verified user without a local password.
+// Accounts created on the first login follow the registration policy, as if
+// the user had registered
+func (s *FederationService) createFederatedUser(identity idpClient.Identity) (model.UserModel, utils.ApiError) {
+	if apiErr := s.policy.Check(identity.Email); apiErr != nil {
+		return model.UserModel{}, apiErr
+	}
+
+	firstName := identity.GivenName
+	if firstName == "" {
+		firstName = strings.Split(identity.Email, "@")[0]
+	}
+	lastName := identity.FamilyName
+	if lastName == "" {
+		lastName = "-"
+	}
+
+	user, err := s.users.Create(model.UserModel{
+		Email:      identity.Email,
+		FirstName:  firstName,
+		LastName:   lastName,
+		IsAdmin:    false,
+		IsVerified: true,
+	})
+	if err != nil {
+		log.Println("Error creating federated user:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("Error creating user", err)
+	}
+	return user, nil
+}
+
+func errUnknownProvider(providerName string) utils.ApiError {
+	return apiError(http.StatusNotFound, utils.CodeNotFound, "federation.unknown_provider", i18n.Params{"provider": providerName})
+}
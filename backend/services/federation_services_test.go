@@ -0,0 +1,361 @@
+package services_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	idpClient "backend/clients/idp"
+	memoryClient "backend/clients/memory"
+	"backend/config"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	stubProvider = "stub"
+	stubClientID = "unichat"
+)
+
+// stubIssuer is an OpenID Connect provider with the discovery document, the
+// key set and the token endpoint. The token endpoint signs the claims of the
+// claims field with the current key, for the nonce of the login
+type stubIssuer struct {
+	server *httptest.Server
+
+	mu           sync.Mutex
+	key          *rsa.PrivateKey
+	kid          string
+	keys         int
+	nonce        string
+	claims       func(nonce string) jwt.MapClaims
+	jwksRequests int
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	stub := &stubIssuer{}
+	stub.rotateKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 stub.server.URL,
+			"authorization_endpoint": stub.server.URL + "/authorize",
+			"token_endpoint":         stub.server.URL + "/token",
+			"jwks_uri":               stub.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.jwksRequests++
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": stub.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(stub.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(stub.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		if r.PostFormValue("code") != "code" || r.PostFormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, stub.claims(stub.nonce))
+		token.Header["kid"] = stub.kid
+		signed, err := token.SignedString(stub.key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]string{"access_token": "access", "id_token": signed})
+	})
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+
+	stub.claims = stub.identity("subject-1", "ana@uni.edu", true)
+	return stub
+}
+
+// rotateKey signs the next ID tokens with a new key, under a new key ID
+func (s *stubIssuer) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys++
+	s.key, s.kid = key, fmt.Sprintf("key-%d", s.keys)
+}
+
+// identity is a valid ID token of a user of the provider
+func (s *stubIssuer) identity(subject string, email string, verified bool) func(nonce string) jwt.MapClaims {
+	return func(nonce string) jwt.MapClaims {
+		now := time.Now()
+		return jwt.MapClaims{
+			"iss":            s.server.URL,
+			"aud":            stubClientID,
+			"sub":            subject,
+			"nonce":          nonce,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"email":          email,
+			"email_verified": verified,
+			"given_name":     "Ana",
+			"family_name":    "Paz",
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+// federationHarness is a FederationService on the memory store, logging in
+// through a stub issuer registered as the stub provider
+type federationHarness struct {
+	stub       *stubIssuer
+	store      *memoryClient.Store
+	federation *services.FederationService
+}
+
+func newFederationHarness(t *testing.T) *federationHarness {
+	utils.ConfigureJWT(config.JWT{Secret: "0123456789abcdef0123456789abcdef"})
+	stub := newStubIssuer(t)
+	idpClient.Register(idpClient.NewOIDCProvider(idpClient.OIDCConfig{
+		Name:        stubProvider,
+		Issuer:      stub.server.URL,
+		ClientID:    stubClientID,
+		RedirectURL: "http://localhost:8080/auth/stub/callback",
+		HTTPClient:  stub.server.Client(),
+	}))
+
+	store := memoryClient.NewStore()
+	return &federationHarness{
+		stub:       stub,
+		store:      store,
+		federation: services.NewFederationService(store.Users(), store.Identities(), store.Sessions(), registrationPolicy(t, services.RegistrationOpen)),
+	}
+}
+
+// registrationPolicy builds a policy with mode and the bundled blocklist
+func registrationPolicy(t *testing.T, mode string) *services.RegistrationPolicy {
+	t.Helper()
+	policy, err := services.NewRegistrationPolicy(config.Registration{Mode: mode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+// login runs the whole flow: the redirect to the provider, which sends the
+// user back with the code, and the callback
+func (h *federationHarness) login(t *testing.T) utils.ApiError {
+	redirectURL, savedState, apiErr := h.federation.StartFederatedLogin(stubProvider)
+	if apiErr != nil {
+		t.Fatalf("StartFederatedLogin: %v", apiErr)
+	}
+	redirect, err := url.Parse(redirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := redirect.Query()
+	if query.Get("client_id") != stubClientID || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL %s", redirectURL)
+	}
+
+	h.stub.mu.Lock()
+	h.stub.nonce = query.Get("nonce")
+	h.stub.mu.Unlock()
+	_, apiErr = h.federation.CompleteFederatedLogin(stubProvider, "code", query.Get("state"), savedState)
+	return apiErr
+}
+
+func TestFederatedLoginRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+	}{
+		{"another nonce", func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }},
+		{"another issuer", func(claims jwt.MapClaims) { claims["iss"] = "https://idp.example.com" }},
+		{"another audience", func(claims jwt.MapClaims) { claims["aud"] = "another-client" }},
+		// past the minute of leeway
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-10 * time.Minute).Unix() }},
+		{"without expiration", func(claims jwt.MapClaims) { delete(claims, "exp") }},
+		{"without subject", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newFederationHarness(t)
+			valid := h.stub.claims
+			h.stub.claims = func(nonce string) jwt.MapClaims {
+				claims := valid(nonce)
+				test.change(claims)
+				return claims
+			}
+
+			apiErr := h.login(t)
+			if apiErr == nil {
+				t.Fatal("the login was accepted")
+			}
+			if apiErr.Status() != http.StatusUnauthorized || apiErr.Code() != utils.CodeInvalidCredentials {
+				t.Errorf("got %d %s, expected %d %s", apiErr.Status(), apiErr.Code(), http.StatusUnauthorized, utils.CodeInvalidCredentials)
+			}
+			if _, err := h.store.Users().GetByEmail("ana@uni.edu"); err == nil {
+				t.Error("a rejected login created the user")
+			}
+		})
+	}
+}
+
+func TestFederatedLoginRefreshesTheKeysOnAnUnknownKeyID(t *testing.T) {
+	h := newFederationHarness(t)
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("first login: %v", apiErr)
+	}
+
+	// the keys are cached between logins
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("second login: %v", apiErr)
+	}
+	if h.stub.jwksRequests != 1 {
+		t.Fatalf("the key set was fetched %d times for the same key, expected once", h.stub.jwksRequests)
+	}
+
+	h.stub.rotateKey(t)
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("login after the key rotation: %v", apiErr)
+	}
+	if h.stub.jwksRequests != 2 {
+		t.Errorf("the key set was fetched %d times, expected a refresh for the new key ID", h.stub.jwksRequests)
+	}
+}
+
+func TestFederatedLoginCreatesTheUser(t *testing.T) {
+	h := newFederationHarness(t)
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("login: %v", apiErr)
+	}
+
+	user, err := h.store.Users().GetByEmail("ana@uni.edu")
+	if err != nil {
+		t.Fatalf("the user wasn't created: %v", err)
+	}
+	if !user.IsVerified || user.PasswordHash != "" || user.FirstName != "Ana" || user.LastName != "Paz" {
+		t.Errorf("expected a verified user without a password named Ana Paz, got verified %t, password %t, %s %s",
+			user.IsVerified, user.PasswordHash != "", user.FirstName, user.LastName)
+	}
+	identity, err := h.store.Identities().Get(stubProvider, "subject-1")
+	if err != nil || identity.UserID != user.ID {
+		t.Fatalf("the subject isn't linked to the new user: %+v %v", identity, err)
+	}
+
+	// the next login goes through the link, even with another email
+	h.stub.claims = h.stub.identity("subject-1", "ana.paz@uni.edu", true)
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("second login: %v", apiErr)
+	}
+	if _, err := h.store.Users().GetByEmail("ana.paz@uni.edu"); err == nil {
+		t.Error("the second login created another user instead of using the link")
+	}
+}
+
+func TestFederatedLoginFollowsTheRegistrationPolicy(t *testing.T) {
+	h := newFederationHarness(t)
+	h.federation = services.NewFederationService(h.store.Users(), h.store.Identities(), h.store.Sessions(), registrationPolicy(t, services.RegistrationClosed))
+
+	apiErr := h.login(t)
+	if apiErr == nil || apiErr.Status() != http.StatusForbidden {
+		t.Fatalf("expected the closed registration to reject the new user, got %v", apiErr)
+	}
+	if _, err := h.store.Users().GetByEmail("ana@uni.edu"); err == nil {
+		t.Error("the user was created with the registration closed")
+	}
+}
+
+func TestFederatedLoginRequiresAVerifiedEmail(t *testing.T) {
+	h := newFederationHarness(t)
+	h.stub.claims = h.stub.identity("subject-1", "ana@uni.edu", false)
+
+	apiErr := h.login(t)
+	if apiErr == nil || apiErr.Code() != utils.CodeEmailNotVerified {
+		t.Fatalf("expected %s, got %v", utils.CodeEmailNotVerified, apiErr)
+	}
+	if _, err := h.store.Users().GetByEmail("ana@uni.edu"); err == nil {
+		t.Error("the user was created from an unverified email")
+	}
+}
+
+func TestFederatedLoginLinksTheExistingUser(t *testing.T) {
+	h := newFederationHarness(t)
+	existing, err := h.store.Users().Create(model.UserModel{
+		Email:        "Ana@uni.edu",
+		FirstName:    "Ana María",
+		LastName:     "Paz",
+		PasswordHash: utils.HashSHA256("Violet-Tundra-Kettle-42"),
+		IsVerified:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("login: %v", apiErr)
+	}
+	identity, err := h.store.Identities().Get(stubProvider, "subject-1")
+	if err != nil || identity.UserID != existing.ID {
+		t.Fatalf("the subject isn't linked to the existing user %d: %+v %v", existing.ID, identity, err)
+	}
+	user, err := h.store.Users().GetByID(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != existing.PasswordHash || user.FirstName != "Ana María" {
+		t.Error("linking a verified account changed its password or name")
+	}
+}
+
+func TestFederatedLoginTakesOverAnUnverifiedUser(t *testing.T) {
+	h := newFederationHarness(t)
+	// someone registered the address without proving it was theirs
+	existing, err := h.store.Users().Create(model.UserModel{
+		Email:        "ana@uni.edu",
+		FirstName:    "Ana",
+		LastName:     "Paz",
+		PasswordHash: utils.HashSHA256("Violet-Tundra-Kettle-42"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if apiErr := h.login(t); apiErr != nil {
+		t.Fatalf("login: %v", apiErr)
+	}
+	user, err := h.store.Users().GetByID(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsVerified || user.PasswordHash != "" {
+		t.Errorf("expected the account verified and without the password, got verified %t, password %t", user.IsVerified, user.PasswordHash != "")
+	}
+	if identity, err := h.store.Identities().Get(stubProvider, "subject-1"); err != nil || identity.UserID != existing.ID {
+		t.Fatalf("the subject isn't linked to the existing user %d: %+v %v", existing.ID, identity, err)
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"backend/dto"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// States reported by the health endpoints
+const (
+	HealthReady    = "ready"
+	HealthNotReady = "not_ready"
+	HealthDraining = "draining"
+	HealthUp       = "up"
+	HealthDown     = "down"
+)
+
+// HealthCheck is a component the service needs to answer requests, like the
+// database. Check should return when ctx ends
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthService checks the components of the readiness endpoint
+type HealthService struct {
+	timeout time.Duration
+	checks  []HealthCheck
+}
+
+// NewHealthService checks the components in checks, giving each one timeout
+// to answer
+func NewHealthService(timeout time.Duration, checks ...HealthCheck) *HealthService {
+	return &HealthService{timeout: timeout, checks: checks}
+}
+
+// Readiness runs every check at the same time and reports each one. The
+// service is ready when all of them are up
+func (s *HealthService) Readiness(ctx context.Context) dto.HealthResponse {
+	response := dto.HealthResponse{Status: HealthReady, Checks: map[string]dto.HealthCheckDto{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range s.checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			result := s.run(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Checks[check.Name] = result
+			if result.Status != HealthUp {
+				response.Status = HealthNotReady
+			}
+		}(check)
+	}
+	wg.Wait()
+	return response
+}
+
+// run runs check with the timeout. A check that ignores its context is
+// reported down when the timeout ends, without waiting for it
+func (s *HealthService) run(ctx context.Context, check HealthCheck) dto.HealthCheckDto {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("no answer in %s", s.timeout)
+		}
+	}
+	result := dto.HealthCheckDto{Status: HealthUp, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		log.Printf("Health check %s failed: %v", check.Name, err)
+		result.Status = HealthDown
+		result.Error = err.Error()
+	}
+	return result
+}
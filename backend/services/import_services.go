@@ -0,0 +1,319 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"backend/dto"
+	"backend/i18n"
+	"backend/model"
+	"backend/utils"
+)
+
+const (
+	// Rows written per transaction
+	importBatchSize = 100
+	// Limits of a single import
+	importMaxRows  = 10000
+	importMaxBytes = 5 << 20
+)
+
+// Accepted header names, in English and Spanish, for each column
+var importColumnNames = map[string]string{
+	"email":      "email",
+	"mail":       "email",
+	"correo":     "email",
+	"first_name": "first_name",
+	"firstname":  "first_name",
+	"nombre":     "first_name",
+	"last_name":  "last_name",
+	"lastname":   "last_name",
+	"apellido":   "last_name",
+	"role":       "role",
+	"rol":        "role",
+}
+
+// Column order used when the file has no header
+var importDefaultColumns = map[string]int{"email": 0, "first_name": 1, "last_name": 2, "role": 3}
+
+// importRow is a valid row waiting to be written
+type importRow struct {
+	index int // position of the result in the response rows
+	user  model.UserModel
+}
+
+// ImportUsers creates the users listed in a CSV file with the columns email,
+// first name, last name and role. Rows are written in batches, each batch in
+// its own transaction; in a dry run nothing is written but every row is
+// still validated and checked against the existing users
+func (s *UserService) ImportUsers(reader io.Reader, options dto.ImportUsersOptions) (dto.ImportUsersResponse, utils.ApiError) {
+	if options.Locale != "" {
+		locale, apiErr := checkLocale(options.Locale)
+		if apiErr != nil {
+			return dto.ImportUsersResponse{}, apiErr
+		}
+		options.Locale = locale
+	}
+
+	data, err := io.ReadAll(io.LimitReader(reader, importMaxBytes+1))
+	if err != nil {
+		log.Println("Error reading import file:", err)
+		return dto.ImportUsersResponse{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "import.read_failed", nil)
+	}
+	if len(data) > importMaxBytes {
+		return dto.ImportUsersResponse{}, apiError(http.StatusRequestEntityTooLarge, utils.CodeBadRequest, "import.too_large", i18n.Params{"mb": importMaxBytes >> 20})
+	}
+	// spreadsheets often save UTF-8 files with a BOM
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+
+	csvReader := csv.NewReader(bytes.NewReader(data))
+	csvReader.Comma = importDelimiter(data)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+
+	response := dto.ImportUsersResponse{DryRun: options.DryRun, Rows: []dto.ImportRowResult{}}
+	columns := importDefaultColumns
+	var pending []importRow
+	seen := map[string]bool{}
+	firstRecord := true
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				log.Println("Error reading import file:", err)
+				return dto.ImportUsersResponse{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "import.read_failed", nil)
+			}
+			response.Rows = append(response.Rows, dto.ImportRowResult{
+				Line:   parseErr.StartLine,
+				Status: dto.ImportStatusInvalid,
+				Reason: parseErr.Err.Error(),
+			})
+			continue
+		}
+		line, _ := csvReader.FieldPos(0)
+
+		if firstRecord {
+			firstRecord = false
+			if header, ok := importHeader(record); ok {
+				columns = header
+				continue
+			}
+		}
+		if isBlankRecord(record) {
+			continue
+		}
+		if len(response.Rows) >= importMaxRows {
+			return dto.ImportUsersResponse{}, apiError(http.StatusRequestEntityTooLarge, utils.CodeBadRequest, "import.too_many_rows", i18n.Params{"rows": importMaxRows})
+		}
+
+		user, reason := importUserFromRecord(record, columns)
+		result := dto.ImportRowResult{Line: line, Email: user.Email, Role: user.Role, Status: dto.ImportStatusInvalid, Reason: reason}
+		normalized, _ := utils.NormalizeEmail(user.Email)
+		if reason == "" && seen[normalized] {
+			result.Status = dto.ImportStatusSkipped
+			result.Reason = "email repeated in the file"
+		}
+		response.Rows = append(response.Rows, result)
+		if result.Reason == "" {
+			seen[normalized] = true
+			pending = append(pending, importRow{index: len(response.Rows) - 1, user: user})
+		}
+	}
+
+	for start := 0; start < len(pending); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		s.importBatch(pending[start:end], response.Rows, options)
+	}
+
+	for _, row := range response.Rows {
+		switch row.Status {
+		case dto.ImportStatusCreated:
+			response.Created++
+		case dto.ImportStatusSkipped:
+			response.Skipped++
+		case dto.ImportStatusInvalid:
+			response.Invalid++
+		case dto.ImportStatusFailed:
+			response.Failed++
+		}
+	}
+	response.Total = len(response.Rows)
+
+	return response, nil
+}
+
+// importBatch skips the users that already exist and creates the rest in a
+// single transaction, sending the invitations once it is committed. The
+// accounts have no password: the invitation link lets each user choose one
+// and verifies the email, without invitations they are meant for LDAP or
+// federated login, and the first of those logins verifies them
+func (s *UserService) importBatch(rows []importRow, results []dto.ImportRowResult, options dto.ImportUsersOptions) {
+	emails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		emails = append(emails, row.user.Email)
+	}
+	existing, err := s.users.GetByEmails(emails)
+	if err != nil {
+		log.Println("Error checking existing users:", err)
+		markImportRows(rows, results, dto.ImportStatusFailed, "could not check existing users")
+		return
+	}
+	exists := map[string]bool{}
+	for _, user := range existing {
+		normalized, _ := utils.NormalizeEmail(user.Email)
+		exists[normalized] = true
+	}
+
+	var toCreate []importRow
+	for _, row := range rows {
+		normalized, _ := utils.NormalizeEmail(row.user.Email)
+		if exists[normalized] {
+			results[row.index].Status = dto.ImportStatusSkipped
+			results[row.index].Reason = "user already exists"
+			continue
+		}
+		toCreate = append(toCreate, row)
+	}
+	if len(toCreate) == 0 {
+		return
+	}
+	if options.DryRun {
+		markImportRows(toCreate, results, dto.ImportStatusCreated, "")
+		return
+	}
+
+	users := make([]model.UserModel, 0, len(toCreate))
+	for _, row := range toCreate {
+		row.user.IsVerified = options.PreVerified
+		users = append(users, row.user)
+	}
+	err = s.users.CreateBatch(users, func(index int, userID int) *model.VerificationToken {
+		// the invitations need the IDs of the accounts
+		toCreate[index].user.ID = userID
+		return nil
+	})
+	if err != nil {
+		log.Println("Error importing users:", err)
+		markImportRows(toCreate, results, dto.ImportStatusFailed, "batch rolled back: "+err.Error())
+		return
+	}
+	markImportRows(toCreate, results, dto.ImportStatusCreated, "")
+
+	if !options.SendInvitations {
+		return
+	}
+	for _, row := range toCreate {
+		err := s.invitations.InviteImportedUser(row.user, options.Locale)
+		if err != nil {
+			log.Println("Error sending invitation email:", err)
+			// Don't fail the import if email fails, an admin can invite again
+		}
+	}
+}
+
+func markImportRows(rows []importRow, results []dto.ImportRowResult, status string, reason string) {
+	for _, row := range rows {
+		results[row.index].Status = status
+		results[row.index].Reason = reason
+	}
+}
+
+// importUserFromRecord validates a row, returning the reason when it is invalid
+func importUserFromRecord(record []string, columns map[string]int) (model.UserModel, string) {
+	field := func(name string) string {
+		index, ok := columns[name]
+		if !ok || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+
+	user := model.UserModel{
+		Email:     field("email"),
+		FirstName: field("first_name"),
+		LastName:  field("last_name"),
+		Role:      strings.ToLower(field("role")),
+	}
+	if user.Role == "" {
+		user.Role = utils.RoleStudent
+	}
+	user.IsAdmin = user.Role == utils.RoleAdmin
+
+	if user.Email == "" {
+		return user, "email is required"
+	}
+	if address, err := mail.ParseAddress(user.Email); err != nil || address.Address != user.Email || len(user.Email) > 100 {
+		return user, "invalid email"
+	}
+	canonical, err := utils.CanonicalEmail(user.Email)
+	if err != nil {
+		return user, "invalid email domain"
+	}
+	user.Email = canonical
+	if user.FirstName == "" || user.LastName == "" {
+		return user, "first name and last name are required"
+	}
+	if len(user.FirstName) > 100 || len(user.LastName) > 100 {
+		return user, "names can have up to 100 characters"
+	}
+	if !utils.IsKnownRole(user.Role) {
+		return user, fmt.Sprintf("unknown role %s, expected one of %s", user.Role, strings.Join(utils.KnownRoles, ", "))
+	}
+	return user, ""
+}
+
+// importHeader maps the columns of a header row. A row is a header when it
+// has no email in it and at least an email column name
+func importHeader(record []string) (map[string]int, bool) {
+	columns := map[string]int{}
+	for i, cell := range record {
+		cell = strings.ToLower(strings.TrimSpace(cell))
+		if strings.Contains(cell, "@") {
+			return nil, false
+		}
+		name := strings.NewReplacer(" ", "_", "-", "_").Replace(cell)
+		if column, ok := importColumnNames[name]; ok {
+			if _, repeated := columns[column]; !repeated {
+				columns[column] = i
+			}
+		}
+	}
+	_, hasEmail := columns["email"]
+	return columns, hasEmail
+}
+
+// importDelimiter detects files saved with ";" by spreadsheets in Spanish locales
+func importDelimiter(data []byte) rune {
+	firstLine := data
+	if end := bytes.IndexByte(data, '\n'); end >= 0 {
+		firstLine = data[:end]
+	}
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		return ';'
+	}
+	return ','
+}
+
+func isBlankRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+
+	accessTokenClient "backend/clients/accesstoken"
+	userCLient "backend/clients/user"
+	"backend/db"
+	"backend/dto"
+	"backend/services"
+)
+
+// TestImportInvitesWithoutPassword imports a user with invitations and
+// accepts the link the email carries
+func TestImportInvitesWithoutPassword(t *testing.T) {
+	users, invitations, sent := newInvitationService(t)
+	importer := services.NewUserService(users, userCLient.NewTokenRepository(db.DB), userCLient.NewSessionRepository(db.DB), accessTokenClient.NewAccessTokenRepository(db.DB), invitations, registrationPolicy(t, services.RegistrationOpen))
+
+	response, apiErr := importer.ImportUsers(strings.NewReader("email,first_name,last_name\n Ana@Uni.EDU ,Ana,García\n"), dto.ImportUsersOptions{SendInvitations: true})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if response.Created != 1 || response.Rows[0].Email != "Ana@uni.edu" {
+		t.Fatalf("expected Ana@uni.edu to be created, got %+v", response)
+	}
+	imported, err := users.GetByEmail("ana@uni.edu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.PasswordHash != "" || imported.IsVerified {
+		t.Errorf("the imported account should wait for the invitation without a password, got %+v", imported)
+	}
+
+	if len(sent.Messages()) != 1 {
+		t.Fatalf("expected one invitation email, got %d", len(sent.Messages()))
+	}
+
+	accept := dto.AcceptInvitationRequest{Token: lastInvitationToken(t, sent), Password: "correct-horse-battery-staple-42", FirstName: "Ana", LastName: "García"}
+	if _, apiErr := invitations.AcceptInvitation(accept); apiErr != nil {
+		t.Fatalf("accepting the invitation: %v", apiErr)
+	}
+	activated, err := users.GetByID(imported.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activated.PasswordHash == "" || !activated.IsVerified {
+		t.Errorf("accepting should set the password and verify the imported account, got %+v", activated)
+	}
+	if _, apiErr := invitations.AcceptInvitation(accept); apiErr == nil {
+		t.Errorf("the invitation link worked twice")
+	}
+}
@@ -0,0 +1,382 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/dto"
+	"backend/i18n"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Lifetime of an invitation when the inviter doesn't choose one
+const invitationDefaultDays = 7
+
+// InvitationService sends the invitations to create an account and creates
+// the accounts of the people who accept them
+type InvitationService struct {
+	users       UserRepository
+	invitations InvitationRepository
+	sessions    SessionRepository
+	config      config.Invitations
+}
+
+func NewInvitationService(users UserRepository, invitations InvitationRepository, sessions SessionRepository, cfg config.Invitations) *InvitationService {
+	return &InvitationService{users: users, invitations: invitations, sessions: sessions, config: cfg}
+}
+
+// invitationLink builds the link sent by email, on the page of invitations.url
+func (s *InvitationService) invitationLink(token string) string {
+	separator := "?"
+	if strings.Contains(s.config.URL, "?") {
+		separator = "&"
+	}
+	return s.config.URL + separator + "token=" + url.QueryEscape(token)
+}
+
+// getInviter loads the user sending or managing invitations and checks that
+// their role may invite
+func (s *InvitationService) getInviter(userID int) (model.UserModel, utils.ApiError) {
+	inviter, err := s.users.GetByID(userID)
+	if err != nil {
+		log.Println("Error getting inviter:", err)
+		return model.UserModel{}, apiError(http.StatusForbidden, utils.CodeForbidden, "invitation.forbidden", nil)
+	}
+	if inviter.IsAdmin {
+		return inviter, nil
+	}
+	for _, role := range s.config.Roles {
+		if role == inviter.Role {
+			return inviter, nil
+		}
+	}
+	return model.UserModel{}, apiError(http.StatusForbidden, utils.CodeForbidden, "invitation.forbidden", nil)
+}
+
+// CreateInvitation invites an email to create an account with a given role
+// and sends the link. A newer invitation for the same email replaces the
+// pending ones. Only admins can invite other admins
+func (s *InvitationService) CreateInvitation(inviterID int, request dto.CreateInvitationRequest) (dto.InvitationDto, utils.ApiError) {
+	inviter, apiErr := s.getInviter(inviterID)
+	if apiErr != nil {
+		return dto.InvitationDto{}, apiErr
+	}
+
+	// stored like the account will be; the lookups compare the normalized form
+	email, err := utils.CanonicalEmail(request.Email)
+	if err != nil {
+		return dto.InvitationDto{}, apiError(http.StatusBadRequest, utils.CodeValidation, "invitation.invalid_email", i18n.Params{"email": request.Email})
+	}
+	role := strings.ToLower(strings.TrimSpace(request.Role))
+	if role == "" {
+		role = utils.RoleStudent
+	}
+	if !utils.IsKnownRole(role) {
+		return dto.InvitationDto{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.unknown_role", i18n.Params{"role": role, "roles": strings.Join(utils.KnownRoles, ", ")})
+	}
+	if role == utils.RoleAdmin && !inviter.IsAdmin {
+		return dto.InvitationDto{}, apiError(http.StatusForbidden, utils.CodeForbidden, "invitation.admin_only", nil)
+	}
+	locale := inviter.Locale
+	if request.Locale != "" {
+		if locale, apiErr = checkLocale(request.Locale); apiErr != nil {
+			return dto.InvitationDto{}, apiErr
+		}
+	}
+
+	if apiErr := s.checkEmailFree(email); apiErr != nil {
+		return dto.InvitationDto{}, apiErr
+	}
+
+	days := request.ExpiresInDays
+	if days == 0 {
+		days = invitationDefaultDays
+	}
+	now := time.Now()
+	invitation, err := s.invitations.Create(model.Invitation{
+		Email:     email,
+		Role:      role,
+		InvitedBy: inviter.ID,
+		ExpiresAt: now.AddDate(0, 0, days),
+		CreatedAt: now,
+	})
+	if err != nil {
+		log.Println("Error creating invitation:", err)
+		return dto.InvitationDto{}, utils.NewInternalServerApiError("Error creating invitation", err)
+	}
+
+	token, err := utils.GenerateInvitationToken(invitation.ID, invitation.Email, invitation.ExpiresAt)
+	if err != nil {
+		log.Println("Error generating invitation token:", err)
+		return dto.InvitationDto{}, utils.NewInternalServerApiError("Error generating invitation link", err)
+	}
+
+	inviterName := strings.TrimSpace(inviter.FirstName + " " + inviter.LastName)
+	err = utils.SendInvitationEmail(invitation.Email, inviterName, invitation.Role, s.invitationLink(token), invitation.ExpiresAt, locale)
+	if err != nil {
+		log.Println("Error sending invitation email:", err)
+		// Don't fail the invitation if email fails, it can be sent again
+	}
+
+	return invitationToDto(invitation, now), nil
+}
+
+// GetInvitations lists the invitations sent by the user, or every invitation for admins
+func (s *InvitationService) GetInvitations(userID int) ([]dto.InvitationDto, utils.ApiError) {
+	inviter, apiErr := s.getInviter(userID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	invitedBy := inviter.ID
+	if inviter.IsAdmin {
+		invitedBy = 0
+	}
+	invitations, err := s.invitations.List(invitedBy)
+	if err != nil {
+		log.Println("Error getting invitations:", err)
+		return nil, utils.NewInternalServerApiError("Error getting invitations", err)
+	}
+
+	now := time.Now()
+	result := make([]dto.InvitationDto, 0, len(invitations))
+	for _, invitation := range invitations {
+		result = append(result, invitationToDto(invitation, now))
+	}
+	return result, nil
+}
+
+// RevokeInvitation revokes a pending invitation sent by the user. Admins can
+// revoke any invitation
+func (s *InvitationService) RevokeInvitation(userID int, invitationID int) utils.ApiError {
+	inviter, apiErr := s.getInviter(userID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	invitedBy := inviter.ID
+	if inviter.IsAdmin {
+		invitedBy = 0
+	}
+	err := s.invitations.Revoke(invitationID, invitedBy, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apiError(http.StatusNotFound, utils.CodeNotFound, "invitation.not_found", nil)
+	}
+	if err != nil {
+		log.Println("Error revoking invitation:", err)
+		return utils.NewInternalServerApiError("Error revoking invitation", err)
+	}
+	return nil
+}
+
+// InviteImportedUser sends the account created by an import the link to
+// choose its password. No password is set or sent: accepting the invitation
+// sets it and verifies the email. Imports have no inviter, InvitedBy is 0
+func (s *InvitationService) InviteImportedUser(user model.UserModel, locale string) error {
+	now := time.Now()
+	userID := user.ID
+	invitation, err := s.invitations.Create(model.Invitation{
+		Email:          user.Email,
+		Role:           user.Role,
+		ExpiresAt:      now.AddDate(0, 0, invitationDefaultDays),
+		AcceptedUserID: &userID,
+		CreatedAt:      now,
+	})
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateInvitationToken(invitation.ID, invitation.Email, invitation.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if locale == "" {
+		locale = user.Locale
+	}
+	return utils.SendImportInvitationEmail(user.Email, user.FirstName, s.invitationLink(token), invitation.ExpiresAt, locale)
+}
+
+// PreviewInvitation describes the invitation behind a link, so the accept
+// page can show the email and role before the invitee picks a password
+func (s *InvitationService) PreviewInvitation(token string) (dto.InvitationPreviewResponse, utils.ApiError) {
+	invitation, apiErr := s.pendingInvitation(token)
+	if apiErr != nil {
+		return dto.InvitationPreviewResponse{}, apiErr
+	}
+	return dto.InvitationPreviewResponse{
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		ExpiresAt: invitation.ExpiresAt,
+	}, nil
+}
+
+// AcceptInvitation creates the account of an invited user, or sets the
+// password of the one an import created, and starts a session. Following the
+// link proves the invitee owns the mailbox, so the account ends up verified
+func (s *InvitationService) AcceptInvitation(request dto.AcceptInvitationRequest) (dto.LoginResponse, utils.ApiError) {
+	invitation, apiErr := s.pendingInvitation(request.Token)
+	if apiErr != nil {
+		return dto.LoginResponse{}, apiErr
+	}
+
+	newUser, apiErr := s.invitedUser(invitation)
+	if apiErr != nil {
+		return dto.LoginResponse{}, apiErr
+	}
+	now := time.Now()
+	newUser.FirstName = strings.TrimSpace(request.FirstName)
+	newUser.LastName = strings.TrimSpace(request.LastName)
+	newUser.IsVerified = true
+	if request.Locale != "" {
+		if newUser.Locale, apiErr = checkLocale(request.Locale); apiErr != nil {
+			return dto.LoginResponse{}, apiErr
+		}
+	}
+	if apiErr := setPassword(&newUser, request.Password); apiErr != nil {
+		return dto.LoginResponse{}, apiErr
+	}
+
+	user, err := s.invitations.Accept(invitation.ID, newUser, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// accepted or revoked while the invitee filled the form
+		return dto.LoginResponse{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.no_longer_valid", nil)
+	}
+	if err != nil {
+		// someone registered the email, maybe under another spelling, while
+		// the invitee filled the form: the unique normalized email refused it
+		if newUser.ID == 0 {
+			if apiErr := s.checkEmailFree(invitation.Email); apiErr != nil {
+				return dto.LoginResponse{}, apiErr
+			}
+		}
+		log.Println("Error accepting invitation:", err)
+		return dto.LoginResponse{}, utils.NewInternalServerApiError("Error creating user", err)
+	}
+
+	err = utils.SendWelcomeEmail(user.Email, user.FirstName, user.Locale)
+	if err != nil {
+		log.Println("Error sending welcome email:", err)
+		// Don't fail the acceptance if welcome email fails
+	}
+
+	response, err := startSession(s.sessions, user)
+	if err != nil {
+		return dto.LoginResponse{}, utils.NewLocalizedApiError("invitation.session_failed", nil, utils.CodeInternal, http.StatusInternalServerError, utils.CauseList{err.Error()})
+	}
+	return response, nil
+}
+
+// invitedUser returns the account an invitation gives access to: the one an
+// import created, or a new one when nobody has the email yet
+func (s *InvitationService) invitedUser(invitation model.Invitation) (model.UserModel, utils.ApiError) {
+	if invitation.AcceptedUserID != nil {
+		imported, err := s.users.GetByID(*invitation.AcceptedUserID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// deleted since the import
+			return model.UserModel{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.no_longer_valid", nil)
+		}
+		if err != nil {
+			log.Println("Error getting imported user:", err)
+			return model.UserModel{}, utils.NewInternalServerApiError("Error getting user", err)
+		}
+		// the link only proves the mailbox it was sent to
+		if imported.Email != invitation.Email {
+			return model.UserModel{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.no_longer_valid", nil)
+		}
+		return imported, nil
+	}
+
+	if apiErr := s.checkEmailFree(invitation.Email); apiErr != nil {
+		return model.UserModel{}, apiErr
+	}
+	return model.UserModel{
+		Email:   invitation.Email,
+		Role:    invitation.Role,
+		IsAdmin: invitation.Role == utils.RoleAdmin,
+	}, nil
+}
+
+// checkEmailFree fails when an account already has the email. GetByEmail
+// compares the normalized form, so "Ana.Perez@uni.edu" finds "ana.perez@uni.edu"
+// whenever the normalization makes them the same account
+func (s *InvitationService) checkEmailFree(email string) utils.ApiError {
+	existingUser, err := s.users.GetByEmail(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("Error checking existing user:", err)
+		return utils.NewInternalServerApiError("Error checking user existence", err)
+	}
+	if existingUser.ID != 0 {
+		return errEmailTaken(email)
+	}
+	return nil
+}
+
+// pendingInvitation checks the signature of an invitation token and that the
+// invitation it points to can still be accepted
+func (s *InvitationService) pendingInvitation(token string) (model.Invitation, utils.ApiError) {
+	invitationID, email, err := utils.ParseInvitationToken(token)
+	if err != nil {
+		log.Println("Error parsing invitation token:", err)
+		return model.Invitation{}, errInvitationInvalid()
+	}
+
+	invitation, err := s.invitations.GetByID(invitationID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("Error getting invitation:", err)
+		}
+		return model.Invitation{}, errInvitationInvalid()
+	}
+	if invitation.Email != email {
+		return model.Invitation{}, errInvitationInvalid()
+	}
+
+	switch invitationStatus(invitation, time.Now()) {
+	case dto.InvitationStatusAccepted:
+		return model.Invitation{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.already_accepted", nil)
+	case dto.InvitationStatusRevoked:
+		return model.Invitation{}, apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.revoked", nil)
+	case dto.InvitationStatusExpired:
+		return model.Invitation{}, errInvitationInvalid()
+	}
+	return invitation, nil
+}
+
+func errInvitationInvalid() utils.ApiError {
+	return apiError(http.StatusBadRequest, utils.CodeBadRequest, "invitation.invalid", nil)
+}
+
+func invitationStatus(invitation model.Invitation, now time.Time) string {
+	switch {
+	case invitation.AcceptedAt != nil:
+		return dto.InvitationStatusAccepted
+	case invitation.RevokedAt != nil:
+		return dto.InvitationStatusRevoked
+	case now.After(invitation.ExpiresAt):
+		return dto.InvitationStatusExpired
+	}
+	return dto.InvitationStatusPending
+}
+
+func invitationToDto(invitation model.Invitation, now time.Time) dto.InvitationDto {
+	return dto.InvitationDto{
+		ID:             invitation.ID,
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		Status:         invitationStatus(invitation, now),
+		InvitedBy:      invitation.InvitedBy,
+		ExpiresAt:      invitation.ExpiresAt,
+		AcceptedAt:     invitation.AcceptedAt,
+		AcceptedUserID: invitation.AcceptedUserID,
+		RevokedAt:      invitation.RevokedAt,
+		CreatedAt:      invitation.CreatedAt,
+	}
+}
@@ -0,0 +1,105 @@
+package services_test
+
+import (
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	userCLient "backend/clients/user"
+	"backend/config"
+	"backend/db"
+	"backend/dto"
+	"backend/mailer"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+)
+
+var invitationTokenPattern = regexp.MustCompile(`token=(\S+)`)
+
+// newInvitationService builds an InvitationService on a migrated SQLite file,
+// since invitations have no memory repository, and keeps the emails it sends
+func newInvitationService(t *testing.T) (userCLient.UserRepository, *services.InvitationService, *mailer.MemoryMailer) {
+	t.Helper()
+	utils.ConfigureJWT(config.JWT{Secret: "0123456789abcdef0123456789abcdef"})
+	if err := db.Connect(config.Database{Driver: "sqlite", DSN: db.SQLiteDSN(filepath.Join(t.TempDir(), "invitations.db"))}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := &mailer.MemoryMailer{}
+	utils.SetMailer(sent, mail.Address{Address: "no-reply@uni.edu"}, mailer.DefaultLocale)
+	t.Cleanup(func() {
+		utils.SetMailer(mailer.LogMailer{}, mail.Address{Address: "no-reply@uni.edu"}, mailer.DefaultLocale)
+	})
+
+	users := userCLient.NewUserRepository(db.DB)
+	invitations := services.NewInvitationService(users, userCLient.NewInvitationRepository(db.DB), userCLient.NewSessionRepository(db.DB), config.Invitations{URL: "https://uni.edu/invite"})
+	return users, invitations, sent
+}
+
+// lastInvitationToken reads the token of the link in the last email sent
+func lastInvitationToken(t *testing.T, sent *mailer.MemoryMailer) string {
+	t.Helper()
+	messages := sent.Messages()
+	if len(messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+	text := messages[len(messages)-1].Text
+	match := invitationTokenPattern.FindStringSubmatch(text)
+	if match == nil {
+		t.Fatalf("the email has no invitation link:\n%s", text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestInvitationEmailsAreNormalized(t *testing.T) {
+	users, invitations, sent := newInvitationService(t)
+
+	admin, err := users.Create(model.UserModel{Email: "admin@uni.edu", Role: utils.RoleAdmin, IsAdmin: true, IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Create(model.UserModel{Email: "ana.perez@uni.edu", IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same account under another spelling
+	_, apiErr := invitations.CreateInvitation(admin.ID, dto.CreateInvitationRequest{Email: " Ana.Perez@UNI.edu "})
+	if apiErr == nil || apiErr.Status() != http.StatusConflict || apiErr.Code() != utils.CodeEmailTaken {
+		t.Errorf("expected the invitation of an existing account to be refused, got %v", apiErr)
+	}
+
+	// the domain is stored in ASCII and the local part as typed
+	invitation, apiErr := invitations.CreateInvitation(admin.ID, dto.CreateInvitationRequest{Email: "Luis@Über.de"})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if invitation.Email != "Luis@xn--ber-goa.de" {
+		t.Errorf("expected the canonical email Luis@xn--ber-goa.de, got %s", invitation.Email)
+	}
+
+	// someone registers the email under another spelling before the invitee
+	// accepts: a conflict, not an error of the unique index
+	if _, apiErr := invitations.CreateInvitation(admin.ID, dto.CreateInvitationRequest{Email: "maria@uni.edu"}); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	token := lastInvitationToken(t, sent)
+	if _, err := users.Create(model.UserModel{Email: "Maria@Uni.edu", IsActive: true}); err != nil {
+		t.Fatal(err)
+	}
+	_, apiErr = invitations.AcceptInvitation(dto.AcceptInvitationRequest{Token: token, Password: "correct-horse-battery-staple-42", FirstName: "María", LastName: "Gómez"})
+	if apiErr == nil || apiErr.Status() != http.StatusConflict || apiErr.Code() != utils.CodeEmailTaken {
+		t.Errorf("expected accepting a taken email to be a conflict, got %v", apiErr)
+	}
+}
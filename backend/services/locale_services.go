@@ -0,0 +1,44 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"backend/i18n"
+	"backend/utils"
+)
+
+// checkLocale returns the supported locale for a language chosen by the user
+func checkLocale(locale string) (string, utils.ApiError) {
+	supported, ok := i18n.Supported(locale)
+	if !ok {
+		params := i18n.Params{"locale": locale, "locales": strings.Join(i18n.Locales(), ", ")}
+		return "", apiError(http.StatusBadRequest, utils.CodeBadRequest, "locale.unsupported", params)
+	}
+	return supported, nil
+}
+
+// GetUserLocale returns the language saved by the user, empty when they
+// didn't choose one
+func (s *UserService) GetUserLocale(userID int) string {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		log.Println("Error getting user locale:", err)
+		return ""
+	}
+	return user.Locale
+}
+
+// UpdateUserLocale saves the language of the API messages and the emails of a user
+func (s *UserService) UpdateUserLocale(userID int, locale string) utils.ApiError {
+	locale, apiErr := checkLocale(locale)
+	if apiErr != nil {
+		return apiErr
+	}
+	if err := s.users.UpdateLocale(userID, locale); err != nil {
+		log.Println("Error updating locale:", err)
+		return utils.NewInternalServerApiError("Error updating locale", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package services_test
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+
+	memoryClient "backend/clients/memory"
+	"backend/config"
+	"backend/dto"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oauthIssuer   = "https://id.uni.edu"
+	oauthRedirect = "https://app.uni.edu/callback"
+	oauthPassword = "correct-horse-battery-staple-42"
+)
+
+// oauthHarness is an OAuthService on the memory store with a confidential
+// client and a local user who can log in on the authorize page
+type oauthHarness struct {
+	oauth  *services.OAuthService
+	users  *services.UserService
+	client dto.CreateOAuthClientResponse
+	user   model.UserModel
+}
+
+func newOAuthHarness(t *testing.T) *oauthHarness {
+	t.Helper()
+	utils.ConfigureJWT(config.JWT{Secret: "0123456789abcdef0123456789abcdef"})
+	if err := utils.ConfigureOIDC(config.OIDC{Issuer: oauthIssuer}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := memoryClient.NewStore()
+	services.SetAuthenticators(services.NewLocalAuthenticator(store.Users()))
+	t.Cleanup(func() { services.SetAuthenticators() })
+
+	user, err := store.Users().Create(model.UserModel{
+		Email:        "ana@uni.edu",
+		PasswordHash: utils.HashSHA256(oauthPassword),
+		FirstName:    "Ana",
+		LastName:     "García",
+		IsVerified:   true,
+		IsActive:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oauth := services.NewOAuthService(store.Users(), store.Sessions(), store.OAuthClients(), store.AuthorizationCodes())
+	client, apiErr := oauth.CreateOAuthClient(dto.CreateOAuthClientRequest{
+		Name:         "Campus app",
+		Scopes:       []string{utils.ScopeOpenID, utils.ScopeProfile, utils.ScopeEmail},
+		GrantTypes:   []string{services.GrantAuthorizationCode, services.GrantRefreshToken},
+		RedirectURIs: []string{oauthRedirect},
+	})
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	return &oauthHarness{
+		oauth:  oauth,
+		users:  services.NewUserService(store.Users(), store.Tokens(), store.Sessions(), store.AccessTokens(), nil, registrationPolicy(t, services.RegistrationOpen)),
+		client: client,
+		user:   user,
+	}
+}
+
+// authorize logs the user in on the authorize page and returns the code and
+// the code_verifier of its PKCE challenge
+func (h *oauthHarness) authorize(t *testing.T, scope string, nonce string) (string, string) {
+	t.Helper()
+	verifier, err := utils.GenerateCodeVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, apiErr := h.oauth.Authorize(dto.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            h.client.ClientID,
+		RedirectURI:         oauthRedirect,
+		Scope:               scope,
+		CodeChallenge:       utils.CodeChallengeS256(verifier),
+		CodeChallengeMethod: "S256",
+		Nonce:               nonce,
+	}, h.user.Email, oauthPassword)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	return code, verifier
+}
+
+// expectGrantError checks the OAuth error code of a token request
+func expectGrantError(t *testing.T, apiErr utils.ApiError, code string) {
+	t.Helper()
+	if apiErr == nil || apiErr.Status() != http.StatusBadRequest || apiErr.Code() != code {
+		t.Errorf("expected a %s error, got %v", code, apiErr)
+	}
+}
+
+// verifyIDToken checks the ID token signature with the published JWKS, like a
+// client that only knows the discovery document would
+func verifyIDToken(t *testing.T, idToken string, clientID string) *utils.IDTokenClaims {
+	t.Helper()
+	keys := services.GetJWKS()
+	claims := &utils.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		for _, key := range keys {
+			if key.Kid != token.Header["kid"] {
+				continue
+			}
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return nil, err
+			}
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+		}
+		return nil, fmt.Errorf("no key %v in the JWKS", token.Header["kid"])
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(oauthIssuer), jwt.WithAudience(clientID))
+	if err != nil {
+		t.Fatalf("the ID token doesn't verify against the JWKS: %v", err)
+	}
+	return claims
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	h := newOAuthHarness(t)
+
+	code, verifier := h.authorize(t, "openid email", "n-0S6_WzA2Mj")
+	response, apiErr := h.oauth.AuthorizationCodeToken(h.client.ClientID, h.client.ClientSecret, code, oauthRedirect, verifier)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	claims := verifyIDToken(t, response.IDToken, h.client.ClientID)
+	if claims.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("expected the nonce of the authorization request, got %q", claims.Nonce)
+	}
+	if claims.Subject != fmt.Sprint(h.user.ID) || claims.Email != h.user.Email || claims.AuthTime == 0 {
+		t.Errorf("unexpected ID token claims %+v", claims)
+	}
+	// profile wasn't requested
+	if claims.Name != "" {
+		t.Errorf("the ID token has the profile claims without the profile scope: %q", claims.Name)
+	}
+
+	// userinfo answers for the access token with the granted claims only
+	auth, apiErr := h.users.VerifyToken(response.AccessToken)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	info, apiErr := h.oauth.GetUserInfo(auth)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if info.Sub != fmt.Sprint(h.user.ID) || info.Email != h.user.Email || info.EmailVerified == nil || !*info.EmailVerified || info.GivenName != "" {
+		t.Errorf("unexpected userinfo %+v", info)
+	}
+}
+
+func TestAuthorizationCodeIsChecked(t *testing.T) {
+	h := newOAuthHarness(t)
+	code, verifier := h.authorize(t, "openid", "")
+
+	// rejected exchanges don't consume the code
+	_, apiErr := h.oauth.AuthorizationCodeToken(h.client.ClientID, h.client.ClientSecret, code, oauthRedirect, utils.CodeChallengeS256(verifier))
+	expectGrantError(t, apiErr, "invalid_grant")
+	_, apiErr = h.oauth.AuthorizationCodeToken(h.client.ClientID, h.client.ClientSecret, code, oauthRedirect+"/other", verifier)
+	expectGrantError(t, apiErr, "invalid_grant")
+	_, apiErr = h.oauth.AuthorizationCodeToken(h.client.ClientID, "wrong-secret", code, oauthRedirect, verifier)
+	if apiErr == nil || apiErr.Status() != http.StatusUnauthorized || apiErr.Code() != "invalid_client" {
+		t.Errorf("expected an invalid_client error, got %v", apiErr)
+	}
+
+	if _, apiErr := h.oauth.AuthorizationCodeToken(h.client.ClientID, h.client.ClientSecret, code, oauthRedirect, verifier); apiErr != nil {
+		t.Fatalf("the right exchange was rejected: %v", apiErr)
+	}
+	_, apiErr = h.oauth.AuthorizationCodeToken(h.client.ClientID, h.client.ClientSecret, code, oauthRedirect, verifier)
+	expectGrantError(t, apiErr, "invalid_grant")
+
+	// the authorize page doesn't issue codes for another redirect_uri
+	_, apiErr = h.oauth.GetAuthorizeClient(h.client.ClientID, "https://evil.example/callback")
+	if apiErr == nil || apiErr.Status() != http.StatusBadRequest {
+		t.Errorf("expected an unregistered redirect_uri to be refused, got %v", apiErr)
+	}
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	h := newOAuthHarness(t)
+	code, verifier := h.authorize(t, "openid profile email", "n-0S6_WzA2Mj")
+	issued, apiErr := h.oauth.AuthorizationCodeToken(h.client.ClientID, h.client.ClientSecret, code, oauthRedirect, verifier)
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+
+	refreshed, apiErr := h.oauth.RefreshTokenGrant(h.client.ClientID, h.client.ClientSecret, issued.RefreshToken, "openid profile")
+	if apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if refreshed.RefreshToken == "" || refreshed.Scope != "openid profile" {
+		t.Errorf("expected a new refresh token narrowed to openid profile, got %+v", refreshed)
+	}
+	claims := verifyIDToken(t, refreshed.IDToken, h.client.ClientID)
+	if claims.Nonce != "" || claims.AuthTime != 0 || claims.Email != "" || claims.GivenName != "Ana" {
+		t.Errorf("unexpected refreshed ID token claims %+v", claims)
+	}
+
+	// the narrowed refresh token can't get the email scope back
+	_, apiErr = h.oauth.RefreshTokenGrant(h.client.ClientID, h.client.ClientSecret, refreshed.RefreshToken, "openid email")
+	expectGrantError(t, apiErr, "invalid_scope")
+
+	// an access token is not a refresh token
+	_, apiErr = h.oauth.RefreshTokenGrant(h.client.ClientID, h.client.ClientSecret, issued.AccessToken, "")
+	expectGrantError(t, apiErr, "invalid_grant")
+}
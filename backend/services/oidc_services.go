@@ -0,0 +1,117 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/dto"
+	"backend/i18n"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// buildIDToken signs an OpenID Connect ID token for the user. authTime is the
+// moment the user logged in, zero when unknown
+func (s *OAuthService) buildIDToken(userID int, clientID string, scopes []string, nonce string, authTime time.Time) (string, utils.ApiError) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		log.Println("Error getting user for id token:", err)
+		return "", utils.NewApiError("user no longer exists", "invalid_grant", http.StatusBadRequest, utils.CauseList{})
+	}
+
+	info := userInfoFromModel(user, scopes)
+	claims := utils.IDTokenClaims{
+		Nonce:         nonce,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Name:          info.Name,
+		GivenName:     info.GivenName,
+		FamilyName:    info.FamilyName,
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+
+	idToken, err := utils.GenerateIDToken(user.ID, clientID, claims)
+	if err != nil {
+		log.Println("Error generating id token:", err)
+		return "", utils.NewInternalServerApiError("failed to generate id token", err)
+	}
+	return idToken, nil
+}
+
+// GetUserInfo returns the OpenID Connect claims of the token holder. Tokens
+// granted to a client need the openid scope; user sessions see every claim.
+// A token without a user is rejected as invalid, one without the scope as
+// forbidden
+func (s *OAuthService) GetUserInfo(auth dto.AuthContext) (dto.UserInfoResponse, utils.ApiError) {
+	if auth.UserID == 0 {
+		return dto.UserInfoResponse{}, errInvalidToken()
+	}
+	if auth.Scopes != nil && !utils.HasScope(auth.Scopes, utils.ScopeOpenID) {
+		return dto.UserInfoResponse{}, apiError(http.StatusForbidden, utils.CodeForbidden, "auth.missing_scope", i18n.Params{"scope": utils.ScopeOpenID})
+	}
+
+	user, err := s.users.GetByID(auth.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return dto.UserInfoResponse{}, errUserNotFound()
+	}
+	if err != nil {
+		return dto.UserInfoResponse{}, utils.NewInternalServerApiError("failed to get user for userinfo", err)
+	}
+
+	scopes := auth.Scopes
+	if scopes == nil {
+		scopes = []string{utils.ScopeProfile, utils.ScopeEmail}
+	}
+	return userInfoFromModel(user, scopes), nil
+}
+
+// GetOpenIDConfiguration builds the discovery document
+func GetOpenIDConfiguration() dto.OpenIDConfiguration {
+	issuer := utils.OIDCIssuer()
+	return dto.OpenIDConfiguration{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/oauth/authorize",
+		TokenEndpoint:                     issuer + "/oauth/token",
+		UserinfoEndpoint:                  issuer + "/userinfo",
+		JwksURI:                           issuer + "/oauth/jwks",
+		ScopesSupported:                   utils.KnownScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               supportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{pkceMethodS256},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "name", "given_name", "family_name"},
+	}
+}
+
+// GetJWKS returns the public keys used to sign ID tokens
+func GetJWKS() []utils.JSONWebKey {
+	return utils.PublicJWKS()
+}
+
+// userInfoFromModel maps the user to the standard claims allowed by scopes
+func userInfoFromModel(user model.UserModel, scopes []string) dto.UserInfoResponse {
+	info := dto.UserInfoResponse{Sub: fmt.Sprintf("%d", user.ID)}
+
+	if utils.HasScope(scopes, utils.ScopeEmail) {
+		verified := user.IsVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+
+	if utils.HasScope(scopes, utils.ScopeProfile) {
+		info.GivenName = user.FirstName
+		info.FamilyName = user.LastName
+		info.Name = user.FirstName + " " + user.LastName
+	}
+
+	return info
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"backend/config"
+	"backend/i18n"
+	"backend/model"
+	"backend/utils"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Error code and per-field reasons of a rejected password
+const (
+	passwordPolicyCode = "password_policy"
+
+	passwordTooShort     = "too_short"
+	passwordTooLong      = "too_long"
+	passwordBannedWord   = "banned_word"
+	passwordPersonalInfo = "personal_info"
+	passwordBreached     = "breached"
+	passwordTooWeak      = "too_weak"
+)
+
+//go:embed password/common_passwords.txt
+var bundledCommonPasswords []byte
+
+// PasswordPolicy decides which passwords are accepted on register, reset,
+// change-password and invitations
+type PasswordPolicy struct {
+	MinLength   int
+	MaxLength   int
+	MinScore    int      // minimum strength, 0 to 4
+	BannedWords []string // rejected anywhere in the password, besides the user's own data
+	// BreachedFile is a bloom filter of SHA-1 hashes of breached passwords,
+	// built with "backend build-breached-filter"
+	BreachedFile string
+
+	breached *utils.BloomFilter
+}
+
+// Always banned, besides password.banned_words
+const defaultBannedWord = "unichat"
+
+var (
+	passwordPolicy   = PasswordPolicy{MinLength: 8, MaxLength: 128, MinScore: 2, BannedWords: []string{defaultBannedWord}}
+	passwordPolicyMu sync.RWMutex
+	// commonPasswords maps the bundled common passwords to their rank
+	commonPasswords = loadWordRanks(bundledCommonPasswords)
+)
+
+// SetPasswordPolicy validates the policy, loads its breached passwords filter
+// and makes it the one in use
+func SetPasswordPolicy(policy PasswordPolicy) error {
+	if policy.MinLength < 1 || policy.MaxLength < policy.MinLength {
+		return fmt.Errorf("invalid password length limits %d-%d", policy.MinLength, policy.MaxLength)
+	}
+	if policy.MinScore < 0 || policy.MinScore > 4 {
+		return fmt.Errorf("password minimum score must be between 0 and 4")
+	}
+
+	if policy.BreachedFile != "" {
+		file, err := os.Open(policy.BreachedFile)
+		if err != nil {
+			return fmt.Errorf("error opening breached passwords filter: %w", err)
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", policy.BreachedFile, err)
+		}
+		policy.breached, err = utils.ReadBloomFilter(file, info.Size())
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", policy.BreachedFile, err)
+		}
+	}
+
+	passwordPolicyMu.Lock()
+	passwordPolicy = policy
+	passwordPolicyMu.Unlock()
+	return nil
+}
+
+// ConfigurePasswordPolicy sets the policy of the password section
+func ConfigurePasswordPolicy(cfg config.Password) error {
+	policy := PasswordPolicy{
+		MinLength:    cfg.MinLength,
+		MaxLength:    128,
+		MinScore:     cfg.MinScore,
+		BannedWords:  []string{defaultBannedWord},
+		BreachedFile: cfg.BreachedFile,
+	}
+	for _, word := range cfg.BannedWords {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			policy.BannedWords = append(policy.BannedWords, word)
+		}
+	}
+
+	if err := SetPasswordPolicy(policy); err != nil {
+		return fmt.Errorf("password policy: %w", err)
+	}
+	return nil
+}
+
+// checkPassword applies the policy to the password of user, reporting every
+// rule it breaks as a reason of the password field
+func checkPassword(password string, user model.UserModel) utils.ApiError {
+	passwordPolicyMu.RLock()
+	policy := passwordPolicy
+	passwordPolicyMu.RUnlock()
+
+	var causes utils.CauseList
+	// the message is translated by the controller from message_key and params
+	reject := func(reason string, key string, params i18n.Params) map[string]interface{} {
+		cause := map[string]interface{}{"field": "password", "reason": reason, "message": i18n.T("en", key, params), "message_key": key}
+		if params != nil {
+			cause["params"] = params
+		}
+		causes = append(causes, cause)
+		return cause
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		reject(passwordTooShort, "password.too_short", i18n.Params{"min": policy.MinLength})
+	}
+	if length > policy.MaxLength {
+		reject(passwordTooLong, "password.too_long", i18n.Params{"max": policy.MaxLength})
+	}
+
+	lower := removeAccents(strings.ToLower(password))
+	for _, word := range policy.BannedWords {
+		if strings.Contains(lower, word) {
+			reject(passwordBannedWord, "password.banned_word", i18n.Params{"word": word})
+			break
+		}
+	}
+	personal := passwordUserInputs(user)
+	for _, input := range personal {
+		if len([]rune(input)) >= 3 && strings.Contains(lower, input) {
+			reject(passwordPersonalInfo, "password.personal_info", nil)
+			break
+		}
+	}
+
+	if isBreachedPassword(password, policy) {
+		reject(passwordBreached, "password.breached", nil)
+	}
+
+	strength := utils.EstimatePasswordStrength(removeAccents(password), commonPasswords, append(personal, policy.BannedWords...))
+	if strength.Score < policy.MinScore {
+		cause := reject(passwordTooWeak, "password.too_weak", nil)
+		cause["patterns"] = strength.Patterns
+	}
+
+	if len(causes) > 0 {
+		return utils.NewLocalizedApiError("password.policy", nil, passwordPolicyCode, http.StatusBadRequest, causes)
+	}
+	return nil
+}
+
+// setPassword checks password against the policy and stores its hash in
+// user. Every password written to an account goes through here, the ones
+// people choose and the generated ones alike
+func setPassword(user *model.UserModel, password string) utils.ApiError {
+	if apiErr := checkPassword(password, *user); apiErr != nil {
+		return apiErr
+	}
+	user.PasswordHash = utils.HashSHA256(password)
+	return nil
+}
+
+// passwordUserInputs are the words an attacker would try first for user:
+// the names and the parts of the email, without accents
+func passwordUserInputs(user model.UserModel) []string {
+	text := user.FirstName + " " + user.LastName
+	if at := strings.LastIndex(user.Email, "@"); at > 0 {
+		local, domain := user.Email[:at], user.Email[at+1:]
+		text += " " + local + " " + strings.Split(domain, ".")[0]
+		// "ana.garcia" is tried whole and by parts
+		text += " " + strings.NewReplacer(".", "", "_", "", "-", "").Replace(local)
+	}
+
+	return strings.FieldsFunc(removeAccents(strings.ToLower(text)), func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("._-+", r)
+	})
+}
+
+// removeAccents turns "garcía" into "garcia", so names match however they are typed
+func removeAccents(value string) string {
+	result, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), value)
+	if err != nil {
+		return value
+	}
+	return result
+}
+
+func isBreachedPassword(password string, policy PasswordPolicy) bool {
+	if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+		return true
+	}
+	if policy.breached == nil {
+		return false
+	}
+	digest := sha1.Sum([]byte(password))
+	return policy.breached.Test(digest[:])
+}
+
+// loadWordRanks reads a word list, one per line and most frequent first
+func loadWordRanks(data []byte) map[string]int {
+	ranks := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if _, repeated := ranks[word]; !repeated {
+			ranks[word] = len(ranks) + 1
+		}
+	}
+	return ranks
+}
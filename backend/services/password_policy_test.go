@@ -0,0 +1,134 @@
+package services_test
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backend/dto"
+	"backend/services"
+	"backend/utils"
+)
+
+// the policy in use when no configuration is loaded
+var defaultPasswordPolicy = services.PasswordPolicy{MinLength: 8, MaxLength: 128, MinScore: 2, BannedWords: []string{"unichat"}}
+
+// passwordPolicy makes policy the one in use for the test
+func passwordPolicy(t *testing.T, policy services.PasswordPolicy) {
+	t.Helper()
+	if err := services.SetPasswordPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := services.SetPasswordPolicy(defaultPasswordPolicy); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// writeBreachedFilter writes a breached passwords filter with passwords, like
+// "backend build-breached-filter" does
+func writeBreachedFilter(t *testing.T, passwords ...string) string {
+	t.Helper()
+	filter := utils.NewBloomFilter(uint64(len(passwords)), 0.001)
+	for _, password := range passwords {
+		digest := sha1.Sum([]byte(password))
+		filter.Add(digest[:])
+	}
+	path := filepath.Join(t.TempDir(), "breached.bloom")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := filter.WriteTo(file); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// passwordReasons registers a user with password and returns the reasons the
+// policy gave, none when the password was accepted
+func passwordReasons(t *testing.T, password string) []string {
+	t.Helper()
+	users, _ := newUserService(t)
+	_, apiErr := users.Register(dto.RegisterRequest{Email: "ana.garcia@uni.edu", Password: password, FirstName: "Ana", LastName: "García"})
+	if apiErr == nil {
+		return nil
+	}
+	if apiErr.Code() != "password_policy" {
+		t.Fatalf("expected a password_policy error, got %v", apiErr)
+	}
+	var reasons []string
+	for _, cause := range apiErr.Cause() {
+		reasons = append(reasons, cause.(map[string]interface{})["reason"].(string))
+	}
+	return reasons
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breached := writeBreachedFilter(t, "Violet-Harbor-Lantern-58")
+	passwordPolicy(t, services.PasswordPolicy{MinLength: 8, MaxLength: 64, MinScore: 2, BannedWords: []string{"unichat", "campus"}, BreachedFile: breached})
+
+	tests := []struct {
+		name     string
+		password string
+		reasons  []string // all of them must be reported; nil when accepted
+	}{
+		{"strong", "Violet-Harbor-Lantern-59", nil},
+		// 64 characters and more bytes: the limits count characters
+		{"accented at the longest", strings.Repeat("ñandú-", 10) + "Vío4", nil},
+		{"too short", "V-h4#k", []string{"too_short"}},
+		{"too long", strings.Repeat("Violet-Harbor-58!", 4), []string{"too_long"}},
+		{"default banned word", "Violet-UniChat-Lantern-58", []string{"banned_word"}},
+		{"configured banned word", "Violet-Campus-Lantern-58", []string{"banned_word"}},
+		{"first name", "Violet-Ana-Lantern-58", []string{"personal_info"}},
+		{"last name without accents", "Violet-Garcia-Lantern-58", []string{"personal_info"}},
+		{"email local part", "anagarcia-Violet-58", []string{"personal_info"}},
+		{"bundled common password", "password", []string{"breached", "too_weak"}},
+		{"common password in capitals", "PASSWORD123", []string{"breached"}},
+		{"in the breached filter", "Violet-Harbor-Lantern-58", []string{"breached"}},
+		{"guessable", "qwerty2024", []string{"too_weak"}},
+		{"every rule at once", "unichat", []string{"too_short", "banned_word", "too_weak"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reasons := passwordReasons(t, test.password)
+			if test.reasons == nil {
+				if reasons != nil {
+					t.Errorf("expected %q to be accepted, got %v", test.password, reasons)
+				}
+				return
+			}
+			for _, expected := range test.reasons {
+				found := false
+				for _, reason := range reasons {
+					found = found || reason == expected
+				}
+				if !found {
+					t.Errorf("expected %q to be rejected as %s, got %v", test.password, expected, reasons)
+				}
+			}
+		})
+	}
+}
+
+func TestSetPasswordPolicyRejectsCorruptFilters(t *testing.T) {
+	path := writeBreachedFilter(t, "Violet-Harbor-Lantern-58")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-8], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := defaultPasswordPolicy
+	policy.BreachedFile = path
+	if err := services.SetPasswordPolicy(policy); err == nil {
+		services.SetPasswordPolicy(defaultPasswordPolicy)
+		t.Errorf("expected a truncated breached filter to be rejected")
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/dto"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Lifetime of a password reset code
+const passwordResetDuration = 15 * time.Minute
+
+// ChangePassword replaces the password of a logged in user, who must know the current one
+func (s *UserService) ChangePassword(userID int, request dto.ChangePasswordRequest) utils.ApiError {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		log.Println("Error getting user by ID:", err)
+		return errUserNotFound()
+	}
+
+	// Accounts from a directory or an external provider have no local password
+	if user.PasswordHash == "" {
+		return apiError(http.StatusConflict, utils.CodeConflict, "password.no_local_password", nil)
+	}
+	if utils.HashSHA256(request.CurrentPassword) != user.PasswordHash {
+		return apiError(http.StatusUnauthorized, utils.CodeInvalidCredentials, "password.invalid", nil)
+	}
+	if request.NewPassword == request.CurrentPassword {
+		return apiError(http.StatusBadRequest, utils.CodeValidation, "password.same_as_current", nil)
+	}
+	if apiErr := setPassword(&user, request.NewPassword); apiErr != nil {
+		return apiErr
+	}
+
+	if err := s.users.UpdatePassword(user.ID, user.PasswordHash); err != nil {
+		log.Println("Error updating password:", err)
+		return utils.NewInternalServerApiError("Error updating password", err)
+	}
+	return nil
+}
+
+// ForgotPassword emails a code to choose a new password. It doesn't tell
+// whether the email belongs to a user, so it can't be used to find accounts
+func (s *UserService) ForgotPassword(email string) utils.ApiError {
+	user, err := s.users.GetByEmail(email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("Error getting user by email:", err)
+			return utils.NewInternalServerApiError("Error getting user", err)
+		}
+		return nil
+	}
+	if !user.IsActive || user.PasswordHash == "" {
+		return nil
+	}
+
+	code, err := s.issueOneTimeCode(user.ID, model.TokenPurposeResetPassword, passwordResetDuration)
+	if err != nil {
+		log.Println("Error saving reset code:", err)
+		return utils.NewInternalServerApiError("Error saving reset code", err)
+	}
+
+	err = utils.SendPasswordResetEmail(user.Email, code, user.FirstName, user.Locale)
+	if err != nil {
+		log.Println("Error sending password reset email:", err)
+		return utils.NewInternalServerApiError("Error sending password reset email", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password with the code sent by ForgotPassword.
+// The code proves the user owns the mailbox, so an unverified email becomes verified
+func (s *UserService) ResetPassword(request dto.ResetPasswordRequest) utils.ApiError {
+	user, err := s.users.GetByEmail(request.Email)
+	if err != nil {
+		log.Println("Error getting user by email:", err)
+		return apiError(http.StatusBadRequest, utils.CodeInvalidCode, "password.reset_code_invalid", nil)
+	}
+
+	// The policy goes first, so a rejected password doesn't use up the code
+	if apiErr := setPassword(&user, request.NewPassword); apiErr != nil {
+		return apiErr
+	}
+
+	err = s.consumeOneTimeCode(user.ID, model.TokenPurposeResetPassword, request.Code)
+	switch {
+	case errors.Is(err, errCodeInvalid), errors.Is(err, errCodeExpired):
+		return apiError(http.StatusBadRequest, utils.CodeInvalidCode, "password.reset_code_invalid", nil)
+	case errors.Is(err, errCodeAttempts):
+		return apiError(http.StatusTooManyRequests, utils.CodeTooManyAttempts, "password.reset_too_many_attempts", nil)
+	case err != nil:
+		return utils.NewInternalServerApiError("Error checking reset code", err)
+	}
+
+	if err := s.users.UpdatePassword(user.ID, user.PasswordHash); err != nil {
+		log.Println("Error updating password:", err)
+		return utils.NewInternalServerApiError("Error updating password", err)
+	}
+	if !user.IsVerified {
+		if err := s.users.VerifyEmail(user.ID); err != nil {
+			log.Println("Error verifying user email:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"backend/config"
+	"backend/dto"
+	"backend/i18n"
+	"backend/utils"
+)
+
+// Registration modes, from registration.mode
+const (
+	RegistrationOpen       = "open"        // anyone can register
+	RegistrationDomain     = "domain"      // only emails of the allowed domains
+	RegistrationInviteOnly = "invite-only" // accounts are only created from invitations
+	RegistrationClosed     = "closed"      // no new accounts
+)
+
+// Error codes of rejected registrations, so the frontend can tell them apart
+const (
+	registrationClosedCode     = "registration_closed"
+	registrationInviteOnlyCode = "registration_invite_only"
+	domainNotAllowedCode       = "email_domain_not_allowed"
+	disposableEmailCode        = "disposable_email"
+)
+
+// Name shown as the source of the blocklist bundled with the service
+const bundledDisposableSource = "bundled"
+
+//go:embed registration/disposable_domains.txt
+var bundledDisposableDomains []byte
+
+// RegistrationPolicy decides who can create an account with Register or on
+// the first login with an external identity provider or the directory.
+// Invitations, imports and SCIM don't go through it, an admin or the
+// identity system of the university decided on those accounts
+type RegistrationPolicy struct {
+	mode           string
+	allowedDomains []string // "unc.edu.ar" or "*.edu.ar"
+	// disposableFile replaces the bundled blocklist when set
+	disposableFile string
+
+	mu               sync.RWMutex // the blocklist can be reloaded
+	disposable       map[string]bool
+	disposableSource string
+}
+
+// NewRegistrationPolicy validates the registration section and loads its
+// blocklist
+func NewRegistrationPolicy(cfg config.Registration) (*RegistrationPolicy, error) {
+	switch cfg.Mode {
+	case RegistrationOpen, RegistrationDomain, RegistrationInviteOnly, RegistrationClosed:
+	default:
+		return nil, fmt.Errorf("registration policy: unknown registration mode %q", cfg.Mode)
+	}
+
+	domains := make([]string, 0, len(cfg.AllowedDomains))
+	for _, value := range cfg.AllowedDomains {
+		domain := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "@"))
+		if strings.TrimPrefix(domain, "*.") == "" || strings.Contains(strings.TrimPrefix(domain, "*."), "*") {
+			return nil, fmt.Errorf("registration policy: invalid allowed domain %q", value)
+		}
+		domains = append(domains, domain)
+	}
+	if cfg.Mode == RegistrationDomain && len(domains) == 0 {
+		return nil, fmt.Errorf("registration policy: registration mode %s needs allowed domains", RegistrationDomain)
+	}
+
+	disposable, source, err := loadDisposableDomains(cfg.DisposableDomainsFile)
+	if err != nil {
+		return nil, fmt.Errorf("registration policy: %w", err)
+	}
+	return &RegistrationPolicy{
+		mode:             cfg.Mode,
+		allowedDomains:   domains,
+		disposableFile:   cfg.DisposableDomainsFile,
+		disposable:       disposable,
+		disposableSource: source,
+	}, nil
+}
+
+// Reload reads the blocklist again, so an updated file is picked up without
+// a restart
+func (p *RegistrationPolicy) Reload() (dto.RegistrationPolicyDto, utils.ApiError) {
+	disposable, source, err := loadDisposableDomains(p.disposableFile)
+	if err != nil {
+		log.Println("Error reloading disposable domains:", err)
+		return dto.RegistrationPolicyDto{}, utils.NewInternalServerApiError("Error reloading disposable domains", err)
+	}
+
+	p.mu.Lock()
+	p.disposable = disposable
+	p.disposableSource = source
+	p.mu.Unlock()
+
+	log.Printf("Loaded %d disposable domains from %s", len(disposable), source)
+	return p.Describe(), nil
+}
+
+// Describe describes the policy for the admin endpoints
+func (p *RegistrationPolicy) Describe() dto.RegistrationPolicyDto {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return dto.RegistrationPolicyDto{
+		Mode:              p.mode,
+		AllowedDomains:    append([]string{}, p.allowedDomains...),
+		DisposableDomains: len(p.disposable),
+		DisposableSource:  p.disposableSource,
+	}
+}
+
+// Check tells whether email may self-register, with the reason as a
+// structured error when it may not
+func (p *RegistrationPolicy) Check(email string) utils.ApiError {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	switch p.mode {
+	case RegistrationClosed:
+		return utils.NewLocalizedApiError("registration.closed", nil, registrationClosedCode, http.StatusForbidden, utils.CauseList{})
+	case RegistrationInviteOnly:
+		return utils.NewLocalizedApiError("registration.invite_only", nil, registrationInviteOnlyCode, http.StatusForbidden, utils.CauseList{})
+	}
+
+	domain := emailDomain(email)
+	if p.mode == RegistrationDomain && !domainAllowed(domain, p.allowedDomains) {
+		return utils.NewLocalizedApiError("registration.domain_not_allowed", i18n.Params{"domain": domain}, domainNotAllowedCode, http.StatusBadRequest, utils.CauseList{
+			map[string]interface{}{"field": "email", "domain": domain, "allowed_domains": p.allowedDomains},
+		})
+	}
+	if blocked, ok := matchDomain(domain, p.disposable); ok {
+		return utils.NewLocalizedApiError("registration.disposable_email", nil, disposableEmailCode, http.StatusBadRequest, utils.CauseList{
+			map[string]interface{}{"field": "email", "domain": blocked},
+		})
+	}
+	return nil
+}
+
+// GetRegistrationPolicy describes the policy Register follows
+func (s *UserService) GetRegistrationPolicy() dto.RegistrationPolicyDto {
+	return s.policy.Describe()
+}
+
+// ReloadDisposableDomains reloads the blocklist of the registration policy
+func (s *UserService) ReloadDisposableDomains() (dto.RegistrationPolicyDto, utils.ApiError) {
+	return s.policy.Reload()
+}
+
+// emailDomain returns the domain in its canonical ASCII form, so lists match
+// internationalized domains
+func emailDomain(email string) string {
+	if canonical, err := utils.CanonicalEmail(email); err == nil {
+		email = canonical
+	}
+	at := strings.LastIndex(email, "@")
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(email[at+1:]), "."))
+}
+
+// domainAllowed matches domain against the allowlist. "*.edu.ar" matches the
+// subdomains of edu.ar but not edu.ar itself
+func domainAllowed(domain string, allowed []string) bool {
+	for _, pattern := range allowed {
+		if suffix, wildcard := strings.CutPrefix(pattern, "*."); wildcard {
+			if strings.HasSuffix(domain, "."+suffix) {
+				return true
+			}
+		} else if domain == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDomain looks domain and its parent domains up in set, so subdomains
+// of a blocked provider are blocked too
+func matchDomain(domain string, set map[string]bool) (string, bool) {
+	for domain != "" {
+		if set[domain] {
+			return domain, true
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return "", false
+}
+
+// loadDisposableDomains reads the blocklist from file, or the bundled one
+func loadDisposableDomains(file string) (map[string]bool, string, error) {
+	data, source := bundledDisposableDomains, bundledDisposableSource
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading disposable domains: %w", err)
+		}
+		data, source = content, file
+	}
+
+	domains := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", fmt.Errorf("error reading disposable domains: %w", err)
+	}
+	return domains, source, nil
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"backend/model"
+	"time"
+)
+
+// The storage the services get injected. The packages of clients/ have the
+// GORM implementations and clients/memory keeps everything in memory, so the
+// services can be tried without a database. Lookups that find nothing fail with
+// gorm.ErrRecordNotFound in both
+
+// UserRepository stores the user accounts
+type UserRepository interface {
+	GetByID(id int) (model.UserModel, error)
+	GetByEmail(email string) (model.UserModel, error)
+	GetByIDs(ids []int) ([]model.UserModel, error)
+	GetByEmails(emails []string) ([]model.UserModel, error)
+	Create(user model.UserModel) (model.UserModel, error)
+	// CreateWithToken creates the user and its first one-time code atomically
+	CreateWithToken(user model.UserModel, token func(userID int) model.VerificationToken) (model.UserModel, error)
+	// CreateBatch creates every user or none, each with the code token builds
+	// for it when that is not nil
+	CreateBatch(users []model.UserModel, token func(index int, userID int) *model.VerificationToken) error
+	Update(user model.UserModel) error
+	UpdatePassword(userID int, passwordHash string) error
+	UpdateLocale(userID int, locale string) error
+	// VerifyEmail also drops the pending email verification codes
+	VerifyEmail(userID int) error
+	// VerifyExternally marks the email verified by an identity provider or a
+	// directory, clearing the password and dropping every pending code
+	VerifyExternally(userID int) error
+	PromoteToAdmin(userID int) error
+	CountRegistrations(from time.Time, to time.Time, onlyVerified bool) (int64, error)
+	CountRegistrationsPerDay(from time.Time, to time.Time) ([]model.DailyCount, error)
+}
+
+// TokenRepository stores the one-time codes sent by email
+type TokenRepository interface {
+	// Replace drops the pending codes of the user with the same purpose
+	Replace(token model.VerificationToken) error
+	GetPending(userID int, purpose string) (model.VerificationToken, error)
+	// AddAttempt fails with gorm.ErrRecordNotFound once the code was used or
+	// tried maxAttempts times
+	AddAttempt(tokenID int, maxAttempts int) error
+	// Consume fails with gorm.ErrRecordNotFound if the code was already used
+	Consume(tokenID int, at time.Time) error
+}
+
+// SessionRepository records logins and activity. The sessions themselves are
+// stateless tokens
+type SessionRepository interface {
+	UpdateLastLogin(userID int, at time.Time) error
+	// TouchLastSeen records activity, throttled by model.ActivityThrottle
+	TouchLastSeen(userID int, at time.Time) error
+	// CountActiveUsersBetween counts the users active on a day of [from, to)
+	CountActiveUsersBetween(from time.Time, to time.Time) (int64, error)
+}
+
+// ScimUserRepository is the UserRepository of the SCIM provisioning, which
+// also pages through filtered users and deletes them. The filters are
+// SQL, so only the GORM repository implements it
+type ScimUserRepository interface {
+	UserRepository
+	Search(where string, args []interface{}, offset int, limit int) ([]model.UserModel, int64, error)
+	// Delete also drops the rows that belong to the user
+	Delete(userID int) error
+}
+
+// InvitationRepository stores the invitations to create an account
+type InvitationRepository interface {
+	// Create revokes the pending invitations of the same email
+	Create(invitation model.Invitation) (model.Invitation, error)
+	GetByID(id int) (model.Invitation, error)
+	// List and Revoke only see the invitations of invitedBy, or all with 0
+	List(invitedBy int) ([]model.Invitation, error)
+	Revoke(id int, invitedBy int, at time.Time) error
+	// Accept creates the user, or updates the one an import created when
+	// user.ID is set, and fails with gorm.ErrRecordNotFound if the invitation
+	// is no longer pending
+	Accept(invitationID int, user model.UserModel, at time.Time) (model.UserModel, error)
+}
+
+// FederatedIdentityRepository stores the provider subjects linked to users
+type FederatedIdentityRepository interface {
+	Get(provider string, subject string) (model.FederatedIdentity, error)
+	Create(identity model.FederatedIdentity) (model.FederatedIdentity, error)
+	UpdateLogin(id int, at time.Time) error
+}
+
+// AccessTokenRepository stores the personal access tokens, by the hash of
+// their value
+type AccessTokenRepository interface {
+	Create(token model.PersonalAccessToken) (model.PersonalAccessToken, error)
+	GetByHash(tokenHash string) (model.PersonalAccessToken, error)
+	// ListByUser returns the tokens of the user, newest first
+	ListByUser(userID int) ([]model.PersonalAccessToken, error)
+	// Revoke fails with gorm.ErrRecordNotFound unless the token is the user's
+	// and still active
+	Revoke(userID int, tokenID int, at time.Time) error
+	// TouchLastUsed records a use, throttled by model.ActivityThrottle
+	TouchLastUsed(tokenID int, at time.Time) error
+}
+
+// OAuthClientRepository stores the registered OAuth clients
+type OAuthClientRepository interface {
+	Create(client model.OAuthClient) (model.OAuthClient, error)
+	GetByClientID(clientID string) (model.OAuthClient, error)
+	List() ([]model.OAuthClient, error)
+	// Deactivate fails with gorm.ErrRecordNotFound for an unknown client
+	Deactivate(clientID string) error
+}
+
+// AuthorizationCodeRepository stores the codes of the authorization code
+// flow, by the hash of their value
+type AuthorizationCodeRepository interface {
+	Create(code model.AuthorizationCode) (model.AuthorizationCode, error)
+	GetByHash(codeHash string) (model.AuthorizationCode, error)
+	// Consume fails with gorm.ErrRecordNotFound if the code was already used
+	Consume(id int, at time.Time) error
+}
+
+// GroupRepository stores the SCIM groups and their members. Like the
+// ScimUserRepository, the searches are SQL and only the GORM repository
+// implements it
+type GroupRepository interface {
+	// Create stores the group and its members together
+	Create(group model.UserGroup, memberIDs []int) (model.UserGroup, error)
+	GetByID(id int) (model.UserGroup, error)
+	GetByDisplayName(displayName string) (model.UserGroup, error)
+	Search(where string, args []interface{}, offset int, limit int) ([]model.UserGroup, int64, error)
+	GetMembers(groupIDs []int) ([]model.GroupMember, error)
+	GetMemberships(userIDs []int) ([]model.GroupMembership, error)
+	// Update leaves the members as they are, Replace replaces them all
+	Update(group model.UserGroup) error
+	Replace(group model.UserGroup, memberIDs []int) (model.UserGroup, error)
+	UpdateMembers(groupID int, add []int, remove []int) error
+	// Delete also drops the memberships
+	Delete(id int) error
+}
+
+// OutboxRepository stores the emails waiting to be sent
+type OutboxRepository interface {
+	Create(email model.OutboxEmail) (model.OutboxEmail, error)
+	// Claim leases up to limit due emails to the caller until now+lease. An
+	// email is only claimed once, even by several instances
+	Claim(now time.Time, lease time.Duration, limit int) ([]model.OutboxEmail, error)
+	MarkSent(id int, attempts int, at time.Time) error
+	Retry(id int, attempts int, nextAttemptAt time.Time, lastError string) error
+	// Fail moves an email to the dead letter state, without its body when
+	// dropBody is set
+	Fail(id int, attempts int, lastError string, dropBody bool) error
+	GetByID(id int) (model.OutboxEmail, error)
+	// List returns the emails with status, newest first
+	List(status string, limit int) ([]model.OutboxEmail, error)
+	// Requeue fails with gorm.ErrRecordNotFound unless the email failed
+	Requeue(id int, now time.Time) (model.OutboxEmail, error)
+}
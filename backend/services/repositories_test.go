@@ -0,0 +1,669 @@
+package services_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	accessTokenClient "backend/clients/accesstoken"
+	emailClient "backend/clients/email"
+	memoryClient "backend/clients/memory"
+	oauthClient "backend/clients/oauth"
+	userCLient "backend/clients/user"
+	"backend/config"
+	"backend/db"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// repositorySet is the storage under check. email gives every check its own
+// addresses, so they can run more than once on the same database
+type repositorySet struct {
+	users              services.UserRepository
+	tokens             services.TokenRepository
+	sessions           services.SessionRepository
+	accessTokens       services.AccessTokenRepository
+	oauthClients       services.OAuthClientRepository
+	authorizationCodes services.AuthorizationCodeRepository
+	outbox             services.OutboxRepository
+	prefix             string
+	emails             int
+}
+
+func (r *repositorySet) email() string {
+	r.emails++
+	return fmt.Sprintf("%s-%d@example.com", r.prefix, r.emails)
+}
+
+func (r *repositorySet) createUser() (model.UserModel, error) {
+	return r.users.Create(model.UserModel{
+		Email:        r.email(),
+		FirstName:    "Check",
+		LastName:     "Repository",
+		PasswordHash: utils.HashSHA256("check-password"),
+	})
+}
+
+var repositoryChecks = []struct {
+	name string
+	run  func(r *repositorySet) error
+}{
+	{"create and get users", checkCreateAndGetUsers},
+	{"update users", checkUpdateUsers},
+	{"get users by IDs", checkGetUsersByIDs},
+	{"one-time codes", checkOneTimeCodes},
+	{"verify emails", checkVerifyEmails},
+	{"count registrations", checkCountRegistrations},
+	{"sessions", checkSessions},
+	{"access tokens", checkAccessTokens},
+	{"oauth clients", checkOAuthClients},
+	{"authorization codes", checkAuthorizationCodes},
+	{"email outbox", checkOutbox},
+}
+
+// TestRepositories runs the behaviors the services expect from their storage
+// on the memory store and on the GORM repositories with a migrated SQLite
+// file. TEST_POSTGRES_DSN and TEST_MYSQL_DSN add those databases; the checks
+// create users, so they should be databases meant for it
+func TestRepositories(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		store := memoryClient.NewStore()
+		runRepositoryChecks(t, &repositorySet{
+			users:              store.Users(),
+			tokens:             store.Tokens(),
+			sessions:           store.Sessions(),
+			accessTokens:       store.AccessTokens(),
+			oauthClients:       store.OAuthClients(),
+			authorizationCodes: store.AuthorizationCodes(),
+			outbox:             store.Outbox(),
+		})
+	})
+
+	databases := []struct {
+		driver string
+		dsn    string
+	}{
+		{"sqlite", ""},
+		{"postgres", os.Getenv("TEST_POSTGRES_DSN")},
+		{"mysql", os.Getenv("TEST_MYSQL_DSN")},
+	}
+	for _, database := range databases {
+		t.Run(database.driver, func(t *testing.T) {
+			dsn := database.dsn
+			if database.driver == "sqlite" {
+				dsn = db.SQLiteDSN(filepath.Join(t.TempDir(), "check.db"))
+			} else if dsn == "" {
+				t.Skipf("TEST_%s_DSN is not set", strings.ToUpper(database.driver))
+			}
+
+			if err := db.Connect(config.Database{Driver: database.driver, DSN: dsn}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if _, err := db.MigrateUp(); err != nil {
+				t.Fatal(err)
+			}
+			runRepositoryChecks(t, &repositorySet{
+				users:              userCLient.NewUserRepository(db.DB),
+				tokens:             userCLient.NewTokenRepository(db.DB),
+				sessions:           userCLient.NewSessionRepository(db.DB),
+				accessTokens:       accessTokenClient.NewAccessTokenRepository(db.DB),
+				oauthClients:       oauthClient.NewClientRepository(db.DB),
+				authorizationCodes: oauthClient.NewAuthorizationCodeRepository(db.DB),
+				outbox:             emailClient.NewOutboxRepository(db.DB),
+			})
+		})
+	}
+}
+
+// runRepositoryChecks runs each check as a subtest on the same storage
+func runRepositoryChecks(t *testing.T, set *repositorySet) {
+	set.prefix = fmt.Sprintf("check-%d", time.Now().UnixNano())
+	for _, check := range repositoryChecks {
+		t.Run(check.name, func(t *testing.T) {
+			if err := check.run(set); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// notFound fails unless err is the gorm.ErrRecordNotFound the services expect
+func notFound(what string, err error) error {
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%s: expected gorm.ErrRecordNotFound, got %v", what, err)
+	}
+	return nil
+}
+
+// sameTime compares timestamps at the millisecond precision of the columns
+func sameTime(a time.Time, b time.Time) bool {
+	return a.Sub(b).Abs() < time.Millisecond
+}
+
+func checkCreateAndGetUsers(r *repositorySet) error {
+	email := r.email()
+	created, err := r.users.Create(model.UserModel{
+		Email:     strings.ToUpper(email),
+		FirstName: "Check",
+		LastName:  "Repository",
+	})
+	if err != nil {
+		return err
+	}
+	if created.ID == 0 {
+		return fmt.Errorf("Create returned no ID")
+	}
+
+	byID, err := r.users.GetByID(created.ID)
+	if err != nil {
+		return fmt.Errorf("GetByID: %w", err)
+	}
+	if byID.Email != created.Email || byID.NormalizedEmail == nil {
+		return fmt.Errorf("GetByID returned email %q, created %q", byID.Email, created.Email)
+	}
+	if byID.Role != utils.RoleStudent || !byID.IsActive || byID.IsVerified {
+		return fmt.Errorf("new users should be active unverified students, got role %q, active %t, verified %t", byID.Role, byID.IsActive, byID.IsVerified)
+	}
+
+	byEmail, err := r.users.GetByEmail(email)
+	if err != nil {
+		return fmt.Errorf("GetByEmail: %w", err)
+	}
+	if byEmail.ID != created.ID {
+		return fmt.Errorf("GetByEmail returned user %d, expected %d", byEmail.ID, created.ID)
+	}
+
+	if _, err := r.users.Create(model.UserModel{Email: email, FirstName: "Check", LastName: "Repository"}); err == nil {
+		return fmt.Errorf("Create accepted the email of another user")
+	}
+	_, err = r.users.GetByID(created.ID + 1000000)
+	if err := notFound("GetByID of a missing user", err); err != nil {
+		return err
+	}
+	_, err = r.users.GetByEmail(r.email())
+	return notFound("GetByEmail of a missing user", err)
+}
+
+func checkUpdateUsers(r *repositorySet) error {
+	user, err := r.createUser()
+	if err != nil {
+		return err
+	}
+	// read it back, the stored defaults may not be in the created user
+	if user, err = r.users.GetByID(user.ID); err != nil {
+		return err
+	}
+
+	user.FirstName = "Updated"
+	if err := r.users.Update(user); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	if err := r.users.UpdatePassword(user.ID, utils.HashSHA256("new-password")); err != nil {
+		return fmt.Errorf("UpdatePassword: %w", err)
+	}
+	if err := r.users.UpdateLocale(user.ID, "es"); err != nil {
+		return fmt.Errorf("UpdateLocale: %w", err)
+	}
+	if err := r.users.PromoteToAdmin(user.ID); err != nil {
+		return fmt.Errorf("PromoteToAdmin: %w", err)
+	}
+
+	stored, err := r.users.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case stored.FirstName != "Updated":
+		return fmt.Errorf("Update didn't save the first name, got %q", stored.FirstName)
+	case stored.PasswordHash != utils.HashSHA256("new-password"):
+		return fmt.Errorf("UpdatePassword didn't save the hash")
+	case stored.Locale != "es":
+		return fmt.Errorf("UpdateLocale didn't save the locale, got %q", stored.Locale)
+	case !stored.IsAdmin || stored.Role != utils.RoleAdmin:
+		return fmt.Errorf("PromoteToAdmin left admin %t and role %q", stored.IsAdmin, stored.Role)
+	}
+	return notFound("PromoteToAdmin of a missing user", r.users.PromoteToAdmin(user.ID+1000000))
+}
+
+func checkGetUsersByIDs(r *repositorySet) error {
+	first, err := r.createUser()
+	if err != nil {
+		return err
+	}
+	second, err := r.createUser()
+	if err != nil {
+		return err
+	}
+
+	users, err := r.users.GetByIDs([]int{second.ID, second.ID + 1000000, first.ID})
+	if err != nil {
+		return err
+	}
+	if len(users) != 2 || users[0].ID != first.ID || users[1].ID != second.ID {
+		return fmt.Errorf("GetByIDs should return users %d and %d in order, got %d users", first.ID, second.ID, len(users))
+	}
+	return nil
+}
+
+func checkOneTimeCodes(r *repositorySet) error {
+	expires := time.Now().Add(time.Hour)
+	user, err := r.users.CreateWithToken(model.UserModel{Email: r.email(), FirstName: "Check", LastName: "Repository"},
+		func(userID int) model.VerificationToken {
+			return model.VerificationToken{UserID: userID, Purpose: model.TokenPurposeResetPassword, TokenHash: "first", ExpiresAt: expires}
+		})
+	if err != nil {
+		return fmt.Errorf("CreateWithToken: %w", err)
+	}
+
+	pending, err := r.tokens.GetPending(user.ID, model.TokenPurposeResetPassword)
+	if err != nil {
+		return fmt.Errorf("GetPending after CreateWithToken: %w", err)
+	}
+	if pending.TokenHash != "first" || pending.UserID != user.ID {
+		return fmt.Errorf("CreateWithToken stored the wrong code")
+	}
+
+	err = r.tokens.Replace(model.VerificationToken{UserID: user.ID, Purpose: model.TokenPurposeResetPassword, TokenHash: "second", ExpiresAt: expires})
+	if err != nil {
+		return fmt.Errorf("Replace: %w", err)
+	}
+	if pending, err = r.tokens.GetPending(user.ID, model.TokenPurposeResetPassword); err != nil {
+		return fmt.Errorf("GetPending after Replace: %w", err)
+	}
+	if pending.TokenHash != "second" {
+		return fmt.Errorf("GetPending returned the replaced code")
+	}
+	_, err = r.tokens.GetPending(user.ID, model.TokenPurposeLoginCode)
+	if err := notFound("GetPending of another purpose", err); err != nil {
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.tokens.AddAttempt(pending.ID, 2); err != nil {
+			return fmt.Errorf("AddAttempt %d of 2: %w", i+1, err)
+		}
+	}
+	if err := notFound("AddAttempt over the limit", r.tokens.AddAttempt(pending.ID, 2)); err != nil {
+		return err
+	}
+
+	if err := r.tokens.Consume(pending.ID, time.Now()); err != nil {
+		return fmt.Errorf("Consume: %w", err)
+	}
+	if err := notFound("Consume of a used code", r.tokens.Consume(pending.ID, time.Now())); err != nil {
+		return err
+	}
+	_, err = r.tokens.GetPending(user.ID, model.TokenPurposeResetPassword)
+	return notFound("GetPending of a used code", err)
+}
+
+func checkVerifyEmails(r *repositorySet) error {
+	user, err := r.users.CreateWithToken(model.UserModel{Email: r.email(), FirstName: "Check", LastName: "Repository"},
+		func(userID int) model.VerificationToken {
+			return model.VerificationToken{UserID: userID, Purpose: model.TokenPurposeVerifyEmail, TokenHash: "code", ExpiresAt: time.Now().Add(time.Hour)}
+		})
+	if err != nil {
+		return fmt.Errorf("CreateWithToken: %w", err)
+	}
+
+	if err := r.users.VerifyEmail(user.ID); err != nil {
+		return fmt.Errorf("VerifyEmail: %w", err)
+	}
+	stored, err := r.users.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+	if !stored.IsVerified {
+		return fmt.Errorf("VerifyEmail didn't mark the user verified")
+	}
+	_, err = r.tokens.GetPending(user.ID, model.TokenPurposeVerifyEmail)
+	return notFound("GetPending after VerifyEmail", err)
+}
+
+func checkCountRegistrations(r *repositorySet) error {
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	count := func() (int64, int64, int64, error) {
+		total, err := r.users.CountRegistrations(from, to, false)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("CountRegistrations: %w", err)
+		}
+		verified, err := r.users.CountRegistrations(from, to, true)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("CountRegistrations of verified users: %w", err)
+		}
+		days, err := r.users.CountRegistrationsPerDay(from, to)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("CountRegistrationsPerDay: %w", err)
+		}
+		var perDay int64
+		for _, day := range days {
+			if _, err := time.Parse("2006-01-02", day.Day); err != nil {
+				return 0, 0, 0, fmt.Errorf("CountRegistrationsPerDay returned the day %q, expected YYYY-MM-DD", day.Day)
+			}
+			perDay += day.Count
+		}
+		return total, verified, perDay, nil
+	}
+
+	total, verified, perDay, err := count()
+	if err != nil {
+		return err
+	}
+	if _, err := r.createUser(); err != nil {
+		return err
+	}
+	newTotal, newVerified, newPerDay, err := count()
+	if err != nil {
+		return err
+	}
+	if newTotal != total+1 || newPerDay != perDay+1 || newVerified != verified {
+		return fmt.Errorf("an unverified registration changed the counts from %d/%d/%d to %d/%d/%d (total/verified/per day)",
+			total, verified, perDay, newTotal, newVerified, newPerDay)
+	}
+	return nil
+}
+
+func checkSessions(r *repositorySet) error {
+	user, err := r.createUser()
+	if err != nil {
+		return err
+	}
+	// noon, so the throttle window doesn't cross midnight
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	noon := today.Add(12 * time.Hour)
+	tomorrow := today.AddDate(0, 0, 1)
+
+	active, err := r.sessions.CountActiveUsersBetween(today, tomorrow)
+	if err != nil {
+		return fmt.Errorf("CountActiveUsersBetween: %w", err)
+	}
+	if err := r.sessions.UpdateLastLogin(user.ID, noon); err != nil {
+		return fmt.Errorf("UpdateLastLogin: %w", err)
+	}
+	// within the throttle window the last activity is kept
+	if err := r.sessions.TouchLastSeen(user.ID, noon.Add(time.Minute)); err != nil {
+		return fmt.Errorf("TouchLastSeen: %w", err)
+	}
+
+	stored, err := r.users.GetByID(user.ID)
+	if err != nil {
+		return err
+	}
+	if stored.LastLoginAt == nil || !sameTime(*stored.LastLoginAt, noon) {
+		return fmt.Errorf("UpdateLastLogin didn't save the login time")
+	}
+	if stored.LastSeenAt == nil || !sameTime(*stored.LastSeenAt, noon) {
+		return fmt.Errorf("TouchLastSeen should skip writes within the throttle window")
+	}
+
+	later := noon.Add(model.ActivityThrottle + time.Minute)
+	if err := r.sessions.TouchLastSeen(user.ID, later); err != nil {
+		return fmt.Errorf("TouchLastSeen: %w", err)
+	}
+	if stored, err = r.users.GetByID(user.ID); err != nil {
+		return err
+	}
+	if stored.LastSeenAt == nil || !sameTime(*stored.LastSeenAt, later) {
+		return fmt.Errorf("TouchLastSeen didn't save the activity after the throttle window")
+	}
+
+	newActive, err := r.sessions.CountActiveUsersBetween(today, tomorrow)
+	if err != nil {
+		return fmt.Errorf("CountActiveUsersBetween: %w", err)
+	}
+	if newActive != active+1 {
+		return fmt.Errorf("CountActiveUsersBetween went from %d to %d after a login", active, newActive)
+	}
+
+	// the first activity of a day is written even within the throttle window,
+	// and the days before stay counted after last_seen_at moves on
+	if err := r.sessions.TouchLastSeen(user.ID, tomorrow.Add(-time.Minute)); err != nil {
+		return fmt.Errorf("TouchLastSeen: %w", err)
+	}
+	if err := r.sessions.TouchLastSeen(user.ID, tomorrow.Add(time.Minute)); err != nil {
+		return fmt.Errorf("TouchLastSeen: %w", err)
+	}
+	for _, day := range []time.Time{today, tomorrow} {
+		counted, err := r.sessions.CountActiveUsersBetween(day, day.AddDate(0, 0, 1))
+		if err != nil {
+			return fmt.Errorf("CountActiveUsersBetween: %w", err)
+		}
+		if counted == 0 {
+			return fmt.Errorf("the user isn't counted as active on %s", day.Format("2006-01-02"))
+		}
+	}
+	if both, err := r.sessions.CountActiveUsersBetween(today, tomorrow.AddDate(0, 0, 1)); err != nil || both != newActive {
+		return fmt.Errorf("a user active on two days should be counted once, got %d (%v)", both, err)
+	}
+	return r.sessions.UpdateLastLogin(user.ID+1000000, now)
+}
+
+func checkAccessTokens(r *repositorySet) error {
+	user, err := r.createUser()
+	if err != nil {
+		return err
+	}
+	// noon, so the throttle window doesn't cross midnight
+	now := time.Now()
+	now = time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, time.Local)
+	first, err := r.accessTokens.Create(model.PersonalAccessToken{UserID: user.ID, Name: "first", TokenHash: utils.HashSHA256(r.email()), Prefix: "pat_a", Scopes: utils.ScopeUsersRead})
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+	second, err := r.accessTokens.Create(model.PersonalAccessToken{UserID: user.ID, Name: "second", TokenHash: utils.HashSHA256(r.email()), Prefix: "pat_b", Scopes: utils.ScopeUsersRead})
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+	if _, err := r.accessTokens.Create(model.PersonalAccessToken{UserID: user.ID, Name: "copy", TokenHash: first.TokenHash, Prefix: "pat_a", Scopes: utils.ScopeUsersRead}); err == nil {
+		return fmt.Errorf("Create accepted a duplicated hash")
+	}
+
+	found, err := r.accessTokens.GetByHash(second.TokenHash)
+	if err != nil || found.ID != second.ID {
+		return fmt.Errorf("GetByHash: got %d, %v", found.ID, err)
+	}
+	if _, err := r.accessTokens.GetByHash(utils.HashSHA256("missing")); notFound("GetByHash", err) != nil {
+		return notFound("GetByHash", err)
+	}
+	listed, err := r.accessTokens.ListByUser(user.ID)
+	if err != nil {
+		return fmt.Errorf("ListByUser: %w", err)
+	}
+	if len(listed) != 2 || listed[0].ID != second.ID {
+		return fmt.Errorf("ListByUser should list the newest token first, got %v", listed)
+	}
+
+	if err := r.accessTokens.TouchLastUsed(first.ID, now); err != nil {
+		return fmt.Errorf("TouchLastUsed: %w", err)
+	}
+	if err := r.accessTokens.TouchLastUsed(first.ID, now.Add(time.Minute)); err != nil {
+		return fmt.Errorf("TouchLastUsed: %w", err)
+	}
+	if found, err = r.accessTokens.GetByHash(first.TokenHash); err != nil {
+		return err
+	}
+	if found.LastUsedAt == nil || !sameTime(*found.LastUsedAt, now) {
+		return fmt.Errorf("TouchLastUsed should skip writes within the throttle window")
+	}
+
+	if err := notFound("Revoke of another user", r.accessTokens.Revoke(user.ID+1000000, first.ID, now)); err != nil {
+		return err
+	}
+	if err := r.accessTokens.Revoke(user.ID, first.ID, now); err != nil {
+		return fmt.Errorf("Revoke: %w", err)
+	}
+	return notFound("second Revoke", r.accessTokens.Revoke(user.ID, first.ID, now))
+}
+
+func checkOAuthClients(r *repositorySet) error {
+	clientID := r.email()
+	created, err := r.oauthClients.Create(model.OAuthClient{ClientID: clientID, Name: "check", Scopes: utils.ScopeUsersRead, GrantTypes: services.GrantClientCredentials, IsActive: true})
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+	if _, err := r.oauthClients.Create(model.OAuthClient{ClientID: clientID, Name: "copy", Scopes: utils.ScopeUsersRead, GrantTypes: services.GrantClientCredentials}); err == nil {
+		return fmt.Errorf("Create accepted a duplicated client_id")
+	}
+
+	found, err := r.oauthClients.GetByClientID(clientID)
+	if err != nil || found.ID != created.ID || !found.IsActive {
+		return fmt.Errorf("GetByClientID: got %+v, %v", found, err)
+	}
+	if _, err := r.oauthClients.GetByClientID(r.email()); notFound("GetByClientID", err) != nil {
+		return notFound("GetByClientID", err)
+	}
+	listed, err := r.oauthClients.List()
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if len(listed) == 0 || listed[len(listed)-1].ID != created.ID {
+		return fmt.Errorf("List should end with the last created client")
+	}
+
+	if err := r.oauthClients.Deactivate(clientID); err != nil {
+		return fmt.Errorf("Deactivate: %w", err)
+	}
+	if found, err = r.oauthClients.GetByClientID(clientID); err != nil || found.IsActive {
+		return fmt.Errorf("Deactivate didn't deactivate the client: %+v, %v", found, err)
+	}
+	return notFound("Deactivate", r.oauthClients.Deactivate(r.email()))
+}
+
+func checkAuthorizationCodes(r *repositorySet) error {
+	user, err := r.createUser()
+	if err != nil {
+		return err
+	}
+	created, err := r.authorizationCodes.Create(model.AuthorizationCode{
+		CodeHash:            utils.HashSHA256(r.email()),
+		ClientID:            "check",
+		UserID:              user.ID,
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               utils.ScopeOpenID,
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+		ExpiresAt:           time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+
+	found, err := r.authorizationCodes.GetByHash(created.CodeHash)
+	if err != nil || found.ID != created.ID || found.ConsumedAt != nil {
+		return fmt.Errorf("GetByHash: got %+v, %v", found, err)
+	}
+	if _, err := r.authorizationCodes.GetByHash(utils.HashSHA256("missing")); notFound("GetByHash", err) != nil {
+		return notFound("GetByHash", err)
+	}
+	if err := r.authorizationCodes.Consume(created.ID, time.Now()); err != nil {
+		return fmt.Errorf("Consume: %w", err)
+	}
+	// a code is only exchanged once
+	return notFound("second Consume", r.authorizationCodes.Consume(created.ID, time.Now()))
+}
+
+func checkOutbox(r *repositorySet) error {
+	now := time.Now()
+	created, err := r.outbox.Create(model.OutboxEmail{
+		Kind:          "check",
+		Recipient:     r.email(),
+		Subject:       "Check",
+		TextBody:      "123456",
+		Status:        model.EmailStatusPending,
+		NextAttemptAt: now.Add(-time.Minute),
+	})
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+
+	// other emails of the database may be due too
+	claimed := func(at time.Time) (bool, error) {
+		emails, err := r.outbox.Claim(at, time.Minute, 1000)
+		if err != nil {
+			return false, fmt.Errorf("Claim: %w", err)
+		}
+		for _, email := range emails {
+			if email.ID == created.ID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if ok, err := claimed(now); err != nil || !ok {
+		return fmt.Errorf("Claim didn't take a due email: %v", err)
+	}
+	if ok, err := claimed(now); err != nil || ok {
+		return fmt.Errorf("Claim took an email leased to another worker: %v", err)
+	}
+	// the worker died, the lease runs out
+	if ok, err := claimed(now.Add(2 * time.Minute)); err != nil || !ok {
+		return fmt.Errorf("Claim didn't take back an abandoned email: %v", err)
+	}
+
+	if err := r.outbox.Fail(created.ID, 3, "smtp down", false); err != nil {
+		return fmt.Errorf("Fail: %w", err)
+	}
+	failed, err := r.outbox.List(model.EmailStatusFailed, 1000)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if len(failed) == 0 || failed[0].ID != created.ID || failed[0].LastError != "smtp down" {
+		return fmt.Errorf("List should start with the failed email")
+	}
+	if found, err := r.outbox.GetByID(created.ID); err != nil || found.TextBody != "123456" {
+		return fmt.Errorf("GetByID should keep the body of a failed email: got %+v, %v", found, err)
+	}
+	if _, err := r.outbox.GetByID(created.ID + 1000000); notFound("GetByID of a missing email", err) != nil {
+		return notFound("GetByID of a missing email", err)
+	}
+
+	secret, err := r.outbox.Create(model.OutboxEmail{
+		Kind:          "check",
+		Recipient:     r.email(),
+		Subject:       "Check",
+		TextBody:      "654321",
+		HTMLBody:      "<p>654321</p>",
+		Status:        model.EmailStatusPending,
+		NextAttemptAt: now.Add(time.Hour),
+	})
+	if err != nil {
+		return fmt.Errorf("Create: %w", err)
+	}
+	if err := r.outbox.Fail(secret.ID, 3, "smtp down", true); err != nil {
+		return fmt.Errorf("Fail: %w", err)
+	}
+	if found, err := r.outbox.GetByID(secret.ID); err != nil || found.TextBody != "" || found.HTMLBody != "" {
+		return fmt.Errorf("Fail should drop the body when asked: got %+v, %v", found, err)
+	}
+
+	requeued, err := r.outbox.Requeue(created.ID, now)
+	if err != nil || requeued.Status != model.EmailStatusPending || requeued.Attempts != 0 {
+		return fmt.Errorf("Requeue: got %+v, %v", requeued, err)
+	}
+	if _, err := r.outbox.Requeue(created.ID, now); notFound("Requeue of a pending email", err) != nil {
+		return notFound("Requeue of a pending email", err)
+	}
+
+	if err := r.outbox.MarkSent(created.ID, 1, now); err != nil {
+		return fmt.Errorf("MarkSent: %w", err)
+	}
+	sent, err := r.outbox.List(model.EmailStatusSent, 1000)
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	for _, email := range sent {
+		if email.ID == created.ID && (email.TextBody != "" || email.SentAt == nil) {
+			return fmt.Errorf("MarkSent should record the time and drop the body")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/dto"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Filterable group attributes, in lower case as SCIM names are case insensitive
+var scimGroupColumns = map[string]scimColumn{
+	"id":                {"id", scimKindID},
+	"displayname":       {"display_name", scimKindString},
+	"externalid":        {"external_id", scimKindString},
+	"members":           {"id IN (SELECT group_id FROM group_members WHERE user_id = ?)", scimKindMember},
+	"members.value":     {"id IN (SELECT group_id FROM group_members WHERE user_id = ?)", scimKindMember},
+	"meta.created":      {"created_at", scimKindTime},
+	"meta.lastmodified": {"updated_at", scimKindTime},
+}
+
+// ListScimGroups pages through the groups matching the SCIM filter
+func (s *ScimService) ListScimGroups(query dto.ScimListQuery, excludeMembers bool) (dto.ScimListResponse, utils.ApiError) {
+	where, args, apiErr := scimWhere(query.Filter, scimGroupColumns)
+	if apiErr != nil {
+		return dto.ScimListResponse{}, apiErr
+	}
+
+	startIndex, count := scimPage(query)
+	groups, total, err := s.groups.Search(where, args, startIndex-1, count)
+	if err != nil {
+		log.Println("Error searching scim groups:", err)
+		return dto.ScimListResponse{}, utils.NewInternalServerApiError("error searching groups", err)
+	}
+
+	resources, apiErr := s.scimGroupsFromModels(groups, excludeMembers)
+	if apiErr != nil {
+		return dto.ScimListResponse{}, apiErr
+	}
+
+	return dto.ScimListResponse{
+		Schemas:      []string{utils.ScimListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+// GetScimGroup returns a single group
+func (s *ScimService) GetScimGroup(id string, excludeMembers bool) (dto.ScimGroup, utils.ApiError) {
+	group, apiErr := s.getScimGroupModel(id)
+	if apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+	return s.scimGroupFromModel(group, excludeMembers)
+}
+
+// CreateScimGroup creates a group with its initial members
+func (s *ScimService) CreateScimGroup(request dto.ScimGroup) (dto.ScimGroup, utils.ApiError) {
+	group := model.UserGroup{ExternalID: request.ExternalID}
+	if apiErr := s.setScimDisplayName(&group, request.DisplayName); apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+	memberIDs, apiErr := s.scimMemberIDs(request.Members)
+	if apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+
+	created, err := s.groups.Create(group, memberIDs)
+	if err != nil {
+		log.Println("Error creating scim group:", err)
+		return dto.ScimGroup{}, utils.NewInternalServerApiError("error creating group", err)
+	}
+	return s.scimGroupFromModel(created, false)
+}
+
+// ReplaceScimGroup overwrites the group and its whole member list (PUT)
+func (s *ScimService) ReplaceScimGroup(id string, request dto.ScimGroup) (dto.ScimGroup, utils.ApiError) {
+	group, apiErr := s.getScimGroupModel(id)
+	if apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+
+	group.ExternalID = request.ExternalID
+	if apiErr := s.setScimDisplayName(&group, request.DisplayName); apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+	memberIDs, apiErr := s.scimMemberIDs(request.Members)
+	if apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+
+	if _, err := s.groups.Replace(group, memberIDs); err != nil {
+		log.Println("Error replacing scim group:", err)
+		return dto.ScimGroup{}, utils.NewInternalServerApiError("error updating group", err)
+	}
+	return s.GetScimGroup(id, false)
+}
+
+// PatchScimGroup applies add, replace and remove operations. Member changes
+// are applied incrementally, so large courses don't have to be resent
+func (s *ScimService) PatchScimGroup(id string, request dto.ScimPatchRequest) (dto.ScimGroup, utils.ApiError) {
+	group, apiErr := s.getScimGroupModel(id)
+	if apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+
+	patch := scimGroupPatch{scim: s, group: group}
+	for _, operation := range request.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return dto.ScimGroup{}, scimError(utils.ScimInvalidSyntax, "unknown patch operation "+operation.Op)
+		}
+		if apiErr := patch.apply(op, operation.Path, operation.Value); apiErr != nil {
+			return dto.ScimGroup{}, apiErr
+		}
+	}
+
+	if patch.replaceMembers {
+		// the member list was replaced as a whole, the group is saved with it
+		if _, err := s.groups.Replace(patch.group, patch.add); err != nil {
+			log.Println("Error patching scim group:", err)
+			return dto.ScimGroup{}, utils.NewInternalServerApiError("error updating group", err)
+		}
+		return s.GetScimGroup(id, false)
+	}
+
+	if patch.attributesChanged {
+		if err := s.groups.Update(patch.group); err != nil {
+			log.Println("Error patching scim group:", err)
+			return dto.ScimGroup{}, utils.NewInternalServerApiError("error updating group", err)
+		}
+	}
+	if len(patch.add) > 0 || len(patch.remove) > 0 {
+		if err := s.groups.UpdateMembers(group.ID, patch.add, patch.remove); err != nil {
+			log.Println("Error patching scim group members:", err)
+			return dto.ScimGroup{}, utils.NewInternalServerApiError("error updating group", err)
+		}
+	}
+	return s.GetScimGroup(id, false)
+}
+
+// DeleteScimGroup removes a group and its memberships, not the users
+func (s *ScimService) DeleteScimGroup(id string) utils.ApiError {
+	groupID, err := strconv.Atoi(id)
+	if err != nil {
+		return utils.NewNotFoundApiError("group " + id + " not found")
+	}
+
+	if err := s.groups.Delete(groupID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.NewNotFoundApiError("group " + id + " not found")
+		}
+		log.Println("Error deleting scim group:", err)
+		return utils.NewInternalServerApiError("error deleting group", err)
+	}
+	return nil
+}
+
+// scimGroupPatch collects the effect of the operations of a PATCH request
+type scimGroupPatch struct {
+	scim              *ScimService // checks the members and the display name
+	group             model.UserGroup
+	attributesChanged bool
+	replaceMembers    bool  // members were replaced, add holds the whole new list
+	add               []int // users to add
+	remove            []int // users to remove
+}
+
+func (p *scimGroupPatch) apply(op string, path string, value json.RawMessage) utils.ApiError {
+	if path == "" {
+		if op == "remove" {
+			return scimError(utils.ScimNoTarget, "remove operations need a path")
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(value, &values); err != nil {
+			return scimError(utils.ScimInvalidSyntax, "patch value without a path must be an object")
+		}
+		for key, keyValue := range values {
+			if apiErr := p.apply(op, key, keyValue); apiErr != nil {
+				return apiErr
+			}
+		}
+		return nil
+	}
+
+	attribute, _ := splitScimPath(path)
+	switch attribute {
+	case "displayname":
+		if op == "remove" {
+			return scimError(utils.ScimMutability, "displayName can't be removed")
+		}
+		text, apiErr := scimString(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		p.attributesChanged = true
+		return p.scim.setScimDisplayName(&p.group, text)
+	case "externalid":
+		text := ""
+		if op != "remove" {
+			var apiErr utils.ApiError
+			if text, apiErr = scimString(value); apiErr != nil {
+				return apiErr
+			}
+		}
+		p.attributesChanged = true
+		p.group.ExternalID = text
+	case "members":
+		return p.applyMembers(op, path, value)
+	default:
+		return scimError(utils.ScimInvalidPath, "unsupported path "+path)
+	}
+	return nil
+}
+
+func (p *scimGroupPatch) applyMembers(op string, path string, value json.RawMessage) utils.ApiError {
+	var ids []int
+	if len(value) > 0 && string(value) != "null" {
+		entries, apiErr := scimMultiValues(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		if ids, apiErr = p.scim.scimMemberIDs(entries); apiErr != nil {
+			return apiErr
+		}
+	}
+
+	switch op {
+	case "add":
+		p.add = appendUnique(p.add, ids...)
+		p.remove = removeInts(p.remove, ids)
+	case "replace":
+		p.replaceMembers = true
+		p.add = ids
+		p.remove = nil
+	case "remove":
+		// members[value eq "12"] selects the members to remove
+		if open := strings.Index(path, "["); open >= 0 {
+			filtered, apiErr := scimMemberFilterIDs(path[open:])
+			if apiErr != nil {
+				return apiErr
+			}
+			ids = append(ids, filtered...)
+		} else if len(ids) == 0 {
+			// no value and no filter removes every member
+			p.replaceMembers = true
+			p.add = []int{}
+			p.remove = nil
+			return nil
+		}
+		p.remove = appendUnique(p.remove, ids...)
+		p.add = removeInts(p.add, ids)
+	}
+	return nil
+}
+
+// scimMemberFilterIDs reads the user IDs of a filter such as
+// [value eq "12" or value eq "13"]
+func scimMemberFilterIDs(filter string) ([]int, utils.ApiError) {
+	filter = strings.TrimSpace(filter)
+	filter = strings.TrimSuffix(strings.TrimPrefix(filter, "["), "]")
+	parsed, err := utils.ParseScimFilter(filter)
+	if err != nil {
+		return nil, scimError(utils.ScimInvalidFilter, err.Error())
+	}
+
+	var ids []int
+	var collect func(utils.ScimFilter) bool
+	collect = func(expr utils.ScimFilter) bool {
+		switch e := expr.(type) {
+		case utils.ScimLogicalExpr:
+			return e.Operator == "or" && collect(e.Left) && collect(e.Right)
+		case utils.ScimAttrExpr:
+			id, ok := scimFilterID(e.Value)
+			if strings.ToLower(e.Path) != "value" || e.Operator != "eq" || !ok {
+				return false
+			}
+			ids = append(ids, id)
+			return true
+		}
+		return false
+	}
+	if !collect(parsed) {
+		return nil, scimError(utils.ScimInvalidFilter, "member filters only support value eq joined with or")
+	}
+	return ids, nil
+}
+
+func (s *ScimService) getScimGroupModel(id string) (model.UserGroup, utils.ApiError) {
+	groupID, err := strconv.Atoi(id)
+	if err != nil {
+		return model.UserGroup{}, utils.NewNotFoundApiError("group " + id + " not found")
+	}
+
+	group, err := s.groups.GetByID(groupID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.UserGroup{}, utils.NewNotFoundApiError("group " + id + " not found")
+		}
+		log.Println("Error getting scim group:", err)
+		return model.UserGroup{}, utils.NewInternalServerApiError("error getting group", err)
+	}
+	return group, nil
+}
+
+// setScimDisplayName validates the name and checks no other group uses it
+func (s *ScimService) setScimDisplayName(group *model.UserGroup, displayName string) utils.ApiError {
+	displayName = strings.TrimSpace(displayName)
+	if displayName == "" || len(displayName) > 255 {
+		return scimError(utils.ScimInvalidValue, "displayName is required and can have up to 255 characters")
+	}
+
+	existing, err := s.groups.GetByDisplayName(displayName)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Println("Error checking existing group:", err)
+		return utils.NewInternalServerApiError("error checking group existence", err)
+	}
+	if existing.ID != 0 && existing.ID != group.ID {
+		return utils.NewApiError("group "+displayName+" already exists", utils.ScimUniqueness, http.StatusConflict, utils.CauseList{})
+	}
+
+	group.DisplayName = displayName
+	return nil
+}
+
+// scimMemberIDs parses member values and checks the users exist
+func (s *ScimService) scimMemberIDs(members []dto.ScimMultiValue) ([]int, utils.ApiError) {
+	ids := []int{}
+	for _, member := range members {
+		id, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, scimError(utils.ScimInvalidValue, "unknown member "+member.Value)
+		}
+		ids = appendUnique(ids, id)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	users, err := s.users.GetByIDs(ids)
+	if err != nil {
+		log.Println("Error getting scim members:", err)
+		return nil, utils.NewInternalServerApiError("error getting members", err)
+	}
+	if len(users) != len(ids) {
+		found := map[int]bool{}
+		for _, user := range users {
+			found[user.ID] = true
+		}
+		for _, id := range ids {
+			if !found[id] {
+				return nil, scimError(utils.ScimInvalidValue, "unknown member "+strconv.Itoa(id))
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (s *ScimService) scimGroupFromModel(group model.UserGroup, excludeMembers bool) (dto.ScimGroup, utils.ApiError) {
+	groups, apiErr := s.scimGroupsFromModels([]model.UserGroup{group}, excludeMembers)
+	if apiErr != nil {
+		return dto.ScimGroup{}, apiErr
+	}
+	return groups[0], nil
+}
+
+// scimGroupsFromModels maps groups to SCIM, loading members and their names
+// with one query each
+func (s *ScimService) scimGroupsFromModels(groups []model.UserGroup, excludeMembers bool) ([]dto.ScimGroup, utils.ApiError) {
+	members := map[int][]dto.ScimMultiValue{}
+	if !excludeMembers && len(groups) > 0 {
+		groupIDs := make([]int, 0, len(groups))
+		for _, group := range groups {
+			groupIDs = append(groupIDs, group.ID)
+		}
+		rows, err := s.groups.GetMembers(groupIDs)
+		if err != nil {
+			log.Println("Error getting scim group members:", err)
+			return nil, utils.NewInternalServerApiError("error getting members", err)
+		}
+
+		userIDs := []int{}
+		for _, row := range rows {
+			userIDs = appendUnique(userIDs, row.UserID)
+		}
+		names := map[int]string{}
+		if len(userIDs) > 0 {
+			users, err := s.users.GetByIDs(userIDs)
+			if err != nil {
+				log.Println("Error getting scim members:", err)
+				return nil, utils.NewInternalServerApiError("error getting members", err)
+			}
+			for _, user := range users {
+				names[user.ID] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+			}
+		}
+
+		for _, row := range rows {
+			userID := strconv.Itoa(row.UserID)
+			members[row.GroupID] = append(members[row.GroupID], dto.ScimMultiValue{
+				Value:   userID,
+				Display: names[row.UserID],
+				Type:    "User",
+				Ref:     ScimBaseURL() + "/Users/" + userID,
+			})
+		}
+	}
+
+	resources := make([]dto.ScimGroup, 0, len(groups))
+	for _, group := range groups {
+		id := strconv.Itoa(group.ID)
+		resources = append(resources, dto.ScimGroup{
+			Schemas:     []string{utils.ScimCoreGroupSchema},
+			ID:          id,
+			ExternalID:  group.ExternalID,
+			DisplayName: group.DisplayName,
+			Members:     members[group.ID],
+			Meta: &dto.ScimMeta{
+				ResourceType: "Group",
+				Created:      group.CreatedAt,
+				LastModified: scimLastModified(group.CreatedAt, group.UpdatedAt),
+				Location:     ScimBaseURL() + "/Groups/" + id,
+			},
+		})
+	}
+	return resources, nil
+}
+
+// appendUnique appends the values that are not in list yet
+func appendUnique(list []int, values ...int) []int {
+	for _, value := range values {
+		if !containsInt(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// removeInts returns list without the values in remove
+func removeInts(list []int, remove []int) []int {
+	result := []int{}
+	for _, value := range list {
+		if !containsInt(remove, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// containsInt reports whether value is in list
+func containsInt(list []int, value int) bool {
+	for _, existing := range list {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
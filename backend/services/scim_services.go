@@ -0,0 +1,824 @@
+package services
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/dto"
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Page size when the request has no count, and the largest one accepted
+	scimDefaultPageSize = 100
+	scimMaxPageSize     = 200
+)
+
+//go:embed scim/schemas.json
+var scimSchemasJSON []byte
+
+//go:embed scim/resource_types.json
+var scimResourceTypesJSON []byte
+
+// Kinds of values an attribute can be filtered by
+const (
+	scimKindString = iota
+	scimKindBool
+	scimKindTime
+	scimKindID
+	scimKindMember // matched through group_members, column is a condition with one ?
+)
+
+type scimColumn struct {
+	column string
+	kind   int
+}
+
+// Filterable user attributes, in lower case as SCIM names are case insensitive
+var scimUserColumns = map[string]scimColumn{
+	"id":                {"id", scimKindID},
+	"username":          {"email", scimKindString},
+	"emails":            {"email", scimKindString},
+	"emails.value":      {"email", scimKindString},
+	"externalid":        {"external_id", scimKindString},
+	"name.givenname":    {"first_name", scimKindString},
+	"name.familyname":   {"last_name", scimKindString},
+	"active":            {"is_active", scimKindBool},
+	"roles":             {"role", scimKindString},
+	"roles.value":       {"role", scimKindString},
+	"groups":            {"id IN (SELECT user_id FROM group_members WHERE group_id = ?)", scimKindMember},
+	"groups.value":      {"id IN (SELECT user_id FROM group_members WHERE group_id = ?)", scimKindMember},
+	"meta.created":      {"created_at", scimKindTime},
+	"meta.lastmodified": {"updated_at", scimKindTime},
+}
+
+// ScimService provisions the users and groups pushed by the identity system
+// of the university with SCIM 2.0
+type ScimService struct {
+	users  ScimUserRepository
+	groups GroupRepository
+}
+
+func NewScimService(users ScimUserRepository, groups GroupRepository) *ScimService {
+	return &ScimService{users: users, groups: groups}
+}
+
+// ScimBaseURL is the public URL of the SCIM endpoints, used in meta.location
+func ScimBaseURL() string {
+	return strings.TrimRight(utils.OIDCIssuer(), "/") + "/scim/v2"
+}
+
+// GetScimServiceProviderConfig describes the SCIM features this service supports
+func GetScimServiceProviderConfig() dto.ScimServiceProviderConfig {
+	return dto.ScimServiceProviderConfig{
+		Schemas:        []string{utils.ScimServiceProviderConfigSchema},
+		Patch:          dto.ScimSupported{Supported: true},
+		Bulk:           dto.ScimBulkConfig{Supported: false},
+		Filter:         dto.ScimFilterConfig{Supported: true, MaxResults: scimMaxPageSize},
+		ChangePassword: dto.ScimSupported{Supported: true},
+		Sort:           dto.ScimSupported{Supported: false},
+		Etag:           dto.ScimSupported{Supported: false},
+		AuthenticationSchemes: []dto.ScimAuthenticationType{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "Access token of an OAuth client granted the scim scope (client_credentials grant)",
+			Primary:     true,
+		}},
+	}
+}
+
+// GetScimSchemas returns the schema definitions of the supported resources
+func GetScimSchemas() ([]map[string]interface{}, error) {
+	return scimDefinitions(scimSchemasJSON, "Schemas", func(doc map[string]interface{}) string {
+		return fmt.Sprint(doc["id"])
+	})
+}
+
+// GetScimResourceTypes returns the User and Group resource types
+func GetScimResourceTypes() ([]map[string]interface{}, error) {
+	return scimDefinitions(scimResourceTypesJSON, "ResourceTypes", func(doc map[string]interface{}) string {
+		return fmt.Sprint(doc["name"])
+	})
+}
+
+// scimDefinitions decodes an embedded list of documents and adds their meta
+func scimDefinitions(data []byte, resourceType string, id func(map[string]interface{}) string) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	if err := json.Unmarshal(data, &docs); err != nil {
+		return nil, fmt.Errorf("invalid embedded %s: %w", resourceType, err)
+	}
+	singular := strings.TrimSuffix(resourceType, "s")
+	for _, doc := range docs {
+		doc["meta"] = map[string]string{
+			"resourceType": singular,
+			"location":     ScimBaseURL() + "/" + resourceType + "/" + id(doc),
+		}
+	}
+	return docs, nil
+}
+
+// ListScimUsers pages through the users matching the SCIM filter
+func (s *ScimService) ListScimUsers(query dto.ScimListQuery, excludeGroups bool) (dto.ScimListResponse, utils.ApiError) {
+	where, args, apiErr := scimWhere(query.Filter, scimUserColumns)
+	if apiErr != nil {
+		return dto.ScimListResponse{}, apiErr
+	}
+
+	startIndex, count := scimPage(query)
+	users, total, err := s.users.Search(where, args, startIndex-1, count)
+	if err != nil {
+		log.Println("Error searching scim users:", err)
+		return dto.ScimListResponse{}, utils.NewInternalServerApiError("error searching users", err)
+	}
+
+	resources, apiErr := s.scimUsersFromModels(users, excludeGroups)
+	if apiErr != nil {
+		return dto.ScimListResponse{}, apiErr
+	}
+
+	return dto.ScimListResponse{
+		Schemas:      []string{utils.ScimListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}, nil
+}
+
+// GetScimUser returns a single user
+func (s *ScimService) GetScimUser(id string, excludeGroups bool) (dto.ScimUser, utils.ApiError) {
+	user, apiErr := s.getScimUserModel(id)
+	if apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+	return s.scimUserFromModel(user, excludeGroups)
+}
+
+// CreateScimUser provisions a user pushed by the identity system. The user is
+// created verified, since the university already owns the mailbox
+func (s *ScimService) CreateScimUser(request dto.ScimUser) (dto.ScimUser, utils.ApiError) {
+	user := model.UserModel{
+		Role:       utils.RoleStudent,
+		IsVerified: true,
+	}
+	if apiErr := applyScimUser(&user, request); apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+	if apiErr := s.checkScimEmailAvailable(user.Email, 0); apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+
+	active := user.IsActive
+	created, err := s.users.Create(user)
+	if err != nil {
+		log.Println("Error creating scim user:", err)
+		return dto.ScimUser{}, utils.NewInternalServerApiError("error creating user", err)
+	}
+
+	// is_active defaults to true in the database, so false is saved separately
+	if !active {
+		created.IsActive = false
+		if err := s.users.Update(created); err != nil {
+			log.Println("Error deactivating scim user:", err)
+			return dto.ScimUser{}, utils.NewInternalServerApiError("error creating user", err)
+		}
+	}
+
+	return s.scimUserFromModel(created, false)
+}
+
+// ReplaceScimUser overwrites the user with the given representation (PUT).
+// Roles are kept when the request does not include them
+func (s *ScimService) ReplaceScimUser(id string, request dto.ScimUser) (dto.ScimUser, utils.ApiError) {
+	user, apiErr := s.getScimUserModel(id)
+	if apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+
+	user.ExternalID = ""
+	user.IsActive = true
+	if apiErr := applyScimUser(&user, request); apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+	return s.saveScimUser(user)
+}
+
+// PatchScimUser applies add, replace and remove operations to a user
+func (s *ScimService) PatchScimUser(id string, request dto.ScimPatchRequest) (dto.ScimUser, utils.ApiError) {
+	user, apiErr := s.getScimUserModel(id)
+	if apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+
+	for _, operation := range request.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return dto.ScimUser{}, scimError(utils.ScimInvalidSyntax, "unknown patch operation "+operation.Op)
+		}
+		if apiErr := patchScimUserPath(&user, op, operation.Path, operation.Value); apiErr != nil {
+			return dto.ScimUser{}, apiErr
+		}
+	}
+	return s.saveScimUser(user)
+}
+
+// DeleteScimUser removes a user and everything that belongs to it
+func (s *ScimService) DeleteScimUser(id string) utils.ApiError {
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return utils.NewNotFoundApiError("user " + id + " not found")
+	}
+
+	if err := s.users.Delete(userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.NewNotFoundApiError("user " + id + " not found")
+		}
+		log.Println("Error deleting scim user:", err)
+		return utils.NewInternalServerApiError("error deleting user", err)
+	}
+	return nil
+}
+
+func (s *ScimService) getScimUserModel(id string) (model.UserModel, utils.ApiError) {
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return model.UserModel{}, utils.NewNotFoundApiError("user " + id + " not found")
+	}
+
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.UserModel{}, utils.NewNotFoundApiError("user " + id + " not found")
+		}
+		log.Println("Error getting scim user:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("error getting user", err)
+	}
+	return user, nil
+}
+
+func (s *ScimService) saveScimUser(user model.UserModel) (dto.ScimUser, utils.ApiError) {
+	if apiErr := s.checkScimEmailAvailable(user.Email, user.ID); apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+	if err := s.users.Update(user); err != nil {
+		log.Println("Error updating scim user:", err)
+		return dto.ScimUser{}, utils.NewInternalServerApiError("error updating user", err)
+	}
+
+	// reload to get the new updated_at
+	return s.GetScimUser(strconv.Itoa(user.ID), false)
+}
+
+// checkScimEmailAvailable fails with a uniqueness error when another user has the email
+func (s *ScimService) checkScimEmailAvailable(email string, userID int) utils.ApiError {
+	existing, err := s.users.GetByEmail(email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Println("Error checking existing user:", err)
+		return utils.NewInternalServerApiError("error checking user existence", err)
+	}
+	if existing.ID != 0 && existing.ID != userID {
+		return utils.NewApiError("user with userName "+email+" already exists", utils.ScimUniqueness, http.StatusConflict, utils.CauseList{})
+	}
+	return nil
+}
+
+// applyScimUser copies a full SCIM representation onto the model
+func applyScimUser(user *model.UserModel, request dto.ScimUser) utils.ApiError {
+	if apiErr := setScimUserName(user, request.UserName); apiErr != nil {
+		return apiErr
+	}
+
+	var name dto.ScimName
+	if request.Name != nil {
+		name = *request.Name
+	}
+	user.FirstName = strings.TrimSpace(name.GivenName)
+	user.LastName = strings.TrimSpace(name.FamilyName)
+	// the columns are required, fall back like federated accounts do
+	if user.FirstName == "" {
+		user.FirstName = strings.Split(user.Email, "@")[0]
+	}
+	if user.LastName == "" {
+		user.LastName = "-"
+	}
+
+	user.ExternalID = request.ExternalID
+	if request.Active != nil {
+		user.IsActive = *request.Active
+	} else {
+		user.IsActive = true
+	}
+
+	if request.Roles != nil {
+		if apiErr := setScimRole(user, request.Roles); apiErr != nil {
+			return apiErr
+		}
+	}
+	if request.Password != "" {
+		if apiErr := setScimPassword(user, request.Password); apiErr != nil {
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// patchScimUserPath applies a single patch operation. Without a path the
+// value is an object whose keys are paths
+func patchScimUserPath(user *model.UserModel, op string, path string, value json.RawMessage) utils.ApiError {
+	if path == "" {
+		if op == "remove" {
+			return scimError(utils.ScimNoTarget, "remove operations need a path")
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(value, &values); err != nil {
+			return scimError(utils.ScimInvalidSyntax, "patch value without a path must be an object")
+		}
+		for key, keyValue := range values {
+			if apiErr := patchScimUserPath(user, op, key, keyValue); apiErr != nil {
+				return apiErr
+			}
+		}
+		return nil
+	}
+
+	attribute, subAttribute := splitScimPath(path)
+	if op == "remove" {
+		switch attribute {
+		case "externalid":
+			user.ExternalID = ""
+		case "roles":
+			user.Role = utils.RoleStudent
+			user.IsAdmin = false
+		case "name", "username", "emails", "active", "password":
+			return scimError(utils.ScimMutability, path+" can't be removed")
+		case "displayname", "groups":
+			// derived or read only, nothing to remove
+		default:
+			return scimError(utils.ScimInvalidPath, "unsupported path "+path)
+		}
+		return nil
+	}
+
+	switch attribute {
+	case "username":
+		text, apiErr := scimString(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		return setScimUserName(user, text)
+	case "externalid":
+		text, apiErr := scimString(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		user.ExternalID = text
+	case "active":
+		active, apiErr := scimBool(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		user.IsActive = active
+	case "name":
+		return patchScimName(user, subAttribute, value)
+	case "emails":
+		if subAttribute != "" && subAttribute != "value" {
+			return nil // type and primary are fixed
+		}
+		entries, apiErr := scimMultiValues(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		if primary := primaryScimValue(entries); primary != "" {
+			return setScimUserName(user, primary)
+		}
+	case "roles":
+		entries, apiErr := scimMultiValues(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		return setScimRole(user, entries)
+	case "password":
+		text, apiErr := scimString(value)
+		if apiErr != nil {
+			return apiErr
+		}
+		return setScimPassword(user, text)
+	case "displayname", "groups":
+		// displayName is derived from the name; groups are managed on /Groups
+	default:
+		return scimError(utils.ScimInvalidPath, "unsupported path "+path)
+	}
+	return nil
+}
+
+func patchScimName(user *model.UserModel, subAttribute string, value json.RawMessage) utils.ApiError {
+	if subAttribute == "" {
+		var name dto.ScimName
+		if err := json.Unmarshal(value, &name); err != nil {
+			return scimError(utils.ScimInvalidValue, "name must be an object")
+		}
+		if name.GivenName != "" {
+			user.FirstName = strings.TrimSpace(name.GivenName)
+		}
+		if name.FamilyName != "" {
+			user.LastName = strings.TrimSpace(name.FamilyName)
+		}
+		return nil
+	}
+
+	text, apiErr := scimString(value)
+	if apiErr != nil {
+		return apiErr
+	}
+	text = strings.TrimSpace(text)
+	switch subAttribute {
+	case "givenname":
+		if text == "" {
+			return scimError(utils.ScimInvalidValue, "name.givenName can't be empty")
+		}
+		user.FirstName = text
+	case "familyname":
+		if text == "" {
+			return scimError(utils.ScimInvalidValue, "name.familyName can't be empty")
+		}
+		user.LastName = text
+	case "formatted":
+		// derived from the given and family names
+	default:
+		return scimError(utils.ScimInvalidPath, "unsupported path name."+subAttribute)
+	}
+	return nil
+}
+
+func setScimUserName(user *model.UserModel, userName string) utils.ApiError {
+	email := strings.ToLower(strings.TrimSpace(userName))
+	if email == "" {
+		return scimError(utils.ScimInvalidValue, "userName is required")
+	}
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email || len(email) > 100 {
+		return scimError(utils.ScimInvalidValue, "userName must be an email address")
+	}
+	user.Email = email
+	return nil
+}
+
+// setScimRole uses the primary role, or the first one. An empty list means student
+func setScimRole(user *model.UserModel, roles []dto.ScimMultiValue) utils.ApiError {
+	role := primaryScimValue(roles)
+	if role == "" {
+		role = utils.RoleStudent
+	}
+	role = strings.ToLower(role)
+	if !utils.IsKnownRole(role) {
+		return scimError(utils.ScimInvalidValue, fmt.Sprintf("unknown role %s, expected one of %s", role, strings.Join(utils.KnownRoles, ", ")))
+	}
+	user.Role = role
+	user.IsAdmin = role == utils.RoleAdmin
+	return nil
+}
+
+// setScimPassword applies the password policy. SCIM errors have no causes,
+// so the rules the password breaks go in the detail of an invalidValue error
+func setScimPassword(user *model.UserModel, password string) utils.ApiError {
+	if apiErr := setPassword(user, password); apiErr != nil {
+		reasons := []string{}
+		for _, cause := range apiErr.Cause() {
+			if reason, ok := cause.(map[string]interface{}); ok {
+				reasons = append(reasons, fmt.Sprint(reason["message"]))
+			}
+		}
+		return scimError(utils.ScimInvalidValue, "password does not meet the password policy: "+strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+func (s *ScimService) scimUserFromModel(user model.UserModel, excludeGroups bool) (dto.ScimUser, utils.ApiError) {
+	users, apiErr := s.scimUsersFromModels([]model.UserModel{user}, excludeGroups)
+	if apiErr != nil {
+		return dto.ScimUser{}, apiErr
+	}
+	return users[0], nil
+}
+
+// scimUsersFromModels maps users to SCIM, loading their groups with one query
+func (s *ScimService) scimUsersFromModels(users []model.UserModel, excludeGroups bool) ([]dto.ScimUser, utils.ApiError) {
+	groups := map[int][]dto.ScimMultiValue{}
+	if !excludeGroups && len(users) > 0 {
+		ids := make([]int, 0, len(users))
+		for _, user := range users {
+			ids = append(ids, user.ID)
+		}
+		memberships, err := s.groups.GetMemberships(ids)
+		if err != nil {
+			log.Println("Error getting scim user groups:", err)
+			return nil, utils.NewInternalServerApiError("error getting groups", err)
+		}
+		for _, membership := range memberships {
+			groups[membership.UserID] = append(groups[membership.UserID], dto.ScimMultiValue{
+				Value:   strconv.Itoa(membership.GroupID),
+				Display: membership.DisplayName,
+				Ref:     ScimBaseURL() + "/Groups/" + strconv.Itoa(membership.GroupID),
+			})
+		}
+	}
+
+	resources := make([]dto.ScimUser, 0, len(users))
+	for _, user := range users {
+		active := user.IsActive
+		role := user.Role
+		if user.IsAdmin {
+			role = utils.RoleAdmin
+		}
+		id := strconv.Itoa(user.ID)
+		fullName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+
+		resources = append(resources, dto.ScimUser{
+			Schemas:    []string{utils.ScimCoreUserSchema},
+			ID:         id,
+			ExternalID: user.ExternalID,
+			UserName:   user.Email,
+			Name: &dto.ScimName{
+				GivenName:  user.FirstName,
+				FamilyName: user.LastName,
+				Formatted:  fullName,
+			},
+			DisplayName: fullName,
+			Emails:      []dto.ScimMultiValue{{Value: user.Email, Type: "work", Primary: true}},
+			Active:      &active,
+			Roles:       []dto.ScimMultiValue{{Value: role, Primary: true}},
+			Groups:      groups[user.ID],
+			Meta: &dto.ScimMeta{
+				ResourceType: "User",
+				Created:      user.CreatedAt,
+				LastModified: scimLastModified(user.CreatedAt, user.UpdatedAt),
+				Location:     ScimBaseURL() + "/Users/" + id,
+			},
+		})
+	}
+	return resources, nil
+}
+
+// scimLastModified falls back to the creation time for rows without updated_at
+func scimLastModified(created time.Time, updated time.Time) time.Time {
+	if updated.Before(created) {
+		return created
+	}
+	return updated
+}
+
+// scimPage turns startIndex and count into a 1-based start and a page size
+func scimPage(query dto.ScimListQuery) (int, int) {
+	startIndex := query.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count := scimDefaultPageSize
+	if query.Count != nil {
+		count = *query.Count
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scimMaxPageSize {
+		count = scimMaxPageSize
+	}
+	return startIndex, count
+}
+
+// scimWhere parses a filter and translates it to a SQL condition
+func scimWhere(filter string, columns map[string]scimColumn) (string, []interface{}, utils.ApiError) {
+	if strings.TrimSpace(filter) == "" {
+		return "", nil, nil
+	}
+	parsed, err := utils.ParseScimFilter(filter)
+	if err != nil {
+		return "", nil, scimError(utils.ScimInvalidFilter, err.Error())
+	}
+	where, args, err := scimFilterToSQL(parsed, columns)
+	if err != nil {
+		return "", nil, scimError(utils.ScimInvalidFilter, err.Error())
+	}
+	return where, args, nil
+}
+
+func scimFilterToSQL(filter utils.ScimFilter, columns map[string]scimColumn) (string, []interface{}, error) {
+	switch expr := filter.(type) {
+	case utils.ScimLogicalExpr:
+		left, leftArgs, err := scimFilterToSQL(expr.Left, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := scimFilterToSQL(expr.Right, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + left + ") " + strings.ToUpper(expr.Operator) + " (" + right + ")", append(leftArgs, rightArgs...), nil
+	case utils.ScimNotExpr:
+		inner, args, err := scimFilterToSQL(expr.Filter, columns)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + inner + ")", args, nil
+	case utils.ScimAttrExpr:
+		return scimAttrToSQL(expr, columns)
+	}
+	return "", nil, fmt.Errorf("unsupported filter")
+}
+
+func scimAttrToSQL(expr utils.ScimAttrExpr, columns map[string]scimColumn) (string, []interface{}, error) {
+	column, ok := columns[strings.ToLower(expr.Path)]
+	if !ok {
+		return "", nil, fmt.Errorf("filtering by %s is not supported", expr.Path)
+	}
+
+	if column.kind == scimKindMember {
+		id, ok := scimFilterID(expr.Value)
+		switch {
+		case expr.Operator != "eq" && expr.Operator != "ne":
+			return "", nil, fmt.Errorf("%s only supports eq and ne", expr.Path)
+		case !ok:
+			return scimNoMatch(expr.Operator), nil, nil
+		case expr.Operator == "ne":
+			return "NOT (" + column.column + ")", []interface{}{id}, nil
+		}
+		return column.column, []interface{}{id}, nil
+	}
+
+	if expr.Operator == "pr" {
+		if column.kind == scimKindString {
+			return column.column + " IS NOT NULL AND " + column.column + " <> ''", nil, nil
+		}
+		return column.column + " IS NOT NULL", nil, nil
+	}
+	if expr.Value == nil {
+		switch expr.Operator {
+		case "eq":
+			return column.column + " IS NULL", nil, nil
+		case "ne":
+			return column.column + " IS NOT NULL", nil, nil
+		}
+		return "", nil, fmt.Errorf("null can only be compared with eq and ne")
+	}
+
+	var value interface{}
+	switch column.kind {
+	case scimKindString:
+		text, ok := expr.Value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("%s must be compared with a string", expr.Path)
+		}
+		// SCIM compares strings ignoring case, which only the MySQL collation
+		// does by itself
+		lower, folded := "LOWER("+column.column+")", strings.ToLower(text)
+		switch expr.Operator {
+		case "co":
+			return lower + " LIKE ? ESCAPE '!'", []interface{}{"%" + escapeLike(folded) + "%"}, nil
+		case "sw":
+			return lower + " LIKE ? ESCAPE '!'", []interface{}{escapeLike(folded) + "%"}, nil
+		case "ew":
+			return lower + " LIKE ? ESCAPE '!'", []interface{}{"%" + escapeLike(folded)}, nil
+		case "eq":
+			return lower + " = ?", []interface{}{folded}, nil
+		case "ne":
+			return lower + " <> ?", []interface{}{folded}, nil
+		}
+		value = text
+	case scimKindBool:
+		flag, ok := expr.Value.(bool)
+		if !ok || (expr.Operator != "eq" && expr.Operator != "ne") {
+			return "", nil, fmt.Errorf("%s only supports eq and ne with true or false", expr.Path)
+		}
+		value = flag
+	case scimKindTime:
+		text, _ := expr.Value.(string)
+		at, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s must be compared with an RFC 3339 date", expr.Path)
+		}
+		value = at
+	case scimKindID:
+		id, ok := scimFilterID(expr.Value)
+		if !ok {
+			return scimNoMatch(expr.Operator), nil, nil
+		}
+		value = id
+	}
+
+	operators := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+	operator, ok := operators[expr.Operator]
+	if !ok {
+		return "", nil, fmt.Errorf("%s does not support %s", expr.Path, expr.Operator)
+	}
+	return column.column + " " + operator + " ?", []interface{}{value}, nil
+}
+
+// scimFilterID reads an ID compared as a string ("12") or a number (12)
+func scimFilterID(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case string:
+		id, err := strconv.Atoi(v)
+		return id, err == nil
+	case float64:
+		return int(v), v == float64(int(v))
+	}
+	return 0, false
+}
+
+// scimNoMatch is the condition for comparing an ID with something that can't be one
+func scimNoMatch(operator string) string {
+	if operator == "ne" {
+		return "1 = 1"
+	}
+	return "1 = 0"
+}
+
+// escapeLike escapes the LIKE wildcards so the value matches literally. The
+// escape character is !, since \ is not the default of every database
+func escapeLike(value string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(value)
+}
+
+// splitScimPath lower cases a patch path and splits it into attribute and
+// sub-attribute: emails[type eq "work"].value gives emails and value
+func splitScimPath(path string) (string, string) {
+	path = strings.ToLower(utils.NormalizeScimPath(strings.TrimSpace(path)))
+	attribute, rest := path, ""
+	if open := strings.Index(path, "["); open >= 0 {
+		attribute = path[:open]
+		if closing := strings.LastIndex(path, "]"); closing > open {
+			rest = strings.TrimPrefix(path[closing+1:], ".")
+		}
+	} else if dot := strings.Index(path, "."); dot >= 0 {
+		attribute, rest = path[:dot], path[dot+1:]
+	}
+	return attribute, rest
+}
+
+func scimString(value json.RawMessage) (string, utils.ApiError) {
+	var text string
+	if err := json.Unmarshal(value, &text); err != nil {
+		return "", scimError(utils.ScimInvalidValue, "expected a string value")
+	}
+	return text, nil
+}
+
+// scimBool accepts JSON booleans and the "True"/"False" strings some
+// identity systems send
+func scimBool(value json.RawMessage) (bool, utils.ApiError) {
+	var flag bool
+	if err := json.Unmarshal(value, &flag); err == nil {
+		return flag, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		if flag, err := strconv.ParseBool(text); err == nil {
+			return flag, nil
+		}
+	}
+	return false, scimError(utils.ScimInvalidValue, "expected a boolean value")
+}
+
+// scimMultiValues accepts a list of values, a single value object or a bare string
+func scimMultiValues(value json.RawMessage) ([]dto.ScimMultiValue, utils.ApiError) {
+	var entries []dto.ScimMultiValue
+	if err := json.Unmarshal(value, &entries); err == nil {
+		return entries, nil
+	}
+	var entry dto.ScimMultiValue
+	if err := json.Unmarshal(value, &entry); err == nil {
+		return []dto.ScimMultiValue{entry}, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		return []dto.ScimMultiValue{{Value: text}}, nil
+	}
+	return nil, scimError(utils.ScimInvalidValue, "expected a list of values")
+}
+
+// primaryScimValue returns the primary entry, or the first one
+func primaryScimValue(entries []dto.ScimMultiValue) string {
+	for _, entry := range entries {
+		if entry.Primary {
+			return entry.Value
+		}
+	}
+	if len(entries) > 0 {
+		return entries[0].Value
+	}
+	return ""
+}
+
+func scimError(scimType string, message string) utils.ApiError {
+	return utils.NewValidationApiError(message, scimType, utils.CauseList{})
+}
@@ -0,0 +1,104 @@
+package services_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	groupClient "backend/clients/group"
+	userCLient "backend/clients/user"
+	"backend/config"
+	"backend/db"
+	"backend/dto"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+)
+
+// TestScimUserFilters runs the filters through the SQL they translate to, on
+// a migrated SQLite file since only the GORM repositories search
+func TestScimUserFilters(t *testing.T) {
+	if err := db.Connect(config.Database{Driver: "sqlite", DSN: db.SQLiteDSN(filepath.Join(t.TempDir(), "scim.db"))}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := userCLient.NewUserRepository(db.DB)
+	for _, user := range []model.UserModel{
+		{Email: "ana_paz@uni.edu", FirstName: "Ana", IsActive: true, ExternalID: "u-1"},
+		{Email: "anaxpaz@uni.edu", FirstName: "Ana", IsActive: false},
+		{Email: "100%off@uni.edu", FirstName: "Promo", IsActive: true},
+		{Email: "hola!@uni.edu", FirstName: "Hola", IsActive: true},
+	} {
+		created, err := users.Create(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// is_active defaults to true, so a deactivated user is saved again
+		if !user.IsActive {
+			created.IsActive = false
+			if err := users.Update(created); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	scim := services.NewScimService(users, groupClient.NewGroupRepository(db.DB))
+
+	tests := []struct {
+		name     string
+		filter   string
+		expected []string // nil when the filter is rejected
+	}{
+		{"no filter", ``, []string{"100%off@uni.edu", "ana_paz@uni.edu", "anaxpaz@uni.edu", "hola!@uni.edu"}},
+		{"eq ignores case", `userName eq "ANA_PAZ@uni.edu"`, []string{"ana_paz@uni.edu"}},
+		{"underscore is not a wildcard", `userName co "_"`, []string{"ana_paz@uni.edu"}},
+		{"underscore in sw", `userName sw "ana_"`, []string{"ana_paz@uni.edu"}},
+		{"percent is not a wildcard", `userName co "%"`, []string{"100%off@uni.edu"}},
+		{"percent in ew", `userName ew "%off@uni.edu"`, []string{"100%off@uni.edu"}},
+		{"the escape character is literal", `userName co "!"`, []string{"hola!@uni.edu"}},
+		{"escape character before a wildcard", `userName co "!%"`, []string{}},
+		{"and before or", `userName sw "hola" or userName sw "ana" and active eq false`, []string{"anaxpaz@uni.edu", "hola!@uni.edu"}},
+		{"grouped or", `(userName sw "hola" or userName sw "ana") and active eq false`, []string{"anaxpaz@uni.edu"}},
+		{"not", `not (active eq true)`, []string{"anaxpaz@uni.edu"}},
+		{"present", `externalId pr`, []string{"ana_paz@uni.edu"}},
+		{"value path", `emails[value sw "ana" and value ew "paz@uni.edu"]`, []string{"ana_paz@uni.edu", "anaxpaz@uni.edu"}},
+		{"sub-attribute", `name.givenName eq "promo"`, []string{"100%off@uni.edu"}},
+		{"unknown attribute", `nickName eq "ana"`, nil},
+		{"unknown attribute in an or", `userName pr or password eq "x"`, nil},
+		{"boolean compared with a string", `active eq "yes"`, nil},
+		{"string ordered with a number", `userName gt 3`, nil},
+		{"null with co", `userName co null`, nil},
+		{"malformed", `userName eq "ana" and`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, apiErr := scim.ListScimUsers(dto.ScimListQuery{Filter: test.filter}, true)
+			if test.expected == nil {
+				if apiErr == nil || apiErr.Status() != http.StatusBadRequest || apiErr.Code() != utils.ScimInvalidFilter {
+					t.Errorf("expected an invalidFilter error, got %v", apiErr)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatal(apiErr)
+			}
+			found := []string{}
+			for _, user := range response.Resources.([]dto.ScimUser) {
+				found = append(found, user.UserName)
+			}
+			sort.Strings(found)
+			if len(found) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, found)
+			}
+			for i := range found {
+				if found[i] != test.expected[i] {
+					t.Fatalf("expected %v, got %v", test.expected, found)
+				}
+			}
+		})
+	}
+}
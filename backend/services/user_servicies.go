@@ -2,102 +2,143 @@ package services
 
 import (
 	"backend/model"
-	"fmt"
+	"errors"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
-	userCLient "backend/clients/user"
 	"backend/dto"
+	"backend/i18n"
 	"backend/utils"
 
 	"gorm.io/gorm"
 )
 
-func Register(request dto.RegisterRequest) (dto.RegisterResponse, error) {
+// Layout used for dates in the usage statistics
+const statsDateLayout = "2006-01-02"
+
+// UserService has the account flows: registration, email verification,
+// login, password and language changes, the personal access tokens and the
+// usage statistics
+type UserService struct {
+	users        UserRepository
+	tokens       TokenRepository
+	sessions     SessionRepository
+	accessTokens AccessTokenRepository
+	invitations  *InvitationService // invites the users of the imports
+	policy       *RegistrationPolicy
+}
+
+func NewUserService(users UserRepository, tokens TokenRepository, sessions SessionRepository, accessTokens AccessTokenRepository, invitations *InvitationService, policy *RegistrationPolicy) *UserService {
+	return &UserService{users: users, tokens: tokens, sessions: sessions, accessTokens: accessTokens, invitations: invitations, policy: policy}
+}
+
+func (s *UserService) Register(request dto.RegisterRequest) (dto.RegisterResponse, utils.ApiError) {
+	// Check the registration mode and the domain of the email
+	if apiErr := s.policy.Check(request.Email); apiErr != nil {
+		return dto.RegisterResponse{}, apiErr
+	}
+
 	// Check if user already exists
-	existingUser, err := userCLient.GetUserByEmail(request.Email)
+	existingUser, err := s.users.GetByEmail(request.Email)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		log.Println("Error checking existing user:", err)
-		return dto.RegisterResponse{}, fmt.Errorf("error checking user existence: %w", err)
+		return dto.RegisterResponse{}, utils.NewInternalServerApiError("Error checking user existence", err)
 	}
 
 	if existingUser.ID != 0 {
-		return dto.RegisterResponse{}, fmt.Errorf("user with email %s already exists", request.Email)
+		return dto.RegisterResponse{}, errEmailTaken(request.Email)
 	}
 
-	// Hash password
-	passwordHash := utils.HashSHA256(request.Password)
+	// Language of the emails; the controller defaults it to Accept-Language
+	locale := ""
+	if request.Locale != "" {
+		supported, apiErr := checkLocale(request.Locale)
+		if apiErr != nil {
+			return dto.RegisterResponse{}, apiErr
+		}
+		locale = supported
+	}
+
+	newUser := model.UserModel{
+		Email:      request.Email,
+		FirstName:  request.FirstName,
+		LastName:   request.LastName,
+		IsAdmin:    false,
+		IsVerified: false,
+		Locale:     locale,
+	}
+
+	// Check the password against the password policy and hash it
+	if apiErr := setPassword(&newUser, request.Password); apiErr != nil {
+		return dto.RegisterResponse{}, apiErr
+	}
 
 	// Generate verification code
 	verificationCode, err := utils.GenerateVerificationCode()
 	if err != nil {
 		log.Println("Error generating verification code:", err)
-		return dto.RegisterResponse{}, fmt.Errorf("error generating verification code: %w", err)
-	}
-
-	// Create user
-	newUser := model.UserModel{
-		Email:            request.Email,
-		PasswordHash:     passwordHash,
-		FirstName:        request.FirstName,
-		LastName:         request.LastName,
-		IsAdmin:          false,
-		IsVerified:       false,
-		VerificationCode: verificationCode,
-		CodeExpiresAt:    time.Now().Add(15 * time.Minute),
+		return dto.RegisterResponse{}, utils.NewInternalServerApiError("Error generating verification code", err)
 	}
 
-	createdUser, err := userCLient.CreateUser(newUser)
+	// Create user together with its verification code
+	createdUser, err := s.users.CreateWithToken(newUser, func(userID int) model.VerificationToken {
+		return oneTimeToken(userID, model.TokenPurposeVerifyEmail, verificationCode, verificationCodeDuration)
+	})
 	if err != nil {
 		log.Println("Error creating user:", err)
-		return dto.RegisterResponse{}, fmt.Errorf("error creating user: %w", err)
+		return dto.RegisterResponse{}, utils.NewInternalServerApiError("Error creating user", err)
 	}
 
 	// Send verification email
-	err = utils.SendVerificationEmail(createdUser.Email, verificationCode, createdUser.FirstName)
+	err = utils.SendVerificationEmail(createdUser.Email, verificationCode, createdUser.FirstName, createdUser.Locale)
 	if err != nil {
 		log.Println("Error sending verification email:", err)
 		// Don't fail registration if email fails
 	}
 
 	return dto.RegisterResponse{
-		Message: "User registered successfully. Please check your email for verification code.",
-		Email:   createdUser.Email,
+		MessageKey: "user.registered",
+		Email:      createdUser.Email,
 	}, nil
 }
 
-func VerifyEmail(request dto.VerifyEmailRequest) (dto.VerifyEmailResponse, error) {
+func (s *UserService) VerifyEmail(request dto.VerifyEmailRequest) (dto.VerifyEmailResponse, utils.ApiError) {
 	// Get user by email
-	user, err := userCLient.GetUserByEmail(request.Email)
+	user, err := s.users.GetByEmail(request.Email)
 	if err != nil {
 		log.Println("Error getting user by email:", err)
-		return dto.VerifyEmailResponse{}, fmt.Errorf("user not found")
+		return dto.VerifyEmailResponse{}, errUserNotFound()
 	}
 
 	// Check if already verified
 	if user.IsVerified {
-		return dto.VerifyEmailResponse{}, fmt.Errorf("email already verified")
-	}
-
-	// Check if code matches
-	if user.VerificationCode != request.Code {
-		return dto.VerifyEmailResponse{}, fmt.Errorf("invalid verification code")
+		return dto.VerifyEmailResponse{}, apiError(http.StatusConflict, utils.CodeAlreadyVerified, "user.email_already_verified", nil)
 	}
 
-	// Check if code expired
-	if time.Now().After(user.CodeExpiresAt) {
-		return dto.VerifyEmailResponse{}, fmt.Errorf("verification code expired")
+	// Check the code, every wrong try counts
+	err = s.consumeOneTimeCode(user.ID, model.TokenPurposeVerifyEmail, request.Code)
+	switch {
+	case errors.Is(err, errCodeInvalid):
+		return dto.VerifyEmailResponse{}, apiError(http.StatusBadRequest, utils.CodeInvalidCode, "verification.code_invalid", nil)
+	case errors.Is(err, errCodeExpired):
+		return dto.VerifyEmailResponse{}, apiError(http.StatusBadRequest, utils.CodeCodeExpired, "verification.code_expired", nil)
+	case errors.Is(err, errCodeAttempts):
+		return dto.VerifyEmailResponse{}, apiError(http.StatusTooManyRequests, utils.CodeTooManyAttempts, "verification.too_many_attempts", nil)
+	case err != nil:
+		return dto.VerifyEmailResponse{}, utils.NewInternalServerApiError("Error checking verification code", err)
 	}
 
 	// Verify user
-	err = userCLient.VerifyUserEmail(user.ID)
+	err = s.users.VerifyEmail(user.ID)
 	if err != nil {
 		log.Println("Error verifying user email:", err)
-		return dto.VerifyEmailResponse{}, fmt.Errorf("error verifying email: %w", err)
+		return dto.VerifyEmailResponse{}, utils.NewInternalServerApiError("Error verifying email", err)
 	}
 
 	// Send welcome email
-	err = utils.SendWelcomeEmail(user.Email, user.FirstName)
+	err = utils.SendWelcomeEmail(user.Email, user.FirstName, user.Locale)
 	if err != nil {
 		log.Println("Error sending welcome email:", err)
 		// Don't fail verification if welcome email fails
@@ -108,77 +149,68 @@ func VerifyEmail(request dto.VerifyEmailRequest) (dto.VerifyEmailResponse, error
 	if err != nil {
 		log.Println("Error generating tokens:", err)
 		return dto.VerifyEmailResponse{
-			Message: "Email verified successfully",
+			MessageKey: "user.email_verified",
 		}, nil
 	}
 
 	return dto.VerifyEmailResponse{
-		Message:      "Email verified successfully. You can now log in.",
+		MessageKey:   "user.email_verified",
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}, nil
 }
 
-func ResendVerificationCode(email string) error {
+func (s *UserService) ResendVerificationCode(email string) utils.ApiError {
 	// Get user by email
-	user, err := userCLient.GetUserByEmail(email)
+	user, err := s.users.GetByEmail(email)
 	if err != nil {
 		log.Println("Error getting user by email:", err)
-		return fmt.Errorf("user not found")
+		return errUserNotFound()
 	}
 
 	// Check if already verified
 	if user.IsVerified {
-		return fmt.Errorf("email already verified")
+		return apiError(http.StatusConflict, utils.CodeAlreadyVerified, "user.email_already_verified", nil)
 	}
 
-	// Generate new verification code
-	verificationCode, err := utils.GenerateVerificationCode()
-	if err != nil {
-		log.Println("Error generating verification code:", err)
-		return fmt.Errorf("error generating verification code: %w", err)
-	}
-
-	// Update verification code
-	err = userCLient.UpdateVerificationCode(user.ID, verificationCode, time.Now().Add(15*time.Minute))
+	// Generate a new verification code, replacing the previous one
+	verificationCode, err := s.issueOneTimeCode(user.ID, model.TokenPurposeVerifyEmail, verificationCodeDuration)
 	if err != nil {
 		log.Println("Error updating verification code:", err)
-		return fmt.Errorf("error updating verification code: %w", err)
+		return utils.NewInternalServerApiError("Error updating verification code", err)
 	}
 
 	// Send verification email
-	err = utils.SendVerificationEmail(user.Email, verificationCode, user.FirstName)
+	err = utils.SendVerificationEmail(user.Email, verificationCode, user.FirstName, user.Locale)
 	if err != nil {
 		log.Println("Error sending verification email:", err)
-		return fmt.Errorf("error sending verification email: %w", err)
+		return utils.NewInternalServerApiError("Error sending verification email", err)
 	}
 
 	return nil
 }
 
-func Login(username string, password string) (dto.LoginResponse, error) {
-	userModel, err := userCLient.GetUserByUsername(username)
+func (s *UserService) Login(username string, password string) (dto.LoginResponse, utils.ApiError) {
+	userModel, err := authenticateUser(username, password)
 	if err != nil {
-		log.Println("Error al obtener el usuario por username")
-		return dto.LoginResponse{}, fmt.Errorf("failed to get user by user: %w", err)
-	}
-
-	// Check if email is verified
-	if !userModel.IsVerified {
-		log.Println("User email not verified")
-		return dto.LoginResponse{}, fmt.Errorf("please verify your email before logging in")
+		return dto.LoginResponse{}, err
 	}
 
-	if utils.HashSHA256(password) != userModel.PasswordHash {
-		log.Println("Error al obtener el usuario por password")
-		return dto.LoginResponse{}, fmt.Errorf("invalid password")
-	}
+	return startSession(s.sessions, userModel)
+}
 
+// startSession issues the token pair of an authenticated user
+func startSession(sessions SessionRepository, userModel model.UserModel) (dto.LoginResponse, utils.ApiError) {
 	// Generate access and refresh tokens
 	accessToken, refreshToken, err := utils.GenerateTokenPair(userModel.ID, userModel.IsAdmin)
 	if err != nil {
 		log.Println("Error al generar los tokens")
-		return dto.LoginResponse{}, fmt.Errorf("failed to generate tokens: %w", err)
+		return dto.LoginResponse{}, utils.NewInternalServerApiError("Error generating tokens", err)
+	}
+
+	// Record the login for usage statistics, without failing the login
+	if err := sessions.UpdateLastLogin(userModel.ID, time.Now()); err != nil {
+		log.Println("Error updating last login:", err)
 	}
 
 	return dto.LoginResponse{
@@ -189,10 +221,10 @@ func Login(username string, password string) (dto.LoginResponse, error) {
 	}, nil
 }
 
-func GetUserByID(id int) (dto.UserDto, error) {
-	userModel, err := userCLient.GetUserByID(id)
+func (s *UserService) GetUserByID(id int) (dto.UserDto, utils.ApiError) {
+	userModel, err := s.users.GetByID(id)
 	if err != nil {
-		return dto.UserDto{}, err
+		return dto.UserDto{}, errUserNotFound()
 	}
 
 	return dto.UserDto{
@@ -201,41 +233,110 @@ func GetUserByID(id int) (dto.UserDto, error) {
 		LastName:  userModel.LastName,
 		Email:     userModel.Email,
 		IsAdmin:   userModel.IsAdmin,
-	}, err
+		Role:      userModel.Role,
+	}, nil
 }
 
-func VerifyToken(token string) error {
-	err := utils.ValidateJWT(token)
+// VerifyToken validates an access token and describes its holder: a user
+// session, or a service client or personal access token restricted to its scopes
+func (s *UserService) VerifyToken(token string) (dto.AuthContext, utils.ApiError) {
+	if strings.HasPrefix(token, AccessTokenPrefix) {
+		auth, err := s.verifyAccessToken(token)
+		if err != nil {
+			log.Println("Error al verificar el access token")
+			return dto.AuthContext{}, errInvalidToken()
+		}
+		return auth, nil
+	}
+
+	claims, err := utils.ParseAccessToken(token)
 	if err != nil {
 		log.Println("Error al verificar el token")
-		return fmt.Errorf("failed to verify token: %w", err)
+		return dto.AuthContext{}, errInvalidToken()
 	}
-	return nil
+
+	if claims.IsClient() {
+		return dto.AuthContext{
+			ClientID: claims.ClientID,
+			Scopes:   utils.ParseScopes(claims.Scope),
+		}, nil
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		return dto.AuthContext{}, errInvalidToken()
+	}
+
+	// Tokens a user granted to an OAuth client are limited to the granted scopes
+	if claims.ClientID != "" {
+		return dto.AuthContext{
+			UserID:   userID,
+			ClientID: claims.ClientID,
+			Scopes:   utils.ParseScopes(claims.Scope),
+		}, nil
+	}
+
+	return dto.AuthContext{
+		UserID:  userID,
+		IsAdmin: claims.IsAdmin,
+	}, nil
 }
 
-func VerifyAdminToken(token string) error {
+// VerifyAdminToken checks the token of the admin endpoints. An admin session
+// passes every endpoint; a personal token of an admin only the ones its
+// scopes cover
+func (s *UserService) VerifyAdminToken(token string, scope string) utils.ApiError {
+	if strings.HasPrefix(token, AccessTokenPrefix) {
+		auth, err := s.verifyAccessToken(token)
+		if err != nil {
+			log.Println("Error al verificar el access token de admin")
+			return errInvalidToken()
+		}
+		if !auth.IsAdmin {
+			return apiError(http.StatusForbidden, utils.CodeForbidden, "auth.admin_required", nil)
+		}
+		if !utils.HasScope(auth.Scopes, scope) {
+			return apiError(http.StatusForbidden, utils.CodeForbidden, "auth.missing_scope", i18n.Params{"scope": scope})
+		}
+		return nil
+	}
+
 	err := utils.ValidateAdminJWT(token)
+	if errors.Is(err, utils.ErrNotAdmin) {
+		return apiError(http.StatusForbidden, utils.CodeForbidden, "auth.admin_required", nil)
+	}
 	if err != nil {
 		log.Println("Error al verificar el token de admin")
-		return fmt.Errorf("failed to verify admin token: %w", err)
+		return errInvalidToken()
 	}
 	return nil
 }
 
 // RefreshAccessToken validates a refresh token and generates new access and refresh tokens
-func RefreshAccessToken(refreshToken string) (dto.RefreshTokenResponse, error) {
+func (s *UserService) RefreshAccessToken(refreshToken string) (dto.RefreshTokenResponse, utils.ApiError) {
 	// Validate refresh token and extract user info
 	userID, isAdmin, err := utils.ValidateRefreshToken(refreshToken)
 	if err != nil {
 		log.Println("Error validating refresh token:", err)
-		return dto.RefreshTokenResponse{}, fmt.Errorf("invalid or expired refresh token")
+		return dto.RefreshTokenResponse{}, apiError(http.StatusUnauthorized, utils.CodeInvalidToken, "auth.invalid_refresh_token", nil)
+	}
+
+	// Deactivated users can't keep their session alive
+	user, err := s.users.GetByID(userID)
+	if err != nil || !user.IsActive {
+		return dto.RefreshTokenResponse{}, apiError(http.StatusUnauthorized, utils.CodeInvalidToken, "auth.invalid_refresh_token", nil)
 	}
 
 	// Generate new token pair
 	newAccessToken, newRefreshToken, err := utils.GenerateTokenPair(userID, isAdmin)
 	if err != nil {
 		log.Println("Error generating new token pair:", err)
-		return dto.RefreshTokenResponse{}, fmt.Errorf("failed to generate new tokens: %w", err)
+		return dto.RefreshTokenResponse{}, utils.NewInternalServerApiError("Error generating new tokens", err)
+	}
+
+	// A refresh means the user is still active; writes are throttled
+	if err := s.sessions.TouchLastSeen(userID, time.Now()); err != nil {
+		log.Println("Error updating last seen:", err)
 	}
 
 	return dto.RefreshTokenResponse{
@@ -246,25 +347,172 @@ func RefreshAccessToken(refreshToken string) (dto.RefreshTokenResponse, error) {
 
 // PromoteToAdmin promotes a user to admin status
 // This should only be called by existing admins
-func PromoteToAdmin(userID int) error {
+func (s *UserService) PromoteToAdmin(userID int) utils.ApiError {
 	// Check if user exists
-	user, err := userCLient.GetUserByID(userID)
+	user, err := s.users.GetByID(userID)
 	if err != nil {
 		log.Println("Error getting user by ID:", err)
-		return fmt.Errorf("user not found")
+		return errUserNotFound()
 	}
 
 	// Check if already admin
 	if user.IsAdmin {
-		return fmt.Errorf("user is already an admin")
+		return apiError(http.StatusConflict, utils.CodeConflict, "user.already_admin", nil)
 	}
 
 	// Promote to admin
-	err = userCLient.PromoteToAdmin(userID)
+	err = s.users.PromoteToAdmin(userID)
 	if err != nil {
 		log.Println("Error promoting user to admin:", err)
-		return fmt.Errorf("error promoting user to admin: %w", err)
+		return utils.NewInternalServerApiError("Error promoting user to admin", err)
 	}
 
 	return nil
 }
+
+// CreateAdmin creates a verified administrator with the password the operator
+// chose, so a new installation can be managed. The password goes through the
+// password policy like any other
+func (s *UserService) CreateAdmin(request dto.CreateAdminRequest) (model.UserModel, utils.ApiError) {
+	existingUser, err := s.users.GetByEmail(request.Email)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		log.Println("Error checking existing user:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("Error checking user existence", err)
+	}
+	if existingUser.ID != 0 {
+		return model.UserModel{}, errEmailTaken(request.Email)
+	}
+
+	newUser := model.UserModel{
+		Email:      request.Email,
+		FirstName:  request.FirstName,
+		LastName:   request.LastName,
+		IsAdmin:    true,
+		Role:       utils.RoleAdmin,
+		IsVerified: true,
+	}
+	if apiErr := setPassword(&newUser, request.Password); apiErr != nil {
+		return model.UserModel{}, apiErr
+	}
+
+	createdUser, err := s.users.Create(newUser)
+	if err != nil {
+		log.Println("Error creating admin:", err)
+		return model.UserModel{}, utils.NewInternalServerApiError("Error creating user", err)
+	}
+	return createdUser, nil
+}
+
+// GetUsageStats returns active users and registration figures for the
+// inclusive day range [from, to]. Active user windows end at the close of "to"
+func (s *UserService) GetUsageStats(from time.Time, to time.Time) (dto.UsageStatsResponse, utils.ApiError) {
+	end := to.AddDate(0, 0, 1)
+
+	dailyActive, err := s.sessions.CountActiveUsersBetween(end.AddDate(0, 0, -1), end)
+	if err != nil {
+		log.Println("Error counting daily active users:", err)
+		return dto.UsageStatsResponse{}, utils.NewInternalServerApiError("Error getting usage stats", err)
+	}
+
+	weeklyActive, err := s.sessions.CountActiveUsersBetween(end.AddDate(0, 0, -7), end)
+	if err != nil {
+		log.Println("Error counting weekly active users:", err)
+		return dto.UsageStatsResponse{}, utils.NewInternalServerApiError("Error getting usage stats", err)
+	}
+
+	monthlyActive, err := s.sessions.CountActiveUsersBetween(end.AddDate(0, 0, -30), end)
+	if err != nil {
+		log.Println("Error counting monthly active users:", err)
+		return dto.UsageStatsResponse{}, utils.NewInternalServerApiError("Error getting usage stats", err)
+	}
+
+	registrations, err := s.users.CountRegistrations(from, end, false)
+	if err != nil {
+		log.Println("Error counting registrations:", err)
+		return dto.UsageStatsResponse{}, utils.NewInternalServerApiError("Error getting usage stats", err)
+	}
+
+	verified, err := s.users.CountRegistrations(from, end, true)
+	if err != nil {
+		log.Println("Error counting verified registrations:", err)
+		return dto.UsageStatsResponse{}, utils.NewInternalServerApiError("Error getting usage stats", err)
+	}
+
+	perDay, err := s.users.CountRegistrationsPerDay(from, end)
+	if err != nil {
+		log.Println("Error counting registrations per day:", err)
+		return dto.UsageStatsResponse{}, utils.NewInternalServerApiError("Error getting usage stats", err)
+	}
+
+	// Fill in the days without registrations so the series has no gaps
+	counts := make(map[string]int64, len(perDay))
+	for _, row := range perDay {
+		counts[row.Day] = row.Count
+	}
+	series := []dto.DailyRegistrationsDto{}
+	for day := from; day.Before(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(statsDateLayout)
+		series = append(series, dto.DailyRegistrationsDto{Date: date, Count: counts[date]})
+	}
+
+	conversion := 0.0
+	if registrations > 0 {
+		conversion = float64(verified) / float64(registrations)
+	}
+
+	return dto.UsageStatsResponse{
+		From:                       from.Format(statsDateLayout),
+		To:                         to.Format(statsDateLayout),
+		DailyActiveUsers:           dailyActive,
+		WeeklyActiveUsers:          weeklyActive,
+		MonthlyActiveUsers:         monthlyActive,
+		TotalRegistrations:         registrations,
+		VerifiedRegistrations:      verified,
+		VerificationConversionRate: conversion,
+		RegistrationsPerDay:        series,
+	}, nil
+}
+
+// GetUsersByIDs returns the public profiles of the requested users. IDs that
+// don't match any user are reported in NotFound instead of failing the lookup
+func (s *UserService) GetUsersByIDs(ids []int) (dto.BatchUsersResponse, utils.ApiError) {
+	// Remove duplicates while keeping the requested order
+	seen := make(map[int]bool, len(ids))
+	uniqueIDs := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			uniqueIDs = append(uniqueIDs, id)
+		}
+	}
+
+	users, err := s.users.GetByIDs(uniqueIDs)
+	if err != nil {
+		log.Println("Error getting users by IDs:", err)
+		return dto.BatchUsersResponse{}, utils.NewInternalServerApiError("Error getting users", err)
+	}
+
+	found := make(map[int]model.UserModel, len(users))
+	for _, user := range users {
+		found[user.ID] = user
+	}
+
+	response := dto.BatchUsersResponse{
+		Users:    []dto.PublicUserDto{},
+		NotFound: []int{},
+	}
+	for _, id := range uniqueIDs {
+		user, ok := found[id]
+		if !ok {
+			response.NotFound = append(response.NotFound, id)
+			continue
+		}
+		response.Users = append(response.Users, dto.PublicUserDto{
+			ID:        user.ID,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+		})
+	}
+
+	return response, nil
+}
@@ -0,0 +1,152 @@
+package services_test
+
+import (
+	"net/http"
+	"testing"
+
+	memoryClient "backend/clients/memory"
+	"backend/config"
+	"backend/dto"
+	"backend/model"
+	"backend/services"
+	"backend/utils"
+)
+
+// newUserService builds a UserService on an empty memory store, with open
+// registration and a JWT secret to sign its tokens
+func newUserService(t *testing.T) (*services.UserService, *memoryClient.Store) {
+	t.Helper()
+	utils.ConfigureJWT(config.JWT{Secret: "0123456789abcdef0123456789abcdef"})
+	store := memoryClient.NewStore()
+	users := services.NewUserService(store.Users(), store.Tokens(), store.Sessions(), store.AccessTokens(), nil, registrationPolicy(t, services.RegistrationOpen))
+	return users, store
+}
+
+func TestVerifyAdminToken(t *testing.T) {
+	users, _ := newUserService(t)
+
+	adminAccess, adminRefresh, err := utils.GenerateTokenPair(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userAccess, err := utils.GenerateJWT(2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientAccess, err := utils.GenerateClientJWT("reports", []string{utils.ScopeUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a client acting for an admin doesn't get the admin endpoints
+	delegatedAccess, _, err := utils.GenerateDelegatedTokenPair(1, "reports", []string{utils.ScopeUsersRead})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		code   string
+	}{
+		{"admin session", adminAccess, 0, ""},
+		{"admin refresh token", adminRefresh, http.StatusUnauthorized, utils.CodeInvalidToken},
+		{"user session", userAccess, http.StatusForbidden, utils.CodeForbidden},
+		{"client token", clientAccess, http.StatusForbidden, utils.CodeForbidden},
+		{"delegated token", delegatedAccess, http.StatusForbidden, utils.CodeForbidden},
+		{"garbage", "not-a-token", http.StatusUnauthorized, utils.CodeInvalidToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiErr := users.VerifyAdminToken(test.token, utils.ScopeAdminRead)
+			if test.status == 0 {
+				if apiErr != nil {
+					t.Fatalf("expected the token to be accepted, got %v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.Status() != test.status || apiErr.Code() != test.code {
+				t.Errorf("expected %d %s, got %v", test.status, test.code, apiErr)
+			}
+		})
+	}
+}
+
+func TestVerifyAdminTokenWithAccessToken(t *testing.T) {
+	users, store := newUserService(t)
+
+	admin, err := store.Users().Create(model.UserModel{Email: "admin@uni.edu", IsActive: true, IsAdmin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	professor, err := store.Users().Create(model.UserModel{Email: "profe@uni.edu", IsActive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createToken := func(userID int, scopes ...string) string {
+		t.Helper()
+		response, apiErr := users.CreateAccessToken(userID, dto.CreateAccessTokenRequest{Name: "script", Scopes: scopes})
+		if apiErr != nil {
+			t.Fatalf("creating a token with %v: %v", scopes, apiErr)
+		}
+		return response.Token
+	}
+	reader := createToken(admin.ID, utils.ScopeAdminRead)
+	importer := createToken(admin.ID, utils.ScopeUsersImport)
+	profile := createToken(admin.ID, utils.ScopeUsersRead)
+	professorToken := createToken(professor.ID, utils.ScopeUsersRead)
+
+	tests := []struct {
+		name   string
+		token  string
+		scope  string
+		status int
+		code   string
+	}{
+		{"read token on a read endpoint", reader, utils.ScopeAdminRead, 0, ""},
+		{"read token on a write endpoint", reader, utils.ScopeAdminWrite, http.StatusForbidden, utils.CodeForbidden},
+		{"read token on the import", reader, utils.ScopeUsersImport, http.StatusForbidden, utils.CodeForbidden},
+		{"import token on the import", importer, utils.ScopeUsersImport, 0, ""},
+		{"import token on a read endpoint", importer, utils.ScopeAdminRead, http.StatusForbidden, utils.CodeForbidden},
+		{"token without admin scopes", profile, utils.ScopeAdminRead, http.StatusForbidden, utils.CodeForbidden},
+		{"token of a non admin", professorToken, utils.ScopeAdminRead, http.StatusForbidden, utils.CodeForbidden},
+		{"unknown token", "pat_unknown", utils.ScopeAdminRead, http.StatusUnauthorized, utils.CodeInvalidToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiErr := users.VerifyAdminToken(test.token, test.scope)
+			if test.status == 0 {
+				if apiErr != nil {
+					t.Fatalf("expected the token to be accepted, got %v", apiErr)
+				}
+				return
+			}
+			if apiErr == nil || apiErr.Status() != test.status || apiErr.Code() != test.code {
+				t.Errorf("expected %d %s, got %v", test.status, test.code, apiErr)
+			}
+		})
+	}
+
+	// only admins can put admin scopes on their tokens
+	_, apiErr := users.CreateAccessToken(professor.ID, dto.CreateAccessTokenRequest{Name: "script", Scopes: []string{utils.ScopeAdminWrite}})
+	if apiErr == nil || apiErr.Status() != http.StatusForbidden {
+		t.Errorf("expected a non admin to be refused the %s scope, got %v", utils.ScopeAdminWrite, apiErr)
+	}
+}
+
+// TestRegisterRejectsAnotherSpelling registers an email that only differs in
+// the case of the local part and the domain of an existing account
+func TestRegisterRejectsAnotherSpelling(t *testing.T) {
+	users, _ := newUserService(t)
+
+	request := dto.RegisterRequest{Email: "ana@uni.edu", Password: "correct-horse-battery-staple-42", FirstName: "Ana", LastName: "García"}
+	if _, apiErr := users.Register(request); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	request.Email = " ANA@Uni.Edu"
+	_, apiErr := users.Register(request)
+	if apiErr == nil || apiErr.Status() != http.StatusConflict || apiErr.Code() != utils.CodeEmailTaken {
+		t.Errorf("expected the other spelling to be taken, got %v", apiErr)
+	}
+}
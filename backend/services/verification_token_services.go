@@ -0,0 +1,87 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"time"
+
+	"backend/model"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Lifetime of an email verification code
+	verificationCodeDuration = 15 * time.Minute
+	// Tries a code allows, the right one included, before a new one is needed
+	verificationMaxAttempts = 5
+)
+
+// Reasons a one-time code is rejected. Each flow turns them into its own message
+var (
+	errCodeInvalid  = errors.New("invalid code")
+	errCodeExpired  = errors.New("code expired")
+	errCodeAttempts = errors.New("too many attempts")
+)
+
+// oneTimeToken builds the stored form of a code, without the code itself
+func oneTimeToken(userID int, purpose string, code string, duration time.Duration) model.VerificationToken {
+	return model.VerificationToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashOneTimeToken(purpose, userID, code),
+		ExpiresAt: time.Now().Add(duration),
+	}
+}
+
+// issueOneTimeCode generates a 6-digit code for purpose and stores its hash,
+// replacing the codes sent before for the same purpose
+func (s *UserService) issueOneTimeCode(userID int, purpose string, duration time.Duration) (string, error) {
+	code, err := utils.GenerateVerificationCode()
+	if err != nil {
+		return "", err
+	}
+	if err := s.tokens.Replace(oneTimeToken(userID, purpose, code, duration)); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// consumeOneTimeCode checks code against the pending code of the user for
+// purpose and uses it up. Every try counts, so a code can't be guessed
+func (s *UserService) consumeOneTimeCode(userID int, purpose string, code string) error {
+	token, err := s.tokens.GetPending(userID, purpose)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errCodeInvalid
+	}
+	if err != nil {
+		log.Println("Error getting verification token:", err)
+		return err
+	}
+
+	if err := s.tokens.AddAttempt(token.ID, verificationMaxAttempts); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errCodeAttempts
+		}
+		log.Println("Error counting verification attempt:", err)
+		return err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		return errCodeExpired
+	}
+	if subtle.ConstantTimeCompare([]byte(utils.HashOneTimeToken(purpose, userID, code)), []byte(token.TokenHash)) != 1 {
+		return errCodeInvalid
+	}
+
+	if err := s.tokens.Consume(token.ID, time.Now()); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// used by a concurrent request
+			return errCodeInvalid
+		}
+		log.Println("Error consuming verification token:", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Marks the start of a serialized BloomFilter
+var bloomMagic = [4]byte{'U', 'C', 'B', 'F'}
+
+const (
+	// bytes of the magic, the bit count and the hash count
+	bloomHeaderSize = 16
+	// more hashes only matter for false positive rates far below any use, and
+	// each one is a memory read on every password check
+	maxBloomHashes = 64
+)
+
+// BloomFilter is a set of SHA-1 digests with no false negatives and a small
+// rate of false positives, so a large breached password corpus fits in memory
+type BloomFilter struct {
+	bits   []uint64
+	size   uint64 // number of bits
+	hashes uint32
+}
+
+// NewBloomFilter sizes a filter for count digests with the given false
+// positive rate
+func NewBloomFilter(count uint64, falsePositiveRate float64) *BloomFilter {
+	if count == 0 {
+		count = 1
+	}
+	size := uint64(math.Ceil(-float64(count) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if size < 64 {
+		size = 64
+	}
+	hashes := uint32(math.Round(float64(size) / float64(count) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	if hashes > maxBloomHashes {
+		hashes = maxBloomHashes
+	}
+	return &BloomFilter{bits: make([]uint64, (size+63)/64), size: size, hashes: hashes}
+}
+
+// Add inserts a SHA-1 digest
+func (f *BloomFilter) Add(digest []byte) {
+	h1, h2 := bloomHashes(digest)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.size
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// Test reports whether the digest may be in the set
+func (f *BloomFilter) Test(digest []byte) bool {
+	h1, h2 := bloomHashes(digest)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.size
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteTo serializes the filter: magic, bit count, hash count and the bits
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	buffered := bufio.NewWriter(w)
+	header := make([]byte, bloomHeaderSize)
+	copy(header, bloomMagic[:])
+	binary.BigEndian.PutUint64(header[4:], f.size)
+	binary.BigEndian.PutUint32(header[12:], f.hashes)
+	if _, err := buffered.Write(header); err != nil {
+		return 0, err
+	}
+	word := make([]byte, 8)
+	for _, bits := range f.bits {
+		binary.BigEndian.PutUint64(word, bits)
+		if _, err := buffered.Write(word); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(header) + 8*len(f.bits)), buffered.Flush()
+}
+
+// ReadBloomFilter loads a filter written by WriteTo. length is the size of
+// the serialized filter, e.g. of its file: the bits are only allocated once
+// the header agrees with it, so a corrupt header can't ask for any amount of
+// memory
+func ReadBloomFilter(r io.Reader, length int64) (*BloomFilter, error) {
+	buffered := bufio.NewReader(r)
+	header := make([]byte, bloomHeaderSize)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		return nil, fmt.Errorf("invalid bloom filter: %w", err)
+	}
+	if [4]byte(header[:4]) != bloomMagic {
+		return nil, errors.New("invalid bloom filter: bad magic")
+	}
+
+	f := &BloomFilter{
+		size:   binary.BigEndian.Uint64(header[4:]),
+		hashes: binary.BigEndian.Uint32(header[12:]),
+	}
+	if f.size == 0 || f.hashes == 0 {
+		return nil, errors.New("invalid bloom filter: empty")
+	}
+	if f.hashes > maxBloomHashes {
+		return nil, fmt.Errorf("invalid bloom filter: %d hashes, at most %d are supported", f.hashes, maxBloomHashes)
+	}
+	// neither the word count nor its bytes may overflow for a corrupt size
+	words := f.size/64 + min(f.size%64, 1)
+	if length < bloomHeaderSize || words > uint64(length) || 8*words != uint64(length-bloomHeaderSize) {
+		return nil, fmt.Errorf("invalid bloom filter: the header has %d bits but the filter has %d bytes", f.size, length)
+	}
+	f.bits = make([]uint64, words)
+	word := make([]byte, 8)
+	for i := range f.bits {
+		if _, err := io.ReadFull(buffered, word); err != nil {
+			return nil, fmt.Errorf("invalid bloom filter: %w", err)
+		}
+		f.bits[i] = binary.BigEndian.Uint64(word)
+	}
+	return f, nil
+}
+
+// bloomHashes splits the digest into the two hashes of double hashing. SHA-1
+// output is already uniform, so no further hashing is needed
+func bloomHashes(digest []byte) (uint64, uint64) {
+	var padded [16]byte
+	copy(padded[:], digest)
+	return binary.BigEndian.Uint64(padded[:8]), binary.BigEndian.Uint64(padded[8:]) | 1
+}
@@ -0,0 +1,95 @@
+package utils_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"backend/utils"
+)
+
+func digest(password string) []byte {
+	sum := sha1.Sum([]byte(password))
+	return sum[:]
+}
+
+func TestBloomFilterRoundTrip(t *testing.T) {
+	filter := utils.NewBloomFilter(1000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.Add(digest(fmt.Sprintf("breached-%d", i)))
+	}
+
+	var serialized bytes.Buffer
+	size, err := filter.WriteTo(&serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(serialized.Len()) {
+		t.Errorf("WriteTo reported %d bytes and wrote %d", size, serialized.Len())
+	}
+
+	loaded, err := utils.ReadBloomFilter(bytes.NewReader(serialized.Bytes()), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if !loaded.Test(digest(fmt.Sprintf("breached-%d", i))) {
+			t.Fatalf("breached-%d was added but is not in the loaded filter", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if loaded.Test(digest(fmt.Sprintf("fresh-%d", i))) {
+			falsePositives++
+		}
+	}
+	// 0.1% expected, 10 of 10000; leave room for chance
+	if falsePositives > 40 {
+		t.Errorf("%d false positives of 10000, expected about 10", falsePositives)
+	}
+}
+
+// bloomHeader builds the header of a serialized filter
+func bloomHeader(magic string, size uint64, hashes uint32) []byte {
+	header := make([]byte, 16)
+	copy(header, magic)
+	binary.BigEndian.PutUint64(header[4:], size)
+	binary.BigEndian.PutUint32(header[12:], hashes)
+	return header
+}
+
+func TestReadBloomFilterRejectsCorruptFiles(t *testing.T) {
+	var valid bytes.Buffer
+	if _, err := utils.NewBloomFilter(100, 0.01).WriteTo(&valid); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		length int64
+	}{
+		{"empty", nil, 0},
+		{"short header", []byte("UCBF"), 4},
+		{"bad magic", append(bloomHeader("ABCD", 64, 3), make([]byte, 8)...), 24},
+		{"no bits", bloomHeader("UCBF", 0, 3), 16},
+		{"no hashes", append(bloomHeader("UCBF", 64, 0), make([]byte, 8)...), 24},
+		{"too many hashes", append(bloomHeader("UCBF", 64, 1<<30), make([]byte, 8)...), 24},
+		// the header asks for 2^60 bytes; it must fail before allocating them
+		{"size beyond the file", bloomHeader("UCBF", 1<<63, 3), 16},
+		{"largest size", bloomHeader("UCBF", 1<<64-1, 3), 16},
+		{"size overflowing the length", append(bloomHeader("UCBF", 1<<63+64, 3), make([]byte, 8)...), 24},
+		{"truncated", valid.Bytes()[:valid.Len()-8], int64(valid.Len() - 8)},
+		{"trailing data", append(append([]byte{}, valid.Bytes()...), 0, 0, 0, 0, 0, 0, 0, 0), int64(valid.Len() + 8)},
+		{"length larger than the data", valid.Bytes(), int64(valid.Len() + 8)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := utils.ReadBloomFilter(bytes.NewReader(test.data), test.length); err == nil {
+				t.Errorf("expected the filter to be rejected")
+			}
+		})
+	}
+}
@@ -1,15 +1,50 @@
 package utils
 
 import (
+	"backend/config"
+	"backend/mailer"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"math/big"
-	"net/smtp"
-	"os"
+	"net/mail"
+	"strconv"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Lifetime of the codes shown in the emails
+const verificationCodeMinutes = 15
+
+var (
+	emailMailer mailer.Mailer = mailer.LogMailer{}
+	emailFrom                 = mail.Address{Name: "UniChat", Address: "no-reply@unichat.local"}
+	emailLocale               = mailer.DefaultLocale
+	emailQueue  EmailQueue
+	emailMu     sync.RWMutex
+)
+
+// Emails that carry a code or a signed link. The outbox
+// drops their body when they can't be delivered and never re-sends them, since
+// what they carry may have expired: the user asks for a new one instead
+var secretEmails = map[string]bool{
+	"verification":      true,
+	"password_reset":    true,
+	"import_invitation": true,
+	"invitation":        true,
+}
+
+// EmailCarriesSecret reports whether emails of kind carry a code or a link
+func EmailCarriesSecret(kind string) bool {
+	return secretEmails[kind]
+}
+
+// EmailQueue stores an email to be delivered later, instead of sending it
+// during the request. kind is the template it was rendered from
+type EmailQueue func(kind string, message mailer.Message) error
+
 // GenerateVerificationCode generates a random 6-digit code
 func GenerateVerificationCode() (string, error) {
 	code := ""
@@ -23,89 +58,163 @@ func GenerateVerificationCode() (string, error) {
 	return code, nil
 }
 
-// SendVerificationEmail sends a verification code to the user's email
-func SendVerificationEmail(toEmail, code, userName string) error {
-	// Get SMTP configuration from environment variables
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	fromEmail := os.Getenv("SMTP_FROM")
-
-	// Validate SMTP configuration
-	if smtpHost == "" || smtpPort == "" {
-		log.Warn("SMTP not configured, skipping email send. Code:", code)
-		// In development, just log the code
-		fmt.Printf("\n=== VERIFICATION CODE FOR %s ===\n%s\n===============================\n", toEmail, code)
-		return nil
+// SetMailer changes how emails are delivered, who sends them and the language
+// used when the recipient has none
+func SetMailer(m mailer.Mailer, from mail.Address, locale string) {
+	emailMu.Lock()
+	defer emailMu.Unlock()
+	emailMailer = m
+	emailFrom = from
+	emailLocale = locale
+}
+
+// LoadMailer sets the mailer of cfg: smtp, file (a maildir in cfg.Dir) or log.
+// cfg.From is the sender and cfg.Locale the language of the recipients
+// without a preferred one
+func LoadMailer(cfg config.Mail) error {
+	var m mailer.Mailer
+	switch cfg.Driver {
+	case "smtp":
+		m = mailer.SMTPMailer{
+			Host:     cfg.SMTP.Host,
+			Port:     strconv.Itoa(cfg.SMTP.Port),
+			Username: cfg.SMTP.User,
+			Password: cfg.SMTP.Password,
+			Security: cfg.SMTP.Security,
+		}
+	case "file":
+		m = mailer.FileMailer{Dir: cfg.Dir}
+	case "log":
+		m = mailer.LogMailer{}
+	default:
+		return fmt.Errorf("mailer: unknown driver %q, expected smtp, file or log", cfg.Driver)
 	}
 
-	// Create message
-	subject := "Email Verification Code"
-	body := fmt.Sprintf(`
-Hello %s,
+	from := emailFrom
+	if cfg.From != "" {
+		address, err := mail.ParseAddress(cfg.From)
+		if err != nil {
+			return fmt.Errorf("mailer: invalid sender %q: %w", cfg.From, err)
+		}
+		if address.Name == "" {
+			address.Name = "UniChat"
+		}
+		from = *address
+	}
 
-Your verification code is: %s
+	SetMailer(m, from, cfg.Locale)
+	return nil
+}
+
+// CheckMailer checks the configured mailer can deliver, for the mailers
+// that can tell without sending. The others report no problem
+func CheckMailer(ctx context.Context) error {
+	emailMu.RLock()
+	m := emailMailer
+	emailMu.RUnlock()
+
+	if checker, ok := m.(mailer.Checker); ok {
+		return checker.Check(ctx)
+	}
+	return nil
+}
 
-This code will expire in 15 minutes.
+// SetEmailQueue makes the emails go through queue. Without a queue, like in
+// the maintenance commands, they are sent right away
+func SetEmailQueue(queue EmailQueue) {
+	emailMu.Lock()
+	defer emailMu.Unlock()
+	emailQueue = queue
+}
 
-If you didn't request this code, please ignore this email.
+// DeliverEmail sends message now with the configured mailer and sender
+func DeliverEmail(message mailer.Message) error {
+	emailMu.RLock()
+	m, from := emailMailer, emailFrom
+	emailMu.RUnlock()
 
-Best regards,
-Users Microservice Team
-`, userName, code)
+	message.From = from
+	return m.Send(message)
+}
 
-	message := []byte(fmt.Sprintf("Subject: %s\r\n\r\n%s", subject, body))
+// sendTemplate renders the email template name for toEmail in locale and
+// queues it, or sends it when there is no queue. Without a locale, like for
+// users that never chose one, it uses the locale of the mail configuration
+func sendTemplate(toEmail string, locale string, name string, data interface{}) error {
+	emailMu.RLock()
+	defaultLocale, queue := emailLocale, emailQueue
+	emailMu.RUnlock()
 
-	// Setup authentication
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	if locale == "" {
+		locale = defaultLocale
+	}
 
-	// Send email
-	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
-	err := smtp.SendMail(addr, auth, fromEmail, []string{toEmail}, message)
+	message, err := mailer.Render(locale, name, data)
 	if err != nil {
-		log.Error("Failed to send email:", err)
-		// In development, still log the code
-		fmt.Printf("\n=== VERIFICATION CODE FOR %s (EMAIL FAILED) ===\n%s\n===============================\n", toEmail, code)
+		log.Errorf("Failed to render %s email: %v", name, err)
 		return err
 	}
+	message.To = []mail.Address{{Address: toEmail}}
 
-	log.Info("Verification email sent successfully to:", toEmail)
-	return nil
-}
-
-// SendWelcomeEmail sends a welcome email after successful verification
-func SendWelcomeEmail(toEmail, userName string) error {
-	smtpHost := os.Getenv("SMTP_HOST")
-	smtpPort := os.Getenv("SMTP_PORT")
-	smtpUser := os.Getenv("SMTP_USER")
-	smtpPass := os.Getenv("SMTP_PASS")
-	fromEmail := os.Getenv("SMTP_FROM")
-
-	if smtpHost == "" || smtpPort == "" {
-		log.Warn("SMTP not configured, skipping welcome email")
+	if queue != nil {
+		if err := queue(name, message); err != nil {
+			log.Errorf("Failed to queue %s email: %v", name, err)
+			return err
+		}
+		log.Infof("Queued %s email to: %s", name, toEmail)
 		return nil
 	}
 
-	subject := "Welcome! Your Account is Verified"
-	body := fmt.Sprintf(`
-Hola %s,
+	if err := DeliverEmail(message); err != nil {
+		log.Errorf("Failed to send %s email: %v", name, err)
+		return err
+	}
+	log.Infof("Sent %s email to: %s", name, toEmail)
+	return nil
+}
 
-¡Bienvenido a nuestra plataforma! Tu correo electrónico ha sido verificado con éxito.
+// SendVerificationEmail sends a verification code to the user's email
+func SendVerificationEmail(toEmail, code, userName, locale string) error {
+	return sendTemplate(toEmail, locale, "verification", map[string]interface{}{
+		"Name":    userName,
+		"Code":    code,
+		"Minutes": verificationCodeMinutes,
+	})
+}
 
-Ahora puedes iniciar sesión y comenzar a utilizar UniChat.
-`, userName)
+// SendWelcomeEmail sends a welcome email after successful verification
+func SendWelcomeEmail(toEmail, userName, locale string) error {
+	return sendTemplate(toEmail, locale, "welcome", map[string]interface{}{
+		"Name": userName,
+	})
+}
 
-	message := []byte(fmt.Sprintf("Subject: %s\r\n\r\n%s", subject, body))
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
-	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
+// SendImportInvitationEmail sends the link to choose the password of an
+// account created by a bulk import
+func SendImportInvitationEmail(toEmail, userName, link string, expiresAt time.Time, locale string) error {
+	return sendTemplate(toEmail, locale, "import_invitation", map[string]interface{}{
+		"Name":      userName,
+		"Email":     toEmail,
+		"Link":      link,
+		"ExpiresAt": expiresAt,
+	})
+}
 
-	err := smtp.SendMail(addr, auth, fromEmail, []string{toEmail}, message)
-	if err != nil {
-		log.Error("Failed to send welcome email:", err)
-		return err
-	}
+// SendInvitationEmail sends the link an invited user follows to create the account
+func SendInvitationEmail(toEmail, inviterName, role, link string, expiresAt time.Time, locale string) error {
+	return sendTemplate(toEmail, locale, "invitation", map[string]interface{}{
+		"InviterName": inviterName,
+		"Role":        role,
+		"Link":        link,
+		"ExpiresAt":   expiresAt,
+	})
+}
 
-	log.Info("Welcome email sent successfully to:", toEmail)
-	return nil
+// SendPasswordResetEmail sends the code used to choose a new password
+func SendPasswordResetEmail(toEmail, code, userName, locale string) error {
+	return sendTemplate(toEmail, locale, "password_reset", map[string]interface{}{
+		"Name":    userName,
+		"Code":    code,
+		"Minutes": verificationCodeMinutes,
+	})
 }
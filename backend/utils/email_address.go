@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"backend/config"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// CanonicalEmail is the form an email is stored in: trimmed, with the domain
+// lowercased and converted to ASCII (IDNA), e.g. "Ana@Über.de" becomes
+// "Ana@xn--ber-goa.de". The local part is kept as typed
+func CanonicalEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", fmt.Errorf("invalid email %q", email)
+	}
+
+	domain, err := idna.Lookup.ToASCII(strings.TrimSuffix(email[at+1:], "."))
+	if err != nil {
+		return "", fmt.Errorf("invalid email domain %q: %w", email[at+1:], err)
+	}
+	return email[:at] + "@" + strings.ToLower(domain), nil
+}
+
+// How NormalizeEmail treats the local part, set by ConfigureEmails
+var (
+	foldLocalPart   = true
+	stripSubaddress = false
+)
+
+// ConfigureEmails sets how the email addresses are normalized
+func ConfigureEmails(cfg config.Emails) {
+	foldLocalPart = cfg.FoldLocalPart
+	stripSubaddress = cfg.StripSubaddress
+}
+
+// NormalizeEmail is the key used to look users up and keep emails unique, so
+// "Alice@Uni.edu" and "alice@uni.edu" are the same account. On top of
+// CanonicalEmail the local part is lowercased unless fold_local_part is
+// false, and the "+tag" of subaddresses is dropped when strip_subaddress is
+// true. Changing either option needs "backend normalize-emails"
+func NormalizeEmail(email string) (string, error) {
+	canonical, err := CanonicalEmail(email)
+	if err != nil {
+		return "", err
+	}
+	at := strings.LastIndex(canonical, "@")
+	local, domain := canonical[:at], canonical[at:]
+
+	if stripSubaddress {
+		if plus := strings.IndexByte(local, '+'); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	if foldLocalPart {
+		local = strings.ToLower(local)
+	}
+	return local + domain, nil
+}
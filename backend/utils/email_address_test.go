@@ -0,0 +1,83 @@
+package utils_test
+
+import (
+	"testing"
+
+	"backend/config"
+	"backend/utils"
+)
+
+func TestCanonicalEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		expected string // empty when the email is invalid
+	}{
+		{"already canonical", "ana@uni.edu", "ana@uni.edu"},
+		{"trimmed", "  ana@uni.edu \t", "ana@uni.edu"},
+		{"domain lowercased, local part kept", "Ana.Perez@UNI.Edu", "Ana.Perez@uni.edu"},
+		{"subaddress kept", "ana+news@uni.edu", "ana+news@uni.edu"},
+		{"IDNA domain", "luis@Über.de", "luis@xn--ber-goa.de"},
+		{"IDNA subdomain", "luis@mail.bücher.example", "luis@mail.xn--bcher-kva.example"},
+		{"punycode domain kept", "luis@xn--ber-goa.de", "luis@xn--ber-goa.de"},
+		{"trailing dot of the domain", "ana@uni.edu.", "ana@uni.edu"},
+		{"no at", "ana.uni.edu", ""},
+		{"no local part", "@uni.edu", ""},
+		{"no domain", "ana@", ""},
+		{"space in the domain", "ana@uni edu.ar", ""},
+		{"underscore in the domain", "ana@uni_edu.ar", ""},
+		{"label starting with a hyphen", "ana@-uni.edu", ""},
+		{"broken punycode", "ana@xn--zz.edu", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			canonical, err := utils.CanonicalEmail(test.email)
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("expected %q to be invalid, got %q", test.email, canonical)
+				}
+				return
+			}
+			if err != nil || canonical != test.expected {
+				t.Errorf("expected %q, got %q (%v)", test.expected, canonical, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	defaults := config.Emails{FoldLocalPart: true}
+	t.Cleanup(func() { utils.ConfigureEmails(defaults) })
+
+	tests := []struct {
+		name     string
+		options  config.Emails
+		email    string
+		expected string
+	}{
+		{"folded by default", defaults, "Ana.Perez@Uni.edu", "ana.perez@uni.edu"},
+		{"subaddress kept by default", defaults, "Ana+News@uni.edu", "ana+news@uni.edu"},
+		{"IDNA domain folded too", defaults, "LUIS@Über.de", "luis@xn--ber-goa.de"},
+		{"without folding", config.Emails{}, "Ana.Perez@Uni.edu", "Ana.Perez@uni.edu"},
+		{"subaddress stripped", config.Emails{FoldLocalPart: true, StripSubaddress: true}, "Ana+News@uni.edu", "ana@uni.edu"},
+		{"only the first tag", config.Emails{FoldLocalPart: true, StripSubaddress: true}, "ana+a+b@uni.edu", "ana@uni.edu"},
+		{"a leading plus is not a tag", config.Emails{FoldLocalPart: true, StripSubaddress: true}, "+ana@uni.edu", "+ana@uni.edu"},
+		{"stripped without folding", config.Emails{StripSubaddress: true}, "Ana+News@uni.edu", "Ana@uni.edu"},
+		// dots are part of the mailbox for most providers, even if Gmail ignores them
+		{"dots kept", config.Emails{FoldLocalPart: true, StripSubaddress: true}, "ana.perez@gmail.com", "ana.perez@gmail.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			utils.ConfigureEmails(test.options)
+			normalized, err := utils.NormalizeEmail(test.email)
+			if err != nil || normalized != test.expected {
+				t.Errorf("expected %q, got %q (%v)", test.expected, normalized, err)
+			}
+		})
+	}
+
+	utils.ConfigureEmails(defaults)
+	if _, err := utils.NormalizeEmail("ana@uni_edu.ar"); err == nil {
+		t.Errorf("expected an invalid domain to fail the normalization too")
+	}
+}
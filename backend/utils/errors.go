@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"backend/i18n"
 	"fmt"
 	"net/http"
 
@@ -9,6 +10,28 @@ import (
 
 type CauseList []interface{}
 
+// Stable error codes of the API. Clients tell errors apart by these, the
+// messages may change and are translated
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidation         = "validation_error"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeAccountLocked      = "account_locked"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeEmailTaken         = "email_taken"
+	CodeAlreadyVerified    = "already_verified"
+	CodeConflict           = "conflict_error"
+	CodeInvalidCode        = "code_invalid"
+	CodeCodeExpired        = "code_expired"
+	CodeTooManyAttempts    = "too_many_attempts"
+	CodeInternal           = "internal_server_error"
+	CodeUnavailable        = "service_unavailable"
+)
+
 type ApiError interface {
 	Message() string
 	Code() string
@@ -72,12 +95,14 @@ func NewMethodNotAllowedApiError() ApiError {
 	return apiErr{"Method not allowed", "method_not_allowed", http.StatusMethodNotAllowed, CauseList{}}
 }
 
+// NewInternalServerApiError keeps err as the cause, for the logs. Clients only
+// get the generic error.unexpected message
 func NewInternalServerApiError(message string, err error) ApiError {
 	cause := CauseList{}
 	if err != nil {
 		cause = append(cause, err.Error())
 	}
-	return apiErr{message, "internal_server_error", http.StatusInternalServerError, cause}
+	return localizedApiErr{apiErr{message, CodeInternal, http.StatusInternalServerError, cause}, "error.unexpected", nil}
 }
 
 func NewForbiddenApiError(message string) ApiError {
@@ -92,6 +117,28 @@ func NewConflictApiError(id string) ApiError {
 	return apiErr{"Can't update " + id + " due to a conflict error", "conflict_error", http.StatusConflict, CauseList{}}
 }
 
+// localizedApiErr is an apiErr whose message comes from the i18n catalog, so
+// the controllers can translate it to the language of the request
+type localizedApiErr struct {
+	apiErr
+	key    string
+	params i18n.Params
+}
+
+func (e localizedApiErr) MessageKey() string {
+	return e.key
+}
+
+func (e localizedApiErr) MessageParams() i18n.Params {
+	return e.params
+}
+
+// NewLocalizedApiError returns an error with the message key. Message() is
+// the English text
+func NewLocalizedApiError(key string, params i18n.Params, error string, status int, cause CauseList) ApiError {
+	return localizedApiErr{apiErr{i18n.T("en", key, params), error, status, cause}, key, params}
+}
+
 func NewApiErrorFromBytes(data []byte) (ApiError, error) {
 	err := apiErr{}
 	e := json.Unmarshal(data, &err)
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 )
 
 func HashSHA256(value string) string {
@@ -10,3 +13,20 @@ func HashSHA256(value string) string {
 	return hex.EncodeToString(hash[:])
 
 }
+
+// GenerateSecureToken returns size random bytes encoded as hex
+func GenerateSecureToken(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// HashOneTimeToken hashes a one-time code with the server secret. A plain hash
+// of a 6-digit code could be reversed by trying them all
+func HashOneTimeToken(purpose string, userID int, code string) string {
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	fmt.Fprintf(mac, "%s:%d:%s", purpose, userID, code)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,9 +1,10 @@
 package utils
 
 import (
+	"backend/config"
+	"errors"
 	"fmt"
-	"log"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -14,22 +15,63 @@ const (
 	jwtDuration = 10 * time.Minute
 	// Refresh token expiration time (longer)
 	refreshTokenDuration = 7 * 24 * time.Hour // 7 days
+	// Service client token expiration time (client credentials grant)
+	ClientTokenDuration = 5 * time.Minute
 )
 
+// Token subjects, used to tell the token types apart
+const (
+	subjectAuth    = "auth"
+	subjectRefresh = "refresh"
+	subjectClient  = "client"
+	subjectState   = "federation_state"
+	subjectInvite  = "invitation"
+)
+
+// Lifetime of the state kept while the user logs in at an external provider
+const federationStateDuration = 10 * time.Minute
+
 var jwtSecret string
 
-func init() {
-	jwtSecret = os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
-	}
+// ErrNotAdmin is returned by ValidateAdminJWT for valid access tokens that
+// aren't the session of an admin: other users, clients and delegated tokens
+var ErrNotAdmin = errors.New("token is not an admin session")
+
+// ConfigureJWT sets the secret the tokens are signed with
+func ConfigureJWT(cfg config.JWT) {
+	jwtSecret = cfg.Secret
 }
 
 type CustomClaims struct {
-	IsAdmin bool `json:"is_admin"`
+	IsAdmin  bool   `json:"is_admin"`
+	ClientID string `json:"client_id,omitempty"` // set on service client tokens
+	Scope    string `json:"scope,omitempty"`     // space separated scopes, empty for user sessions
 	jwt.RegisteredClaims
 }
 
+// IsClient reports whether the claims belong to a service client token
+func (c *CustomClaims) IsClient() bool {
+	return c.Subject == subjectClient
+}
+
+// UserID returns the user ID carried by a user token
+func (c *CustomClaims) UserID() (int, error) {
+	var userID int
+	if _, err := fmt.Sscanf(c.ID, "%d", &userID); err != nil {
+		return 0, fmt.Errorf("invalid user ID in token")
+	}
+	return userID, nil
+}
+
+// ExtractBearerToken strips the optional "Bearer " prefix from an Authorization header
+func ExtractBearerToken(header string) string {
+	header = strings.TrimSpace(header)
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return header
+}
+
 // UserID associated with each token
 func GenerateJWT(userID int, isAdmin bool) (string, error) {
 	// set the expiration time
@@ -43,7 +85,7 @@ func GenerateJWT(userID int, isAdmin bool) (string, error) {
 			IssuedAt:  jwt.NewNumericDate(time.Now()),     // set who issued the token
 			NotBefore: jwt.NewNumericDate(time.Now()),     // set when the token is valid
 			Issuer:    "backend",                          // set the issuer of the token
-			Subject:   "auth",                             // set the subject of the token
+			Subject:   subjectAuth,                        // set the subject of the token
 			ID:        fmt.Sprintf("%d", userID),
 		},
 	}
@@ -61,8 +103,15 @@ func GenerateJWT(userID int, isAdmin bool) (string, error) {
 
 // ValidateJWT validates the JWT token and returns the user ID
 func ValidateJWT(tokenString string) error {
+	_, err := ParseAccessToken(tokenString)
+	return err
+}
+
+// ParseAccessToken validates an access token (user session or service client)
+// and returns its claims. Refresh tokens are rejected
+func ParseAccessToken(tokenString string) (*CustomClaims, error) {
 	// parse the token
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// check if the signing method is valid
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -71,53 +120,40 @@ func ValidateJWT(tokenString string) error {
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed parsing token: %w", err)
+		return nil, fmt.Errorf("failed parsing token: %w", err)
 	}
 
 	// check if the token is valid
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*CustomClaims)
 
-	if ok {
+	if ok && token.Valid {
 
 		if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
-			return fmt.Errorf("token expired at %v", claims.ExpiresAt.Time)
+			return nil, fmt.Errorf("token expired at %v", claims.ExpiresAt.Time)
 		}
-		return nil
+
+		if claims.Subject != subjectAuth && claims.Subject != subjectClient {
+			return nil, fmt.Errorf("invalid token type")
+		}
+		return claims, nil
 	}
 
-	return fmt.Errorf("invalid token")
+	return nil, fmt.Errorf("invalid token")
 }
 
+// ValidateAdminJWT checks that the token is the access token of an admin
+// session. Refresh tokens are rejected like in ParseAccessToken
 func ValidateAdminJWT(tokenString string) error {
-	// parse the token
-	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// check if the signing method is valid
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
-
+	claims, err := ParseAccessToken(tokenString)
 	if err != nil {
-		return fmt.Errorf("failed parsing token: %w", err)
+		return err
 	}
 
-	// check if the token is valid and cast to CustomClaims
-	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
-		// check expiration
-		if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
-			return fmt.Errorf("token expired at %v", claims.ExpiresAt.Time)
-		}
-
-		// check if user is admin
-		if !claims.IsAdmin {
-			return fmt.Errorf("user is not admin")
-		}
-
-		return nil
+	// only the sessions of the user, not the tokens of clients acting for them
+	if claims.Subject != subjectAuth || claims.ClientID != "" || !claims.IsAdmin {
+		return ErrNotAdmin
 	}
-
-	return fmt.Errorf("invalid token")
+	return nil
 }
 
 // GenerateRefreshToken generates a refresh token for the user
@@ -133,7 +169,7 @@ func GenerateRefreshToken(userID int, isAdmin bool) (string, error) {
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
 			Issuer:    "backend",
-			Subject:   "refresh",
+			Subject:   subjectRefresh,
 			ID:        fmt.Sprintf("%d", userID),
 		},
 	}
@@ -149,8 +185,29 @@ func GenerateRefreshToken(userID int, isAdmin bool) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateRefreshToken validates the refresh token and returns user ID and isAdmin
+// ValidateRefreshToken validates the refresh token and returns user ID and isAdmin.
+// Refresh tokens issued to OAuth clients are rejected, they must be used on /oauth/token
 func ValidateRefreshToken(tokenString string) (int, bool, error) {
+	claims, err := ParseRefreshToken(tokenString)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if claims.ClientID != "" {
+		return 0, false, fmt.Errorf("refresh token belongs to an oauth client")
+	}
+
+	// extract user ID from claims.ID
+	userID, err := claims.UserID()
+	if err != nil {
+		return 0, false, err
+	}
+
+	return userID, claims.IsAdmin, nil
+}
+
+// ParseRefreshToken validates a refresh token and returns its claims
+func ParseRefreshToken(tokenString string) (*CustomClaims, error) {
 	// parse the token
 	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		// check if the signing method is valid
@@ -161,32 +218,25 @@ func ValidateRefreshToken(tokenString string) (int, bool, error) {
 	})
 
 	if err != nil {
-		return 0, false, fmt.Errorf("failed parsing refresh token: %w", err)
+		return nil, fmt.Errorf("failed parsing refresh token: %w", err)
 	}
 
 	// check if the token is valid and cast to CustomClaims
 	if claims, ok := token.Claims.(*CustomClaims); ok && token.Valid {
 		// check expiration
 		if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
-			return 0, false, fmt.Errorf("refresh token expired at %v", claims.ExpiresAt.Time)
+			return nil, fmt.Errorf("refresh token expired at %v", claims.ExpiresAt.Time)
 		}
 
 		// check if subject is "refresh"
-		if claims.Subject != "refresh" {
-			return 0, false, fmt.Errorf("invalid token type")
-		}
-
-		// extract user ID from claims.ID
-		var userID int
-		_, err := fmt.Sscanf(claims.ID, "%d", &userID)
-		if err != nil {
-			return 0, false, fmt.Errorf("invalid user ID in token")
+		if claims.Subject != subjectRefresh {
+			return nil, fmt.Errorf("invalid token type")
 		}
 
-		return userID, claims.IsAdmin, nil
+		return claims, nil
 	}
 
-	return 0, false, fmt.Errorf("invalid refresh token")
+	return nil, fmt.Errorf("invalid refresh token")
 }
 
 // GenerateTokenPair generates both access and refresh tokens
@@ -203,3 +253,165 @@ func GenerateTokenPair(userID int, isAdmin bool) (accessToken string, refreshTok
 
 	return accessToken, refreshToken, nil
 }
+
+// GenerateClientJWT generates a short-lived access token for a service client
+func GenerateClientJWT(clientID string, scopes []string) (string, error) {
+	expirationTime := time.Now().Add(ClientTokenDuration)
+
+	claims := CustomClaims{
+		ClientID: clientID,
+		Scope:    strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			Issuer:    "backend",
+			Subject:   subjectClient,
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	tokenString, err := token.SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", fmt.Errorf("failed generating client token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// GenerateDelegatedTokenPair generates access and refresh tokens that a user
+// granted to an OAuth client. They are limited to scopes and never carry admin rights
+func GenerateDelegatedTokenPair(userID int, clientID string, scopes []string) (accessToken string, refreshToken string, err error) {
+	now := time.Now()
+	scope := strings.Join(scopes, " ")
+
+	accessClaims := CustomClaims{
+		ClientID: clientID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(jwtDuration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    "backend",
+			Subject:   subjectAuth,
+			ID:        fmt.Sprintf("%d", userID),
+		},
+	}
+	accessToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", "", fmt.Errorf("failed generating token: %w", err)
+	}
+
+	refreshClaims := accessClaims
+	refreshClaims.ExpiresAt = jwt.NewNumericDate(now.Add(refreshTokenDuration))
+	refreshClaims.Subject = subjectRefresh
+	refreshToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", "", fmt.Errorf("failed generating refresh token: %w", err)
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// AccessTokenDuration is how long user access tokens are valid, in seconds
+func AccessTokenDuration() int {
+	return int(jwtDuration.Seconds())
+}
+
+// FederationStateClaims keep the login state of the flow against an external
+// identity provider between the redirect and the callback
+type FederationStateClaims struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	jwt.RegisteredClaims
+}
+
+// GenerateFederationState signs the login state so it can be kept in a cookie
+func GenerateFederationState(claims FederationStateClaims) (string, error) {
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(federationStateDuration)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Issuer:    "backend",
+		Subject:   subjectState,
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", fmt.Errorf("failed generating federation state: %w", err)
+	}
+	return tokenString, nil
+}
+
+// ParseFederationState validates a login state generated by GenerateFederationState
+func ParseFederationState(tokenString string) (*FederationStateClaims, error) {
+	claims := &FederationStateClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(jwtSecret), nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing federation state: %w", err)
+	}
+
+	if claims.Subject != subjectState {
+		return nil, fmt.Errorf("invalid token type")
+	}
+	return claims, nil
+}
+
+// InvitationClaims are carried by the link sent to an invited user. The link
+// only proves the invitation was issued here; whether it is still pending is
+// checked against the database
+type InvitationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// GenerateInvitationToken signs the token of an invitation link
+func GenerateInvitationToken(invitationID int, email string, expiresAt time.Time) (string, error) {
+	claims := InvitationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "backend",
+			Subject:   subjectInvite,
+			ID:        fmt.Sprintf("%d", invitationID),
+		},
+	}
+
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret))
+	if err != nil {
+		return "", fmt.Errorf("failed generating invitation token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// ParseInvitationToken validates an invitation token and returns the
+// invitation ID and email it was issued for
+func ParseInvitationToken(tokenString string) (int, string, error) {
+	claims := &InvitationClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(jwtSecret), nil
+	}, jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, "", fmt.Errorf("failed parsing invitation token: %w", err)
+	}
+
+	if claims.Subject != subjectInvite {
+		return 0, "", fmt.Errorf("invalid token type")
+	}
+	var invitationID int
+	if _, err := fmt.Sscanf(claims.ID, "%d", &invitationID); err != nil {
+		return 0, "", fmt.Errorf("invalid invitation ID in token")
+	}
+	return invitationID, claims.Email, nil
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"backend/config"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ID tokens are read once by the client right after login
+const idTokenDuration = 10 * time.Minute
+
+// Issuer and key of the ID tokens, set at startup by ConfigureOIDC
+var (
+	oidcIssuer   = "http://localhost:8080"
+	signingKey   *rsa.PrivateKey
+	signingKeyID string
+)
+
+// IDTokenClaims are the OpenID Connect ID token claims. Profile and email
+// claims are only set when the matching scope was granted
+type IDTokenClaims struct {
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// JSONWebKey is the public part of the signing key (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// OIDCIssuer is the issuer identifier, the public base URL of this service
+func OIDCIssuer() string {
+	return oidcIssuer
+}
+
+// ConfigureOIDC sets the issuer and reads the RSA key of the ID tokens from
+// the signing key file. Without it a key is generated, so ID tokens don't
+// survive a restart. It runs at startup, so a bad key stops the service
+// before it serves any request
+func ConfigureOIDC(cfg config.OIDC) error {
+	oidcIssuer = cfg.Issuer
+
+	var key *rsa.PrivateKey
+	path := cfg.SigningKeyFile
+	if path == "" {
+		log.Println("The OIDC signing key file is not set, generating a temporary signing key")
+		generated, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return fmt.Errorf("error generating the OIDC signing key: %w", err)
+		}
+		key = generated
+	} else {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading the OIDC signing key: %w", err)
+		}
+		if key, err = parseRSAPrivateKey(data); err != nil {
+			return fmt.Errorf("error parsing the OIDC signing key %s: %w", path, err)
+		}
+	}
+
+	if err := key.Validate(); err != nil {
+		return fmt.Errorf("invalid OIDC signing key: %w", err)
+	}
+	if key.N.BitLen() < 2048 {
+		return fmt.Errorf("the OIDC signing key has %d bits, at least 2048 are needed", key.N.BitLen())
+	}
+
+	// the key ID is derived from the public key, so it changes with the key
+	hash := sha256.Sum256(key.PublicKey.N.Bytes())
+	signingKey = key
+	signingKeyID = base64.RawURLEncoding.EncodeToString(hash[:8])
+	return nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is not an RSA key")
+	}
+	return key, nil
+}
+
+// GenerateIDToken signs an ID token for the user and client with RS256
+func GenerateIDToken(userID int, clientID string, claims IDTokenClaims) (string, error) {
+	if signingKey == nil {
+		return "", fmt.Errorf("failed generating id token: no signing key loaded")
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    OIDCIssuer(),
+		Subject:   fmt.Sprintf("%d", userID),
+		Audience:  jwt.ClaimStrings{clientID},
+		ExpiresAt: jwt.NewNumericDate(now.Add(idTokenDuration)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = signingKeyID
+
+	tokenString, err := token.SignedString(signingKey)
+	if err != nil {
+		return "", fmt.Errorf("failed generating id token: %w", err)
+	}
+	return tokenString, nil
+}
+
+// PublicJWKS returns the public keys clients use to verify ID tokens, none
+// before the signing key is loaded
+func PublicJWKS() []JSONWebKey {
+	if signingKey == nil {
+		return []JSONWebKey{}
+	}
+
+	return []JSONWebKey{{
+		Kty: "RSA",
+		Use: "sig",
+		Kid: signingKeyID,
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(signingKey.PublicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signingKey.PublicKey.E)).Bytes()),
+	}}
+}
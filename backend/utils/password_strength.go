@@ -0,0 +1,294 @@
+package utils
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Kinds of patterns found by EstimatePasswordStrength, used as warnings
+const (
+	PasswordPatternDictionary = "common_word"
+	PasswordPatternPersonal   = "personal_info"
+	PasswordPatternSequence   = "sequence"
+	PasswordPatternRepeat     = "repeated_characters"
+	PasswordPatternKeyboard   = "keyboard_pattern"
+	PasswordPatternDate       = "date"
+)
+
+// Keyboard rows checked for walks like "qwerty" or "asdf"
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm", "qwertzuiop", "azertyuiop"}
+
+// Common character substitutions undone before the dictionary lookup
+var leetReplacer = strings.NewReplacer("4", "a", "@", "a", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+// PasswordStrength is the estimate of how many guesses an attacker needs
+type PasswordStrength struct {
+	Guesses  float64
+	Score    int      // 0 (too guessable) to 4 (very unguessable), like zxcvbn
+	Patterns []string // patterns found in the weakest reading of the password
+}
+
+// passwordMatch is a guessable piece of the password
+type passwordMatch struct {
+	pattern string
+	log10   float64 // log10 of the guesses needed for the piece
+}
+
+// EstimatePasswordStrength estimates the guesses needed for password in the
+// style of zxcvbn: it splits the password in the pieces that are cheapest to
+// guess (dictionary words, the user's own data, sequences, repeats, keyboard
+// walks, dates) and brute force for the rest. dictionary maps lowercase words
+// to their frequency rank; userInputs are words tied to the user, like the
+// name or the email
+func EstimatePasswordStrength(password string, dictionary map[string]int, userInputs []string) PasswordStrength {
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return PasswordStrength{}
+	}
+
+	personal := map[string]bool{}
+	for _, input := range userInputs {
+		if input = strings.ToLower(strings.TrimSpace(input)); len([]rune(input)) >= 3 {
+			personal[input] = true
+		}
+	}
+
+	// best[j] is the cheapest way (in log10 guesses) to guess the first j runes
+	best := make([]float64, n+1)
+	choice := make([]passwordMatch, n+1)
+	start := make([]int, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = math.Inf(1)
+		for i := 0; i < j; i++ {
+			match := cheapestMatch(runes[i:j], dictionary, personal)
+			if match.log10 < 0 {
+				continue
+			}
+			if cost := best[i] + match.log10; cost < best[j] {
+				best[j], choice[j], start[j] = cost, match, i
+			}
+		}
+	}
+
+	strength := PasswordStrength{Guesses: math.Pow(10, best[n])}
+	seen := map[string]bool{}
+	for j := n; j > 0; j = start[j] {
+		if pattern := choice[j].pattern; pattern != "" && !seen[pattern] {
+			seen[pattern] = true
+			strength.Patterns = append(strength.Patterns, pattern)
+		}
+	}
+
+	switch {
+	case best[n] < 3:
+		strength.Score = 0
+	case best[n] < 6:
+		strength.Score = 1
+	case best[n] < 8:
+		strength.Score = 2
+	case best[n] < 10:
+		strength.Score = 3
+	default:
+		strength.Score = 4
+	}
+	return strength
+}
+
+// cheapestMatch returns the cheapest pattern that explains piece, or a
+// negative cost when none does
+func cheapestMatch(piece []rune, dictionary map[string]int, personal map[string]bool) passwordMatch {
+	match := passwordMatch{log10: -1}
+	consider := func(pattern string, guesses float64) {
+		if cost := math.Log10(math.Max(guesses, 1)); match.log10 < 0 || cost < match.log10 {
+			match = passwordMatch{pattern: pattern, log10: cost}
+		}
+	}
+
+	if len(piece) == 1 {
+		consider("", float64(charsetSize(piece[0])))
+		return match
+	}
+
+	word := string(piece)
+	lower := strings.ToLower(word)
+	variations := caseVariations(word)
+	for _, candidate := range []string{lower, leetReplacer.Replace(lower)} {
+		factor := variations
+		if candidate != lower {
+			factor *= 2
+		}
+		reversed := reverseString(candidate)
+		if personal[candidate] {
+			consider(PasswordPatternPersonal, factor)
+		}
+		if personal[reversed] {
+			consider(PasswordPatternPersonal, factor*2)
+		}
+		if rank, ok := dictionary[candidate]; ok && len(piece) >= 3 {
+			consider(PasswordPatternDictionary, float64(rank)*factor)
+		}
+		if rank, ok := dictionary[reversed]; ok && len(piece) >= 3 {
+			consider(PasswordPatternDictionary, float64(rank)*factor*2)
+		}
+	}
+
+	if len(piece) >= 3 {
+		if guesses, ok := sequenceGuesses(piece); ok {
+			consider(PasswordPatternSequence, guesses)
+		}
+		if isRepeat(piece) {
+			consider(PasswordPatternRepeat, float64(charsetSize(piece[0])*len(piece)))
+		}
+		if guesses, ok := dateGuesses(word); ok {
+			consider(PasswordPatternDate, guesses)
+		}
+	}
+	if len(piece) >= 4 && isKeyboardWalk(lower) {
+		consider(PasswordPatternKeyboard, float64(50*len(piece)))
+	}
+	return match
+}
+
+// caseVariations counts the capitalizations an attacker tries for a word:
+// all lowercase is free, a leading or full uppercase is cheap, anything else doubles
+func caseVariations(word string) float64 {
+	hasUpper := strings.ToLower(word) != word
+	switch {
+	case !hasUpper:
+		return 1
+	case strings.ToUpper(word) == word:
+		return 2
+	case unicode.IsUpper([]rune(word)[0]) && strings.ToLower(word[1:]) == word[1:]:
+		return 2
+	}
+	return 4
+}
+
+// sequenceGuesses matches runs like "abc", "4567" or "zyx"
+func sequenceGuesses(piece []rune) (float64, bool) {
+	delta := piece[1] - piece[0]
+	if delta != 1 && delta != -1 {
+		return 0, false
+	}
+	for i := 2; i < len(piece); i++ {
+		if piece[i]-piece[i-1] != delta {
+			return 0, false
+		}
+	}
+
+	base := 26.0
+	switch {
+	case strings.ContainsRune("aAzZ019", piece[0]):
+		base = 4
+	case unicode.IsDigit(piece[0]):
+		base = 10
+	}
+	if delta < 0 {
+		base *= 2
+	}
+	return base * float64(len(piece)), true
+}
+
+func isRepeat(piece []rune) bool {
+	for _, r := range piece[1:] {
+		if r != piece[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func isKeyboardWalk(lower string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, lower) || strings.Contains(row, reverseString(lower)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dateGuesses matches years (1900-2049), all-digit dates like 31122004 or
+// 311204 and dates with separators like 19/04/1987 or 1987-04-19
+func dateGuesses(word string) (float64, bool) {
+	if guesses, ok := separatedDateGuesses(word); ok {
+		return guesses, true
+	}
+	for _, r := range word {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	switch len(word) {
+	case 4:
+		if word >= "1900" && word <= "2049" {
+			return 150, true
+		}
+		// day and month, like 3112
+		return 366, looksLikeDayMonth(word)
+	case 6, 8:
+		return 366 * 150, looksLikeDayMonth(word[:4]) || looksLikeDayMonth(word[len(word)-4:])
+	}
+	return 0, false
+}
+
+// separatedDateGuesses matches day, month and year joined by the same
+// separator, with the year first or last and the day and month either way
+func separatedDateGuesses(word string) (float64, bool) {
+	for _, separator := range []string{"/", "-", ".", "_", " "} {
+		parts := strings.Split(word, separator)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, part := range parts {
+			for _, r := range part {
+				if r < '0' || r > '9' {
+					return 0, false
+				}
+			}
+		}
+
+		year, first, second := parts[2], parts[0], parts[1]
+		if len(parts[0]) == 4 {
+			year, first, second = parts[0], parts[1], parts[2]
+		}
+		if (len(year) != 2 && len(year) != 4) || len(first) < 1 || len(first) > 2 || len(second) < 1 || len(second) > 2 {
+			return 0, false
+		}
+		pad := func(part string) string { return strings.Repeat("0", 2-len(part)) + part }
+		if looksLikeDayMonth(pad(first)+pad(second)) || looksLikeDayMonth(pad(second)+pad(first)) {
+			// the separator is one of a few more guesses
+			return 366 * 150 * 4, true
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+func looksLikeDayMonth(digits string) bool {
+	day := int(digits[0]-'0')*10 + int(digits[1]-'0')
+	month := int(digits[2]-'0')*10 + int(digits[3]-'0')
+	return day >= 1 && day <= 31 && month >= 1 && month <= 12
+}
+
+// charsetSize is the number of characters of the class of r
+func charsetSize(r rune) int {
+	switch {
+	case r >= '0' && r <= '9':
+		return 10
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		return 26
+	case r < 128:
+		return 33
+	}
+	return 100
+}
+
+func reverseString(value string) string {
+	runes := []rune(value)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
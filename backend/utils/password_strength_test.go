@@ -0,0 +1,68 @@
+package utils_test
+
+import (
+	"testing"
+
+	"backend/utils"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	dictionary := map[string]int{"password": 1, "monkey": 20, "dragon": 50, "horse": 700, "correct": 900}
+	// checkPassword passes the user's data lower cased and without accents
+	userInputs := []string{"ana", "garcia", "anagarcia", "uni"}
+
+	tests := []struct {
+		name     string
+		password string
+		score    int
+		pattern  string // a pattern expected among the warnings, empty for none
+	}{
+		{"empty", "", 0, ""},
+		{"common word", "password", 0, utils.PasswordPatternDictionary},
+		{"capitalized", "Password", 0, utils.PasswordPatternDictionary},
+		{"leet", "P@ssw0rd", 0, utils.PasswordPatternDictionary},
+		{"reversed", "drowssap", 0, utils.PasswordPatternDictionary},
+		{"word and digits", "monkey123", 0, utils.PasswordPatternSequence},
+		{"sequence", "abcdef", 0, utils.PasswordPatternSequence},
+		{"descending sequence", "987654", 0, utils.PasswordPatternSequence},
+		{"repeat", "aaaaaaaaaaaa", 0, utils.PasswordPatternRepeat},
+		{"keyboard walk", "qwertyuiop", 0, utils.PasswordPatternKeyboard},
+		{"year", "1987", 0, utils.PasswordPatternDate},
+		{"all-digit date", "19041987", 1, utils.PasswordPatternDate},
+		{"date with slashes", "19/04/1987", 1, utils.PasswordPatternDate},
+		{"date with the year first", "1987-04-19", 1, utils.PasswordPatternDate},
+		{"month first date", "4.19.87", 1, utils.PasswordPatternDate},
+		{"personal info", "anagarcia", 0, utils.PasswordPatternPersonal},
+		{"personal info and a date", "ana.garcia1987", 1, utils.PasswordPatternPersonal},
+		{"random", "x7#Kp2!vQz", 4, ""},
+		{"passphrase", "correct-horse-battery-staple", 4, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			strength := utils.EstimatePasswordStrength(test.password, dictionary, userInputs)
+			if strength.Score != test.score {
+				t.Errorf("expected score %d for %q, got %d (%v)", test.score, test.password, strength.Score, strength.Patterns)
+			}
+			if test.pattern == "" {
+				return
+			}
+			for _, pattern := range strength.Patterns {
+				if pattern == test.pattern {
+					return
+				}
+			}
+			t.Errorf("expected the %s pattern for %q, got %v", test.pattern, test.password, strength.Patterns)
+		})
+	}
+}
+
+func TestEstimatePasswordStrengthGrowsWithLength(t *testing.T) {
+	previous := 0.0
+	for _, password := range []string{"x7#K", "x7#Kp2!v", "x7#Kp2!vQz9&", "x7#Kp2!vQz9&mL4$"} {
+		strength := utils.EstimatePasswordStrength(password, nil, nil)
+		if strength.Guesses <= previous {
+			t.Errorf("%q needs %g guesses, not more than a shorter password (%g)", password, strength.Guesses, previous)
+		}
+		previous = strength.Guesses
+	}
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+// RFC 7636 section 4.1: 43 to 128 unreserved characters
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// IsValidCodeVerifier reports whether verifier is a well formed PKCE code verifier
+func IsValidCodeVerifier(verifier string) bool {
+	return codeVerifierPattern.MatchString(verifier)
+}
+
+// IsValidCodeChallenge reports whether challenge looks like an S256 challenge
+func IsValidCodeChallenge(challenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(challenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// VerifyPKCE checks a code verifier against an S256 code challenge
+func VerifyPKCE(verifier string, challenge string) bool {
+	if !IsValidCodeVerifier(verifier) {
+		return false
+	}
+	computed := CodeChallengeS256(verifier)
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// GenerateCodeVerifier returns a random PKCE code verifier, used when this
+// service is the client of an external provider
+func GenerateCodeVerifier() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CodeChallengeS256 derives the S256 code challenge of a verifier
+func CodeChallengeS256(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,21 @@
+package utils
+
+// Roles a user can have. Admin is kept in sync with the is_admin flag
+const (
+	RoleStudent   = "student"
+	RoleProfessor = "professor"
+	RoleAdmin     = "admin"
+)
+
+// KnownRoles lists every role the service understands
+var KnownRoles = []string{RoleStudent, RoleProfessor, RoleAdmin}
+
+// IsKnownRole reports whether the service understands role
+func IsKnownRole(role string) bool {
+	for _, known := range KnownRoles {
+		if known == role {
+			return true
+		}
+	}
+	return false
+}
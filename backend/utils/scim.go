@@ -0,0 +1,313 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SCIM schema and message URNs (RFC 7643 and RFC 7644)
+const (
+	ScimCoreUserSchema              = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimCoreGroupSchema             = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ScimListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimPatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIM error types returned in scimType (RFC 7644 section 3.12)
+const (
+	ScimInvalidFilter = "invalidFilter"
+	ScimInvalidSyntax = "invalidSyntax"
+	ScimInvalidPath   = "invalidPath"
+	ScimInvalidValue  = "invalidValue"
+	ScimNoTarget      = "noTarget"
+	ScimUniqueness    = "uniqueness"
+	ScimMutability    = "mutability"
+)
+
+// SCIM filter expressions (RFC 7644 section 3.4.2.2). A filter parses into a
+// tree of these nodes, which the services translate into SQL
+type ScimFilter interface {
+	isScimFilter()
+}
+
+// ScimAttrExpr compares an attribute: userName eq "ana@uni.edu", title pr
+type ScimAttrExpr struct {
+	Path     string      // attribute path without the core schema prefix, e.g. name.givenName
+	Operator string      // eq, ne, co, sw, ew, pr, gt, ge, lt, le
+	Value    interface{} // string, float64, bool or nil; unused for pr
+}
+
+// ScimLogicalExpr joins two filters with "and" or "or"
+type ScimLogicalExpr struct {
+	Operator string
+	Left     ScimFilter
+	Right    ScimFilter
+}
+
+// ScimNotExpr negates a filter: not (active eq false)
+type ScimNotExpr struct {
+	Filter ScimFilter
+}
+
+func (ScimAttrExpr) isScimFilter()    {}
+func (ScimLogicalExpr) isScimFilter() {}
+func (ScimNotExpr) isScimFilter()     {}
+
+var scimCompareOperators = []string{"eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le"}
+
+// ParseScimFilter parses a filter such as
+// userName eq "ana@uni.edu" and (active eq true or emails[value co "@uni.edu"]).
+// Value paths like emails[value co "x"] are flattened to emails.value co "x"
+func ParseScimFilter(filter string) (ScimFilter, error) {
+	tokens, err := tokenizeScimFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+
+	parser := &scimFilterParser{tokens: tokens}
+	expr, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", parser.tokens[parser.pos].text)
+	}
+	return expr, nil
+}
+
+type scimToken struct {
+	text   string
+	quoted bool // string literal, already unescaped
+}
+
+func tokenizeScimFilter(filter string) ([]scimToken, error) {
+	var tokens []scimToken
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, scimToken{text: string(c)})
+			i++
+		case c == '"':
+			// find the closing quote, skipping escaped ones
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %w", err)
+			}
+			tokens = append(tokens, scimToken{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, scimToken{text: filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type scimFilterParser struct {
+	tokens []scimToken
+	pos    int
+	prefix string // attribute of the enclosing value path
+}
+
+func (p *scimFilterParser) peekKeyword(keyword string) bool {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return false
+	}
+	return strings.EqualFold(p.tokens[p.pos].text, keyword)
+}
+
+func (p *scimFilterParser) next() (scimToken, error) {
+	if p.pos >= len(p.tokens) {
+		return scimToken{}, fmt.Errorf("unexpected end of filter")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+func (p *scimFilterParser) expect(text string) error {
+	token, err := p.next()
+	if err != nil {
+		return err
+	}
+	if token.quoted || token.text != text {
+		return fmt.Errorf("expected %q in filter, got %q", text, token.text)
+	}
+	return nil
+}
+
+// or has the lowest precedence, then and, then not and grouping
+func (p *scimFilterParser) parseOr() (ScimFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = ScimLogicalExpr{Operator: "or", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseAnd() (ScimFilter, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = ScimLogicalExpr{Operator: "and", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseTerm() (ScimFilter, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return ScimNotExpr{Filter: inner}, nil
+	}
+
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if token.quoted {
+		return nil, fmt.Errorf("expected an attribute in filter, got %q", token.text)
+	}
+
+	if token.text == "(" {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	path := NormalizeScimPath(token.text)
+	if p.prefix != "" {
+		path = p.prefix + "." + path
+	}
+
+	// value path: emails[type eq "work"]
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted && p.tokens[p.pos].text == "[" {
+		if p.prefix != "" {
+			return nil, fmt.Errorf("nested value paths are not supported")
+		}
+		p.pos++
+		p.prefix = path
+		inner, err := p.parseOr()
+		p.prefix = ""
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	operator, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	op := strings.ToLower(operator.text)
+	if operator.quoted {
+		return nil, fmt.Errorf("expected an operator after %s", path)
+	}
+	if op == "pr" {
+		return ScimAttrExpr{Path: path, Operator: op}, nil
+	}
+	if !containsFold(scimCompareOperators, op) {
+		return nil, fmt.Errorf("unknown operator %q", operator.text)
+	}
+
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	expr := ScimAttrExpr{Path: path, Operator: op}
+	if value.quoted {
+		expr.Value = value.text
+		return expr, nil
+	}
+	switch strings.ToLower(value.text) {
+	case "true":
+		expr.Value = true
+	case "false":
+		expr.Value = false
+	case "null":
+		expr.Value = nil
+	default:
+		number, err := strconv.ParseFloat(value.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q in filter", value.text)
+		}
+		expr.Value = number
+	}
+	return expr, nil
+}
+
+// NormalizeScimPath drops the core schema prefix so
+// urn:ietf:params:scim:schemas:core:2.0:User:userName becomes userName
+func NormalizeScimPath(path string) string {
+	for _, schema := range []string{ScimCoreUserSchema, ScimCoreGroupSchema} {
+		if len(path) > len(schema) && strings.EqualFold(path[:len(schema)+1], schema+":") {
+			return path[len(schema)+1:]
+		}
+	}
+	return path
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package utils_test
+
+import (
+	"fmt"
+	"testing"
+
+	"backend/utils"
+)
+
+// describeScimFilter prints a filter tree with explicit grouping, so the tests
+// can compare precedence at a glance
+func describeScimFilter(filter utils.ScimFilter) string {
+	switch expr := filter.(type) {
+	case utils.ScimLogicalExpr:
+		return "(" + describeScimFilter(expr.Left) + " " + expr.Operator + " " + describeScimFilter(expr.Right) + ")"
+	case utils.ScimNotExpr:
+		return "not " + describeScimFilter(expr.Filter)
+	case utils.ScimAttrExpr:
+		if expr.Operator == "pr" {
+			return expr.Path + " pr"
+		}
+		return fmt.Sprintf("%s %s %#v", expr.Path, expr.Operator, expr.Value)
+	}
+	return fmt.Sprintf("%T", filter)
+}
+
+func TestParseScimFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		expected string
+	}{
+		{"comparison", `userName eq "ana@uni.edu"`, `userName eq "ana@uni.edu"`},
+		{"present", `title pr`, `title pr`},
+		{"keywords ignore case", `userName EQ "a" AND title PR`, `(userName eq "a" and title pr)`},
+		{"and binds tighter than or", `a eq 1 or b eq 2 and c eq 3`, `(a eq 1 or (b eq 2 and c eq 3))`},
+		{"and before or", `a eq 1 and b eq 2 or c eq 3`, `((a eq 1 and b eq 2) or c eq 3)`},
+		{"left associative", `a eq 1 or b eq 2 or c eq 3`, `((a eq 1 or b eq 2) or c eq 3)`},
+		{"parentheses", `(a eq 1 or b eq 2) and c eq 3`, `((a eq 1 or b eq 2) and c eq 3)`},
+		{"not", `not (active eq false) and a pr`, `(not active eq false and a pr)`},
+		{"value path", `emails[type eq "work" and value co "@uni.edu"]`, `(emails.type eq "work" and emails.value co "@uni.edu")`},
+		{"value path in an expression", `a pr or emails[value sw "x"]`, `(a pr or emails.value sw "x")`},
+		{"schema prefix dropped", `urn:ietf:params:scim:schemas:core:2.0:User:name.givenName eq "Ana"`, `name.givenName eq "Ana"`},
+		{"escaped quote", `userName eq "a\"b"`, `userName eq "a\"b"`},
+		{"wildcards are literal text", `userName co "100%_!"`, `userName co "100%_!"`},
+		{"booleans", `active eq true`, `active eq true`},
+		{"null", `title eq null`, `title eq <nil>`},
+		{"number", `id gt 12.5`, `id gt 12.5`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := utils.ParseScimFilter(test.filter)
+			if err != nil {
+				t.Fatalf("parsing %s: %v", test.filter, err)
+			}
+			if got := describeScimFilter(parsed); got != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseScimFilterRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+	}{
+		{"empty", ``},
+		{"blank", `   `},
+		{"attribute alone", `userName`},
+		{"no value", `userName eq`},
+		{"unknown operator", `userName is "ana"`},
+		{"quoted attribute", `"userName" eq "ana"`},
+		{"quoted operator", `userName "eq" "ana"`},
+		{"unterminated string", `userName eq "ana`},
+		{"bad escape", `userName eq "a\qb"`},
+		{"bare word value", `userName eq ana`},
+		{"dangling and", `userName eq "ana" and`},
+		{"unclosed parenthesis", `(userName eq "ana"`},
+		{"extra parenthesis", `userName eq "ana")`},
+		{"not without parentheses", `not active eq true`},
+		{"unclosed value path", `emails[value eq "x"`},
+		{"nested value paths", `emails[value[type eq "x"]]`},
+		{"two expressions", `userName eq "ana" title pr`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if parsed, err := utils.ParseScimFilter(test.filter); err == nil {
+				t.Errorf("expected %q to be rejected, got %s", test.filter, describeScimFilter(parsed))
+			}
+		})
+	}
+}
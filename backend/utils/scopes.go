@@ -0,0 +1,56 @@
+package utils
+
+import "strings"
+
+// Scopes that can be granted to clients and tokens
+const (
+	ScopeUsersRead = "users:read" // read public user profiles
+	ScopeOpenID    = "openid"     // OpenID Connect sign in, returns an ID token
+	ScopeProfile   = "profile"    // given_name and family_name claims
+	ScopeEmail     = "email"      // email and email_verified claims
+	ScopeSCIM      = "scim"       // provision users and groups through /scim/v2
+)
+
+// KnownScopes lists every scope the service understands
+var KnownScopes = []string{ScopeUsersRead, ScopeOpenID, ScopeProfile, ScopeEmail, ScopeSCIM}
+
+// Scopes that open the admin endpoints to the personal tokens of admins. They
+// are never granted to OAuth clients
+const (
+	ScopeAdminRead   = "admin:read"   // stats, registration policy, outbox and client listings
+	ScopeAdminWrite  = "admin:write"  // promotions, clients, email resends and blocklist reloads
+	ScopeUsersImport = "users:import" // bulk import from CSV
+)
+
+// AdminScopes lists the scopes only admins can put on a personal token
+var AdminScopes = []string{ScopeAdminRead, ScopeAdminWrite, ScopeUsersImport}
+
+// ParseScopes splits a space separated scope string. The result is never nil,
+// even for an empty string, so it can mark a scope restricted token
+func ParseScopes(scope string) []string {
+	scopes := strings.Fields(scope)
+	if scopes == nil {
+		return []string{}
+	}
+	return scopes
+}
+
+// HasScope reports whether scope is present in scopes
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKnownScope reports whether the service understands scope
+func IsKnownScope(scope string) bool {
+	return HasScope(KnownScopes, scope)
+}
+
+// IsAdminScope reports whether scope guards admin endpoints
+func IsAdminScope(scope string) bool {
+	return HasScope(AdminScopes, scope)
+}